@@ -1,3 +1,5 @@
 module unix-sort
 
 go 1.25
+
+require golang.org/x/text v0.32.0
@@ -1,75 +1,1093 @@
 package main
 
 import (
-	"bufio"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"unix-sort/sortutil"
 )
 
+// stringList collects repeated string options in command-line order.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// keyList collects repeated -k options in command-line order.
+type keyList []sortutil.KeySpec
+
+func (k *keyList) String() string { return "" }
+
+func (k *keyList) Set(value string) error {
+	spec, err := sortutil.ParseKeySpec(value)
+	if err != nil {
+		return err
+	}
+	*k = append(*k, spec)
+	return nil
+}
+
+// scaleList collects repeated --scale=FIELD:FACTOR options; a later FIELD wins.
+type scaleList map[int]float64
+
+func (s *scaleList) String() string { return "" }
+
+func (s *scaleList) Set(value string) error {
+	field, factor, ok := strings.Cut(value, ":")
+	n, err := strconv.Atoi(field)
+	if !ok || err != nil || n < 1 {
+		return fmt.Errorf("invalid scale %q: want FIELD:FACTOR with FIELD from 1", value)
+	}
+	f, err := strconv.ParseFloat(factor, 64)
+	if err != nil {
+		return fmt.Errorf("invalid scale %q: %v", value, err)
+	}
+	if *s == nil {
+		*s = make(scaleList)
+	}
+	(*s)[n] = f
+	return nil
+}
+
+// minBufferSize is the smallest -S: a smaller buffer drowns the sort in
+// temporary files.
+const minBufferSize = 1 << 20
+
+// parseBufferSize parses the -S argument: a number with an optional suffix b, K, M,
+// G or T (K by default, as in GNU sort), or a percentage of physical memory like 50%.
+// The result is at least minBufferSize.
+func parseBufferSize(value string) (int, error) {
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		p, err := parsePercent(percent)
+		if err != nil {
+			return 0, fmt.Errorf("sort: invalid -S %q: %v", value, err)
+		}
+		total, ok := sortutil.TotalMemory()
+		if !ok {
+			return 0, fmt.Errorf("sort: invalid -S %q: the size of memory is unknown", value)
+		}
+		return int(max(min(float64(total)*p/100, math.MaxInt/2), minBufferSize)), nil
+	}
+
+	number, unit := value, 1024.0
+	if i := strings.IndexAny(value, "bKMGT"); i >= 0 && i == len(value)-1 {
+		number, unit = value[:i], math.Pow(1024, float64(strings.IndexByte("bKMGT", value[i])))
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n <= 0 || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("sort: invalid -S %q: want a positive size like 500M or 50%%", value)
+	}
+	return int(max(min(n*unit, math.MaxInt/2), minBufferSize)), nil
+}
+
+// reservedFiles is how many descriptors --batch-size leaves for stdin, stdout,
+// stderr, the file being written and the inputs of -m.
+const reservedFiles = 8
+
+// parseBatchSize parses --batch-size: a number of files or a percentage of the
+// limit on open files like 50%. The result is between 2 and the limit less
+// reservedFiles, when the limit is known.
+func parseBatchSize(value string) (int, error) {
+	limit, known := sortutil.OpenFileLimit()
+	var n int
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		p, err := parsePercent(percent)
+		if err != nil {
+			return 0, fmt.Errorf("sort: invalid --batch-size %q: %v", value, err)
+		}
+		if !known {
+			return 0, fmt.Errorf("sort: invalid --batch-size %q: the limit on open files is unknown", value)
+		}
+		n = int(float64(limit) * p / 100)
+	} else {
+		var err error
+		if n, err = strconv.Atoi(value); err != nil || n < 2 {
+			return 0, fmt.Errorf("sort: invalid --batch-size %q: want at least 2 files or a percentage", value)
+		}
+	}
+	if known {
+		n = min(n, limit-reservedFiles)
+	}
+	return max(n, 2), nil
+}
+
+// parsePercent parses the number of a percentage, from 0 exclusive to 100.
+func parsePercent(value string) (float64, error) {
+	p, err := strconv.ParseFloat(value, 64)
+	if err != nil || p <= 0 || p > 100 {
+		return 0, fmt.Errorf("percentage must be above 0 and at most 100")
+	}
+	return p, nil
+}
+
+// progressMode is the value of --progress: "" (off), "auto" or "always".
+// Like a bool flag, a bare --progress means auto: only when stderr is a
+// terminal.
+type progressMode string
+
+func (p *progressMode) String() string { return string(*p) }
+
+func (p *progressMode) IsBoolFlag() bool { return true }
+
+func (p *progressMode) Set(value string) error {
+	switch value {
+	case "true", "auto":
+		*p = "auto"
+	case "always":
+		*p = "always"
+	case "false", "never":
+		*p = ""
+	default:
+		return fmt.Errorf("want auto, always or never")
+	}
+	return nil
+}
+
+// writer returns where progress goes, or nil when it is off.
+func (p progressMode) writer() io.Writer {
+	if p == "always" {
+		return os.Stderr
+	}
+	if p == "auto" {
+		if info, err := os.Stderr.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return os.Stderr
+		}
+	}
+	return nil
+}
+
+// startProfiles starts the CPU profile and returns a function that stops it
+// and writes the heap profile. An empty file name disables that profile.
+func startProfiles(cpuPath, memPath string) (func() error, error) {
+	var cpuFile *os.File
+	if cpuPath != "" {
+		var err error
+		if cpuFile, err = os.Create(cpuPath); err != nil {
+			return nil, fmt.Errorf("sort: cannot create CPU profile: %w", err)
+		}
+		if err = pprof.StartCPUProfile(cpuFile); err != nil {
+			_ = cpuFile.Close()
+			return nil, fmt.Errorf("sort: cannot start CPU profile: %w", err)
+		}
+	}
+
+	return func() error {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			if err := cpuFile.Close(); err != nil {
+				return fmt.Errorf("sort: cannot write CPU profile: %w", err)
+			}
+		}
+		if memPath == "" {
+			return nil
+		}
+		memFile, err := os.Create(memPath)
+		if err != nil {
+			return fmt.Errorf("sort: cannot create heap profile: %w", err)
+		}
+		runtime.GC() // актуальная статистика живых объектов
+		err = pprof.WriteHeapProfile(memFile)
+		if closeErr := memFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("sort: cannot write heap profile: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// loadConfig sets the flags of fs listed in the file at path, one per line as
+// name=value (a bool flag may omit =value); blank lines and # comments are skipped.
+// The file does not override flags already set on the command line; repeatable
+// flags such as k may appear in it several times.
+func loadConfig(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("sort: cannot read config: %w", err)
+	}
+	onCommandLine := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, hasValue := strings.Cut(line, "=")
+		name = strings.TrimLeft(strings.TrimSpace(name), "-")
+		value = strings.TrimSpace(value)
+		f := fs.Lookup(name)
+		switch {
+		case f == nil || name == "config":
+			return fmt.Errorf("sort: %s:%d: unknown option %q", path, i+1, name)
+		case onCommandLine[name]:
+			continue
+		case !hasValue:
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				return fmt.Errorf("sort: %s:%d: option %q needs a value", path, i+1, name)
+			}
+			value = "true"
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("sort: %s:%d: invalid value %q for %s: %v", path, i+1, value, name, err)
+		}
+	}
+	return nil
+}
+
+// readOrderFile reads the values of --order-file, one per line, with blanks
+// around them trimmed and empty lines skipped. A repeated value keeps the place
+// of the first one, since a category list may be an export with duplicates.
+func readOrderFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("sort: cannot read --order-file: %w", err)
+	}
+	var values []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		value := strings.TrimSpace(line)
+		if value == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+		values = append(values, value)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("sort: --order-file %s lists no values", path)
+	}
+	return values, nil
+}
+
+// verbs are the subcommands accepted as the first argument, in addition to -m and -c.
+var verbs = []string{"sort", "merge", "check"}
+
+// splitVerb removes a leading verb from args: "merge a b" is "-m a b" and
+// "check a" is "-c a". A file with such a name is given as ./merge or after --.
+func splitVerb(args []string) (string, []string) {
+	if len(args) > 0 && slices.Contains(verbs, args[0]) {
+		return args[0], args[1:]
+	}
+	return "", args
+}
+
+// parseArgs parses flags found anywhere among the operands, like GNU getopt:
+// "sort file -n" is the same as "sort -n file". Everything after "--" is an operand,
+// and a lone "-" is an operand denoting stdin.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	args = splitShort(fs, args)
+	var operands []string
+	for {
+		_ = fs.Parse(args) // с flag.ExitOnError ошибка завершает программу сама
+		rest := fs.Args()
+		// Parse останавливается на первом операнде или съедает "--"
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(operands, rest...)
+		}
+		if len(rest) == 0 {
+			return operands
+		}
+		operands = append(operands, rest[0])
+		args = rest[1:]
+	}
+}
+
+// splitShort expands clusters of one-letter flags as GNU getopt reads them:
+// "-nr" is "-n -r", and a flag that takes a value takes the rest of the cluster,
+// so "-k2,2h" is "-k 2,2h" and "-bt:" is "-b -t :". Arguments naming a flag as a
+// whole, like "-parallel" or "-r=false", are left to the flag package, and so
+// are clusters with an unknown letter and everything after "--".
+func splitShort(fs *flag.FlagSet, args []string) []string {
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		switch f := fs.Lookup(name); {
+		case len(arg) < 2 || arg[0] != '-' || name == "help":
+			out = append(out, arg)
+		case f != nil:
+			out = append(out, arg)
+			// Значение в следующем аргументе не разбирается как флаг: "-t -"
+			if !isBoolFlag(f) && !strings.Contains(arg, "=") && i+1 < len(args) {
+				i++
+				out = append(out, args[i])
+			}
+		case arg[1] == '-':
+			out = append(out, arg)
+		default:
+			flags, takesNext := splitCluster(fs, arg[1:])
+			out = append(out, flags...)
+			if takesNext && i+1 < len(args) {
+				i++
+				out = append(out, args[i])
+			}
+		}
+	}
+	return out
+}
+
+// splitCluster returns the flags of the cluster c without its dash, or "-"+c
+// unchanged when one of its letters is not a flag. takesNext is set when the
+// last flag takes a value and the cluster ends right after it, as in "-nk 2".
+func splitCluster(fs *flag.FlagSet, c string) (flags []string, takesNext bool) {
+	for j := 0; j < len(c); j++ {
+		f := fs.Lookup(c[j : j+1])
+		if f == nil {
+			return []string{"-" + c}, false
+		}
+		flags = append(flags, "-"+c[j:j+1])
+		if !isBoolFlag(f) {
+			if value := c[j+1:]; value != "" {
+				return append(flags, value), false
+			}
+			return flags, true
+		}
+	}
+	return flags, false
+}
+
+// isBoolFlag reports whether f takes no value, like -n or --stable.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// jsonDisorder is a disorder error that prints as one JSON object (--check-format=json).
+type jsonDisorder struct {
+	err  error
+	text string
+}
+
+func (e *jsonDisorder) Error() string { return e.text }
+
+func (e *jsonDisorder) Unwrap() error { return e.err }
+
+// formatDisorder makes a disorder error print as JSON with the line number,
+// both lines and the deciding key when format is json; other errors pass through.
+func formatDisorder(err error, format string) error {
+	var disorder *sortutil.Disorder
+	if format != "json" || !errors.As(err, &disorder) {
+		return err
+	}
+	data, _ := json.Marshal(disorder)
+	return &jsonDisorder{err: err, text: string(data)}
+}
+
+// mergeInto merges sources into the sorted file path and replaces it with the
+// result (--merge-into). Without -m the sources are first sorted into a
+// temporary file; the result is written next to path and renamed over it, so
+// path is left unchanged on error. A missing path counts as empty.
+func mergeInto(path string, sources []string, sorted bool, opts sortutil.SortOptions) error {
+	if !sorted {
+		inputs, closeInputs, err := openInputs(sources, opts.IgnoreMissing)
+		if err != nil {
+			return err
+		}
+		defer closeInputs()
+		tmp, err := sortutil.SortToTempFile(sortutil.JoinInputs(inputs, opts), opts)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp)
+		sources = []string{tmp}
+	}
+
+	switch _, err := os.Stat(path); {
+	case err == nil:
+		// Существующие строки идут первыми: с -s равные строки из path остаются впереди новых
+		sources = append([]string{path}, sources...)
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("sort: cannot open '%s': %v", path, err)
+	}
+
+	out, err := createReplacement(path)
+	if err != nil {
+		return err
+	}
+	return out.commit(sortutil.MergeSorted(sources, out, opts))
+}
+
+// replacement is a temporary file in the directory of path that replaces path
+// on commit (-o, --merge-into). Until then path is unchanged, so it can be read
+// as input (sort -o file file), even by an external sort.
+type replacement struct {
+	*os.File
+	path string
+	mode fs.FileMode
+}
+
+func createReplacement(path string) (*replacement, error) {
+	mode := fs.FileMode(0o644)
+	switch info, err := os.Stat(path); {
+	case err == nil:
+		mode = info.Mode().Perm()
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("sort: cannot open '%s': %v", path, err)
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("sort: cannot create temporary file for '%s': %v", path, err)
+	}
+	return &replacement{File: file, path: path, mode: mode}, nil
+}
+
+// commit closes the temporary file and, if err is nil, renames it to path with
+// the permissions of the file it replaces; otherwise the temporary file is removed
+// and path stays untouched. It returns err or the first error of its own.
+func (r *replacement) commit(err error) error {
+	if closeErr := r.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("sort: write failed: %s: %v", r.Name(), closeErr)
+	}
+	if err == nil {
+		err = os.Chmod(r.Name(), r.mode)
+	}
+	if err == nil {
+		err = os.Rename(r.Name(), r.path)
+	}
+	if err != nil {
+		os.Remove(r.Name())
+	}
+	return err
+}
+
+// openInputs opens the input files in order, "-" being stdin. With
+// ignoreMissing a file that cannot be opened is skipped with a warning, and
+// the error is returned only when none opens.
+func openInputs(sources []string, ignoreMissing bool) ([]io.Reader, func(), error) {
+	var inputs []io.Reader
+	var files []*os.File
+	closeAll := func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}
+	for _, source := range sources {
+		if source == "-" {
+			inputs = append(inputs, os.Stdin)
+			continue
+		}
+		file, err := os.Open(source)
+		if err != nil && ignoreMissing {
+			fmt.Fprintf(os.Stderr, "sort: cannot open '%s': %v; skipped\n", source, err)
+			continue
+		}
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("sort: cannot open '%s': %v", source, err)
+		}
+		files = append(files, file)
+		inputs = append(inputs, file)
+	}
+	if len(inputs) == 0 {
+		return nil, nil, fmt.Errorf("sort: none of the %d input files could be opened", len(sources))
+	}
+	return inputs, closeAll, nil
+}
+
+// errUnsorted is the result of -C for unsorted input: exit status 1 without a message.
+var errUnsorted = errors.New("sort: input is not sorted")
+
+// main maps errors to exit codes like GNU sort: 1 for disorder found by -c,
+// 2 for any other trouble.
 func main() {
+	err := run()
+	var sortErr *sortutil.SortError
+	switch {
+	case err == nil:
+		return
+	case errors.Is(err, errUnsorted):
+		os.Exit(1)
+	case errors.As(err, &sortErr) && (sortErr.Kind == sortutil.KindDisorder || sortErr.Kind == sortutil.KindDuplicate):
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	log.Print(err)
+	os.Exit(2)
+}
+
+// run parses the command line and sorts, merges or checks the input.
+func run() (err error) {
 	reverse := flag.Bool("r", false, "sort in reverse order")
 	numeric := flag.Bool("n", false, "sort numerically")
 	unique := flag.Bool("u", false, "suppress duplicate lines")
-	keyCol := flag.Int("k", 0, "sort by column N (1-based index)")
+	enumerateGroups := flag.Bool("enumerate-groups", false, "prefix every output line with the number of its group of equal keys and the -t separator or a tab")
+	requireUnique := flag.Bool("require-unique", false, "fail with exit status 1 if two lines have equal keys")
+	uniqueExact := flag.Bool("unique-exact", false, "with -u, keep keys that are equal by value but written differently, like 007 and 7")
+	epsilon := flag.Float64("epsilon", 0, "with -u, treat numeric keys that differ by at most `E` as duplicates")
+	locale := flag.String("locale", "", "compare text by the collation rules of `LOCALE`, e.g. de_DE.UTF-8 (default: bytes, as in the C locale)")
+	numericLocale := flag.String("numeric-locale", "", "read -n and -h numbers with the decimal point and digit grouping of `LOCALE` (default: LC_ALL or LC_NUMERIC)")
+	timeLocale := flag.String("time-locale", "", "recognise -M month names of `LOCALE` as well as English ones (default: LC_ALL or LC_TIME)")
+	decimalComma := flag.Bool("decimal-comma", false, "read -n and -h numbers with a decimal comma and no digit grouping")
+	localeFromEnv := flag.Bool("locale-from-env", false, "take the collation locale from LC_ALL, LC_COLLATE or LANG")
+	stable := flag.Bool("s", false, "stabilize sort by disabling last-resort comparison")
+	assertStable := flag.Bool("assert-stable", false, "with -s, check that lines with equal keys are output in input order and warn if not (debugging aid; also SORT_ASSERT_STABLE=1)")
+	warnTies := flag.Bool("warn-ties", false, "warn when lines with equal keys may be output in a different order between runs")
+	noLastResort := flag.Bool("no-last-resort", false, "disable last-resort comparison without keeping input order")
+	tiebreak := flag.String("tiebreak", "", "order lines with equal keys by the whole `line`, by key text, by input index or not at all (none)")
+	var keys keyList
+	var scales scaleList
+	flag.Var(&scales, "scale", "multiply numeric keys of a field by a factor before comparing, given as `FIELD:FACTOR` (repeatable)")
+	var tempDirs stringList
+	flag.Var(&tempDirs, "T", "use `DIR` for temporary files (repeatable, files are balanced across them)")
+	flag.Var(&keys, "k", "sort via a key; POS1[,POS2], POS is F[.C][OPTS] (repeatable)")
+	separator := flag.String("t", "", "use `SEP` instead of non-blank to blank transition as field separator")
+	columns := flag.String("columns", "", "split lines into fixed-width fields of `WIDTHS`, e.g. 10,5,8, for -k")
+	trimTrailingSep := flag.Bool("trim-trailing-separator", false, "ignore one field separator at the end of a line when extracting keys")
 	ignoreBlanks := flag.Bool("b", false, "ignore leading and trailing blanks")
 	check := flag.Bool("c", false, "check whether input is sorted")
+	quietCheck := flag.Bool("C", false, "like -c, but do not report the first bad line; stop reading at it")
+	checkStrict := flag.Bool("check-strict", false, "check that input is strictly ascending (no equal keys); implies -c")
 	month := flag.Bool("M", false, "sort by month name")
-	human := flag.Bool("h", false, "sort by human-readable numeric values")
+	human := flag.Bool("h", false, "sort by human-readable numeric values like 2K and 1G (not help: use --help)")
+	general := flag.Bool("g", false, "sort by general numeric value")
+	version := flag.Bool("V", false, "natural sort of version numbers")
+	random := flag.Bool("R", false, "shuffle, but group identical keys")
+	fold := flag.Bool("f", false, "fold lower case to upper case characters")
+	dictionary := flag.Bool("d", false, "consider only blanks and alphanumeric characters")
+	nonprinting := flag.Bool("i", false, "consider only printable characters")
+	zero := flag.Bool("z", false, "line delimiter is NUL, not newline")
+	inputZero := flag.Bool("input-zero", false, "input lines end with NUL; output lines still end with a newline")
+	outputZero := flag.Bool("output-zero", false, "end output lines with NUL; input lines still end with a newline")
+	outputCRLF := flag.Bool("output-crlf", false, "end output lines with CRLF (\\r\\n) for Windows tools, whatever the input line endings")
+	partialLine := flag.String("partial-line", "keep", "final line without a newline: `keep` it or drop it")
+	skipBlank := flag.Bool("skip-blank", false, "drop empty lines, and with -b lines of only blanks, instead of sorting them first")
+	maxLineLength := flag.Int("max-line-length", 0, "fail on input lines longer than `N` bytes, or truncate them with --long-lines=truncate")
+	longLines := flag.String("long-lines", "error", "input lines longer than --max-line-length: `error` or truncate")
+	embeddedNUL := flag.String("embedded-nul", "keep", "NUL bytes inside lines without -z: `keep`, strip or reject them")
+	group := flag.Bool("group", false, "separate groups of lines with equal keys by an empty line")
+	onlyKeys := flag.Bool("only-keys", false, "output only the keys each line was compared by")
+	padWidth := flag.Int("pad-width", 0, "zero-pad the number in the first key to `N` characters in the output")
+	summary := flag.Bool("summary", false, "print count, min, max, sum and mean of the numeric keys to stderr")
+	recordSeparator := flag.String("record-separator", "", "records end with `STR` instead of a newline and may span lines, e.g. '\\n\\n' for paragraphs")
+	recordKeyLine := flag.Int("record-key-line", 1, "take keys from line `N` of a multi-line record; 0 means the whole record")
+	unbuffered := flag.Bool("unbuffered", false, "flush output after every line")
+	ignoreMissing := flag.Bool("ignore-missing", false, "skip input files that cannot be opened, with a warning, unless none can")
+	checkFormat := flag.String("check-format", "text", "report disorder found by -c or --check-inputs as `text` or json")
+	merge := flag.Bool("m", false, "merge already sorted files; do not sort")
+	mergeIntoFile := flag.String("merge-into", "", "merge the input into the sorted `FILE` and replace FILE with the result")
+	checkInputs := flag.Bool("check-inputs", false, "with -m, fail on the first input line that is out of order")
+	jsonKey := flag.String("json-key", "", "sort JSON lines by the field at dotted `PATH`")
+	totalOrderCheck := flag.Bool("total-order-check", false, "report comparisons that are not a consistent total order (debugging aid)")
+	keyDefaultNumeric := flag.Bool("key-default-numeric", false, "compare every key numerically unless it selects its own mode (n, g, h, M, V, R or l)")
+	duration := flag.Bool("duration", false, "compare keys as Go durations like 1h2m3s, 500ms or 1.5s")
+	order := flag.String("order", "", "compare keys by their position in the comma-separated `LIST`, e.g. low,medium,high")
+	orderFile := flag.String("order-file", "", "like --order, with the values listed in `FILE`, one per line")
+	humanTies := flag.String("human-ties", "line", "-h keys of equal value like 1000 and 1K: equal keys (`line`) or ordered by unit (unit)")
+	orderUnknown := flag.String("order-unknown", "last", "put keys that are not in --order `first` or last")
+	boolMode := flag.Bool("bool", false, "compare keys as booleans (yes/no, true/false, on/off, 1/0), false first")
+	rightAlign := flag.Bool("right-align", false, "compare keys as text right-justified to the same width, so 2 sorts before 10")
+	money := flag.Bool("money", false, "compare keys as amounts with an optional currency symbol and digit grouping, like $1,234.50 or €2.000,00")
+	radix := flag.Int("radix", 0, "compare keys as numbers in base `N` from 2 to 36, with digits 0-9 and a-z")
+	epoch := flag.String("epoch", "", "compare keys as Unix timestamps in `UNIT`: s, ms, or auto to tell them apart by the number of digits")
+	autoUnit := flag.Bool("auto-unit", false, "compare keys as sizes (10K, 2Gi), durations (500ms, 1h) or plain numbers, detected per key")
+	natural := flag.Bool("natural", false, "compare runs of digits in keys by value and the text between them by --locale, like a locale-aware -V")
+	hexMode := flag.Bool("hex", false, "compare keys as hexadecimal numbers with an optional 0x prefix")
+	ipMode := flag.Bool("ip", false, "compare keys as IPv4/IPv6 addresses")
+	ipInvalidLast := flag.Bool("ip-invalid-last", false, "with --ip, put keys that are not addresses last")
+	jsonInvalidLast := flag.Bool("json-invalid-last", false, "put lines without the --json-key field last")
+	runes := flag.Bool("runes", false, "count -k character positions in UTF-8 runes instead of bytes")
+	csvMode := flag.Bool("csv", false, "split key fields as comma-separated values")
+	tsvMode := flag.Bool("tsv", false, "split key fields at tabs, reading \\t, \\n and \\\\ inside a field as escapes")
+	outputFile := flag.String("o", "", "write the result to `FILE` instead of stdout; FILE may also be an input")
+	splitLines := flag.Int("split-lines", 0, "write the output into files of at most `N` lines named by --split-prefix")
+	splitBytes := flag.Int64("split-bytes", 0, "write the output into files of at most `N` bytes of whole lines named by --split-prefix")
+	splitPrefix := flag.String("split-prefix", "x", "name output files of --split-lines and --split-bytes `PREFIX`000, PREFIX001, ...")
+	header := flag.Int("header", 0, "output the first `N` lines first, unsorted")
+	prependIndex := flag.Bool("prepend-index", false, "prefix every line with its input line number and the -t separator or a tab before sorting")
+	stripIndex := flag.Bool("strip-index", false, "remove the first field and its separator from every output line")
+	keyPrefix := flag.Bool("key-prefix", false, "sort KEY<TAB>LINE input by the precomputed KEY (before -t or a tab) and output only LINE")
+	verify := flag.Bool("verify", false, "check that the output has the same lines as the input; not with -u")
+	footerLines := flag.Int("footer", 0, "output the last `N` lines last, unsorted")
+	keyName := flag.String("key-name", "", "sort by the header column `NAME` (implies --header=1)")
+	keyRegex := flag.String("key-regex", "", "use the first capture group of `RE` as the key")
+	ignoreAccents := flag.Bool("ignore-accents", false, "ignore diacritics in keys, so café equals cafe; combine with -f to ignore case too")
+	squeezeBlanks := flag.Bool("squeeze-blanks", false, "compare every run of blanks inside keys as a single space")
+	ignoreComment := flag.String("ignore-comment", "", "exclude a trailing comment starting with `CHAR` from the keys")
+	unquote := flag.Bool("unquote", false, "strip a matching pair of quotes around keys; \"\" and \\\" inside mean one quote")
+	quoteChar := flag.String("quote-char", `"`, "the quote `CHAR` stripped by --unquote")
+	reverseKey := flag.String("reverse-key", "", "compare keys reversed by `MODE`: dots (a.example.com as com.example.a) or chars")
+	keyFromByte := flag.Int("key-from-byte", 0, "use the line from byte `N` (counting from 1) to its end as the key")
+	keyTemplate := flag.String("key-template", "", "build the key from fields by `TEMPLATE`, e.g. '{2}-{1}'")
+	compressProgram := flag.String("compress-program", "", "compress temporaries with `PROG`; decompress them with PROG -d")
+	parallel := flag.Int("parallel", 0, "use at most `N` goroutines (default: number of CPUs)")
+	parallelThreshold := flag.Int("parallel-threshold", 0, "sort inputs of fewer than `N` lines in one goroutine despite --parallel or --auto (default 65536)")
+	uniqCount := flag.Bool("uniq-count", false, "count runs of lines with equal keys in already sorted input and output each run once, prefixed by its size, like uniq -c")
+	bufferSize := flag.String("S", "", "keep at most `SIZE` of input in memory before sorting externally, e.g. 500M or 50% of RAM")
+	batchSize := flag.String("batch-size", "", "merge at most `N` files at once, or a percentage of the open file limit like 50%")
+	parallelFiles := flag.Bool("parallel-files", false, "sort every input file on its own, --parallel at a time, and merge the results")
+	parallelMerge := flag.Bool("parallel-merge", false, "merge temporary files in parallel groups, bounded by --parallel")
+	mergeThreads := flag.Int("threads-for-merge", 0, "merge temporary files in `N` parallel groups, independently of --parallel (default 1)")
+	resumeDir := flag.String("resume-dir", "", "keep sorted chunks and a manifest in `DIR` to resume an interrupted external sort")
+	var progress progressMode
+	flag.Var(&progress, "progress", "report external sort progress to stderr when it is a terminal; --progress=always forces it")
+	auto := flag.Bool("auto", false, "size the memory limit by the available RAM and sort large inputs in parallel in memory")
+	inMemoryOnly := flag.Bool("in-memory-only", false, "fail instead of spilling to temporary files when the input exceeds the memory limit")
+	useMmap := flag.Bool("mmap", false, "memory-map the input file instead of reading it line by line")
+	config := flag.String("config", "", "read options from `FILE`, one name[=value] per line; command-line flags take precedence")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile to `FILE`")
+	memProfile := flag.String("memprofile", "", "write a heap profile to `FILE` after sorting")
+	transform := flag.String("transform", "", "normalize keys by the comma-separated `STEPS` in order: lower, upper, trim, squeeze, dictionary, printable, accents, reverse, strip-punct")
+	stripChars := flag.String("strip-chars", "", "trim characters in `SET` from both ends of every key before comparing")
 
-	flag.Parse()
+	// -h занят сортировкой -h, как в GNU sort, поэтому справка — только --help (или -help)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [check|merge|sort] [OPTION]... [FILE]...\n"+
+			"Sort lines of the FILEs, or of standard input. -h sorts human-readable numbers; --help prints this help.\n\n",
+			filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
 
-	source := "-"
-	var scanner *bufio.Scanner
-	if flag.NArg() > 0 {
-		source = flag.Arg(0)
-		file, err := os.Open(source)
-		if err != nil {
-			log.Fatalf("sort: cannot open '%s': %v\n", source, err)
+	verb, args := splitVerb(os.Args[1:])
+	operands := parseArgs(flag.CommandLine, args)
+	// "-" читается на своём месте среди файлов; второй раз stdin уже пуст
+	// (а при -m два чтения перемешали бы его строки), поэтому это ошибка
+	if i := slices.Index(operands, "-"); i >= 0 && slices.Contains(operands[i+1:], "-") {
+		return fmt.Errorf("sort: standard input '-' given more than once")
+	}
+	if *config != "" {
+		if err := loadConfig(flag.CommandLine, *config); err != nil {
+			return err
+		}
+	}
+	switch verb {
+	case "merge":
+		*merge = true
+	case "check":
+		*check = true
+	}
+	if *quietCheck {
+		*check = true
+	}
+
+	// Профили записываются и при ошибке сортировки: run возвращает, а не завершает процесс
+	stopProfiles, err := startProfiles(*cpuProfile, *memProfile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if profErr := stopProfiles(); err == nil {
+			err = profErr
+		}
+	}()
+
+	// Управляющие последовательности вроде \n в разделителе записей разворачиваются
+	recordSep, err := strconv.Unquote(`"` + strings.ReplaceAll(*recordSeparator, `"`, `\"`) + `"`)
+	if err != nil {
+		return fmt.Errorf("sort: invalid --record-separator %q: %v", *recordSeparator, err)
+	}
+	if recordSep != "" && (*inputZero || *outputZero) {
+		return fmt.Errorf("sort: --record-separator cannot be combined with --input-zero or --output-zero")
+	}
+	if *outputCRLF && (*zero || *outputZero || recordSep != "") {
+		return fmt.Errorf("sort: --output-crlf cannot be combined with -z, --output-zero or --record-separator")
+	}
+
+	collation := *locale
+	if collation == "" && *localeFromEnv {
+		collation = sortutil.LocaleFromEnv()
+	}
+	if err := sortutil.ValidateLocale(collation); err != nil {
+		return err
+	}
+	numberLocale := *numericLocale
+	if numberLocale == "" {
+		// Непонятная локаль окружения не ошибка: числа читаются как в C
+		if numberLocale = sortutil.NumericLocaleFromEnv(); sortutil.ValidateLocale(numberLocale) != nil {
+			numberLocale = ""
+		}
+	}
+	if err := sortutil.ValidateLocale(numberLocale); err != nil {
+		return err
+	}
+	monthLocale := *timeLocale
+	if monthLocale == "" {
+		if monthLocale = sortutil.TimeLocaleFromEnv(); sortutil.ValidateLocale(monthLocale) != nil {
+			monthLocale = ""
+		}
+	}
+	if err := sortutil.ValidateLocale(monthLocale); err != nil {
+		return err
+	}
+
+	// Как в GNU sort, -t — ровно один символ; многобайтовая руна UTF-8 — тоже один символ
+	var separatorSet bool
+	flag.Visit(func(f *flag.Flag) { separatorSet = separatorSet || f.Name == "t" })
+	switch {
+	case separatorSet && *separator == "":
+		return fmt.Errorf("sort: empty tab")
+	case *separator == `\0`:
+		*separator = "\x00"
+	case utf8.RuneCountInString(*separator) > 1:
+		return fmt.Errorf("sort: multi-character tab %q", *separator)
+	}
+
+	var widths []int
+	if *columns != "" {
+		for _, w := range strings.Split(*columns, ",") {
+			width, err := strconv.Atoi(w)
+			if err != nil || width <= 0 {
+				return fmt.Errorf("sort: invalid --columns width %q", w)
+			}
+			widths = append(widths, width)
+		}
+	}
+
+	if *requireUnique && *unique {
+		return fmt.Errorf("sort: --require-unique cannot be used with -u, which drops the duplicates")
+	}
+	if *verify && *unique {
+		return fmt.Errorf("sort: --verify cannot be used with -u, which drops lines")
+	}
+
+	if *checkFormat != "text" && *checkFormat != "json" {
+		return fmt.Errorf("sort: invalid --check-format %q: want text or json", *checkFormat)
+	}
+
+	if *partialLine != "keep" && *partialLine != "drop" {
+		return fmt.Errorf("sort: invalid --partial-line %q: want keep or drop", *partialLine)
+	}
+
+	switch *embeddedNUL {
+	case "keep", "strip", "reject":
+	default:
+		return fmt.Errorf("sort: invalid --embedded-nul %q: want keep, strip or reject", *embeddedNUL)
+	}
+
+	// --tiebreak сводит -s и --no-last-resort в одну опцию; line — порядок по умолчанию
+	keyTieBreak := false
+	switch *tiebreak {
+	case "", "line":
+	case "key":
+		keyTieBreak, *stable = true, true
+	case "none":
+		*noLastResort = true
+	case "index":
+		*stable = true
+	default:
+		return fmt.Errorf("sort: invalid --tiebreak %q: want line, key, none or index", *tiebreak)
+	}
+
+	if *keyFromByte < 0 {
+		return fmt.Errorf("sort: invalid --key-from-byte %d: bytes are counted from 1", *keyFromByte)
+	}
+
+	if *longLines != "error" && *longLines != "truncate" {
+		return fmt.Errorf("sort: invalid --long-lines %q: want error or truncate", *longLines)
+	}
+	if *maxLineLength < 0 {
+		return fmt.Errorf("sort: invalid --max-line-length %d", *maxLineLength)
+	}
+
+	var bufferBytes, batchFiles int
+	if *bufferSize != "" {
+		if bufferBytes, err = parseBufferSize(*bufferSize); err != nil {
+			return err
+		}
+	}
+	if *batchSize != "" {
+		if batchFiles, err = parseBatchSize(*batchSize); err != nil {
+			return err
+		}
+	}
+
+	var keyRE *regexp.Regexp
+	if *keyRegex != "" {
+		if keyRE, err = regexp.Compile(*keyRegex); err != nil {
+			return fmt.Errorf("sort: invalid --key-regex: %v", err)
+		}
+	}
+	if *keyPrefix && (len(keys) > 0 || *keyName != "" || *keyRegex != "" || *keyTemplate != "" || *keyFromByte > 0 || *prependIndex) {
+		return fmt.Errorf("sort: --key-prefix cannot be used with -k, --key-name, --key-regex, --key-template, --key-from-byte or --prepend-index")
+	}
+	if *ignoreComment != "" && utf8.RuneCountInString(*ignoreComment) != 1 {
+		return fmt.Errorf("sort: --ignore-comment wants a single character, got %q", *ignoreComment)
+	}
+	var transforms []string
+	if *transform != "" {
+		if transforms, err = sortutil.ParseTransforms(*transform); err != nil {
+			return err
+		}
+	}
+	if *epoch != "" && *epoch != sortutil.EpochSeconds && *epoch != sortutil.EpochMillis && *epoch != sortutil.EpochAuto {
+		return fmt.Errorf("sort: invalid --epoch %q: want s, ms or auto", *epoch)
+	}
+	if *reverseKey != "" && *reverseKey != sortutil.ReverseDots && *reverseKey != sortutil.ReverseChars {
+		return fmt.Errorf("sort: invalid --reverse-key %q: want dots or chars", *reverseKey)
+	}
+	if utf8.RuneCountInString(*quoteChar) != 1 {
+		return fmt.Errorf("sort: --quote-char wants a single character, got %q", *quoteChar)
+	}
+	var unquoteMark string
+	if *unquote {
+		unquoteMark = *quoteChar
+	}
+
+	var orderList []string
+	if *order != "" {
+		seen := make(map[string]bool)
+		for _, value := range strings.Split(*order, ",") {
+			if value == "" || seen[value] {
+				return fmt.Errorf("sort: invalid --order %q: empty or repeated value %q", *order, value)
+			}
+			seen[value] = true
+			orderList = append(orderList, value)
+		}
+	}
+	if *orderFile != "" {
+		if *order != "" {
+			return fmt.Errorf("sort: --order and --order-file cannot be used together")
+		}
+		if orderList, err = readOrderFile(*orderFile); err != nil {
+			return err
+		}
+	}
+	if *radix != 0 && (*radix < 2 || *radix > 36) {
+		return fmt.Errorf("sort: invalid --radix %d: want 2 to 36", *radix)
+	}
+	if *humanTies != "line" && *humanTies != "unit" {
+		return fmt.Errorf("sort: invalid --human-ties %q: want line or unit", *humanTies)
+	}
+	if *orderUnknown != "first" && *orderUnknown != "last" {
+		return fmt.Errorf("sort: invalid --order-unknown %q: want first or last", *orderUnknown)
+	}
+
+	var keyTmpl *sortutil.KeyTemplate
+	if *keyTemplate != "" {
+		if keyTmpl, err = sortutil.ParseKeyTemplate(*keyTemplate); err != nil {
+			return fmt.Errorf("sort: %v", err)
 		}
-		defer func() { _ = file.Close() }()
-		scanner = bufio.NewScanner(file)
-	} else {
-		scanner = bufio.NewScanner(os.Stdin)
 	}
 
 	opts := sortutil.SortOptions{
-		Reverse:      *reverse,
-		Numeric:      *numeric,
-		Month:        *month,
-		Human:        *human,
-		KeyCol:       *keyCol,
-		IgnoreBlanks: *ignoreBlanks,
-		Unique:       *unique,
+		Reverse:           *reverse,
+		Numeric:           *numeric,
+		GeneralNumeric:    *general,
+		Month:             *month,
+		Human:             *human,
+		Version:           *version,
+		Random:            *random,
+		FoldCase:          *fold,
+		Dictionary:        *dictionary,
+		IgnoreNonprinting: *nonprinting,
+		Keys:              keys,
+		IgnoreBlanks:      *ignoreBlanks,
+		StripChars:        *stripChars,
+		JSONKey:           *jsonKey,
+		JSONInvalidLast:   *jsonInvalidLast,
+		IP:                *ipMode,
+		Hex:               *hexMode,
+		Radix:             *radix,
+		Natural:           *natural,
+		Epoch:             *epoch,
+		AutoUnit:          *autoUnit,
+		Money:             *money,
+		Duration:          *duration,
+		RightAlign:        *rightAlign,
+		Bool:              *boolMode,
+		Order:             orderList,
+		OrderUnknownFirst: *orderUnknown == "first",
+		HumanUnitTies:     *humanTies == "unit",
+		KeyDefaultNumeric: *keyDefaultNumeric,
+		IPInvalidLast:     *ipInvalidLast,
+		Separator:         *separator,
+		TrimTrailingSep:   *trimTrailingSep,
+		Columns:           widths,
+		Runes:             *runes,
+		CSV:               *csvMode,
+		TSV:               *tsvMode,
+		Header:            *header,
+		Footer:            *footerLines,
+		Verify:            *verify,
+		PrependIndex:      *prependIndex,
+		StripIndex:        *stripIndex,
+		KeyPrefix:         *keyPrefix,
+		KeyName:           *keyName,
+		KeyRegex:          keyRE,
+		KeyTemplate:       keyTmpl,
+		KeyFromByte:       *keyFromByte,
+		IgnoreComment:     *ignoreComment,
+		Unquote:           unquoteMark,
+		ReverseKey:        *reverseKey,
+		Transform:         transforms,
+		SqueezeBlanks:     *squeezeBlanks,
+		IgnoreAccents:     *ignoreAccents,
+		Unique:            *unique,
+		UniqueExact:       *uniqueExact,
+		RequireUnique:     *requireUnique,
+		EnumerateGroups:   *enumerateGroups,
+		Epsilon:           *epsilon,
+		Locale:            collation,
+		NumericLocale:     numberLocale,
+		TimeLocale:        monthLocale,
+		DecimalComma:      *decimalComma,
+		Stable:            *stable,
+		NoLastResort:      *noLastResort,
+		WarnTies:          *warnTies,
+		AssertStable:      *assertStable || os.Getenv("SORT_ASSERT_STABLE") != "",
+		KeyTieBreak:       keyTieBreak,
+		ZeroTerminated:    *zero,
+		InputZero:         *inputZero,
+		OutputZero:        *outputZero,
+		OutputCRLF:        *outputCRLF,
+		RecordSeparator:   recordSep,
+		RecordKeyLine:     *recordKeyLine,
+		Unbuffered:        *unbuffered,
+		DropPartial:       *partialLine == "drop",
+		StripNUL:          *embeddedNUL == "strip",
+		RejectNUL:         *embeddedNUL == "reject",
+		MaxLineLength:     *maxLineLength,
+		SkipBlank:         *skipBlank,
+		TruncateLong:      *longLines == "truncate",
+		Summary:           *summary,
+		Group:             *group,
+		OnlyKeys:          *onlyKeys,
+		PadWidth:          *padWidth,
+		CheckInputs:       *checkInputs,
+		CheckStrict:       *checkStrict,
+		TotalOrderCheck:   *totalOrderCheck,
+		InMemoryOnly:      *inMemoryOnly,
+		Auto:              *auto,
+		IgnoreMissing:     *ignoreMissing,
+		TempDirs:          tempDirs,
+		Scale:             scales,
+		ResumeDir:         *resumeDir,
+		CompressProgram:   *compressProgram,
+		Parallel:          *parallel,
+		ParallelThreshold: *parallelThreshold,
+		BufferSize:        bufferBytes,
+		BatchSize:         batchFiles,
+		ParallelMerge:     *parallelMerge,
+		MergeThreads:      *mergeThreads,
+		Progress:          progress.writer(),
 	}
 
-	if *check {
-		err := sortutil.CheckSorting(scanner, source, opts)
-		if err != nil {
-			log.Fatal(err)
+	if *parallel < 0 || *parallelThreshold < 0 || *mergeThreads < 0 {
+		return fmt.Errorf("sort: invalid --parallel, --parallel-threshold or --threads-for-merge: must not be negative")
+	}
+
+	var output io.Writer = os.Stdout
+	if *splitLines < 0 || *splitBytes < 0 {
+		return fmt.Errorf("sort: invalid --split-lines or --split-bytes: must not be negative")
+	}
+	if *splitLines > 0 || *splitBytes > 0 {
+		if *check || *checkStrict || *mergeIntoFile != "" || *outputFile != "" {
+			return fmt.Errorf("sort: --split-lines and --split-bytes cannot be used with -c, -o or --merge-into")
 		}
-		return
+		split := sortutil.NewSplitWriter(*splitPrefix, *splitLines, *splitBytes, opts)
+		defer func() {
+			if closeErr := split.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		output = split
+	}
+	if *outputFile != "" {
+		if *check || *checkStrict || *mergeIntoFile != "" {
+			return fmt.Errorf("sort: -o cannot be used with -c or --merge-into")
+		}
+		out, createErr := createReplacement(*outputFile)
+		if createErr != nil {
+			return createErr
+		}
+		defer func() { err = out.commit(err) }()
+		output = out
+	}
+
+	if *parallelFiles && (*check || *checkStrict || *merge || *mergeIntoFile != "" || opts.Header > 0 || opts.Footer > 0 ||
+		opts.KeyName != "" || opts.Summary || opts.Verify || opts.PrependIndex || opts.ResumeDir != "" || opts.InMemoryOnly) {
+		return fmt.Errorf("sort: --parallel-files cannot be used with -c, -m, --merge-into, --header, --footer, --key-name, --summary, --verify, --prepend-index, --resume-dir or --in-memory-only")
+	}
+
+	if *uniqCount && (*check || *checkStrict || *merge || *mergeIntoFile != "") {
+		return fmt.Errorf("sort: --uniq-count cannot be used with -c, -m or --merge-into")
+	}
+
+	if *mergeIntoFile != "" {
+		if *check || *checkStrict || opts.Header > 0 || opts.Footer > 0 {
+			return fmt.Errorf("sort: --merge-into cannot be used with -c, --header or --footer")
+		}
+		sources := operands
+		if len(sources) == 0 {
+			sources = []string{"-"}
+		}
+		return formatDisorder(mergeInto(*mergeIntoFile, sources, *merge, opts), *checkFormat)
+	}
+
+	if *merge {
+		sources := operands
+		if len(sources) == 0 {
+			sources = []string{"-"}
+		}
+		return formatDisorder(sortutil.MergeSorted(sources, output, opts), *checkFormat)
 	}
 
-	// Попытка in-memory сортировки
-	lines, err := sortutil.ReadLinesWithLimit(scanner)
+	sources := operands
+	if len(sources) == 0 {
+		sources = []string{"-"}
+	}
+	if (*check || *checkStrict) && len(sources) > 1 {
+		return fmt.Errorf("sort: extra operand '%s' not allowed with -c", sources[1])
+	}
+	if *uniqCount && len(sources) > 1 {
+		return fmt.Errorf("sort: extra operand '%s' not allowed with --uniq-count", sources[1])
+	}
+	inputs, closeInputs, err := openInputs(sources, *ignoreMissing)
 	if err != nil {
-		if errors.Is(err, sortutil.ErrInputTooLarge) {
-			err = sortutil.ExternalSort(scanner, opts, lines)
-			if err != nil {
-				log.Fatal(err)
-			}
-			return
+		return err
+	}
+	defer closeInputs()
+	if *parallelFiles && len(inputs) > 1 {
+		return sortutil.SortInputs(inputs, output, opts)
+	}
+	source := sources[0]
+	input := sortutil.JoinInputs(inputs, opts)
+	opts.ResumeInputs = sources
+
+	if *check || *checkStrict {
+		err := sortutil.CheckSorting(sortutil.NewLineReader(input, opts), source, opts)
+		var disorder *sortutil.Disorder
+		if *quietCheck && errors.As(err, &disorder) {
+			// -C только сообщает код выхода: ввод дальше нарушения не читается
+			return errUnsorted
 		}
-		log.Fatal(err)
+		if source == "-" && errors.As(err, &disorder) {
+			// Остаток канала дочитывается, чтобы пишущий в него процесс завершился
+			// сам, а не от SIGPIPE посреди записи; код выхода решает main
+			_, _ = io.Copy(io.Discard, input)
+		}
+		return formatDisorder(err, *checkFormat)
+	}
+
+	if *uniqCount {
+		return formatDisorder(sortutil.CountUnique(sortutil.NewLineReader(input, opts), output, source, opts), *checkFormat)
 	}
 
-	lines = sortutil.SortInMemory(lines, opts)
-	for _, line := range lines {
-		fmt.Println(line)
+	if *useMmap && len(sources) == 1 && source != "-" {
+		return sortutil.SortMapped(source, output, opts)
 	}
+
+	return sortutil.Sort(input, output, opts)
 }
@@ -0,0 +1,1820 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"unix-sort/sortutil"
+)
+
+// TestMain runs main instead of the tests when SORT_TEST_MAIN is set:
+// runSort re-executes the test binary this way to test the command line.
+func TestMain(m *testing.M) {
+	if os.Getenv("SORT_TEST_MAIN") == "1" {
+		// Флаги пакета testing уже зарегистрированы: main получает чистый набор
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		// Как у настоящего flag.CommandLine, справка выводится через flag.Usage
+		flag.CommandLine.Usage = func() { flag.Usage() }
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// result is what a run of the command left behind.
+type result struct {
+	stdout, stderr string
+	code           int
+}
+
+// runSort runs sort with args in dir, feeding it stdin.
+func runSort(t *testing.T, dir, stdin string, args ...string) result {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "SORT_TEST_MAIN=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		t.Fatalf("run sort %q: %v", args, err)
+	}
+	return result{stdout.String(), stderr.String(), cmd.ProcessState.ExitCode()}
+}
+
+// writeFiles creates the named files with their contents in a new directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// TestOperandPlacement checks that flags are honoured before and after the
+// file operand, and that everything after "--" is an operand.
+func TestOperandPlacement(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"file":  "10\n9\n100\n",
+		"-file": "b\na\n",
+	})
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"flag first", []string{"-n", "file"}, "9\n10\n100\n"},
+		{"flag last", []string{"file", "-n"}, "9\n10\n100\n"},
+		{"flags around", []string{"-r", "file", "-n"}, "100\n10\n9\n"},
+		{"no flag", []string{"file"}, "10\n100\n9\n"},
+		{"dash file after --", []string{"--", "-file"}, "a\nb\n"},
+		{"flag before --", []string{"-r", "--", "-file"}, "b\na\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := runSort(t, dir, "", c.args...)
+			if res.code != 0 || res.stdout != c.want {
+				t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=0 stdout %q",
+					c.args, res.code, res.stdout, res.stderr, c.want)
+			}
+		})
+	}
+}
+
+// TestBundledFlags checks that one-letter flags can be bundled as in GNU
+// sort, with a value flag taking the rest of the bundle or the next argument,
+// and that a bundle with an unknown letter is an error.
+func TestBundledFlags(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"file":   "10\n9\n100\n",
+		"blanks": "B\n a\nc\n",
+		"fields": "b:2\na:10\nc:1\n",
+	})
+	cases := []struct {
+		name  string
+		args  []string
+		stdin string
+		code  int
+		want  string
+	}{
+		{"-nr", []string{"-nr", "file"}, "", 0, "100\n10\n9\n"},
+		{"-rn after file", []string{"file", "-rn"}, "", 0, "100\n10\n9\n"},
+		{"-bf", []string{"-bf", "blanks"}, "", 0, " a\nB\nc\n"},
+		{"-t: -k2n", []string{"-t:", "-k2n", "fields"}, "", 0, "c:1\nb:2\na:10\n"},
+		{"value flag last in bundle", []string{"-nt", ":", "-k2", "fields"}, "", 0, "c:1\nb:2\na:10\n"},
+		{"value in bundle", []string{"-rnt:", "-k2", "fields"}, "", 0, "a:10\nb:2\nc:1\n"},
+		{"-cz sorted", []string{"-cz"}, "a\x00b\x00", 0, ""},
+		{"-cz unsorted", []string{"-cz"}, "b\x00a\x00", 1, ""},
+		{"unknown letter", []string{"-nq", "file"}, "", 2, ""},
+		{"bundle after --", []string{"-n", "--", "-nr"}, "", 2, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := runSort(t, dir, c.stdin, c.args...)
+			if res.code != c.code || res.stdout != c.want {
+				t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q",
+					c.args, res.code, res.stdout, res.stderr, c.code, c.want)
+			}
+		})
+	}
+}
+
+// TestAttachedValues checks that a value may be attached to a one-letter flag,
+// as in GNU sort: "-k2,2h" is "-k 2,2h", and that a separate value starting
+// with "-" is not taken for a flag.
+func TestAttachedValues(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"file": "a-2 1M\na-10 1K\na-1 1K\n"})
+	tmp := t.TempDir()
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"keys", []string{"-k2,2h", "-k1,1V", "file"}, "a-1 1K\na-10 1K\na-2 1M\n"},
+		{"separator", []string{"-t-", "-k2,2n", "file"}, "a-1 1K\na-2 1M\na-10 1K\n"},
+		{"separate dash value", []string{"-t", "-", "-k", "2,2n", "file"}, "a-1 1K\na-2 1M\na-10 1K\n"},
+		{"buffer and temporary directory", []string{"-S1b", "-T" + tmp, "-k2,2h", "-k1,1V", "file"}, "a-1 1K\na-10 1K\na-2 1M\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := runSort(t, dir, "", c.args...)
+			if res.code != 0 || res.stdout != c.want {
+				t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=0 stdout %q",
+					c.args, res.code, res.stdout, res.stderr, c.want)
+			}
+		})
+	}
+
+	res := runSort(t, dir, "", "-oout", "-k2,2h", "-k1,1V", "file")
+	if got, err := os.ReadFile(filepath.Join(dir, "out")); res.code != 0 || err != nil || string(got) != "a-1 1K\na-10 1K\na-2 1M\n" {
+		t.Errorf("sort -oout: rc=%d stderr %q, out %q (%v)", res.code, res.stderr, got, err)
+	}
+}
+
+// TestOperandStdin checks that with no operand sort reads stdin, and that a
+// flag-like operand after "--" is not taken for a flag.
+func TestOperandStdin(t *testing.T) {
+	res := runSort(t, t.TempDir(), "b\na\n", "-r")
+	if res.code != 0 || res.stdout != "b\na\n" {
+		t.Errorf("sort -r < stdin: rc=%d stdout %q stderr %q", res.code, res.stdout, res.stderr)
+	}
+	res = runSort(t, t.TempDir(), "", "--", "-n")
+	if res.code == 0 || !strings.Contains(res.stderr, "sort: cannot open '-n'") {
+		t.Errorf("sort -- -n: rc=%d stderr %q, want a missing file '-n'", res.code, res.stderr)
+	}
+}
+
+// TestPartialLineFlag checks the values of --partial-line.
+func TestPartialLineFlag(t *testing.T) {
+	cases := []struct {
+		value, want string
+		code        int
+	}{
+		{"keep", "a\nb\nc\n", 0},
+		{"drop", "a\nb\n", 0},
+		{"wait", "", 2},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), "b\na\nc", "--partial-line", c.value)
+		if res.code != c.code || res.stdout != c.want {
+			t.Errorf("--partial-line %s: rc=%d stdout %q stderr %q, want rc=%d stdout %q",
+				c.value, res.code, res.stdout, res.stderr, c.code, c.want)
+		}
+	}
+}
+
+// TestCheckKeyChainCommand runs -c with two keys on a file ordered by the
+// composite key only: it passes, and each key alone reports the disorder.
+func TestCheckKeyChainCommand(t *testing.T) {
+	input := "c 1\nb 1\nb 2\na 2\n"
+	cases := []struct {
+		args   []string
+		code   int
+		stderr string
+	}{
+		{[]string{"-c", "-k", "2n", "-k", "1r"}, 0, ""},
+		{[]string{"-c", "-k", "2n"}, 1, "sort: -:2: disorder: b 1\n"},
+		{[]string{"-c", "-k", "1r"}, 1, "sort: -:3: disorder: b 2\n"},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), input, c.args...)
+		if res.code != c.code || res.stderr != c.stderr {
+			t.Errorf("sort %q: rc=%d stderr %q, want rc=%d stderr %q", c.args, res.code, res.stderr, c.code, c.stderr)
+		}
+	}
+}
+
+// TestCheckHeaderFlag checks that -c --header=N passes a sorted file with a
+// header and reports a disorder with its line number in the whole file.
+func TestCheckHeaderFlag(t *testing.T) {
+	cases := []struct {
+		input  string
+		args   []string
+		code   int
+		stderr string
+	}{
+		{"name\na\nb\n", []string{"-c"}, 1, "sort: -:2: disorder: a\n"},
+		{"name\na\nb\n", []string{"-c", "--header=1"}, 0, ""},
+		{"name\na\nc\nb\n", []string{"-c", "--header=1"}, 1, "sort: -:4: disorder: b\n"},
+		{"name,n\nb,1\na,2\n", []string{"-c", "--csv", "--key-name", "n", "-n"}, 0, ""},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), c.input, c.args...)
+		if res.code != c.code || res.stderr != c.stderr {
+			t.Errorf("sort %q: rc=%d stderr %q, want rc=%d stderr %q", c.args, res.code, res.stderr, c.code, c.stderr)
+		}
+	}
+}
+
+// TestLocaleFlags checks that --locale-from-env takes the collation from
+// LC_COLLATE, that --locale overrides it and that an unknown locale fails.
+func TestLocaleFlags(t *testing.T) {
+	t.Setenv("LC_ALL", "")
+	t.Setenv("LC_COLLATE", "de_DE.UTF-8")
+	input := "b\nä\na\n"
+	cases := []struct {
+		args []string
+		code int
+		want string
+	}{
+		{nil, 0, "a\nb\nä\n"},
+		{[]string{"--locale-from-env"}, 0, "a\nä\nb\n"},
+		{[]string{"--locale-from-env", "--locale", "C"}, 0, "a\nb\nä\n"},
+		{[]string{"--locale", "not a locale"}, 2, ""},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), input, c.args...)
+		if res.code != c.code || res.stdout != c.want {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want)
+		}
+	}
+}
+
+// TestNumericLocaleFlags checks that -n reads numbers in the locale of
+// LC_NUMERIC, that --numeric-locale and --decimal-comma override it, that an
+// unknown environment locale falls back to C and that a bad flag fails.
+func TestNumericLocaleFlags(t *testing.T) {
+	input := "2\n1,5\n1.25\n"
+	cases := []struct {
+		env  string
+		args []string
+		code int
+		want string
+	}{
+		{"", []string{"-n"}, 0, "1,5\n1.25\n2\n"},
+		{"de_DE.UTF-8", []string{"-n"}, 0, "1,5\n2\n1.25\n"},
+		{"de_DE.UTF-8", []string{"-n", "--numeric-locale", "C"}, 0, "1,5\n1.25\n2\n"},
+		{"", []string{"-n", "--numeric-locale", "de_DE"}, 0, "1,5\n2\n1.25\n"},
+		{"", []string{"-n", "--decimal-comma"}, 0, "1.25\n1,5\n2\n"},
+		{"not a locale", []string{"-n"}, 0, "1,5\n1.25\n2\n"},
+		{"", []string{"-n", "--numeric-locale", "not a locale"}, 2, ""},
+	}
+	for _, c := range cases {
+		t.Setenv("LC_ALL", "")
+		t.Setenv("LC_NUMERIC", c.env)
+		res := runSort(t, t.TempDir(), input, c.args...)
+		if res.code != c.code || res.stdout != c.want {
+			t.Errorf("LC_NUMERIC=%q sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q",
+				c.env, c.args, res.code, res.stdout, res.stderr, c.code, c.want)
+		}
+	}
+}
+
+// TestRecordSeparatorFlag checks that escapes in --record-separator are
+// expanded and that a malformed one is rejected.
+func TestRecordSeparatorFlag(t *testing.T) {
+	input := "b\n2\n\na\n1\n\n"
+	res := runSort(t, t.TempDir(), input, `--record-separator=\n\n`)
+	if want := "a\n1\n\nb\n2\n\n"; res.code != 0 || res.stdout != want {
+		t.Errorf(`--record-separator=\n\n: rc=%d stdout %q stderr %q, want %q`, res.code, res.stdout, res.stderr, want)
+	}
+	res = runSort(t, t.TempDir(), input, `--record-separator=\q`)
+	if res.code == 0 || !strings.Contains(res.stderr, "sort: invalid --record-separator") {
+		t.Errorf(`--record-separator=\q: rc=%d stderr %q, want an error`, res.code, res.stderr)
+	}
+}
+
+// TestProfileFlags checks that --cpuprofile and --memprofile write non-empty
+// profiles, and that the profiles are written even when the sort fails.
+func TestProfileFlags(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"success", nil, 0},
+		{"missing input", []string{"missing"}, 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			args := append([]string{"--cpuprofile", "cpu.prof", "--memprofile", "mem.prof"}, c.args...)
+			res := runSort(t, dir, "b\na\n", args...)
+			if res.code != c.code {
+				t.Fatalf("sort %q: rc=%d stderr %q, want rc=%d", args, res.code, res.stderr, c.code)
+			}
+			for _, name := range []string{"cpu.prof", "mem.prof"} {
+				info, err := os.Stat(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Size() == 0 {
+					t.Errorf("%s is empty", name)
+				}
+			}
+		})
+	}
+}
+
+// TestSeparatorFlag checks the values of -t: one byte, one multi-byte rune and
+// \0 are accepted, while an empty or multi-character separator is rejected.
+func TestSeparatorFlag(t *testing.T) {
+	cases := []struct {
+		name   string
+		sep    string
+		input  string
+		code   int
+		want   string
+		stderr string
+	}{
+		{"single byte", ":", "a:2\nb:1\n", 0, "b:1\na:2\n", ""},
+		{"multi-byte rune", "·", "a·2\nb·1\n", 0, "b·1\na·2\n", ""},
+		{"NUL", `\0`, "a\x002\nb\x001\n", 0, "b\x001\na\x002\n", ""},
+		{"empty", "", "a\n", 2, "", "sort: empty tab"},
+		{"multi-character", "::", "a\n", 2, "", `sort: multi-character tab "::"`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := runSort(t, t.TempDir(), c.input, "-t", c.sep, "-k", "2")
+			if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+				t.Errorf("sort -t %q -k 2: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+					c.sep, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+			}
+		})
+	}
+}
+
+// TestEmbeddedNULFlag checks the values of --embedded-nul.
+func TestEmbeddedNULFlag(t *testing.T) {
+	cases := []struct {
+		value, want string
+		code        int
+	}{
+		{"keep", "a\x00\nb\n", 0},
+		{"strip", "a\nb\n", 0},
+		{"reject", "", 2},
+		{"drop", "", 2},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), "b\na\x00\n", "--embedded-nul", c.value)
+		if res.code != c.code || res.stdout != c.want {
+			t.Errorf("--embedded-nul %s: rc=%d stdout %q stderr %q, want rc=%d stdout %q",
+				c.value, res.code, res.stdout, res.stderr, c.code, c.want)
+		}
+	}
+}
+
+// TestProgressMode checks the values of --progress and that auto reports
+// only to a terminal: with stderr redirected it is off.
+func TestProgressMode(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close(); _ = w.Close() }()
+	saved := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = saved }()
+
+	cases := []struct {
+		value string
+		on    bool
+		err   bool
+	}{
+		{"true", false, false}, // голый --progress
+		{"auto", false, false},
+		{"always", true, false},
+		{"never", false, false},
+		{"false", false, false},
+		{"sometimes", false, true},
+	}
+	for _, c := range cases {
+		var p progressMode
+		if err := p.Set(c.value); (err != nil) != c.err {
+			t.Errorf("--progress=%s: err = %v, want error %v", c.value, err, c.err)
+			continue
+		}
+		if on := p.writer() != nil; on != c.on {
+			t.Errorf("--progress=%s with stderr not a terminal: on = %v, want %v", c.value, on, c.on)
+		}
+	}
+}
+
+// TestIgnoreMissing checks that --ignore-missing sorts and merges the files
+// that open, warns about the rest, and fails only when none opens.
+func TestIgnoreMissing(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"one": "c\na\n",
+		"two": "b\n",
+	})
+	warning := "sort: cannot open 'missing': "
+	cases := []struct {
+		name   string
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{"sort", []string{"--ignore-missing", "one", "missing", "two"}, 0, "a\nb\nc\n", warning},
+		{"merge", []string{"--ignore-missing", "-m", "two", "missing"}, 0, "b\n", warning},
+		{"none opens", []string{"--ignore-missing", "missing", "gone"}, 2, "", "sort: none of the 2 input files could be opened"},
+		{"merge none opens", []string{"--ignore-missing", "-m", "missing"}, 2, "", "sort: none of the 1 input files could be opened"},
+		{"without the flag", []string{"one", "missing"}, 2, "", warning},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res := runSort(t, dir, "", c.args...)
+			if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+				t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr containing %q",
+					c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+			}
+		})
+	}
+}
+
+// TestColumnsFlag checks that --columns takes positive widths only.
+func TestColumnsFlag(t *testing.T) {
+	cases := []struct {
+		value, want string
+		code        int
+	}{
+		{"1,2", "xb2\nya1\n", 0},
+		{"1,x", "", 2},
+		{"1,0", "", 2},
+		{"1,,2", "", 2},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), "ya1\nxb2\n", "--columns", c.value, "-k", "3,3r")
+		if res.code != c.code || res.stdout != c.want {
+			t.Errorf("--columns %s: rc=%d stdout %q stderr %q, want rc=%d stdout %q",
+				c.value, res.code, res.stdout, res.stderr, c.code, c.want)
+		}
+		if c.code != 0 && !strings.Contains(res.stderr, "sort: invalid --columns width") {
+			t.Errorf("--columns %s: stderr %q, want an invalid width", c.value, res.stderr)
+		}
+	}
+}
+
+// TestCheckFormat checks that --check-format=json reports disorder as one
+// JSON object with the exit code of -c, for -c and for -m --check-inputs.
+func TestCheckFormat(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"unsorted": "1 b\n1 a\n"})
+	cases := []struct {
+		args []string
+		want map[string]any
+	}{
+		{[]string{"-c", "--check-format=json", "-k", "1,1n", "-k", "2,2"},
+			map[string]any{"source": "-", "line": 2.0, "previous": "1 b", "current": "1 a", "key": 2.0}},
+		{[]string{"-m", "--check-inputs", "--check-format=json", "unsorted"},
+			map[string]any{"source": "unsorted", "line": 2.0, "previous": "1 b", "current": "1 a", "key": 1.0}},
+	}
+	for _, c := range cases {
+		res := runSort(t, dir, "1 b\n1 a\n", c.args...)
+		var got map[string]any
+		if err := json.Unmarshal([]byte(res.stderr), &got); err != nil || res.code != 1 {
+			t.Errorf("sort %q: rc=%d stderr %q (%v), want rc=1 and JSON", c.args, res.code, res.stderr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("sort %q: report %v, want %v", c.args, got, c.want)
+		}
+	}
+
+	res := runSort(t, dir, "b\na\n", "-c")
+	if want := "sort: -:2: disorder: a\n"; res.code != 1 || res.stderr != want {
+		t.Errorf("sort -c: rc=%d stderr %q, want rc=1 stderr %q", res.code, res.stderr, want)
+	}
+	res = runSort(t, dir, "", "--check-format=xml")
+	if res.code != 2 || !strings.Contains(res.stderr, `sort: invalid --check-format "xml"`) {
+		t.Errorf("--check-format=xml: rc=%d stderr %q, want an invalid format", res.code, res.stderr)
+	}
+}
+
+// TestVerbs checks that each verb behaves like its flag, and that a file
+// named like a verb is reached through ./ or after --.
+func TestVerbs(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"one":    "a\nc\n",
+		"two":    "b\nd\n",
+		"merge":  "z\ny\n",
+		"sorted": "a\nb\n",
+		"mixed":  "b\na\n",
+	})
+	cases := []struct {
+		verb, flag []string
+	}{
+		{[]string{"sort", "-r", "mixed"}, []string{"-r", "mixed"}},
+		{[]string{"merge", "one", "two"}, []string{"-m", "one", "two"}},
+		{[]string{"check", "sorted"}, []string{"-c", "sorted"}},
+		{[]string{"check", "mixed"}, []string{"-c", "mixed"}},
+		{[]string{"sort", "--", "merge"}, []string{"./merge"}},
+	}
+	for _, c := range cases {
+		got, want := runSort(t, dir, "", c.verb...), runSort(t, dir, "", c.flag...)
+		if got != want {
+			t.Errorf("sort %q = %+v, want %+v as sort %q", c.verb, got, want, c.flag)
+		}
+	}
+
+	if res := runSort(t, dir, "", "merge", "one", "two"); res.stdout != "a\nb\nc\nd\n" {
+		t.Errorf("sort merge one two: stdout %q", res.stdout)
+	}
+	if res := runSort(t, dir, "", "check", "mixed"); res.code != 1 || res.stderr != "sort: mixed:2: disorder: a\n" {
+		t.Errorf("sort check mixed: rc=%d stderr %q, want a disorder", res.code, res.stderr)
+	}
+	if res := runSort(t, dir, "", "./merge"); res.code != 0 || res.stdout != "y\nz\n" {
+		t.Errorf("sort ./merge: rc=%d stdout %q stderr %q", res.code, res.stdout, res.stderr)
+	}
+}
+
+// TestConfigFile checks that --config sets the same options as the command
+// line, that flags given on the command line take precedence, and that a bad
+// line is reported with its number.
+func TestConfigFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"keys.conf":      "# сначала число, потом имя в обратном порядке\nt=:\n\nk=2,2n\n--k = 1,1r\n",
+		"reverse.conf":   "r\nt=:\n",
+		"unknown.conf":   "t=:\nsize=1\n",
+		"novalue.conf":   "k\n",
+		"invalid.conf":   "n=maybe\n",
+		"recursive.conf": "config=keys.conf\n",
+	})
+	input := "b:10\na:9\nc:9\n"
+	cases := []struct {
+		name       string
+		config     []string
+		equivalent []string
+	}{
+		{"keys", []string{"--config", "keys.conf"}, []string{"-t", ":", "-k", "2,2n", "-k", "1,1r"}},
+		{"bool flag", []string{"--config", "reverse.conf"}, []string{"-r", "-t", ":"}},
+		{"command line wins", []string{"--config", "keys.conf", "-k", "1,1"}, []string{"-t", ":", "-k", "1,1"}},
+		{"command line wins after operand", []string{"--config=reverse.conf", "-", "-r=false"}, []string{"-t", ":"}},
+	}
+	for _, c := range cases {
+		got, want := runSort(t, dir, input, c.config...), runSort(t, dir, input, c.equivalent...)
+		if got.code != 0 || got != want {
+			t.Errorf("%s: sort %q = %+v, want %+v as sort %q", c.name, c.config, got, want, c.equivalent)
+		}
+	}
+
+	bad := []struct {
+		config, stderr string
+	}{
+		{"unknown.conf", `sort: unknown.conf:2: unknown option "size"`},
+		{"novalue.conf", `sort: novalue.conf:1: option "k" needs a value`},
+		{"invalid.conf", `sort: invalid.conf:1: invalid value "maybe" for n`},
+		{"recursive.conf", `sort: recursive.conf:1: unknown option "config"`},
+		{"missing.conf", "sort: cannot read config"},
+	}
+	for _, c := range bad {
+		res := runSort(t, dir, input, "--config", c.config)
+		if res.code != 2 || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("--config %s: rc=%d stderr %q, want rc=2 stderr %q", c.config, res.code, res.stderr, c.stderr)
+		}
+	}
+}
+
+// TestVerifyFlag checks that --verify leaves the output alone and is refused
+// with -u, which drops lines on purpose.
+func TestVerifyFlag(t *testing.T) {
+	res := runSort(t, t.TempDir(), "b\na\nb\n", "--verify")
+	if res.code != 0 || res.stdout != "a\nb\nb\n" {
+		t.Errorf("--verify: rc=%d stdout %q stderr %q", res.code, res.stdout, res.stderr)
+	}
+	res = runSort(t, t.TempDir(), "b\na\nb\n", "--verify", "-u")
+	if res.code != 2 || !strings.Contains(res.stderr, "sort: --verify cannot be used with -u") {
+		t.Errorf("--verify -u: rc=%d stderr %q, want an error", res.code, res.stderr)
+	}
+}
+
+// TestIgnoreCommentFlag checks that --ignore-comment takes one character.
+func TestIgnoreCommentFlag(t *testing.T) {
+	res := runSort(t, t.TempDir(), "b # 1\na # 2\n", "--ignore-comment", "#")
+	if res.code != 0 || res.stdout != "a # 2\nb # 1\n" {
+		t.Errorf("--ignore-comment '#': rc=%d stdout %q stderr %q", res.code, res.stdout, res.stderr)
+	}
+	res = runSort(t, t.TempDir(), "a\n", "--ignore-comment", "//")
+	if res.code != 2 || !strings.Contains(res.stderr, `sort: --ignore-comment wants a single character, got "//"`) {
+		t.Errorf("--ignore-comment //: rc=%d stderr %q, want an error", res.code, res.stderr)
+	}
+}
+
+// TestOrderFlags checks the values of --order and --order-unknown.
+func TestOrderFlags(t *testing.T) {
+	cases := []struct {
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{[]string{"--order", "low,high"}, 0, "low\nhigh\nx\n", ""},
+		{[]string{"--order", "low,high", "--order-unknown", "first"}, 0, "x\nlow\nhigh\n", ""},
+		{[]string{"--order", "low,,high"}, 2, "", `sort: invalid --order "low,,high": empty or repeated value ""`},
+		{[]string{"--order", "low,low"}, 2, "", `repeated value "low"`},
+		{[]string{"--order", "low", "--order-unknown", "middle"}, 2, "", `sort: invalid --order-unknown "middle"`},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), "high\nx\nlow\n", c.args...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestReadOrderFile reads --order-file lists with blanks, empty lines and
+// repeated values.
+func TestReadOrderFile(t *testing.T) {
+	cases := []struct {
+		data string
+		want []string
+		err  string
+	}{
+		{"low\nmedium\nhigh\n", []string{"low", "medium", "high"}, ""},
+		{"  low \n\n\thigh\r\nlow\n", []string{"low", "high"}, ""},
+		{"high", []string{"high"}, ""},
+		{"\n  \n", nil, "lists no values"},
+	}
+	for _, c := range cases {
+		dir := writeFiles(t, map[string]string{"order": c.data})
+		got, err := readOrderFile(filepath.Join(dir, "order"))
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("readOrderFile(%q): err %v, want %q", c.data, err, c.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("readOrderFile(%q) = %q, %v, want %q", c.data, got, err, c.want)
+		}
+	}
+	if _, err := readOrderFile(filepath.Join(t.TempDir(), "missing")); err == nil || !strings.Contains(err.Error(), "sort: cannot read --order-file") {
+		t.Errorf("missing file: err %v", err)
+	}
+}
+
+// TestOrderFileFlag sorts a CSV column by the category order of another file.
+func TestOrderFileFlag(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"B": "critical\nhigh\nmedium\nlow\n",
+		"A": "1,disk,low\n2,cpu,critical\n3,net,unknown\n4,mem,medium\n5,io,critical\n",
+	})
+	cases := []struct {
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{[]string{"-t", ",", "-k", "3,3", "--order-file", "B", "A"}, 0, "2,cpu,critical\n5,io,critical\n4,mem,medium\n1,disk,low\n3,net,unknown\n", ""},
+		{[]string{"-t", ",", "-k", "3,3", "--order-file", "B", "--order-unknown", "first", "-r", "A"}, 0, "1,disk,low\n4,mem,medium\n5,io,critical\n2,cpu,critical\n3,net,unknown\n", ""},
+		{[]string{"--order-file", "B", "--order", "low", "A"}, 2, "", "sort: --order and --order-file cannot be used together"},
+		{[]string{"--order-file", "missing", "A"}, 2, "", "sort: cannot read --order-file"},
+	}
+	for _, c := range cases {
+		res := runSort(t, dir, "", c.args...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestZeroFlags checks --input-zero and --output-zero on their own and
+// their conflict with --record-separator.
+func TestZeroFlags(t *testing.T) {
+	cases := []struct {
+		input  string
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{"b\na\n", []string{"--output-zero"}, 0, "a\x00b\x00", ""},
+		{"b\x00a\x00", []string{"--input-zero"}, 0, "a\nb\n", ""},
+		{"b\x00a\x00", []string{"--input-zero", "--output-zero"}, 0, "a\x00b\x00", ""},
+		{"b\na\n", []string{"--output-zero", "--record-separator=;"}, 2, "",
+			"sort: --record-separator cannot be combined with --input-zero or --output-zero"},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), c.input, c.args...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestRequireUniqueFlag checks the exit status of --require-unique and that
+// it is rejected together with -u.
+func TestRequireUniqueFlag(t *testing.T) {
+	cases := []struct {
+		input  string
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{"b\na\n", []string{"--require-unique"}, 0, "a\nb\n", ""},
+		{"1 b\n2 a\n1 c\n", []string{"--require-unique", "-k", "1,1"}, 1, "",
+			`sort: duplicate key in sorted lines 1 and 2: "1 b" and "1 c"`},
+		{"b\na\n", []string{"--require-unique", "-u"}, 2, "", "sort: --require-unique cannot be used with -u"},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), c.input, c.args...)
+		if res.code != c.code || !strings.HasPrefix(res.stdout, c.want) || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestScaleFlag checks --scale parsing: a later factor for the same field
+// wins, and malformed values are rejected.
+func TestScaleFlag(t *testing.T) {
+	cases := []struct {
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{[]string{"-k", "1,1n", "--scale=1:-1"}, 0, "10\n2\n1\n", ""},
+		{[]string{"-k", "1,1n", "--scale=1:-1", "--scale=1:2"}, 0, "1\n2\n10\n", ""},
+		{[]string{"--scale=1"}, 2, "", `invalid scale "1": want FIELD:FACTOR with FIELD from 1`},
+		{[]string{"--scale=0:2"}, 2, "", `invalid scale "0:2"`},
+		{[]string{"--scale=1:x"}, 2, "", `invalid scale "1:x"`},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), "2\n10\n1\n", c.args...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestFieldHintFlag checks that -k2 on single-column input sorts as usual
+// and hints at -t on stderr.
+func TestFieldHintFlag(t *testing.T) {
+	res := runSort(t, t.TempDir(), "b\na\n", "-k", "2")
+	want := "sort: hint: field 2 is missing in 2 of 2 lines; fields are separated by blanks, use -t to set the separator\n"
+	if res.code != 0 || res.stdout != "a\nb\n" || res.stderr != want {
+		t.Errorf("sort -k 2: rc=%d stdout %q stderr %q, want the hint %q", res.code, res.stdout, res.stderr, want)
+	}
+	if res = runSort(t, t.TempDir(), "b\na\n", "-k", "2", "-t", ","); res.stderr != "" {
+		t.Errorf("sort -k 2 -t ,: stderr %q, want no hint", res.stderr)
+	}
+}
+
+// TestMaxLineLengthFlag checks the exit status of --max-line-length and the
+// validation of --long-lines.
+func TestMaxLineLengthFlag(t *testing.T) {
+	cases := []struct {
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{[]string{"--max-line-length=4"}, 2, "", "sort: line 1 is longer than 4 bytes (--max-line-length)"},
+		{[]string{"--max-line-length=5"}, 0, "a\nbbbbb\n", ""},
+		{[]string{"--max-line-length=2", "--long-lines=truncate"}, 0, "a\nbb\n", ""},
+		{[]string{"--max-line-length=2", "--long-lines=drop"}, 2, "", `sort: invalid --long-lines "drop": want error or truncate`},
+		{[]string{"--max-line-length=-1"}, 2, "", "sort: invalid --max-line-length -1"},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), "bbbbb\na\n", c.args...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestKeyFromByteFlag checks that --key-from-byte counts bytes from 1 and
+// rejects a negative offset.
+func TestKeyFromByteFlag(t *testing.T) {
+	res := runSort(t, t.TempDir(), "1 b\n2 a\n", "--key-from-byte=3")
+	if res.code != 0 || res.stdout != "2 a\n1 b\n" {
+		t.Errorf("--key-from-byte=3: rc=%d stdout %q stderr %q", res.code, res.stdout, res.stderr)
+	}
+	res = runSort(t, t.TempDir(), "1 b\n2 a\n", "--key-from-byte=-1")
+	if want := "sort: invalid --key-from-byte -1: bytes are counted from 1"; res.code != 2 || !strings.Contains(res.stderr, want) {
+		t.Errorf("--key-from-byte=-1: rc=%d stderr %q, want %q", res.code, res.stderr, want)
+	}
+}
+
+// TestSkipBlankFlag checks that --skip-blank drops empty lines, and with -b
+// lines of only blanks, from the output of the command.
+func TestSkipBlankFlag(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--skip-blank"}, "  \na\nb\n"},
+		{[]string{"--skip-blank", "-b"}, "a\nb\n"},
+		{nil, "\n  \na\nb\n"},
+	} {
+		res := runSort(t, t.TempDir(), "b\n\n  \na\n", c.args...)
+		if res.code != 0 || res.stdout != c.want {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want %q", c.args, res.code, res.stdout, res.stderr, c.want)
+		}
+	}
+}
+
+// TestTiebreakFlag runs every --tiebreak choice on lines with tied numeric
+// keys.
+func TestTiebreakFlag(t *testing.T) {
+	input := "7 a\n07 c\n007 b\n7 0\n"
+	cases := []struct {
+		tiebreak string
+		code     int
+		want     string // пусто — порядок внутри группы не проверяется
+		stderr   string
+	}{
+		{"line", 0, "007 b\n07 c\n7 0\n7 a\n", ""},
+		{"key", 0, "007 b\n07 c\n7 a\n7 0\n", ""},
+		{"index", 0, input, ""},
+		{"none", 0, "", ""},
+		{"random", 2, "", `sort: invalid --tiebreak "random": want line, key, none or index`},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), input, "-k", "1,1n", "--tiebreak="+c.tiebreak)
+		if res.code != c.code || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("--tiebreak=%s: rc=%d stderr %q, want rc=%d stderr %q", c.tiebreak, res.code, res.stderr, c.code, c.stderr)
+			continue
+		}
+		switch {
+		case c.code != 0:
+		case c.want != "" && res.stdout != c.want:
+			t.Errorf("--tiebreak=%s: stdout %q, want %q", c.tiebreak, res.stdout, c.want)
+		case c.want == "" && len(res.stdout) != len(input):
+			t.Errorf("--tiebreak=%s: stdout %q, want the input lines in any order", c.tiebreak, res.stdout)
+		}
+	}
+}
+
+// TestMergeInto merges new lines into an existing sorted file and checks the
+// file afterwards: the combined sort on success, the old content on error.
+func TestMergeInto(t *testing.T) {
+	cases := []struct {
+		name   string
+		files  map[string]string
+		stdin  string
+		args   []string
+		code   int
+		want   string // содержимое out после запуска
+		stderr string
+	}{
+		{"unsorted stdin", map[string]string{"out": "b\nd\n"}, "e\na\nc\n", nil, 0, "a\nb\nc\nd\ne\n", ""},
+		{"sorted files", map[string]string{"out": "b\nd\n", "x": "a\nc\n", "y": "e\n"}, "", []string{"-m", "x", "y"}, 0, "a\nb\nc\nd\ne\n", ""},
+		{"unsorted file", map[string]string{"out": "1\n3\n", "x": "4\n2\n"}, "", []string{"-n", "x"}, 0, "1\n2\n3\n4\n", ""},
+		{"unique", map[string]string{"out": "a\nb\n"}, "b\nc\na\n", []string{"-u"}, 0, "a\nb\nc\n", ""},
+		{"missing file", nil, "b\na\n", nil, 0, "a\nb\n", ""},
+		{"unsorted with -m", map[string]string{"out": "a\nc\n", "x": "d\nb\n"}, "", []string{"-m", "--check-inputs", "x"}, 1, "a\nc\n", "disorder: b"},
+		{"missing input", map[string]string{"out": "a\n"}, "", []string{"missing"}, 2, "a\n", "sort: cannot open 'missing'"},
+		{"with -c", map[string]string{"out": "a\n"}, "", []string{"-c"}, 2, "a\n", "sort: --merge-into cannot be used with -c"},
+	}
+	for _, c := range cases {
+		dir := writeFiles(t, c.files)
+		res := runSort(t, dir, c.stdin, append([]string{"--merge-into=out"}, c.args...)...)
+		if res.code != c.code || res.stdout != "" || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("%s: rc=%d stdout %q stderr %q, want rc=%d stderr %q", c.name, res.code, res.stdout, res.stderr, c.code, c.stderr)
+		}
+		got, err := os.ReadFile(filepath.Join(dir, "out"))
+		if err != nil || string(got) != c.want {
+			t.Errorf("%s: out = %q, %v, want %q", c.name, got, err, c.want)
+		}
+		// Временный файл рядом с out не остаётся
+		want := len(c.files)
+		if _, ok := c.files["out"]; !ok {
+			want++
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != want {
+			t.Errorf("%s: %d files left in the directory, want %d", c.name, len(entries), want)
+		}
+	}
+
+	// Права существующего файла сохраняются
+	dir := writeFiles(t, map[string]string{"out": "b\n"})
+	if err := os.Chmod(filepath.Join(dir, "out"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if res := runSort(t, dir, "a\n", "--merge-into=out"); res.code != 0 {
+		t.Fatalf("rc=%d stderr %q", res.code, res.stderr)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "out")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("mode after --merge-into: %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+}
+
+// TestBoolFlag checks that --bool puts false before true before unknown keys.
+func TestBoolFlag(t *testing.T) {
+	res := runSort(t, t.TempDir(), "x yes\ny ?\nz no\n", "--bool", "-k", "2,2")
+	if want := "z no\nx yes\ny ?\n"; res.code != 0 || res.stdout != want {
+		t.Errorf("--bool -k 2,2: rc=%d stdout %q stderr %q, want %q", res.code, res.stdout, res.stderr, want)
+	}
+}
+
+// TestCheckPipe writes far more than a pipe buffer into -c, with the disorder
+// on line 3: the writer finishes without a broken pipe, and sort exits with 1
+// and the disorder message instead of dying mid-stream.
+func TestCheckPipe(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-c")
+	cmd.Env = append(os.Environ(), "SORT_TEST_MAIN=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	input := "a\nc\nb\n" + strings.Repeat("z\n", 1<<20)
+	_, writeErr := stdin.Write([]byte(input))
+	closeErr := stdin.Close()
+	waitErr := cmd.Wait()
+	if writeErr != nil || closeErr != nil {
+		t.Errorf("write into -c: %v, close: %v", writeErr, closeErr)
+	}
+	var exit *exec.ExitError
+	if !errors.As(waitErr, &exit) || exit.ExitCode() != 1 {
+		t.Errorf("sort -c: %v, want exit status 1", waitErr)
+	}
+	if want := "sort: -:3: disorder: b\n"; stderr.String() != want || stdout.Len() != 0 {
+		t.Errorf("sort -c: stdout %q stderr %q, want stderr %q", stdout.String(), stderr.String(), want)
+	}
+}
+
+// TestUnquoteFlag checks --unquote with -n and a custom --quote-char.
+func TestUnquoteFlag(t *testing.T) {
+	cases := []struct {
+		input  string
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{"\"10\"\n\"9\"\n", []string{"--unquote", "-n"}, 0, "\"9\"\n\"10\"\n", ""},
+		{"'10'\n'9'\n", []string{"--unquote", "--quote-char=Q", "-n"}, 0, "'10'\n'9'\n", ""},
+		{"'10'\n'9'\n", []string{"--unquote", "--quote-char='", "-n"}, 0, "'9'\n'10'\n", ""},
+		{"a\n", []string{"--unquote", "--quote-char=''"}, 2, "", `sort: --quote-char wants a single character, got "''"`},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), c.input, c.args...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestReverseKeyFlag checks --reverse-key on hostnames and an invalid mode.
+func TestReverseKeyFlag(t *testing.T) {
+	input := "c.example.org\nb.example.com\na.example.com\n"
+	cases := []struct {
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{[]string{"--reverse-key=dots"}, 0, "a.example.com\nb.example.com\nc.example.org\n", ""},
+		{[]string{"--reverse-key=chars"}, 0, "c.example.org\na.example.com\nb.example.com\n", ""},
+		{[]string{"--reverse-key=words"}, 2, "", `sort: invalid --reverse-key "words": want dots or chars`},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), input, c.args...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestHumanTiesFlag checks --human-ties with -h -u and an invalid value.
+func TestHumanTiesFlag(t *testing.T) {
+	cases := []struct {
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{[]string{"-h", "-u", "-s"}, 0, "1K\n", ""},
+		{[]string{"-h", "-u", "--human-ties=unit"}, 0, "1000\n1K\n", ""},
+		{[]string{"-h", "--human-ties=text"}, 2, "", `sort: invalid --human-ties "text": want line or unit`},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), "1K\n1000\n", c.args...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestSplitFlags sorts into files of two lines with --split-prefix, and
+// checks the flags that cannot be combined.
+func TestSplitFlags(t *testing.T) {
+	dir := t.TempDir()
+	res := runSort(t, dir, "e\nd\nc\nb\na\n", "--split-lines=2", "--split-prefix=part-")
+	if res.code != 0 || res.stdout != "" {
+		t.Fatalf("--split-lines=2: rc=%d stdout %q stderr %q", res.code, res.stdout, res.stderr)
+	}
+	for name, want := range map[string]string{"part-000": "a\nb\n", "part-001": "c\nd\n", "part-002": "e\n"} {
+		if got, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--split-lines=-1"},
+		{"--split-bytes=10", "-c"},
+	} {
+		if res := runSort(t, t.TempDir(), "a\n", args...); res.code != 2 || !strings.Contains(res.stderr, "sort: ") {
+			t.Errorf("sort %q: rc=%d stderr %q, want an error", args, res.code, res.stderr)
+		}
+	}
+}
+
+// TestOutputFlag checks that -o writes the result into a file, that the file
+// may be an input, and that a failed sort leaves it untouched.
+func TestOutputFlag(t *testing.T) {
+	cases := []struct {
+		name   string
+		files  map[string]string
+		stdin  string
+		args   []string
+		code   int
+		want   string // содержимое out после запуска
+		stderr string
+	}{
+		{"stdin", nil, "b\na\n", []string{"-o", "out"}, 0, "a\nb\n", ""},
+		{"replace", map[string]string{"out": "old\n"}, "b\na\n", []string{"-o", "out"}, 0, "a\nb\n", ""},
+		{"input", map[string]string{"out": "c\na\nb\n"}, "", []string{"-o", "out", "out"}, 0, "a\nb\nc\n", ""},
+		{"input and stdin", map[string]string{"out": "c\na\n"}, "b\n", []string{"-o", "out", "out", "-"}, 0, "a\nb\nc\n", ""},
+		{"merge input", map[string]string{"out": "a\nc\n", "x": "b\nd\n"}, "", []string{"-m", "-o", "out", "out", "x"}, 0, "a\nb\nc\nd\n", ""},
+		{"missing input", map[string]string{"out": "old\n"}, "", []string{"-o", "out", "missing"}, 2, "old\n", "sort: cannot open 'missing'"},
+		{"with -c", map[string]string{"out": "old\n"}, "a\n", []string{"-c", "-o", "out"}, 2, "old\n", "sort: -o cannot be used with -c or --merge-into"},
+		{"with --split-lines", map[string]string{"out": "old\n"}, "a\n", []string{"--split-lines=1", "-o", "out"}, 2, "old\n",
+			"sort: --split-lines and --split-bytes cannot be used with -c, -o or --merge-into"},
+	}
+	for _, c := range cases {
+		dir := writeFiles(t, c.files)
+		res := runSort(t, dir, c.stdin, c.args...)
+		if res.code != c.code || res.stdout != "" || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("%s: rc=%d stdout %q stderr %q, want rc=%d stderr %q", c.name, res.code, res.stdout, res.stderr, c.code, c.stderr)
+		}
+		got, err := os.ReadFile(filepath.Join(dir, "out"))
+		if err != nil || string(got) != c.want {
+			t.Errorf("%s: out = %q, %v, want %q", c.name, got, err, c.want)
+		}
+		// Временный файл рядом с out не остаётся
+		want := len(c.files)
+		if _, ok := c.files["out"]; !ok {
+			want++
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != want {
+			t.Errorf("%s: %d files left in the directory, want %d", c.name, len(entries), want)
+		}
+	}
+
+	// Права существующего файла сохраняются
+	dir := writeFiles(t, map[string]string{"out": "b\na\n"})
+	if err := os.Chmod(filepath.Join(dir, "out"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if res := runSort(t, dir, "", "-o", "out", "out"); res.code != 0 {
+		t.Fatalf("rc=%d stderr %q", res.code, res.stderr)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "out")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("mode after -o: %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	res := runSort(t, t.TempDir(), "a\n", "-o", filepath.Join("missing", "out"))
+	if res.code != 2 || !strings.Contains(res.stderr, "sort: cannot create temporary file for") {
+		t.Errorf("missing directory: rc=%d stderr %q, want a create error", res.code, res.stderr)
+	}
+}
+
+// TestOutputReplacesInputExternal sorts a file into itself through the
+// replacement of -o with a limit small enough for many temporary files: the
+// input is read to its end before it is replaced.
+func TestOutputReplacesInputExternal(t *testing.T) {
+	var input, want strings.Builder
+	for i := range 5000 {
+		fmt.Fprintf(&input, "%06d\n", (i*7919)%5000)
+		fmt.Fprintf(&want, "%06d\n", i)
+	}
+	path := filepath.Join(writeFiles(t, map[string]string{"data": input.String()}), "data")
+
+	in, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := createReplacement(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.commit(sortutil.ExternalSortReader(in, out, sortutil.SortOptions{}, 4096)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(path); err != nil || string(got) != want.String() {
+		t.Errorf("data after the external sort: %d bytes, %v, want %d sorted bytes", len(got), err, want.Len())
+	}
+}
+
+// TestParallelFilesFlag checks that --parallel-files sorts several files like
+// their concatenation and rejects the modes it cannot serve.
+func TestParallelFilesFlag(t *testing.T) {
+	files := map[string]string{"x": "b 1\nd 1\na 2\n", "y": "c 1\nb 2", "z": "a 3\n"}
+	cases := []struct {
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{[]string{"x", "y", "z"}, 0, "a 2\na 3\nb 1\nb 2\nc 1\nd 1\n", ""},
+		{[]string{"--parallel=1", "x", "y", "z"}, 0, "a 2\na 3\nb 1\nb 2\nc 1\nd 1\n", ""},
+		{[]string{"-s", "-k", "2,2n", "x", "y", "z"}, 0, "b 1\nd 1\nc 1\na 2\nb 2\na 3\n", ""},
+		{[]string{"-u", "-k", "1,1", "x", "y", "z"}, 0, "a 2\nb 1\nc 1\nd 1\n", ""},
+		{[]string{"x"}, 0, "a 2\nb 1\nd 1\n", ""},
+		{[]string{"x", "missing"}, 2, "", "sort: cannot open 'missing'"},
+		{[]string{"-c", "x", "y"}, 2, "", "sort: --parallel-files cannot be used with -c"},
+		{[]string{"-m", "x", "y"}, 2, "", "sort: --parallel-files cannot be used with"},
+		{[]string{"--in-memory-only", "x", "y"}, 2, "", "--resume-dir or --in-memory-only"},
+	}
+	for _, c := range cases {
+		res := runSort(t, writeFiles(t, files), "", append([]string{"--parallel-files"}, c.args...)...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestFoldUniqueFlag checks that -f -u -k 2 keeps one line of keys that
+// differ only in case.
+func TestFoldUniqueFlag(t *testing.T) {
+	input := "c FOO\nb foo\nd bar\na Foo\n"
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-f", "-u", "-k", "2"}, "d bar\na Foo\n"},
+		{[]string{"-u", "-k", "2f"}, "d bar\na Foo\n"},
+		{[]string{"-f", "-u", "-s", "-k", "2"}, "d bar\nc FOO\n"},
+		{[]string{"-u", "-k", "2"}, "c FOO\na Foo\nd bar\nb foo\n"},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), input, c.args...)
+		if res.code != 0 || res.stdout != c.want {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want %q", c.args, res.code, res.stdout, res.stderr, c.want)
+		}
+	}
+}
+
+// TestStdinOperand checks that "-" among the files reads stdin at its place,
+// which -s shows for equal keys, and that stdin given twice is an error.
+func TestStdinOperand(t *testing.T) {
+	files := map[string]string{"a": "x 1\nb 1\n", "b": "x 3\na 3\n"}
+	stdin := "x 2\nc 2\n"
+	cases := []struct {
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{[]string{"-s", "-k", "1,1", "a", "-", "b"}, 0, "a 3\nb 1\nc 2\nx 1\nx 2\nx 3\n", ""},
+		{[]string{"-s", "-k", "1,1", "b", "-", "a"}, 0, "a 3\nb 1\nc 2\nx 3\nx 2\nx 1\n", ""},
+		{[]string{"-s", "-k", "1,1", "-", "a"}, 0, "b 1\nc 2\nx 2\nx 1\n", ""},
+		{[]string{"-m", "-k", "2,2n", "a", "-", "b"}, 0, "x 1\nb 1\nx 2\nc 2\nx 3\na 3\n", ""},
+		{[]string{"a", "-", "b", "-"}, 2, "", "sort: standard input '-' given more than once"},
+		{[]string{"-m", "-", "-"}, 2, "", "sort: standard input '-' given more than once"},
+	}
+	for _, c := range cases {
+		res := runSort(t, writeFiles(t, files), stdin, c.args...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestUniqCountFlag checks --uniq-count on sorted input, its exit code on a
+// disorder and the flags it cannot be combined with.
+func TestUniqCountFlag(t *testing.T) {
+	files := map[string]string{"x": "a\n", "y": "b\n"}
+	cases := []struct {
+		stdin  string
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{"a 1\nb 1\nc 2\n", []string{"-k", "2,2n"}, 0, "2\ta 1\n1\tc 2\n", ""},
+		{"a:1\nb:1\n", []string{"-t", ":", "-k", "2,2"}, 0, "2:a:1\n", ""},
+		{"a\nc\nb\n", nil, 1, "", "sort: -:3: disorder: b\n"},
+		{"", []string{"x", "y"}, 2, "", "sort: extra operand 'y' not allowed with --uniq-count"},
+		{"a\n", []string{"-c"}, 2, "", "sort: --uniq-count cannot be used with -c, -m or --merge-into"},
+	}
+	for _, c := range cases {
+		res := runSort(t, writeFiles(t, files), c.stdin, append([]string{"--uniq-count"}, c.args...)...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestParseBufferSize checks the suffixes of -S, the kilobyte default, the
+// clamp to minBufferSize and math.MaxInt/2, and percentages of memory.
+func TestParseBufferSize(t *testing.T) {
+	cases := []struct {
+		value string
+		want  int
+	}{
+		{"1G", 1 << 30},
+		{"2048", 2 << 20},
+		{"1.5M", 3 << 19},
+		{"1.0T", 1 << 40},
+		{"3072K", 3 << 20},
+		{"10b", minBufferSize},
+		{"100", minBufferSize},
+		{"1e30T", 1 << 62}, // math.MaxInt/2, округлённое до float64
+	}
+	for _, c := range cases {
+		if got, err := parseBufferSize(c.value); err != nil || got != c.want {
+			t.Errorf("parseBufferSize(%q) = %d, %v, want %d", c.value, got, err, c.want)
+		}
+	}
+	for _, value := range []string{"", "0", "-1M", "M", "5X", "1MB", "x", "Inf", "0%", "101%", "abc%", "-5%"} {
+		if _, err := parseBufferSize(value); err == nil || !strings.HasPrefix(err.Error(), "sort: invalid -S") {
+			t.Errorf("parseBufferSize(%q): err = %v, want an invalid -S", value, err)
+		}
+	}
+	if total, ok := sortutil.TotalMemory(); ok {
+		for _, c := range []struct {
+			value string
+			want  int
+		}{{"50%", int(total / 2)}, {"100.0%", int(total)}, {"1e-9%", minBufferSize}} {
+			if got, err := parseBufferSize(c.value); err != nil || got != c.want {
+				t.Errorf("parseBufferSize(%q) = %d, %v, want %d", c.value, got, err, c.want)
+			}
+		}
+	}
+}
+
+// TestParseBatchSize checks --batch-size counts and percentages of the open
+// file limit, clamped between 2 and the limit less reservedFiles.
+func TestParseBatchSize(t *testing.T) {
+	limit, known := sortutil.OpenFileLimit()
+	if !known || limit < 64 {
+		t.Skipf("open file limit %d, %v", limit, known)
+	}
+	cases := []struct {
+		value string
+		want  int
+	}{
+		{"2", 2},
+		{"16", 16},
+		{"100%", limit - reservedFiles},
+		{"50%", min(limit/2, limit-reservedFiles)},
+		{"1e-9%", 2},
+		{"1000000000000", limit - reservedFiles},
+	}
+	for _, c := range cases {
+		if got, err := parseBatchSize(c.value); err != nil || got != c.want {
+			t.Errorf("parseBatchSize(%q) = %d, %v, want %d", c.value, got, err, c.want)
+		}
+	}
+	for _, value := range []string{"", "1", "0", "-3", "x", "2.5", "0%", "150%", "%"} {
+		if _, err := parseBatchSize(value); err == nil || !strings.HasPrefix(err.Error(), "sort: invalid --batch-size") {
+			t.Errorf("parseBatchSize(%q): err = %v, want an invalid --batch-size", value, err)
+		}
+	}
+}
+
+// TestBufferSizeFlags sorts input larger than -S 1M through temporary files
+// merged two at a time, and checks the messages for invalid sizes.
+func TestBufferSizeFlags(t *testing.T) {
+	var input, want strings.Builder
+	for i := range 200000 {
+		fmt.Fprintf(&input, "%07d\n", (i*7919)%200000)
+		fmt.Fprintf(&want, "%07d\n", i)
+	}
+	dir := t.TempDir()
+	tmp := filepath.Join(dir, "tmp")
+	if err := os.Mkdir(tmp, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	res := runSort(t, dir, input.String(), "-S", "1M", "--batch-size", "2", "-T", "tmp")
+	if res.code != 0 || res.stdout != want.String() {
+		t.Errorf("-S 1M --batch-size 2: rc=%d stderr %q, output equal %v", res.code, res.stderr, res.stdout == want.String())
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("%d temporary files left", len(entries))
+	}
+
+	cases := []struct {
+		args   []string
+		stderr string
+	}{
+		{[]string{"-S", "0"}, `sort: invalid -S "0": want a positive size like 500M or 50%`},
+		{[]string{"-S", "200%"}, `sort: invalid -S "200%": percentage must be above 0 and at most 100`},
+		{[]string{"--batch-size", "1"}, `sort: invalid --batch-size "1": want at least 2 files or a percentage`},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), "a\n", c.args...)
+		if res.code != 2 || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stderr %q, want rc=2 stderr %q", c.args, res.code, res.stderr, c.stderr)
+		}
+	}
+}
+
+// TestWarnTiesFlag checks that --warn-ties warns with --tiebreak=none and
+// --no-last-resort, and stays silent when -s determines the order.
+func TestWarnTiesFlag(t *testing.T) {
+	input := "b 1\na 1\nc 2\n"
+	warning := "sort: warning: 1 pairs of adjacent lines have equal keys but differ"
+	cases := []struct {
+		args   []string
+		stderr string // пусто — без предупреждения
+	}{
+		{[]string{"--tiebreak=none"}, warning},
+		{[]string{"--no-last-resort"}, warning},
+		{[]string{"--no-last-resort", "-s"}, ""},
+		{nil, ""},
+	}
+	for _, c := range cases {
+		args := append([]string{"--warn-ties", "-k", "2,2"}, c.args...)
+		res := runSort(t, t.TempDir(), input, args...)
+		if res.code != 0 || len(res.stdout) != len(input) || c.stderr == "" && res.stderr != "" || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want stderr %q", args, res.code, res.stdout, res.stderr, c.stderr)
+		}
+	}
+}
+
+// TestHelpFlag checks that -h sorts human-readable numbers and that --help
+// and -help print the usage with exit code 0.
+func TestHelpFlag(t *testing.T) {
+	res := runSort(t, t.TempDir(), "1G\n2K\n3M\n", "-h")
+	if res.code != 0 || res.stdout != "2K\n3M\n1G\n" || res.stderr != "" {
+		t.Errorf("-h: rc=%d stdout %q stderr %q, want the human sort", res.code, res.stdout, res.stderr)
+	}
+	for _, flag := range []string{"--help", "-help"} {
+		res := runSort(t, t.TempDir(), "b\na\n", flag)
+		if res.code != 0 || res.stdout != "" || !strings.Contains(res.stderr, "[check|merge|sort] [OPTION]... [FILE]...") ||
+			!strings.Contains(res.stderr, "--help prints this help") || !strings.Contains(res.stderr, "not help: use --help") {
+			t.Errorf("%s: rc=%d stdout %q stderr %.200q, want the usage", flag, res.code, res.stdout, res.stderr)
+		}
+	}
+}
+
+// TestRadixFlag checks --radix with octal keys and the range of bases.
+func TestRadixFlag(t *testing.T) {
+	cases := []struct {
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{[]string{"--radix", "8"}, 0, "644\n755\n1777\n", ""},
+		{[]string{"--radix=36", "-r"}, 0, "1777\n755\n644\n", ""},
+		{[]string{"--radix", "1"}, 2, "", "sort: invalid --radix 1: want 2 to 36"},
+		{[]string{"--radix", "37"}, 2, "", "sort: invalid --radix 37: want 2 to 36"},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), "755\n1777\n644\n", c.args...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestCheckZeroFlag checks -cz on NUL-terminated records: the exit code and a
+// message that quotes the whole record, ended by a newline.
+func TestCheckZeroFlag(t *testing.T) {
+	cases := []struct {
+		input  string
+		args   []string
+		code   int
+		stderr string
+	}{
+		{"a\nz\x00b\x00c\x00", []string{"-c", "-z"}, 0, ""},
+		{"b\x00a\nz\x00", []string{"-c", "-z"}, 1, "sort: -:2: disorder: a\nz\n"},
+		{"b\na\x00", []string{"-c", "-z"}, 0, ""},
+		{"b\na\x00", []string{"-c"}, 1, "sort: -:2: disorder: a\x00\n"},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), c.input, c.args...)
+		if res.code != c.code || res.stderr != c.stderr {
+			t.Errorf("sort %q on %q: rc=%d stderr %q, want rc=%d stderr %q", c.args, c.input, res.code, res.stderr, c.code, c.stderr)
+		}
+	}
+}
+
+// TestParallelThresholdFlag checks that --parallel with a low
+// --parallel-threshold sorts correctly and that negative values fail.
+func TestParallelThresholdFlag(t *testing.T) {
+	input := "d 1\nb 2\nc 1\na 2\ne 1\n"
+	cases := []struct {
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{[]string{"--parallel=4", "--parallel-threshold=2", "-s", "-k", "2,2n"}, 0, "d 1\nc 1\ne 1\nb 2\na 2\n", ""},
+		{[]string{"--parallel=4", "--parallel-threshold=100"}, 0, "a 2\nb 2\nc 1\nd 1\ne 1\n", ""},
+		{[]string{"--parallel-threshold=-1"}, 2, "", "sort: invalid --parallel, --parallel-threshold or --threads-for-merge: must not be negative"},
+		{[]string{"--parallel=-2"}, 2, "", "sort: invalid --parallel, --parallel-threshold or --threads-for-merge: must not be negative"},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), input, c.args...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestNaturalFlag checks --natural on file names and with --locale.
+func TestNaturalFlag(t *testing.T) {
+	cases := []struct {
+		input string
+		args  []string
+		want  string
+	}{
+		{"img12.png\nimg9.png\nimg100.png\n", []string{"--natural"}, "img9.png\nimg12.png\nimg100.png\n"},
+		{"img12.png\nimg9.png\nimg100.png\n", []string{"--natural", "-r"}, "img100.png\nimg12.png\nimg9.png\n"},
+		{"Äpfel 2\nBirnen 1\n", []string{"--natural"}, "Birnen 1\nÄpfel 2\n"},
+		{"Birnen 1\nÄpfel 2\n", []string{"--natural", "--locale", "de_DE.UTF-8"}, "Äpfel 2\nBirnen 1\n"},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), c.input, c.args...)
+		if res.code != 0 || res.stdout != c.want {
+			t.Errorf("sort %q on %q: rc=%d stdout %q stderr %q, want %q", c.args, c.input, res.code, res.stdout, res.stderr, c.want)
+		}
+	}
+}
+
+// TestEmptyStdin checks that empty stdin gives empty output and status 0 in
+// every mode, and that --split-lines creates no files.
+func TestEmptyStdin(t *testing.T) {
+	modes := [][]string{
+		nil,
+		{"-c"},
+		{"--check-strict"},
+		{"-m"},
+		{"-u"},
+		{"-n", "-r"},
+		{"--uniq-count"},
+		{"--header", "1"},
+		{"-o", "out"},
+		{"--split-lines", "10", "--split-prefix", "part"},
+	}
+	for _, args := range modes {
+		dir := t.TempDir()
+		res := runSort(t, dir, "", args...)
+		if res.code != 0 || res.stdout != "" || res.stderr != "" {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want nothing", args, res.code, res.stdout, res.stderr)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if e.Name() != "out" {
+				t.Errorf("sort %q created %s", args, e.Name())
+			}
+		}
+	}
+}
+
+// TestFoldCaseTieBreakFlag checks the documented tie-break of -f: lines whose
+// keys differ only in case are ordered by bytes, or by input with -s, also in
+// the external sort.
+func TestFoldCaseTieBreakFlag(t *testing.T) {
+	input := "apple\nApple\nbanana\nAPPLE\n"
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-f"}, "APPLE\nApple\napple\nbanana\n"},
+		{[]string{"-f", "-r"}, "banana\napple\nApple\nAPPLE\n"},
+		{[]string{"-f", "-s"}, "apple\nApple\nAPPLE\nbanana\n"},
+		{[]string{"-f", "-S", "1b", "--batch-size", "2"}, "APPLE\nApple\napple\nbanana\n"},
+		{[]string{"-f", "-s", "-S", "1b", "--batch-size", "2"}, "apple\nApple\nAPPLE\nbanana\n"},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		res := runSort(t, dir, input, append(c.args, "-T", dir)...)
+		if res.code != 0 || res.stdout != c.want {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want %q", c.args, res.code, res.stdout, res.stderr, c.want)
+		}
+	}
+}
+
+// TestTSVFlag checks that --tsv skips escaped tabs when -k selects a field and
+// outputs the lines unchanged.
+func TestTSVFlag(t *testing.T) {
+	input := `b\tz` + "\t2\n" + "a\t3\n" + `c\\` + "\t1\n"
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--tsv", "-k", "2,2"}, `c\\` + "\t1\n" + `b\tz` + "\t2\n" + "a\t3\n"},
+		{[]string{"--tsv", "-k", "2,2", "-r"}, "a\t3\n" + `b\tz` + "\t2\n" + `c\\` + "\t1\n"},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), input, c.args...)
+		if res.code != 0 || res.stdout != c.want {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want %q", c.args, res.code, res.stdout, res.stderr, c.want)
+		}
+	}
+
+	// Табуляция после \ экранирована: с --tsv ключ -k 2 — это 1, с -t — q
+	escaped := "b\\\tq\t1\na\t2\n"
+	if res := runSort(t, t.TempDir(), escaped, "--tsv", "-k", "2,2"); res.stdout != escaped {
+		t.Errorf("--tsv: stdout %q, want %q", res.stdout, escaped)
+	}
+	if res, want := runSort(t, t.TempDir(), escaped, "-t", "\t", "-k", "2,2"), "a\t2\nb\\\tq\t1\n"; res.stdout != want {
+		t.Errorf("-t TAB: stdout %q, want %q", res.stdout, want)
+	}
+}
+
+// TestOutputCRLFFlag checks --output-crlf on stdout and -o, and that it is
+// rejected together with NUL terminators and --record-separator.
+func TestOutputCRLFFlag(t *testing.T) {
+	cases := []struct {
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{[]string{"--output-crlf"}, 0, "a\r\nb\r\nc\r\n", ""},
+		{[]string{"--output-crlf", "-r"}, 0, "c\r\nb\r\na\r\n", ""},
+		{[]string{"--output-crlf", "-z"}, 2, "", "sort: --output-crlf cannot be combined with -z, --output-zero or --record-separator"},
+		{[]string{"--output-crlf", "--output-zero"}, 2, "", "sort: --output-crlf cannot be combined with -z, --output-zero or --record-separator"},
+		{[]string{"--output-crlf", "--record-separator", ";"}, 2, "", "sort: --output-crlf cannot be combined with -z, --output-zero or --record-separator"},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), "b\r\nc\na\r\n", c.args...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+
+	dir := t.TempDir()
+	if res := runSort(t, dir, "b\na\n", "--output-crlf", "-o", "out"); res.code != 0 {
+		t.Fatalf("-o: rc=%d stderr %q", res.code, res.stderr)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "out")); err != nil || string(data) != "a\r\nb\r\n" {
+		t.Errorf("-o: file %q, %v, want %q", data, err, "a\r\nb\r\n")
+	}
+}
+
+// TestTransformFlag checks that the order of --transform steps matters, as in
+// the example of the README, and that unknown steps are rejected.
+func TestTransformFlag(t *testing.T) {
+	cases := []struct {
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{[]string{"--transform=strip-punct,squeeze"}, 0, "a b\na - c\n", ""},
+		{[]string{"--transform=squeeze,strip-punct"}, 0, "a - c\na b\n", ""},
+		{[]string{"--transform=lower,nope"}, 2, "", `sort: unknown --transform "nope": want accents, dictionary, lower,`},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), "a - c\na b\n", c.args...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestKeyPrefixFlag checks --key-prefix with the awk pipeline of the README
+// and its conflicts with the other ways to choose a key.
+func TestKeyPrefixFlag(t *testing.T) {
+	conflict := "sort: --key-prefix cannot be used with -k, --key-name, --key-regex, --key-template, --key-from-byte or --prepend-index"
+	cases := []struct {
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{[]string{"--key-prefix", "-n"}, 0, "bb\nccc\naaaa\n", ""},
+		{[]string{"--key-prefix", "-n", "-r"}, 0, "aaaa\nccc\nbb\n", ""},
+		{[]string{"--key-prefix", "-k", "2"}, 2, "", conflict},
+		{[]string{"--key-prefix", "--key-regex", "(a)"}, 2, "", conflict},
+		{[]string{"--key-prefix", "--prepend-index"}, 2, "", conflict},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), "3\tccc\n4\taaaa\n2\tbb\n", c.args...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestEpochFlag checks --epoch on a log mixing seconds and milliseconds, and
+// the values it accepts.
+func TestEpochFlag(t *testing.T) {
+	input := "1699000001 b\n1699000000500 a\n1699000000 c\n"
+	cases := []struct {
+		args   []string
+		code   int
+		want   string
+		stderr string
+	}{
+		{[]string{"--epoch=auto"}, 0, "1699000000 c\n1699000000500 a\n1699000001 b\n", ""},
+		{[]string{"--epoch=s"}, 0, "1699000000 c\n1699000001 b\n1699000000500 a\n", ""},
+		{[]string{"--epoch=ms", "-r"}, 0, "1699000000500 a\n1699000001 b\n1699000000 c\n", ""},
+		{[]string{"--epoch=us"}, 2, "", `sort: invalid --epoch "us": want s, ms or auto`},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), input, c.args...)
+		if res.code != c.code || res.stdout != c.want || !strings.Contains(res.stderr, c.stderr) {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want rc=%d stdout %q stderr %q",
+				c.args, res.code, res.stdout, res.stderr, c.code, c.want, c.stderr)
+		}
+	}
+}
+
+// TestQuietCheckFlag checks that -C reports the order only by its exit status.
+func TestQuietCheckFlag(t *testing.T) {
+	cases := []struct {
+		input string
+		args  []string
+		code  int
+	}{
+		{"a\nb\nc\n", []string{"-C"}, 0},
+		{"a\nc\nb\n", []string{"-C"}, 1},
+		{"c\nb\na\n", []string{"-C", "-r"}, 0},
+		{"a\na\n", []string{"-C"}, 0},
+		{"a\na\n", []string{"-C", "--check-strict"}, 1},
+		{"10\n9\n", []string{"-C", "-n"}, 1},
+		{"", []string{"-C"}, 0},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), c.input, c.args...)
+		if res.code != c.code || res.stdout != "" || res.stderr != "" {
+			t.Errorf("sort %q on %q: rc=%d stdout %q stderr %q, want rc=%d and no output", c.args, c.input, res.code, res.stdout, res.stderr, c.code)
+		}
+	}
+
+	dir := writeFiles(t, map[string]string{"data": "b\na\n" + strings.Repeat("z\n", 100000)})
+	if res := runSort(t, dir, "", "-C", "data"); res.code != 1 || res.stderr != "" {
+		t.Errorf("sort -C data: rc=%d stderr %q, want rc=1 and no message", res.code, res.stderr)
+	}
+}
+
+// TestUniqueOnlyKeysFlag checks that -k 2 -u --only-keys prints the sorted set
+// of distinct field-2 values.
+func TestUniqueOnlyKeysFlag(t *testing.T) {
+	input := "a red\nb green\nc red\nd blue\ne green\n"
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-b", "-k", "2", "-u", "--only-keys"}, "blue\ngreen\nred\n"},
+		{[]string{"-t", " ", "-k", "2", "-u", "--only-keys", "-r"}, "red\ngreen\nblue\n"},
+		{[]string{"-k", "2", "-u", "--only-keys"}, " blue\n green\n red\n"},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), input, c.args...)
+		if res.code != 0 || res.stdout != c.want {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want %q", c.args, res.code, res.stdout, res.stderr, c.want)
+		}
+	}
+}
+
+// TestThreadsForMergeFlag checks that --threads-for-merge merges temporary
+// files like the sequential merge and rejects negative values.
+func TestThreadsForMergeFlag(t *testing.T) {
+	var lines []string
+	for i := range 20000 {
+		lines = append(lines, fmt.Sprintf("%05d", (i*7919)%20000))
+	}
+	input := strings.Join(lines, "\n") + "\n"
+	want := runSort(t, t.TempDir(), input).stdout
+	for _, args := range [][]string{
+		{"--threads-for-merge=2"},
+		{"--threads-for-merge", "4", "--parallel", "1"},
+		{"--parallel=8", "--threads-for-merge=2", "-u"},
+	} {
+		dir := t.TempDir()
+		res := runSort(t, dir, input, append(args, "-S", "16K", "-T", dir)...)
+		if res.code != 0 || res.stdout != want {
+			t.Errorf("sort %q: rc=%d stderr %q, output differs", args, res.code, res.stderr)
+		}
+	}
+	res := runSort(t, t.TempDir(), "a\n", "--threads-for-merge=-1")
+	if res.code != 2 || !strings.Contains(res.stderr, "sort: invalid --parallel, --parallel-threshold or --threads-for-merge: must not be negative") {
+		t.Errorf("--threads-for-merge=-1: rc=%d stderr %q", res.code, res.stderr)
+	}
+}
+
+// TestAutoUnitFlag sorts a column of mixed sizes, durations and numbers with
+// --auto-unit.
+func TestAutoUnitFlag(t *testing.T) {
+	input := "a 1h\nb 10M\nc 10m\nd 42\ne n/a\nf 2Gi\n"
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--auto-unit", "-k", "2,2"}, "e n/a\nd 42\nb 10M\nf 2Gi\nc 10m\na 1h\n"},
+		{[]string{"--auto-unit", "-k", "2,2", "-r"}, "a 1h\nc 10m\nf 2Gi\nb 10M\nd 42\ne n/a\n"},
+	}
+	for _, c := range cases {
+		res := runSort(t, t.TempDir(), input, c.args...)
+		if res.code != 0 || res.stdout != c.want {
+			t.Errorf("sort %q: rc=%d stdout %q stderr %q, want %q", c.args, res.code, res.stdout, res.stderr, c.want)
+		}
+	}
+}
+
+// TestAssertStableFlag checks that --assert-stable and SORT_ASSERT_STABLE
+// leave the -s output unchanged and report nothing for a stable sort.
+func TestAssertStableFlag(t *testing.T) {
+	input := "b 1\na 2\nb 0\na 1\n"
+	want := "a 2\na 1\nb 1\nb 0\n"
+	res := runSort(t, t.TempDir(), input, "-s", "-k", "1,1", "--assert-stable")
+	if res.code != 0 || res.stdout != want || res.stderr != "" {
+		t.Errorf("--assert-stable: rc=%d stdout %q stderr %q, want %q", res.code, res.stdout, res.stderr, want)
+	}
+	t.Setenv("SORT_ASSERT_STABLE", "1")
+	res = runSort(t, t.TempDir(), input, "-s", "-k", "1,1", "-S", "8b", "-T", t.TempDir())
+	if res.code != 0 || res.stdout != want || res.stderr != "" {
+		t.Errorf("SORT_ASSERT_STABLE=1: rc=%d stdout %q stderr %q, want %q", res.code, res.stdout, res.stderr, want)
+	}
+}
+
+// TestTimeLocaleFlag checks that -M recognises the month names of LC_TIME and
+// of --time-locale, that the flag overrides the environment and that an
+// unknown --time-locale fails.
+func TestTimeLocaleFlag(t *testing.T) {
+	t.Setenv("LC_ALL", "")
+	input := "Mar\nfévrier\ndécembre\n"
+	cases := []struct {
+		env  string
+		args []string
+		code int
+		want string
+	}{
+		{"", []string{"-M"}, 0, "décembre\nfévrier\nMar\n"},
+		{"fr_FR.UTF-8", []string{"-M"}, 0, "février\nMar\ndécembre\n"},
+		{"", []string{"-M", "--time-locale", "fr_FR.UTF-8"}, 0, "février\nMar\ndécembre\n"},
+		{"fr_FR.UTF-8", []string{"-M", "--time-locale", "C"}, 0, "décembre\nfévrier\nMar\n"},
+		{"not a locale", []string{"-M"}, 0, "décembre\nfévrier\nMar\n"},
+		{"", []string{"-M", "--time-locale", "not a locale"}, 2, ""},
+	}
+	for _, c := range cases {
+		t.Setenv("LC_TIME", c.env)
+		res := runSort(t, t.TempDir(), input, c.args...)
+		if res.code != c.code || res.stdout != c.want {
+			t.Errorf("LC_TIME=%q sort %q: rc=%d stdout %q, want rc=%d stdout %q (stderr %q)",
+				c.env, c.args, res.code, res.stdout, c.code, c.want, res.stderr)
+		}
+	}
+}
+
+// TestMoneyFlag checks that --money orders amounts with currency symbols and
+// reads a lone separator before three digits after a zero as a decimal one.
+func TestMoneyFlag(t *testing.T) {
+	input := "$1,234.50\n0,125\n€2.000,00\n$0.5\n1234.567\n"
+	want := "0,125\n$0.5\n$1,234.50\n1234.567\n€2.000,00\n"
+	if res := runSort(t, t.TempDir(), input, "--money"); res.code != 0 || res.stdout != want {
+		t.Errorf("sort --money: rc=%d stdout %q, want %q (stderr %q)", res.code, res.stdout, want, res.stderr)
+	}
+}
@@ -0,0 +1,51 @@
+package sortutil
+
+import "testing"
+
+func TestFoldAccents(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"cafe", "cafe"},
+		{"caf\u00e9", "cafe"},
+		{"cafe\u0301", "cafe"},
+		{"Ångström", "Angstrom"},
+		{"naïve façade", "naive facade"},
+		{"øl łódź", "øl łodz"},
+		{"日本", "日本"},
+	}
+	for _, c := range cases {
+		if got := foldAccents(c.in); got != c.want {
+			t.Errorf("foldAccents(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+// TestSortIgnoreAccents checks that accented and plain spellings group
+// together with --ignore-accents, also with -f, while the output keeps the
+// original lines.
+func TestSortIgnoreAccents(t *testing.T) {
+	input := "cafe\ncafé\ncab\nCafé\ncad\n"
+	cases := []struct {
+		name string
+		opts SortOptions
+		want string
+	}{
+		{"bytes", SortOptions{}, "Café\ncab\ncad\ncafe\ncafé\n"},
+		{"accents", SortOptions{IgnoreAccents: true}, "Café\ncab\ncad\ncafe\ncafé\n"},
+		{"accents stable", SortOptions{IgnoreAccents: true, FoldCase: true, Stable: true}, "cab\ncad\ncafe\ncafé\nCafé\n"},
+		{"accents unique", SortOptions{IgnoreAccents: true, Unique: true}, "Café\ncab\ncad\ncafe\n"},
+		{"accents and case unique", SortOptions{IgnoreAccents: true, FoldCase: true, Unique: true}, "cab\ncad\nCafé\n"},
+		{"accents key", SortOptions{IgnoreAccents: true, Stable: true, Keys: []KeySpec{{StartField: 1, EndField: 1, StartChar: 3}}},
+			"cab\ncad\ncafe\ncafé\nCafé\n"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+	// Ключ без диакритики уже не сами байты строки
+	if opts := (SortOptions{IgnoreAccents: true}); newComparator(opts).plainBytes(opts) {
+		t.Error("--ignore-accents compares plain bytes")
+	}
+}
@@ -0,0 +1,159 @@
+package sortutil
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// parallelMinLines is the smallest input sorted in parallel by default
+// (--parallel-threshold): on smaller input starting the goroutines and merging
+// the segments costs more than it saves.
+const parallelMinLines = 1 << 16
+
+// systemMemory returns the memory available to the process in bytes, or false
+// when it is unknown. It is a variable so that tests can check the --auto
+// choice against a given amount of memory.
+var systemMemory = availableMemory
+
+// availableMemory reads MemAvailable from /proc/meminfo (Linux).
+func availableMemory() (uint64, bool) {
+	return memInfo("MemAvailable:")
+}
+
+// TotalMemory returns the physical memory of the system in bytes (MemTotal of
+// /proc/meminfo), or false when it is unknown; -S 50% is a share of it.
+func TotalMemory() (uint64, bool) {
+	return memInfo("MemTotal:")
+}
+
+// memInfo returns the /proc/meminfo field with the given name, in bytes.
+func memInfo(field string) (uint64, bool) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		rest, ok := strings.CutPrefix(s.Text(), field)
+		if !ok {
+			continue
+		}
+		kb, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(rest), " kB"), 10, 64)
+		return kb * 1024, err == nil
+	}
+	return 0, false
+}
+
+// memoryLimit returns how many bytes of input Sort keeps in memory before
+// switching to external sort: BufferSize (-S) if set, maxMemoryBytes or, with
+// --auto, half of the available memory (but not less than maxMemoryBytes). The
+// other half is left for line headers, segment merge copies and the rest of
+// the system.
+func (opts SortOptions) memoryLimit() int {
+	if opts.BufferSize > 0 {
+		return opts.BufferSize
+	}
+	if !opts.Auto {
+		return maxMemoryBytes
+	}
+	available, ok := systemMemory()
+	if !ok {
+		return maxMemoryBytes
+	}
+	return int(max(min(available/2, math.MaxInt), maxMemoryBytes))
+}
+
+// sortParallel reports whether SortInMemory sorts lines in parallel (--auto or
+// --parallel above 1): the input has at least --parallel-threshold lines, there
+// is more than one goroutine, and the comparisons do not keep a shared log.
+// The runs of the goroutines are parts of lines itself, so together they stay
+// within the memory limit; on top of them the merge needs a slice of string
+// headers, and if that exceeds the limit the lines are sorted in one goroutine.
+func (opts SortOptions) sortParallel(lines []string) bool {
+	if !(opts.Auto || opts.Parallel > 1) || len(lines) < opts.parallelThreshold() || opts.workers() < 2 || opts.TotalOrderCheck {
+		return false
+	}
+	data := 0
+	for _, line := range lines {
+		data += len(line)
+	}
+	return linesMemory(data, lines)+stringHeaderSize*len(lines) <= opts.memoryLimit()
+}
+
+// parallelThreshold returns the smallest number of lines sorted in parallel.
+func (opts SortOptions) parallelThreshold() int {
+	if opts.ParallelThreshold > 0 {
+		return opts.ParallelThreshold
+	}
+	return parallelMinLines
+}
+
+// sortSegments sorts lines by splitting them into one segment per worker,
+// sorting the segments concurrently and merging them. Each goroutine has its
+// own comparator, since locale collation keeps buffers and is not safe for
+// concurrent use. The merge takes equal lines from the earlier segment first,
+// so -s keeps input order just like sort.SliceStable.
+func sortSegments(lines []string, opts SortOptions) {
+	workers := min(opts.workers(), len(lines))
+	segments := make([][]string, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		// Отрезки сортируются на месте, в самом lines
+		segment := lines[i*len(lines)/workers : (i+1)*len(lines)/workers]
+		segments[i] = segment
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			comp := newComparator(opts)
+			less := func(a, b int) bool { return comp.compareLines(segment[a], segment[b]) < 0 }
+			if opts.Stable {
+				sort.SliceStable(segment, less)
+			} else {
+				sort.Slice(segment, less)
+			}
+		}()
+	}
+	wg.Wait()
+	copy(lines, mergeSegments(segments, newComparator(opts)))
+}
+
+// mergeSegments merges the sorted segments by comp into a new slice. Equal
+// lines are taken from the earlier segment first.
+func mergeSegments(segments [][]string, comp *comparator) []string {
+	type cursor struct {
+		segment []string
+		index   int
+	}
+	total := 0
+	for _, segment := range segments {
+		total += len(segment)
+	}
+	h := newHeap(len(segments), func(a, b cursor) bool {
+		if res := comp.compareLines(a.segment[0], b.segment[0]); res != 0 {
+			return res < 0
+		}
+		return a.index < b.index
+	})
+	for i, segment := range segments {
+		if len(segment) > 0 {
+			h.push(cursor{segment: segment, index: i})
+		}
+	}
+	merged := make([]string, 0, total)
+	for len(h.items) > 0 {
+		top := &h.items[0]
+		merged = append(merged, top.segment[0])
+		if top.segment = top.segment[1:]; len(top.segment) > 0 {
+			h.fixTop()
+		} else {
+			h.pop()
+		}
+	}
+	return merged
+}
@@ -0,0 +1,173 @@
+package sortutil
+
+import (
+	"slices"
+	"testing"
+)
+
+// withSystemMemory replaces systemMemory for the duration of the test.
+func withSystemMemory(t *testing.T, available uint64, ok bool) {
+	t.Helper()
+	saved := systemMemory
+	systemMemory = func() (uint64, bool) { return available, ok }
+	t.Cleanup(func() { systemMemory = saved })
+}
+
+func TestMemoryLimit(t *testing.T) {
+	cases := []struct {
+		name      string
+		opts      SortOptions
+		available uint64
+		known     bool
+		want      int
+	}{
+		{"default", SortOptions{}, 64 << 30, true, maxMemoryBytes},
+		{"auto takes half", SortOptions{Auto: true}, 8 << 30, true, 4 << 30},
+		{"auto not below default", SortOptions{Auto: true}, 64 << 20, true, maxMemoryBytes},
+		{"auto unknown memory", SortOptions{Auto: true}, 0, false, maxMemoryBytes},
+		{"buffer size", SortOptions{BufferSize: 1 << 20}, 64 << 30, true, 1 << 20},
+		{"buffer size over auto", SortOptions{BufferSize: 1 << 20, Auto: true}, 8 << 30, true, 1 << 20},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			withSystemMemory(t, c.available, c.known)
+			if got := c.opts.memoryLimit(); got != c.want {
+				t.Errorf("memoryLimit() = %d, want %d", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSortParallel(t *testing.T) {
+	small := numberedLines("line", parallelMinLines-1)
+	large := numberedLines("line", parallelMinLines)
+	cases := []struct {
+		name  string
+		opts  SortOptions
+		lines []string
+		want  bool
+	}{
+		{"sequential by default", SortOptions{}, large, false},
+		{"auto", SortOptions{Auto: true, Parallel: 4}, large, true},
+		{"parallel", SortOptions{Parallel: 4}, large, true},
+		{"below the minimum", SortOptions{Auto: true, Parallel: 4}, small, false},
+		{"parallel below the minimum", SortOptions{Parallel: 4}, small, false},
+		{"lower threshold", SortOptions{Parallel: 4, ParallelThreshold: 100}, small[:100], true},
+		{"below the threshold", SortOptions{Parallel: 4, ParallelThreshold: 100}, small[:99], false},
+		{"higher threshold", SortOptions{Auto: true, Parallel: 4, ParallelThreshold: parallelMinLines + 1}, large, false},
+		{"one worker", SortOptions{Auto: true, Parallel: 1}, large, false},
+		{"total order check", SortOptions{Auto: true, Parallel: 4, TotalOrderCheck: true}, large, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			withSystemMemory(t, 8<<30, true)
+			if got := c.opts.sortParallel(c.lines); got != c.want {
+				t.Errorf("sortParallel() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+// TestSortParallelMemoryLimit checks that lines are sorted in parallel only
+// while the lines and the string headers of the merge fit in -S, and that a
+// small -S with --parallel still sorts correctly, chunk by chunk.
+func TestSortParallelMemoryLimit(t *testing.T) {
+	lines := slices.Clone(numberedLines("line", 1000))
+	data := 0
+	for _, line := range lines {
+		data += len(line)
+	}
+	limit := linesMemory(data, lines) + stringHeaderSize*len(lines)
+	cases := []struct {
+		buffer int
+		want   bool
+	}{
+		{limit, true},
+		{limit - 1, false},
+		{data, false},
+	}
+	for _, c := range cases {
+		opts := SortOptions{Parallel: 4, ParallelThreshold: 1, BufferSize: c.buffer}
+		if got := opts.sortParallel(lines); got != c.want {
+			t.Errorf("-S %d (limit %d): sortParallel() = %v, want %v", c.buffer, limit, got, c.want)
+		}
+	}
+
+	input := joinLines(mixedLines(20000))
+	want := sortText(t, input, SortOptions{})
+	for _, buffer := range []int{4 << 10, 64 << 10, 1 << 20} {
+		opts := SortOptions{Parallel: 4, ParallelThreshold: 1, BufferSize: buffer, TempDirs: []string{t.TempDir()}}
+		if got := sortText(t, input, opts); got != want {
+			t.Errorf("-S %d --parallel=4: output differs from the sequential sort", buffer)
+		}
+	}
+}
+
+// TestAutoSortOutput checks that --auto sorts like the sequential path,
+// stable order of equal keys included.
+func TestAutoSortOutput(t *testing.T) {
+	withSystemMemory(t, 8<<30, true)
+	input := joinLines(mixedLines(parallelMinLines + 1000))
+	key, err := ParseKeySpec("1,1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stable := range []bool{false, true} {
+		base := SortOptions{Stable: stable, Keys: []KeySpec{key}}
+		want := sortText(t, input, base)
+		for _, parallel := range []int{3, 4} {
+			opts := base
+			opts.Auto, opts.Parallel = true, parallel
+			if got := sortText(t, input, opts); got != want {
+				t.Errorf("stable=%v parallel=%d: output differs from sequential sort", stable, parallel)
+			}
+		}
+	}
+}
+
+// TestParallelThresholdOutput sorts the same input just below and at
+// --parallel-threshold and checks that both sides match the sequential sort,
+// with -s and -u.
+func TestParallelThresholdOutput(t *testing.T) {
+	lines := mixedLines(1000)
+	key, err := ParseKeySpec("1,1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, base := range []SortOptions{{}, {Stable: true, Keys: []KeySpec{key}}, {Unique: true, Keys: []KeySpec{key}}} {
+		want := SortInMemory(slices.Clone(lines), base)
+		for _, threshold := range []int{len(lines), len(lines) + 1} {
+			opts := base
+			opts.Parallel, opts.ParallelThreshold = 4, threshold
+			if parallel := threshold <= len(lines); opts.sortParallel(lines) != parallel {
+				t.Fatalf("threshold %d: sortParallel = %v, want %v", threshold, !parallel, parallel)
+			}
+			if got := SortInMemory(slices.Clone(lines), opts); !slices.Equal(got, want) {
+				t.Errorf("%+v: output differs from the sequential sort", opts)
+			}
+		}
+	}
+}
+
+// BenchmarkSortSmall sorts 1000 lines sequentially, with --parallel and the
+// default threshold (which keeps it sequential), and forced parallel.
+func BenchmarkSortSmall(b *testing.B) {
+	fixture := benchFixture("string", 1000)
+	cases := []struct {
+		name string
+		opts SortOptions
+	}{
+		{"sequential", SortOptions{}},
+		{"parallel-default-threshold", SortOptions{Parallel: 4}},
+		{"parallel-forced", SortOptions{Parallel: 4, ParallelThreshold: 1}},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			lines := make([]string, len(fixture))
+			for b.Loop() {
+				copy(lines, fixture)
+				SortInMemory(lines, c.opts)
+			}
+		})
+	}
+}
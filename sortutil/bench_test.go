@@ -0,0 +1,157 @@
+package sortutil
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+var benchLines = flag.Int("benchlines", 100000, "number of generated lines in sortutil benchmarks")
+
+// benchSize returns the fixture size: -benchlines, or a tenth of it with -short.
+func benchSize() int {
+	if testing.Short() {
+		return max(*benchLines/10, 1)
+	}
+	return *benchLines
+}
+
+// benchFixture generates n random lines whose first field suits the mode:
+// words, numbers, month names or human-readable sizes.
+func benchFixture(mode string, n int) []string {
+	rng := rand.New(rand.NewSource(1))
+	months := []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+	lines := make([]string, n)
+	for i := range lines {
+		var key string
+		switch mode {
+		case "numeric":
+			key = fmt.Sprintf("%d.%02d", rng.Intn(1000000)-500000, rng.Intn(100))
+		case "month":
+			key = months[rng.Intn(len(months))]
+		case "human":
+			key = fmt.Sprintf("%d%c", rng.Intn(1024), " KMGT"[rng.Intn(5)])
+		default:
+			key = fmt.Sprintf("%x", rng.Int63())
+		}
+		// Повторы ключей нужны, чтобы -u было что удалять
+		lines[i] = fmt.Sprintf("%s\tline %d", key, rng.Intn(n/4+1))
+	}
+	return lines
+}
+
+func BenchmarkSortInMemory(b *testing.B) {
+	cases := []struct {
+		name string
+		mode string
+		opts SortOptions
+	}{
+		{"string", "string", SortOptions{}},
+		{"string-unique", "string", SortOptions{Unique: true}},
+		{"numeric", "numeric", SortOptions{Numeric: true}},
+		{"numeric-unique", "numeric", SortOptions{Numeric: true, Unique: true}},
+		{"month", "month", SortOptions{Month: true}},
+		{"human", "human", SortOptions{Human: true}},
+		{"key", "string", SortOptions{Keys: []KeySpec{{StartField: 2}}}},
+	}
+	for _, c := range cases {
+		fixture := benchFixture(c.mode, benchSize())
+		b.Run(c.name, func(b *testing.B) {
+			lines := make([]string, len(fixture))
+			for b.Loop() {
+				copy(lines, fixture)
+				SortInMemory(lines, c.opts)
+			}
+		})
+	}
+}
+
+// BenchmarkSortTies sorts lines keyed by one of twelve month names, so that
+// almost every comparison is a tie: the default breaks it by the whole line
+// with the unstable sort, -s keeps the input order with the stable one.
+func BenchmarkSortTies(b *testing.B) {
+	keys := []KeySpec{{StartField: 1, EndField: 1, Month: true}}
+	cases := []struct {
+		name string
+		opts SortOptions
+	}{
+		{"last-resort", SortOptions{Keys: keys}},
+		{"stable", SortOptions{Keys: keys, Stable: true}},
+		{"no-last-resort", SortOptions{Keys: keys, NoLastResort: true}},
+	}
+	fixture := benchFixture("month", benchSize())
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			lines := make([]string, len(fixture))
+			for b.Loop() {
+				copy(lines, fixture)
+				SortInMemory(lines, c.opts)
+			}
+		})
+	}
+}
+
+// BenchmarkExternalSort runs ExternalSortReader with a limit of about a
+// hundredth of the input, so every iteration spills and merges 100 chunks.
+func BenchmarkExternalSort(b *testing.B) {
+	fixture := benchFixture("string", benchSize())
+	input := strings.Join(fixture, "\n") + "\n"
+	limit := (len(input) + 16*len(fixture)) / 100
+	for b.Loop() {
+		if err := ExternalSortReader(strings.NewReader(input), io.Discard, SortOptions{}, limit); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCheckSorting runs -c over already sorted input: by plain bytes,
+// which takes the fast path, and by a numeric key, which does not.
+func BenchmarkCheckSorting(b *testing.B) {
+	cases := []struct {
+		name string
+		mode string
+		opts SortOptions
+	}{
+		{"bytes", "string", SortOptions{}},
+		{"numeric", "numeric", SortOptions{Numeric: true}},
+	}
+	for _, c := range cases {
+		input := strings.Join(SortInMemory(benchFixture(c.mode, benchSize()), c.opts), "\n") + "\n"
+		b.Run(c.name, func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			b.ReportAllocs()
+			for b.Loop() {
+				if err := CheckSorting(NewLineReader(strings.NewReader(input), c.opts), "-", c.opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkMergeHeap merges 64 sorted in-memory runs with the merge heap,
+// without temporary files or output, and reports the allocations per merge.
+func BenchmarkMergeHeap(b *testing.B) {
+	const runs = 64
+	fixture := benchFixture("string", benchSize())
+	sorted := make([]string, runs)
+	for i := range runs {
+		run := SortInMemory(fixture[i*len(fixture)/runs:(i+1)*len(fixture)/runs], SortOptions{})
+		sorted[i] = strings.Join(run, "\n") + "\n"
+	}
+	comp := newComparator(SortOptions{})
+	b.ReportAllocs()
+	for b.Loop() {
+		files := make([]*tempFile, runs)
+		for i, run := range sorted {
+			files[i] = &tempFile{Scanner: bufio.NewScanner(strings.NewReader(run))}
+		}
+		if err := mergeStreams(files, comp, func(string) error { return nil }); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package sortutil
+
+import (
+	"cmp"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// randomSeed is shared by all comparisons of a run so that -R groups equal keys.
+var randomSeed = maphash.MakeSeed()
+
+// comparator compares lines by the key chain resolved from SortOptions.
+type comparator struct {
+	keys       []KeySpec
+	reverse    bool
+	lastResort bool    // сравнивать целые строки при равных ключах
+	byKeyText  bool    // --tiebreak=key: равные по значению ключи упорядочиваются по тексту
+	exactKeys  bool    // --unique-exact: равные по значению ключи различаются по тексту
+	epsilon    float64 // --epsilon: -u считает числовые ключи с разницей до epsilon равными
+
+	jsonPath        []string // --json-key, разбитый по точкам
+	jsonInvalidLast bool
+
+	orderCheck *orderChecker // --total-order-check; nil — без проверки
+	tagLen     int           // --assert-stable: длина метки номера перед записью
+}
+
+// newComparator resolves the keys of opts once, applying global flags
+// to the keys that have no modifiers of their own.
+func newComparator(opts SortOptions) *comparator {
+	keys := opts.Keys
+	if opts.KeyPrefix && len(keys) == 0 {
+		// --key-prefix: ключ — первая колонка до разделителя -t или табуляции
+		keys = []KeySpec{{StartField: 1, EndField: 1, Separator: opts.indexSeparator()}}
+	}
+	if len(keys) == 0 || opts.KeyRegex != nil || opts.KeyTemplate != nil || opts.KeyFromByte > 0 {
+		// Без -k ключом служит вся строка (или совпадение --key-regex, --key-template, --key-from-byte)
+		keys = []KeySpec{{}}
+	}
+
+	resolved := make([]KeySpec, len(keys))
+	for i, k := range keys {
+		if !k.hasOrdering() {
+			k.Numeric = opts.Numeric
+			k.GeneralNumeric = opts.GeneralNumeric
+			k.Human = opts.Human
+			k.Month = opts.Month
+			k.Version = opts.Version
+			k.Random = opts.Random
+			k.Reverse = opts.Reverse
+			k.FoldCase = opts.FoldCase
+			k.Dictionary = opts.Dictionary
+			k.IgnoreNonprinting = opts.IgnoreNonprinting
+			k.trimBlanks = opts.IgnoreBlanks
+			k.ip = opts.IP
+			k.hex = opts.Hex
+			k.radix = opts.Radix
+			k.natural = opts.Natural
+			k.epoch = opts.Epoch
+			k.autoUnit = opts.AutoUnit
+			k.money = opts.Money
+			k.duration = opts.Duration
+			k.order = opts.Order != nil
+			k.boolean = opts.Bool
+			k.rightAlign = opts.RightAlign
+		}
+		// --key-default-numeric: -n и для ключей с модификаторами вроде b или r,
+		// если ключ не выбрал свой режим (n, g, h, M, V, R или l)
+		if opts.KeyDefaultNumeric && !k.hasMode() {
+			k.Numeric = true
+		}
+		k.stripChars = opts.StripChars
+		k.transform = transformChain(opts.Transform)
+		k.csv = opts.CSV
+		k.tsv = opts.TSV
+		k.sep = opts.Separator
+		if k.Separator != "" {
+			k.sep = k.Separator
+		}
+		k.trimSep = opts.TrimTrailingSep
+		if opts.seqTagged {
+			k.tagLen = seqTagLen
+		}
+		k.widths = opts.Columns
+		k.runes = opts.Runes
+		k.regex = opts.KeyRegex
+		k.template = opts.KeyTemplate
+		k.fromByte = opts.KeyFromByte
+		k.comment = opts.IgnoreComment
+		k.numeric = opts.numberFormat()
+		k.quote = opts.Unquote
+		k.reverseBy = opts.ReverseKey
+		k.squeeze = opts.SqueezeBlanks
+		k.accents = opts.IgnoreAccents
+		if opts.RecordSeparator != "" {
+			k.recordLine = opts.RecordKeyLine
+		}
+		k.comparer = comparers[k.mode()](opts)
+		if factor, ok := opts.Scale[k.StartField]; ok && k.numericMode() {
+			k.scale = factor
+			k.comparer = newScaledComparer(k)
+		}
+		resolved[i] = k
+	}
+	c := &comparator{
+		keys:       resolved,
+		reverse:    opts.Reverse,
+		lastResort: !opts.Stable && !opts.NoLastResort,
+		exactKeys:  opts.UniqueExact,
+		byKeyText:  opts.KeyTieBreak,
+		epsilon:    opts.Epsilon,
+	}
+	if opts.seqTagged {
+		c.tagLen = seqTagLen
+	}
+	if opts.JSONKey != "" {
+		c.jsonPath = strings.Split(opts.JSONKey, ".")
+		c.jsonInvalidLast = opts.JSONInvalidLast
+	}
+	if opts.TotalOrderCheck {
+		c.orderCheck = newOrderChecker()
+	}
+	return c
+}
+
+// compareKeys compares a and b key by key and returns -1, 0 or 1.
+// Reverse order (the key's own r or an inherited -r) is already applied.
+func (c *comparator) compareKeys(a, b string) int {
+	if c.jsonPath != nil {
+		res := c.compareJSON(a, b)
+		if c.reverse {
+			res = -res
+		}
+		return res
+	}
+	for _, k := range c.keys {
+		res := compareField(k.extract(a), k.extract(b), k)
+		if k.Reverse {
+			res = -res
+		}
+		if res != 0 {
+			return res
+		}
+	}
+	if c.exactKeys {
+		return c.compareKeyText(a, b)
+	}
+	return 0
+}
+
+// duplicate reports whether b is a duplicate of a for -u. Without --epsilon
+// that is key equality; with it numeric keys (-n, -g, -h) are also equal when
+// their values differ by at most epsilon.
+func (c *comparator) duplicate(a, b string) bool {
+	if c.epsilon <= 0 || c.jsonPath != nil {
+		return c.compareKeys(a, b) == 0
+	}
+	for _, k := range c.keys {
+		ka, kb := k.extract(a), k.extract(b)
+		if compareField(ka, kb, k) == 0 {
+			continue
+		}
+		if !k.numericMode() {
+			return false
+		}
+		va, okA := k.number(ka)
+		vb, okB := k.number(kb)
+		if !okA || !okB || math.Abs(va-vb) > c.epsilon {
+			return false
+		}
+	}
+	return !c.exactKeys || c.compareKeyText(a, b) == 0
+}
+
+// keyText returns the keys of line exactly as they are compared (--only-keys),
+// joined by the -t separator or a space.
+func (c *comparator) keyText(line string) string {
+	if c.jsonPath != nil {
+		v, ok := jsonValue(line, c.jsonPath)
+		if !ok {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+	sep := " "
+	if c.keys[0].sep != "" {
+		sep = c.keys[0].sep
+	}
+	keys := make([]string, len(c.keys))
+	for i, k := range c.keys {
+		keys[i] = k.extract(line)
+	}
+	return strings.Join(keys, sep)
+}
+
+// decidingKey returns the number (from 1) of the first key on which a and b
+// differ, or 0 when all keys are equal and only the whole lines differ.
+func (c *comparator) decidingKey(a, b string) int {
+	if c.jsonPath != nil {
+		if c.compareJSON(a, b) != 0 {
+			return 1
+		}
+		return 0
+	}
+	for i, k := range c.keys {
+		if compareField(k.extract(a), k.extract(b), k) != 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// compareKeyText orders keys that are equal by value by their exact text,
+// so that -n -u keeps both 007 and 7.
+func (c *comparator) compareKeyText(a, b string) int {
+	for _, k := range c.keys {
+		res := strings.Compare(k.extract(a), k.extract(b))
+		if k.Reverse {
+			res = -res
+		}
+		if res != 0 {
+			return res
+		}
+	}
+	return 0
+}
+
+// compareLines compares keys first and whole lines as the last resort, like GNU sort.
+// With -s or --no-last-resort lines with equal keys compare equal.
+func (c *comparator) compareLines(a, b string) int {
+	res := c.order(a, b)
+	if c.orderCheck != nil {
+		c.orderCheck.check(c, a, b, res)
+	}
+	return res
+}
+
+// order is compareLines without --total-order-check.
+func (c *comparator) order(a, b string) int {
+	res := c.compareKeys(a, b)
+	if res == 0 && c.byKeyText {
+		res = c.compareKeyText(a, b)
+	}
+	if res != 0 || !c.lastResort {
+		return res
+	}
+	return c.tieBreak(a, b)
+}
+
+// tieBreak orders lines whose keys are equal; -r reverses it as well.
+func (c *comparator) tieBreak(a, b string) int {
+	if c.reverse {
+		return strings.Compare(b, a)
+	}
+	return strings.Compare(a, b)
+}
+
+// compareField compares two extracted keys with the comparer resolved for k.
+func compareField(a, b string, k KeySpec) int {
+	return k.comparer.Compare(normalizeKey(a, k), normalizeKey(b, k))
+}
+
+// normalizeKey applies -d, -f and -i to an extracted key in one pass, in GNU's
+// order: dictionary, then fold, then ignore-nonprinting; --squeeze-blanks
+// replaces every run of blanks with one space. --ignore-accents strips
+// diacritics before all of them, and the --transform steps run first, in order.
+// Only the copy of the key used for comparison changes; the output line stays
+// byte for byte as it was read.
+func normalizeKey(s string, k KeySpec) string {
+	for _, t := range k.transform {
+		s = t(s)
+	}
+	if k.accents {
+		s = foldAccents(s)
+	}
+	if !k.FoldCase && !k.Dictionary && !k.IgnoreNonprinting && !k.squeeze {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	blank := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if k.Dictionary && !isBlank(c) && !isAlnum(c) {
+			continue
+		}
+		if k.squeeze && isBlank(c) {
+			if !blank {
+				b.WriteByte(' ')
+			}
+			blank = true
+			continue
+		}
+		blank = false
+		if k.FoldCase && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		if k.IgnoreNonprinting && (c < 0x20 || c > 0x7e) {
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// generalValue parses the leading floating-point number of s like strtod does.
+func generalValue(s string) (float64, bool) {
+	s = strings.TrimLeft(s, " \t")
+
+	// Длиннейший префикс из символов, допустимых в числе
+	i := 0
+	for i < len(s) && strings.IndexByte("+-.0123456789eEinfatyINFATY", s[i]) >= 0 {
+		i++
+	}
+	for ; i > 0; i-- {
+		f, err := strconv.ParseFloat(s[:i], 64)
+		if err == nil {
+			return f, true
+		}
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// compareGeneral orders keys for -g: non-numbers, then NaN, then numbers.
+func compareGeneral(a, b string) int {
+	fa, okA := generalValue(a)
+	fb, okB := generalValue(b)
+	if c := cmp.Compare(generalRank(fa, okA), generalRank(fb, okB)); c != 0 {
+		return c
+	}
+	if !okA || fa != fa {
+		return 0
+	}
+	return cmp.Compare(fa, fb)
+}
+
+func generalRank(f float64, ok bool) int {
+	switch {
+	case !ok:
+		return 0
+	case f != f: // NaN
+		return 1
+	}
+	return 2
+}
+
+// compareVersion compares version strings by value (compareVersionValue);
+// versions equal by value but written differently, like 1.01 and 1.1, are
+// ordered by their text so that the order does not depend on the input.
+func compareVersion(a, b string) int {
+	if res := compareVersionValue(a, b); res != 0 {
+		return res
+	}
+	return strings.Compare(a, b)
+}
+
+// compareVersionValue compares version strings the way Debian's verrevcmp does:
+// digit runs by numeric value ignoring leading zeros, other characters with
+// letters before symbols.
+func compareVersionValue(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for i < len(a) && !isDigit(a[i]) || j < len(b) && !isDigit(b[j]) {
+			oa, ob := versionOrder(a, i), versionOrder(b, j)
+			if oa != ob {
+				return cmp.Compare(oa, ob)
+			}
+			i++
+			j++
+		}
+
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+
+		firstDiff := 0
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = cmp.Compare(a[i], b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return firstDiff
+		}
+	}
+	return 0
+}
+
+// versionOrder ranks the character at s[i]: '~' first, then end of string
+// and digits, then letters, then everything else.
+func versionOrder(s string, i int) int {
+	if i >= len(s) {
+		return 0
+	}
+	c := s[i]
+	switch {
+	case isDigit(c):
+		return 0
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		return int(c)
+	case c == '~':
+		return -1
+	}
+	return int(c) + 256
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isBlank(c byte) bool { return c == ' ' || c == '\t' }
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
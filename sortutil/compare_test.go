@@ -0,0 +1,247 @@
+package sortutil
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestLastResortWholeLine breaks ties between equal keys by the whole line,
+// the same in memory and in the merge of temporary files.
+func TestLastResortWholeLine(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"numeric key", "b\t1\na\t2\nc\t1\na\t1\n", SortOptions{Keys: []KeySpec{{StartField: 2, Numeric: true}}},
+			"a\t1\nb\t1\nc\t1\na\t2\n"},
+		// Ключ с собственным n не наследует -r, а последнее сравнение строк наследует
+		{"numeric key reverse", "b\t1\na\t2\nc\t1\na\t1\n", SortOptions{Keys: []KeySpec{{StartField: 2, Numeric: true}}, Reverse: true},
+			"c\t1\nb\t1\na\t1\na\t2\n"},
+		{"equal numbers", "z\t01\na\t1\nm\t1.0\n", SortOptions{Keys: []KeySpec{{StartField: 2, EndField: 2}}, Numeric: true},
+			"a\t1\nm\t1.0\nz\t01\n"},
+		{"fold case", "apple\nApple\nAPPLE\n", SortOptions{FoldCase: true}, "APPLE\nApple\napple\n"},
+		{"fold case reverse", "apple\nApple\nAPPLE\n", SortOptions{FoldCase: true, Reverse: true}, "apple\nApple\nAPPLE\n"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s in memory: got %q, want %q", c.name, got, c.want)
+		}
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(c.input), &out, c.opts, 1); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != c.want {
+			t.Errorf("%s external: got %q, want %q", c.name, out.String(), c.want)
+		}
+	}
+}
+
+// TestLastResortMatrix shows the effect of -s and --no-last-resort on lines
+// with tied keys: the default orders them by the whole line, -s keeps the
+// input order, and --no-last-resort only keeps the key order.
+func TestLastResortMatrix(t *testing.T) {
+	input := []string{"2\tb", "1\tz", "2\ta", "1\ty", "2\tc", "1\tx"}
+	key := []KeySpec{{StartField: 1, EndField: 1}}
+	cases := []struct {
+		name string
+		opts SortOptions
+		want []string // nil — порядок внутри групп не проверяется
+	}{
+		{"default", SortOptions{}, []string{"1\tx", "1\ty", "1\tz", "2\ta", "2\tb", "2\tc"}},
+		{"reverse", SortOptions{Reverse: true}, []string{"2\tc", "2\tb", "2\ta", "1\tz", "1\ty", "1\tx"}},
+		{"stable", SortOptions{Stable: true}, []string{"1\tz", "1\ty", "1\tx", "2\tb", "2\ta", "2\tc"}},
+		{"stable reverse", SortOptions{Stable: true, Reverse: true}, []string{"2\tb", "2\ta", "2\tc", "1\tz", "1\ty", "1\tx"}},
+		{"stable and no last resort", SortOptions{Stable: true, NoLastResort: true}, []string{"1\tz", "1\ty", "1\tx", "2\tb", "2\ta", "2\tc"}},
+		{"no last resort", SortOptions{NoLastResort: true}, nil},
+	}
+	for _, c := range cases {
+		c.opts.Keys = key
+		got := SortInMemory(slices.Clone(input), c.opts)
+		if c.want != nil {
+			if !slices.Equal(got, c.want) {
+				t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+			}
+			continue
+		}
+		// Ключи идут по порядку, а строки — те же, что во вводе
+		for i := 1; i < len(got); i++ {
+			if got[i-1][0] > got[i][0] {
+				t.Errorf("%s: keys out of order in %q", c.name, got)
+			}
+		}
+		sorted := slices.Sorted(slices.Values(got))
+		if !slices.Equal(sorted, slices.Sorted(slices.Values(input))) {
+			t.Errorf("%s: got %q, not a permutation of the input", c.name, got)
+		}
+	}
+}
+
+// TestKeyTieBreak checks --tiebreak=key: numeric keys equal by value are
+// ordered by their text, and lines with the same key text keep the input
+// order, in memory and through temporary files.
+func TestKeyTieBreak(t *testing.T) {
+	input := "7 a\n07 c\n1 z\n007 b\n7 0\n"
+	cases := []struct {
+		name string
+		opts SortOptions
+		want string
+	}{
+		{"key", SortOptions{KeyTieBreak: true, Stable: true}, "1 z\n007 b\n07 c\n7 a\n7 0\n"},
+		{"key reverse", SortOptions{KeyTieBreak: true, Stable: true, Keys: []KeySpec{{StartField: 1, EndField: 1, Numeric: true, Reverse: true}}},
+			"7 a\n7 0\n07 c\n007 b\n1 z\n"},
+		{"line", SortOptions{}, "1 z\n007 b\n07 c\n7 0\n7 a\n"},
+		{"index", SortOptions{Stable: true}, "1 z\n7 a\n07 c\n007 b\n7 0\n"},
+	}
+	for _, c := range cases {
+		if c.opts.Keys == nil {
+			c.opts.Keys = []KeySpec{{StartField: 1, EndField: 1, Numeric: true}}
+		}
+		if got := sortText(t, input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(input), &out, c.opts, 2); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if out.String() != c.want {
+			t.Errorf("%s: ExternalSortReader = %q, want %q", c.name, out.String(), c.want)
+		}
+	}
+}
+
+// TestNormalizeKey checks that -d, -f and -i compose in one key transform.
+func TestNormalizeKey(t *testing.T) {
+	cases := []struct {
+		key  string
+		spec KeySpec
+		want string
+	}{
+		{"a-B c", KeySpec{}, "a-B c"},
+		{"a-B c", KeySpec{Dictionary: true}, "aB c"},
+		{"a-B c", KeySpec{FoldCase: true}, "A-B C"},
+		{"a\x01b\xff", KeySpec{IgnoreNonprinting: true}, "ab"},
+		{"a-\x01b c", KeySpec{Dictionary: true, FoldCase: true, IgnoreNonprinting: true}, "AB C"},
+		{"a   b\t\tc ", KeySpec{squeeze: true}, "a b c "},
+		{"a - b", KeySpec{squeeze: true, Dictionary: true}, "a b"},
+		{"x \t Y", KeySpec{squeeze: true, FoldCase: true}, "X Y"},
+	}
+	for _, c := range cases {
+		if got := normalizeKey(c.key, c.spec); got != c.want {
+			t.Errorf("normalizeKey(%q, %+v) = %q, want %q", c.key, c.spec, got, c.want)
+		}
+	}
+}
+
+// TestLastResortIsBytewise checks that -f, -d and -i apply to keys only: lines
+// are output byte for byte, lines with equal keys fall back to a byte-wise
+// comparison of the whole lines, and -s keeps them in input order instead.
+func TestLastResortIsBytewise(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"fold case stable", "apple\nApple\nAPPLE\n", SortOptions{FoldCase: true, Stable: true}, "apple\nApple\nAPPLE\n"},
+		{"fold case key", "2 b\n1 B\n3 b\n", SortOptions{FoldCase: true, Keys: []KeySpec{{StartField: 2, EndField: 2}}}, "1 B\n2 b\n3 b\n"},
+		{"dictionary", "a-b\nab\na.b\nb\n", SortOptions{Dictionary: true}, "a-b\na.b\nab\nb\n"},
+		{"ignore nonprinting", "a\x01b\nab\naa\n", SortOptions{IgnoreNonprinting: true}, "aa\na\x01b\nab\n"},
+		{"ignore nonprinting stable", "ab\na\x01b\n", SortOptions{IgnoreNonprinting: true, Stable: true}, "ab\na\x01b\n"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// TestCompareVersion checks that digit runs compare by value ignoring leading
+// zeros, and that versions equal by value are ordered by their text.
+func TestCompareVersion(t *testing.T) {
+	cases := []struct {
+		a, b  string
+		value int // compareVersionValue
+		want  int // compareVersion
+	}{
+		{"1.01", "1.1", 0, -1},
+		{"1.1", "1.01", 0, 1},
+		{"1.001", "1.01", 0, -1},
+		{"1.010", "1.9", 1, 1},
+		{"v007", "v7", 0, -1},
+		{"1.02", "1.1", 1, 1},
+		{"1.0", "1.0", 0, 0},
+		{"2.0", "10.0", -1, -1},
+	}
+	for _, c := range cases {
+		if got := compareVersionValue(c.a, c.b); got != c.value {
+			t.Errorf("compareVersionValue(%q, %q) = %d, want %d", c.a, c.b, got, c.value)
+		}
+		if got := compareVersion(c.a, c.b); got != c.want {
+			t.Errorf("compareVersion(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+
+	// С -s последним сравнением строк не упорядочить: порядок задаёт сам -V
+	for _, input := range []string{"1.1\n1.01\n1.001\n", "1.001\n1.01\n1.1\n"} {
+		got := sortText(t, input, SortOptions{Version: true, Stable: true})
+		if want := "1.001\n1.01\n1.1\n"; got != want {
+			t.Errorf("sort -V -s of %q = %q, want %q", input, got, want)
+		}
+	}
+}
+
+// TestSqueezeBlanks checks that lines differing only in the length of blank
+// runs have equal keys, while the output keeps every line as read.
+func TestSqueezeBlanks(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"stable", "a b\na   b\na\tb\n", SortOptions{SqueezeBlanks: true, Stable: true}, "a b\na   b\na\tb\n"},
+		{"without", "a b\na   b\na\tb\n", SortOptions{Stable: true}, "a\tb\na   b\na b\n"},
+		{"unique", "a b\nb\na  b\n", SortOptions{SqueezeBlanks: true, Unique: true, Stable: true}, "a b\nb\n"},
+		{"order", "a  c\na b\n", SortOptions{SqueezeBlanks: true}, "a b\na  c\n"},
+		{"key", "1 x  y\n2 x y\n", SortOptions{SqueezeBlanks: true, Stable: true, Reverse: true, Keys: []KeySpec{{StartField: 2}}}, "1 x  y\n2 x y\n"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// TestScale checks that --scale multiplies the numbers of numeric keys that
+// start in the given field, and leaves other fields and text keys alone.
+func TestScale(t *testing.T) {
+	field := func(n int, numeric bool) KeySpec {
+		return KeySpec{StartField: n, EndField: n, Numeric: numeric}
+	}
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"negative factor", "2\n10\n1\n", SortOptions{Keys: []KeySpec{field(1, true)}, Scale: map[int]float64{1: -1}}, "10\n2\n1\n"},
+		{"other field", "2\n10\n1\n", SortOptions{Keys: []KeySpec{field(1, true)}, Scale: map[int]float64{2: -1}}, "1\n2\n10\n"},
+		{"text key", "b\na\n", SortOptions{Keys: []KeySpec{field(1, false)}, Scale: map[int]float64{1: -1}}, "a\nb\n"},
+		{"no number first", "2\nx\n1\n", SortOptions{Keys: []KeySpec{field(1, true)}, Scale: map[int]float64{1: -1}}, "x\n2\n1\n"},
+		{"general numeric", "1e3\n5\n", SortOptions{Keys: []KeySpec{{StartField: 1, EndField: 1, GeneralNumeric: true}}, Scale: map[int]float64{1: -1}},
+			"1e3\n5\n"},
+		{"human", "1K\n2M\n512\n", SortOptions{Keys: []KeySpec{{StartField: 1, EndField: 1, Human: true}}, Scale: map[int]float64{1: -1}},
+			"2M\n1K\n512\n"},
+		{"two keys", "1 3\n1 5\n0 9\n", SortOptions{Keys: []KeySpec{field(1, true), field(2, true)}, Scale: map[int]float64{2: -1}},
+			"0 9\n1 5\n1 3\n"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
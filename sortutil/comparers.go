@@ -0,0 +1,514 @@
+package sortutil
+
+import (
+	"cmp"
+	"hash/maphash"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Mode names an ordering mode of a key.
+type Mode string
+
+const (
+	ModeText           Mode = "text"
+	ModeNumeric        Mode = "numeric"  // -n
+	ModeGeneralNumeric Mode = "general"  // -g
+	ModeHuman          Mode = "human"    // -h
+	ModeMonth          Mode = "month"    // -M
+	ModeVersion        Mode = "version"  // -V
+	ModeRandom         Mode = "random"   // -R
+	ModeIP             Mode = "ip"       // --ip
+	ModeHex            Mode = "hex"      // --hex
+	ModeRadix          Mode = "radix"    // --radix
+	ModeNatural        Mode = "natural"  // --natural
+	ModeEpoch          Mode = "epoch"    // --epoch
+	ModeAutoUnit       Mode = "autounit" // --auto-unit
+	ModeMoney          Mode = "money"    // --money
+	ModeDuration       Mode = "duration" // --duration
+	ModeRightAlign     Mode = "right"    // --right-align
+	ModeOrder          Mode = "order"    // --order
+	ModeBool           Mode = "bool"     // --bool
+)
+
+// KeyComparer compares two extracted keys and returns -1, 0 or 1.
+type KeyComparer interface {
+	Compare(a, b string) int
+}
+
+// KeyComparerFunc adapts an ordinary function to KeyComparer.
+type KeyComparerFunc func(a, b string) int
+
+func (f KeyComparerFunc) Compare(a, b string) int { return f(a, b) }
+
+// comparers maps every mode to a constructor of its comparer. The constructor
+// takes SortOptions so that modes with parameters are set up once.
+var comparers = map[Mode]func(SortOptions) KeyComparer{
+	ModeText:           newTextComparer,
+	ModeNumeric:        func(opts SortOptions) KeyComparer { return KeyComparerFunc(opts.numberFormat().compareNumeric) },
+	ModeGeneralNumeric: func(SortOptions) KeyComparer { return KeyComparerFunc(compareGeneral) },
+	ModeHuman:          newHumanComparer,
+	ModeMonth:          newMonthComparer,
+	ModeVersion:        func(SortOptions) KeyComparer { return KeyComparerFunc(compareVersion) },
+	ModeRandom:         func(SortOptions) KeyComparer { return KeyComparerFunc(compareRandom) },
+	ModeIP:             newIPComparer,
+	ModeHex:            func(SortOptions) KeyComparer { return KeyComparerFunc(compareHex) },
+	ModeRadix:          newRadixComparer,
+	ModeNatural:        newNaturalComparer,
+	ModeEpoch:          newEpochComparer,
+	ModeAutoUnit:       newAutoUnitComparer,
+	ModeMoney:          func(SortOptions) KeyComparer { return KeyComparerFunc(compareMoney) },
+	ModeDuration:       func(SortOptions) KeyComparer { return KeyComparerFunc(compareDuration) },
+	ModeRightAlign:     func(SortOptions) KeyComparer { return KeyComparerFunc(compareRightAligned) },
+	ModeOrder:          newOrderComparer,
+	ModeBool:           func(SortOptions) KeyComparer { return KeyComparerFunc(compareBool) },
+}
+
+// mode returns the ordering mode selected by the key's flags.
+func (k KeySpec) mode() Mode {
+	switch {
+	case k.Random:
+		return ModeRandom
+	case k.ip:
+		return ModeIP
+	case k.hex:
+		return ModeHex
+	case k.radix != 0:
+		return ModeRadix
+	case k.natural:
+		return ModeNatural
+	case k.epoch != "":
+		return ModeEpoch
+	case k.autoUnit:
+		return ModeAutoUnit
+	case k.money:
+		return ModeMoney
+	case k.duration:
+		return ModeDuration
+	case k.rightAlign:
+		return ModeRightAlign
+	case k.order:
+		return ModeOrder
+	case k.boolean:
+		return ModeBool
+	case k.Human:
+		return ModeHuman
+	case k.Month:
+		return ModeMonth
+	case k.GeneralNumeric:
+		return ModeGeneralNumeric
+	case k.Numeric:
+		return ModeNumeric
+	case k.Version:
+		return ModeVersion
+	}
+	return ModeText
+}
+
+// durationValue parses the Go duration (1h2m3s, 500ms, 1.5s) at the start of s
+// after blanks; ok is false when there is none. The duration ends at the first
+// blank, so "1.5s elapsed" is 1.5s.
+func durationValue(s string) (time.Duration, bool) {
+	s = strings.TrimLeft(s, " \t")
+	if end := strings.IndexAny(s, " \t"); end >= 0 {
+		s = s[:end]
+	}
+	d, err := time.ParseDuration(s)
+	return d, err == nil
+}
+
+// compareDuration compares keys as durations; keys that are not durations go first.
+func compareDuration(a, b string) int {
+	da, okA := durationValue(a)
+	db, okB := durationValue(b)
+	switch {
+	case okA != okB:
+		if okA {
+			return 1
+		}
+		return -1
+	}
+	return cmp.Compare(da, db)
+}
+
+// Values of SortOptions.Epoch.
+const (
+	EpochSeconds = "s"    // ключи — секунды Unix
+	EpochMillis  = "ms"   // ключи — миллисекунды Unix
+	EpochAuto    = "auto" // единица угадывается по числу цифр
+)
+
+// newEpochComparer compares keys as Unix timestamps in the unit opts.Epoch
+// (--epoch), so that with "auto" 1699000000 (seconds) and 1699000000500
+// (milliseconds) are compared as moments, not as numbers. Keys without a number
+// go first and are equal to each other.
+func newEpochComparer(opts SortOptions) KeyComparer {
+	unit := opts.Epoch
+	return KeyComparerFunc(func(a, b string) int {
+		ta, okA := epochValue(a, unit)
+		tb, okB := epochValue(b, unit)
+		switch {
+		case okA != okB:
+			if okA {
+				return 1
+			}
+			return -1
+		case !okA:
+			return 0
+		}
+		return cmp.Compare(ta, tb)
+	})
+}
+
+// epochValue returns the timestamp at the start of s after blanks in
+// milliseconds: digits with an optional fraction, in unit. With "auto" the unit
+// follows the number of integer digits: up to 10 seconds, up to 13
+// milliseconds, up to 16 microseconds, nanoseconds beyond.
+func epochValue(s, unit string) (float64, bool) {
+	s = strings.TrimLeft(s, " \t")
+	digits := 0
+	for digits < len(s) && isDigit(s[digits]) {
+		digits++
+	}
+	if digits == 0 {
+		return 0, false
+	}
+	end := digits
+	if end+1 < len(s) && s[end] == '.' && isDigit(s[end+1]) {
+		end++
+		for end < len(s) && isDigit(s[end]) {
+			end++
+		}
+	}
+	v, err := strconv.ParseFloat(s[:end], 64)
+	if err != nil {
+		return 0, false
+	}
+	switch {
+	case unit == EpochSeconds || unit == EpochAuto && digits <= 10:
+		return v * 1e3, true
+	case unit == EpochMillis || digits <= 13:
+		return v, true
+	case digits <= 16:
+		return v / 1e3, true
+	}
+	return v / 1e6, true
+}
+
+// Categories of --auto-unit keys, in the order they are sorted.
+const (
+	unitNone     = iota // не число
+	unitNumber          // число без единицы: 42, 1.5
+	unitSize            // размер с суффиксом -h: 10K, 2Gi
+	unitDuration        // длительность Go: 500ms, 1h30m
+)
+
+// unitValue detects the category of key s for --auto-unit and returns its value
+// in the category: a duration in nanoseconds, a size in bytes, a number as is.
+// The suffixes do not overlap: sizes are upper case (10M is megabytes) and
+// durations lower case (10m is minutes).
+func (f numberFormat) unitValue(s string) (int, float64) {
+	if d, ok := durationValue(s); ok && strings.IndexFunc(s, unicode.IsLetter) >= 0 {
+		return unitDuration, float64(d)
+	}
+	number, rest, ok := f.parse(s)
+	switch {
+	case !ok:
+		return unitNone, 0
+	case rest != "" && strings.IndexByte(humanSuffixes, rest[0]) >= 0:
+		return unitSize, f.humanValue(s)
+	}
+	return unitNumber, number
+}
+
+// newAutoUnitComparer returns the --auto-unit comparer: keys are compared by
+// value within their category (unitValue), and the categories go in the order
+// non-numbers, plain numbers, sizes, durations. Keys without a number are equal
+// to each other.
+func newAutoUnitComparer(opts SortOptions) KeyComparer {
+	f := opts.numberFormat()
+	return KeyComparerFunc(func(a, b string) int {
+		ca, va := f.unitValue(a)
+		cb, vb := f.unitValue(b)
+		if c := cmp.Compare(ca, cb); c != 0 {
+			return c
+		}
+		return cmp.Compare(va, vb)
+	})
+}
+
+// boolValue returns 0 for false, no, off, n, f and 0, 1 for true, yes, on, y, t
+// and 1 (in any case, blanks around ignored), and false for anything else.
+func boolValue(s string) (int, bool) {
+	switch strings.ToLower(trimBlanks(s)) {
+	case "false", "no", "off", "n", "f", "0":
+		return 0, true
+	case "true", "yes", "on", "y", "t", "1":
+		return 1, true
+	}
+	return 0, false
+}
+
+// compareBool orders false before true; other keys go after both and are
+// compared as text.
+func compareBool(a, b string) int {
+	va, okA := boolValue(a)
+	vb, okB := boolValue(b)
+	switch {
+	case okA && okB:
+		return cmp.Compare(va, vb)
+	case okA != okB:
+		if okA {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// compareRightAligned compares keys as text right-justified to the same width:
+// the shorter key is padded with spaces on the left, so 2 goes before 10 and
+// A9 before A10. Blanks around the key are ignored.
+func compareRightAligned(a, b string) int {
+	a, b = trimBlanks(a), trimBlanks(b)
+	if len(a) < len(b) {
+		a = strings.Repeat(" ", len(b)-len(a)) + a
+	} else if len(b) < len(a) {
+		b = strings.Repeat(" ", len(a)-len(b)) + b
+	}
+	return strings.Compare(a, b)
+}
+
+func (f numberFormat) compareHuman(a, b string) int {
+	return cmp.Compare(f.humanValue(a), f.humanValue(b))
+}
+
+// newHumanComparer returns the -h comparer. With --human-ties=unit keys of equal
+// value are ordered by the unit they are written in (1000 < 1K, 1000K < 1M)
+// and are not duplicates for -u; otherwise 1000 and 1K are equal keys.
+func newHumanComparer(opts SortOptions) KeyComparer {
+	f := opts.numberFormat()
+	if !opts.HumanUnitTies {
+		return KeyComparerFunc(f.compareHuman)
+	}
+	return KeyComparerFunc(func(a, b string) int {
+		if c := f.compareHuman(a, b); c != 0 {
+			return c
+		}
+		return cmp.Compare(f.humanUnit(a), f.humanUnit(b))
+	})
+}
+
+// humanUnit ranks the suffix of a human-readable number: 0 without one, then
+// K, Ki, M, Mi and so on, so that a smaller unit ranks lower.
+func (f numberFormat) humanUnit(s string) int {
+	_, rest, ok := f.parse(s)
+	if !ok || rest == "" {
+		return 0
+	}
+	power := strings.IndexByte(humanSuffixes, rest[0]) + 1
+	if power == 0 {
+		return 0
+	}
+	if len(rest) > 1 && rest[1] == 'i' {
+		return 2 * power
+	}
+	return 2*power - 1
+}
+
+// compareHex compares the leading hexadecimal numbers of a and b (compareDigits).
+func compareHex(a, b string) int {
+	digitsA, _, okA := parseHex(a)
+	digitsB, _, okB := parseHex(b)
+	return compareDigits(digitsA, okA, digitsB, okB)
+}
+
+// newRadixComparer compares the leading numbers of keys in base opts.Radix
+// (--radix), with digits 0-9 and then a-z in either case, like compareHex.
+func newRadixComparer(opts SortOptions) KeyComparer {
+	base := opts.Radix
+	return KeyComparerFunc(func(a, b string) int {
+		digitsA, okA := radixDigits(a, base)
+		digitsB, okB := radixDigits(b, base)
+		return compareDigits(digitsA, okA, digitsB, okB)
+	})
+}
+
+// radixDigits returns the leading digits of s in base after blanks; ok is false
+// when there are none.
+func radixDigits(s string, base int) (string, bool) {
+	s = strings.TrimLeft(s, " \t")
+	end := 0
+	for end < len(s) && digitValue(s[end]) < base {
+		end++
+	}
+	return s[:end], end > 0
+}
+
+// digitValue returns the value of the digit c in bases up to 36, or 36 if c is not a digit.
+func digitValue(c byte) int {
+	switch {
+	case isDigit(c):
+		return int(c - '0')
+	case c >= 'a' && c <= 'z':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'Z':
+		return int(c-'A') + 10
+	}
+	return 36
+}
+
+// compareDigits compares two numbers given by their digits in the same base.
+// Numbers of any length compare without overflow: first by the count of
+// significant digits, then digit by digit, since their ASCII order (0-9, then
+// a-z) matches their value. Keys without a number go first.
+func compareDigits(digitsA string, okA bool, digitsB string, okB bool) int {
+	switch {
+	case okA != okB:
+		if okA {
+			return 1
+		}
+		return -1
+	case !okA:
+		return 0
+	}
+	digitsA = strings.TrimLeft(digitsA, "0")
+	digitsB = strings.TrimLeft(digitsB, "0")
+	if c := cmp.Compare(len(digitsA), len(digitsB)); c != 0 {
+		return c
+	}
+	return strings.Compare(strings.ToLower(digitsA), strings.ToLower(digitsB))
+}
+
+// newNaturalComparer returns the --natural comparer, a version sort aware of
+// the locale: runs of digits are compared by value, and the text between them
+// by the collation of opts.Locale (bytes in the C locale), so file2 goes before
+// file10 and, in de_DE, Äpfel 2 before Birnen 1. Keys of equal value written
+// differently (a01 and a1) are ordered by bytes.
+func newNaturalComparer(opts SortOptions) KeyComparer {
+	text := newTextComparer(opts)
+	return KeyComparerFunc(func(a, b string) int {
+		if c := compareNatural(a, b, text); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+}
+
+// compareNatural compares a and b run by run: a run of digits goes before
+// text, two runs of digits are compared by value (compareDigits), two runs of
+// text by text. A key that is a prefix of another goes first.
+func compareNatural(a, b string, text KeyComparer) int {
+	for a != "" && b != "" {
+		runA, restA := naturalRun(a)
+		runB, restB := naturalRun(b)
+		digitsA, digitsB := isDigit(runA[0]), isDigit(runB[0])
+		var c int
+		switch {
+		case digitsA && digitsB:
+			c = compareDigits(runA, true, runB, true)
+		case digitsA != digitsB:
+			c = 1
+			if digitsA {
+				c = -1
+			}
+		default:
+			c = text.Compare(runA, runB)
+		}
+		if c != 0 {
+			return c
+		}
+		a, b = restA, restB
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// naturalRun splits the non-empty s into its leading run of digits or of other
+// characters and the rest.
+func naturalRun(s string) (run, rest string) {
+	digits := isDigit(s[0])
+	end := 1
+	for end < len(s) && isDigit(s[end]) == digits {
+		end++
+	}
+	return s[:end], s[end:]
+}
+
+func compareRandom(a, b string) int {
+	return cmp.Compare(maphash.String(randomSeed, a), maphash.String(randomSeed, b))
+}
+
+// newScaledComparer compares the numbers of keys of k multiplied by the --scale
+// factor. The values are float64, so the precision is that of -g; keys without
+// a number go first.
+func newScaledComparer(k KeySpec) KeyComparer {
+	return KeyComparerFunc(func(a, b string) int {
+		va, okA := k.number(a)
+		vb, okB := k.number(b)
+		switch {
+		case okA != okB:
+			if okA {
+				return 1
+			}
+			return -1
+		case !okA:
+			return 0
+		}
+		return cmp.Compare(va, vb)
+	})
+}
+
+// newOrderComparer orders keys by their position in opts.Order (--order),
+// such as low,medium,high. Keys not in the list go after the known ones
+// (before them with --order-unknown=first) and compare with each other as text.
+func newOrderComparer(opts SortOptions) KeyComparer {
+	rank := make(map[string]int, len(opts.Order))
+	for i, value := range opts.Order {
+		rank[value] = i
+	}
+	unknown := len(opts.Order)
+	if opts.OrderUnknownFirst {
+		unknown = -1
+	}
+	position := func(key string) (int, bool) {
+		if i, ok := rank[trimBlanks(key)]; ok {
+			return i, true
+		}
+		return unknown, false
+	}
+	return KeyComparerFunc(func(a, b string) int {
+		ra, okA := position(a)
+		rb, okB := position(b)
+		if !okA && !okB {
+			return strings.Compare(a, b)
+		}
+		return cmp.Compare(ra, rb)
+	})
+}
+
+// newIPComparer orders IPv4 and IPv6 addresses numerically, IPv4 first.
+// Keys that are not addresses go first (last with --ip-invalid-last) and
+// compare with each other as text.
+func newIPComparer(opts SortOptions) KeyComparer {
+	invalid := -1
+	if opts.IPInvalidLast {
+		invalid = 1
+	}
+	return KeyComparerFunc(func(a, b string) int {
+		ipA, errA := netip.ParseAddr(trimBlanks(a))
+		ipB, errB := netip.ParseAddr(trimBlanks(b))
+		switch {
+		case errA != nil && errB != nil:
+			return strings.Compare(a, b)
+		case errA != nil:
+			return invalid
+		case errB != nil:
+			return -invalid
+		}
+		// IPv4 в записи IPv6 (::ffff:10.0.0.1) стоит рядом с обычным IPv4
+		return ipA.Unmap().Compare(ipB.Unmap())
+	})
+}
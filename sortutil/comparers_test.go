@@ -0,0 +1,491 @@
+package sortutil
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestComparers checks every registered comparer on keys where its mode and
+// plain text comparison disagree, and that each of them is antisymmetric.
+func TestComparers(t *testing.T) {
+	cases := []struct {
+		mode Mode
+		opts SortOptions
+		a, b string
+		want int
+	}{
+		{ModeText, SortOptions{}, "10", "9", -1},
+		{ModeText, SortOptions{}, "b", "b", 0},
+		{ModeNumeric, SortOptions{Numeric: true}, "10", "9", 1},
+		{ModeNumeric, SortOptions{Numeric: true}, "-1", "", 1}, // ключ без числа меньше любого числа
+		{ModeNumeric, SortOptions{Numeric: true}, "007", "7", 0},
+		{ModeGeneralNumeric, SortOptions{GeneralNumeric: true}, "1e3", "999", 1},
+		{ModeGeneralNumeric, SortOptions{GeneralNumeric: true}, "nan", "-inf", -1},
+		{ModeHuman, SortOptions{Human: true}, "2K", "1M", -1},
+		{ModeHuman, SortOptions{Human: true}, "900", "1K", -1},
+		{ModeMonth, SortOptions{Month: true}, "Feb", "Jan", 1},
+		{ModeMonth, SortOptions{Month: true}, "foo", "Jan", -1},
+		{ModeVersion, SortOptions{Version: true}, "v1.10", "v1.9", 1},
+		{ModeVersion, SortOptions{Version: true}, "1.0~rc1", "1.0", -1},
+		{ModeRandom, SortOptions{Random: true}, "same", "same", 0},
+		{ModeIP, SortOptions{IP: true}, "10.0.0.2", "9.0.0.1", 1},
+		{ModeIP, SortOptions{IP: true}, "::1", "10.0.0.1", 1},
+		{ModeHex, SortOptions{Hex: true}, "0xff", "a0", 1},
+		{ModeRadix, SortOptions{Radix: 8}, "10", "7", 1},
+		{ModeNatural, SortOptions{Natural: true}, "file10", "file2", 1},
+		{ModeEpoch, SortOptions{Epoch: EpochAuto}, "1699000000500", "1699000001", -1},
+		{ModeAutoUnit, SortOptions{AutoUnit: true}, "10m", "10M", 1},
+		{ModeMoney, SortOptions{Money: true}, "$1,000", "$999.99", 1},
+		{ModeDuration, SortOptions{Duration: true}, "1h", "59m", 1},
+		{ModeRightAlign, SortOptions{RightAlign: true}, "10", "9", 1},
+		{ModeOrder, SortOptions{Order: []string{"low", "high"}}, "high", "low", 1},
+		{ModeBool, SortOptions{Bool: true}, "yes", "0", 1},
+	}
+	covered := make(map[Mode]bool)
+	for _, c := range cases {
+		k := newComparator(c.opts).keys[0]
+		if k.mode() != c.mode {
+			t.Errorf("%+v selects mode %s, want %s", c.opts, k.mode(), c.mode)
+			continue
+		}
+		covered[c.mode] = true
+		if got := k.comparer.Compare(c.a, c.b); got != c.want {
+			t.Errorf("%s: compare(%q, %q) = %d, want %d", c.mode, c.a, c.b, got, c.want)
+		}
+		if back := k.comparer.Compare(c.b, c.a); back != -c.want {
+			t.Errorf("%s: compare(%q, %q) = %d, want %d", c.mode, c.b, c.a, back, -c.want)
+		}
+	}
+	for mode := range comparers {
+		if !covered[mode] {
+			t.Errorf("no test case for the comparer of mode %s", mode)
+		}
+	}
+}
+
+// TestSortIP sorts a mix of IPv4 and IPv6 addresses with --ip, with the
+// lines that are not addresses placed first and last.
+func TestSortIP(t *testing.T) {
+	input := "2001:db8::1\n10.0.0.2\nhost\n9.0.0.1\n::ffff:9.0.0.5\n::1\n192.168.1.10\n192.168.1.9\n"
+	cases := []struct {
+		name string
+		opts SortOptions
+		want []string
+	}{
+		{"invalid first", SortOptions{IP: true}, []string{
+			"host", "9.0.0.1", "::ffff:9.0.0.5", "10.0.0.2", "192.168.1.9", "192.168.1.10", "::1", "2001:db8::1",
+		}},
+		{"invalid last", SortOptions{IP: true, IPInvalidLast: true}, []string{
+			"9.0.0.1", "::ffff:9.0.0.5", "10.0.0.2", "192.168.1.9", "192.168.1.10", "::1", "2001:db8::1", "host",
+		}},
+		{"reverse", SortOptions{IP: true, Reverse: true}, []string{
+			"2001:db8::1", "::1", "192.168.1.10", "192.168.1.9", "10.0.0.2", "::ffff:9.0.0.5", "9.0.0.1", "host",
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			want := strings.Join(c.want, "\n") + "\n"
+			if got := sortText(t, input, c.opts); got != want {
+				t.Errorf("got:\n%swant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+// TestCompareHex checks --hex keys with and without the 0x prefix, in any
+// case and of any length, and keys that have no hexadecimal number.
+func TestCompareHex(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"0x10", "0x2", 1},
+		{"0x10", "10", 0},
+		{"255", "0xff", 1}, // 0x255 > 0xff
+		{"ff", "0xFF", 0},
+		{"0x0010", "0x10", 0},
+		{"  0x2", "0x10", -1},
+		{"0x1f3a rest", "0x1f3b", -1},
+		{"ffffffffffffffffffff", "0x1" + strings.Repeat("0", 19), 1}, // больше 64 бит
+		{"zzz", "0", -1},
+		{"0x", "0", 0}, // "0x" без цифр — это число 0 с остатком "x"
+		{"zzz", "", 0},
+	}
+	for _, c := range cases {
+		if got := compareHex(c.a, c.b); got != c.want {
+			t.Errorf("compareHex(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+		if back := compareHex(c.b, c.a); back != -c.want {
+			t.Errorf("compareHex(%q, %q) = %d, want %d", c.b, c.a, back, -c.want)
+		}
+	}
+}
+
+// TestSortHex sorts a mix of prefixed and bare hexadecimal keys with --hex.
+func TestSortHex(t *testing.T) {
+	input := "0x10\n255\n0x2\nnot hex\n0xff\n0x1F3A\n"
+	want := "not hex\n0x2\n0x10\n0xff\n255\n0x1F3A\n"
+	if got := sortText(t, input, SortOptions{Hex: true}); got != want {
+		t.Errorf("got:\n%swant:\n%s", got, want)
+	}
+}
+
+// TestCompareRadix checks --radix keys in bases 2, 8 and 36: digits beyond
+// the base end the number, letters compare in either case, and numbers of any
+// length compare without overflow.
+func TestCompareRadix(t *testing.T) {
+	cases := []struct {
+		a, b string
+		base int
+		want int
+	}{
+		{"10", "7", 8, 1},
+		{"0755", "644", 8, 1},
+		{"17", "9", 8, 1}, // 9 не восьмеричная цифра: ключ без числа
+		{"78", "7", 8, 0}, // число кончается на 8
+		{"101", "11", 2, 1},
+		{"2", "1", 2, -1}, // 2 — не двоичная цифра
+		{"z", "10", 36, -1},
+		{"ZZ", "zz", 36, 0},
+		{"a", "B", 36, -1},
+		{"  1a", "1B", 36, -1},
+		{"zzzzzzzzzzzzzzzzzzzz", "1" + strings.Repeat("0", 19), 36, 1}, // больше 64 бит
+		{"-1", "0", 10, -1}, // знака нет: "-1" — ключ без числа
+		{"", "x", 10, 0},
+	}
+	for _, c := range cases {
+		compare := newRadixComparer(SortOptions{Radix: c.base})
+		if got := compare.Compare(c.a, c.b); got != c.want {
+			t.Errorf("base %d: compare(%q, %q) = %d, want %d", c.base, c.a, c.b, got, c.want)
+		}
+		if back := compare.Compare(c.b, c.a); back != -c.want {
+			t.Errorf("base %d: compare(%q, %q) = %d, want %d", c.base, c.b, c.a, back, -c.want)
+		}
+	}
+}
+
+// TestSortRadix sorts octal permissions and base-36 identifiers with --radix,
+// as the whole line and as a key.
+func TestSortRadix(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"octal", "755\n1777\n644\n9\n0700\n", SortOptions{Radix: 8}, "9\n644\n0700\n755\n1777\n"},
+		{"base36", "a1\nZ\n10\n9\n", SortOptions{Radix: 36}, "9\nZ\n10\na1\n"},
+		{"key", "x 11\ny 2\n", SortOptions{Radix: 2, Keys: []KeySpec{{StartField: 2, EndField: 2}}}, "y 2\nx 11\n"},
+		{"reverse", "10\n7\n", SortOptions{Radix: 8, Reverse: true}, "10\n7\n"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// TestCompareNatural checks --natural: runs of digits by value before text,
+// text runs by the locale, and keys of equal value ordered by bytes.
+func TestCompareNatural(t *testing.T) {
+	cases := []struct {
+		a, b   string
+		locale string
+		want   int
+	}{
+		{"file10", "file2", "", 1},
+		{"file2", "file2", "", 0},
+		{"a01", "a1", "", -1}, // равны по значению, затем по байтам
+		{"a1b", "a1", "", 1},  // префикс идёт первым
+		{"1a", "a", "", -1},   // цифры раньше текста
+		{"v1.10", "v1.9", "", 1},
+		{"x" + strings.Repeat("9", 30), "x1" + strings.Repeat("0", 30), "", -1}, // больше 64 бит
+		{"Äpfel 2", "Birnen 1", "", 1},
+		{"Äpfel 2", "Birnen 1", "de_DE.UTF-8", -1},
+	}
+	for _, c := range cases {
+		compare := newNaturalComparer(SortOptions{Locale: c.locale})
+		if got := compare.Compare(c.a, c.b); got != c.want {
+			t.Errorf("locale %q: compare(%q, %q) = %d, want %d", c.locale, c.a, c.b, got, c.want)
+		}
+		if back := compare.Compare(c.b, c.a); back != -c.want {
+			t.Errorf("locale %q: compare(%q, %q) = %d, want %d", c.locale, c.b, c.a, back, -c.want)
+		}
+	}
+}
+
+// TestSortNatural sorts file names and mixed keys with --natural, in the C
+// locale and in de_DE, as the whole line and as a key.
+func TestSortNatural(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"files", "file10\nfile2\nfile1\nfile02\n", SortOptions{Natural: true}, "file1\nfile02\nfile2\nfile10\n"},
+		{"C locale", "Äpfel 2\nBirnen 1\napfel 10\n", SortOptions{Natural: true}, "Birnen 1\napfel 10\nÄpfel 2\n"},
+		{"de_DE", "Äpfel 2\nBirnen 1\napfel 10\n", SortOptions{Natural: true, Locale: "de_DE.UTF-8"}, "apfel 10\nÄpfel 2\nBirnen 1\n"},
+		{"key", "x img12\ny img9\n", SortOptions{Natural: true, Keys: []KeySpec{{StartField: 2, EndField: 2}}}, "y img9\nx img12\n"},
+		{"reverse", "a2\na10\n", SortOptions{Natural: true, Reverse: true}, "a10\na2\n"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// TestEpochValue converts timestamps to milliseconds in each unit, with the
+// unit of "auto" chosen by the number of integer digits.
+func TestEpochValue(t *testing.T) {
+	cases := []struct {
+		s, unit string
+		want    float64
+		ok      bool
+	}{
+		{"1699000000", EpochSeconds, 1699000000e3, true},
+		{"1699000000.5", EpochSeconds, 1699000000500, true},
+		{"1699000000500", EpochMillis, 1699000000500, true},
+		{"1699000000", EpochMillis, 1699000000, true},
+		{"  1699000000 GET", EpochAuto, 1699000000e3, true},
+		{"1699000000500", EpochAuto, 1699000000500, true},
+		{"1699000000500000", EpochAuto, 1699000000500, true},
+		{"1699000000500000000", EpochAuto, 1699000000500, true},
+		{"42", EpochAuto, 42e3, true},
+		{"12.", EpochSeconds, 12e3, true},
+		{"-5", EpochSeconds, 0, false},
+		{"", EpochAuto, 0, false},
+		{"now", EpochAuto, 0, false},
+	}
+	for _, c := range cases {
+		got, ok := epochValue(c.s, c.unit)
+		if ok != c.ok || got != c.want {
+			t.Errorf("epochValue(%q, %s) = %v, %v, want %v, %v", c.s, c.unit, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+// TestSortEpoch sorts a log mixing second and millisecond timestamps into
+// chronological order with --epoch=auto, unlike -n.
+func TestSortEpoch(t *testing.T) {
+	input := "1699000001 b\n1699000000500 a2\n-\n1699000000 a1\n1699000000999 a3\n"
+	cases := []struct {
+		name string
+		opts SortOptions
+		want string
+	}{
+		{"auto", SortOptions{Epoch: EpochAuto}, "-\n1699000000 a1\n1699000000500 a2\n1699000000999 a3\n1699000001 b\n"},
+		{"auto reverse", SortOptions{Epoch: EpochAuto, Reverse: true}, "1699000001 b\n1699000000999 a3\n1699000000500 a2\n1699000000 a1\n-\n"},
+		{"numeric", SortOptions{Numeric: true}, "-\n1699000000 a1\n1699000001 b\n1699000000500 a2\n1699000000999 a3\n"},
+		{"key", SortOptions{Epoch: EpochAuto, Keys: []KeySpec{{StartField: 1, EndField: 1}}}, "-\n1699000000 a1\n1699000000500 a2\n1699000000999 a3\n1699000001 b\n"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// TestUnitValue detects the category and value of --auto-unit keys.
+func TestUnitValue(t *testing.T) {
+	cases := []struct {
+		s        string
+		category int
+		value    float64
+	}{
+		{"42", unitNumber, 42},
+		{"-1.5", unitNumber, -1.5},
+		{"10K", unitSize, 10e3},
+		{"2Gi", unitSize, 2 << 30},
+		{"10M", unitSize, 10e6},
+		{"10m", unitDuration, float64(10 * time.Minute)},
+		{"500ms", unitDuration, float64(500 * time.Millisecond)},
+		{"1h30m", unitDuration, float64(90 * time.Minute)},
+		{"n/a", unitNone, 0},
+		{"", unitNone, 0},
+	}
+	f := SortOptions{}.numberFormat()
+	for _, c := range cases {
+		category, value := f.unitValue(c.s)
+		if category != c.category || value != c.value {
+			t.Errorf("unitValue(%q) = %d, %v, want %d, %v", c.s, category, value, c.category, c.value)
+		}
+	}
+}
+
+// TestSortAutoUnit sorts the mixed keys of the README with --auto-unit: by
+// value within each category, and the categories in their fixed order.
+func TestSortAutoUnit(t *testing.T) {
+	want := "n/a\n0\n42\n10K\n1M\n1500K\n2Gi\n500ms\n90s\n10m\n1h\n"
+	input := "1h\n10K\n90s\n2Gi\n42\nn/a\n1M\n10m\n0\n500ms\n1500K\n"
+	if got := sortText(t, input, SortOptions{AutoUnit: true}); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	keyed := "a 1M\nb 90s\nc 1500K\n"
+	opts := SortOptions{AutoUnit: true, Reverse: true, Keys: []KeySpec{{StartField: 2, EndField: 2}}}
+	if got, want := sortText(t, keyed, opts), "b 90s\nc 1500K\na 1M\n"; got != want {
+		t.Errorf("key, reverse: got %q, want %q", got, want)
+	}
+}
+
+// TestSortDuration sorts a mix of ms, s, m and h durations into real-time
+// order, with the keys that are not durations first.
+func TestSortDuration(t *testing.T) {
+	input := "1h\n500ms\n1.5s\n90m\nn/a\n2m30s\n1h2m3s\n1500ms elapsed\n-1s\n"
+	want := "n/a\n-1s\n500ms\n1.5s\n1500ms elapsed\n2m30s\n1h\n1h2m3s\n90m\n"
+	if got := sortText(t, input, SortOptions{Duration: true}); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	k, err := ParseKeySpec("2,2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := sortText(t, "b 2s\na 150ms\nc 1m\n", SortOptions{Duration: true, Reverse: true, Keys: []KeySpec{k}})
+	if want := "c 1m\nb 2s\na 150ms\n"; got != want {
+		t.Errorf("-k 2,2 -r: got %q, want %q", got, want)
+	}
+}
+
+// TestCompareRightAligned checks that the shorter key is compared as if padded
+// on the left, where plain text comparison puts 10 before 2.
+func TestCompareRightAligned(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"2", "10", -1},
+		{"A9", "A10", -1},
+		{"10", "10", 0},
+		{" 7 ", "7", 0}, // пробелы вокруг ключа не считаются
+		{"ab", "b", 1},
+		{"", "0", -1},
+		{"-5", "10", -1}, // это не число: '-' меньше '1'
+	}
+	for _, c := range cases {
+		if got := compareRightAligned(c.a, c.b); got != c.want {
+			t.Errorf("compareRightAligned(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+
+	input := "10\n2\n1\n100\n20\n"
+	if got, want := sortText(t, input, SortOptions{}), "1\n10\n100\n2\n20\n"; got != want {
+		t.Errorf("text: got %q, want %q", got, want)
+	}
+	if got, want := sortText(t, input, SortOptions{RightAlign: true}), "1\n2\n10\n20\n100\n"; got != want {
+		t.Errorf("--right-align: got %q, want %q", got, want)
+	}
+}
+
+// TestSortOrder sorts severity labels into the order given by --order, with
+// unknown labels after or before the known ones.
+func TestSortOrder(t *testing.T) {
+	order := []string{"low", "medium", "high", "critical"}
+	input := "high\nlow\nbogus\ncritical\nmedium\n low\nalpha\n"
+	cases := []struct {
+		name string
+		opts SortOptions
+		want string
+	}{
+		{"unknown last", SortOptions{Order: order}, " low\nlow\nmedium\nhigh\ncritical\nalpha\nbogus\n"},
+		{"unknown first", SortOptions{Order: order, OrderUnknownFirst: true}, "alpha\nbogus\n low\nlow\nmedium\nhigh\ncritical\n"},
+		{"reverse", SortOptions{Order: order, Reverse: true}, "bogus\nalpha\ncritical\nhigh\nmedium\nlow\n low\n"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	k, err := ParseKeySpec("2,2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := sortText(t, "disk high\ncpu low\nnet critical\n", SortOptions{Order: order, Keys: []KeySpec{k}})
+	if want := "cpu low\ndisk high\nnet critical\n"; got != want {
+		t.Errorf("-k 2,2: got %q, want %q", got, want)
+	}
+}
+
+// TestSortBool sorts a column of mixed boolean spellings: every false before
+// every true, and unknown tokens after both.
+func TestSortBool(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"no", "yes", -1},
+		{"False", "TRUE", -1},
+		{"0", "1", -1},
+		{"off", "n", 0},
+		{" yes ", "t", 0},
+		{"maybe", "yes", 1},
+		{"maybe", "other", -1}, // неизвестные сравниваются как текст
+		{"", "0", 1},
+	}
+	for _, c := range cases {
+		if got := compareBool(c.a, c.b); got != c.want {
+			t.Errorf("compareBool(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+
+	k, err := ParseKeySpec("2,2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := "a yes\nb no\nc true\nd 0\ne maybe\nf 1\ng false\n"
+	got := sortText(t, input, SortOptions{Bool: true, Stable: true, Keys: []KeySpec{k}})
+	if want := "b no\nd 0\ng false\na yes\nc true\nf 1\ne maybe\n"; got != want {
+		t.Errorf("-k 2,2 -s: got %q, want %q", got, want)
+	}
+	got = sortText(t, input, SortOptions{Bool: true, Reverse: true, Keys: []KeySpec{k}})
+	if want := "e maybe\nf 1\nc true\na yes\ng false\nd 0\nb no\n"; got != want {
+		t.Errorf("-k 2,2 -r: got %q, want %q", got, want)
+	}
+}
+
+// TestHumanTies orders -h keys of equal value written in different units:
+// by default 1000 and 1K are equal keys, so the last resort or -s decides and
+// -u keeps one of them; with --human-ties=unit the smaller unit goes first
+// and -u keeps both.
+func TestHumanTies(t *testing.T) {
+	cases := []struct {
+		s    string
+		want int
+	}{
+		{"1000", 0},
+		{"1K", 1},
+		{"1Ki", 2},
+		{"1000K", 1},
+		{"1M", 3},
+		{"2Gi", 6},
+		{"5x", 0},
+		{"", 0},
+	}
+	for _, c := range cases {
+		if got := cNumeric.humanUnit(c.s); got != c.want {
+			t.Errorf("humanUnit(%q) = %d, want %d", c.s, got, c.want)
+		}
+	}
+
+	input := "1M\n1K\n1000K\n1000\n"
+	sorts := []struct {
+		name string
+		opts SortOptions
+		want string
+	}{
+		{"line", SortOptions{Human: true}, "1000\n1K\n1000K\n1M\n"},
+		{"line stable", SortOptions{Human: true, Stable: true}, "1K\n1000\n1M\n1000K\n"},
+		{"line unique", SortOptions{Human: true, Unique: true, Stable: true}, "1K\n1M\n"},
+		{"unit", SortOptions{Human: true, HumanUnitTies: true, Stable: true}, "1000\n1K\n1000K\n1M\n"},
+		{"unit reverse", SortOptions{Human: true, HumanUnitTies: true, Reverse: true}, "1M\n1000K\n1K\n1000\n"},
+		{"unit unique", SortOptions{Human: true, HumanUnitTies: true, Unique: true}, "1000\n1K\n1000K\n1M\n"},
+	}
+	for _, c := range sorts {
+		if got := sortText(t, input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package sortutil
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// tempWriter writes records to a new object of a TempStore, piping them
+// through --compress-program when one is set.
+type tempWriter struct {
+	*recordWriter
+	name  string
+	store TempStore
+	w     io.WriteCloser // объект хранилища
+	cmd   *exec.Cmd      // компрессор; nil — запись без сжатия
+	pipe  io.WriteCloser // stdin компрессора
+}
+
+func newTempWriter(store TempStore, pattern string, opts SortOptions) (*tempWriter, error) {
+	name, w, err := store.Create(pattern)
+	if err != nil {
+		return nil, err
+	}
+	t := &tempWriter{name: name, store: store, w: w}
+	if opts.CompressProgram == "" {
+		t.recordWriter = newRecordWriter(w, opts)
+		return t, nil
+	}
+
+	t.cmd = exec.Command(opts.CompressProgram)
+	t.cmd.Stdout = w
+	t.cmd.Stderr = os.Stderr
+	if t.pipe, err = t.cmd.StdinPipe(); err == nil {
+		err = t.cmd.Start()
+	}
+	if err != nil {
+		_ = w.Close()
+		_ = store.Remove(name)
+		return nil, fmt.Errorf("sort: couldn't execute compress program %s: %w", opts.CompressProgram, err)
+	}
+	t.recordWriter = newRecordWriter(t.pipe, opts)
+	return t, nil
+}
+
+// close flushes the records, waits for the compressor and completes the object.
+func (t *tempWriter) close() error {
+	err := t.flush()
+	if t.cmd != nil {
+		// Компрессор дожидаемся всегда, даже если запись в него сломалась
+		if closeErr := t.pipe.Close(); err == nil {
+			err = closeErr
+		}
+		if waitErr := t.cmd.Wait(); waitErr != nil && err == nil {
+			err = fmt.Errorf("compress program %s failed: %w", t.cmd.Path, waitErr)
+		}
+	}
+	if closeErr := t.w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// discard stops the compressor and removes the partially written object.
+func (t *tempWriter) discard() {
+	if t.cmd != nil {
+		_ = t.pipe.Close()
+		_ = t.cmd.Process.Kill()
+		_ = t.cmd.Wait()
+	}
+	_ = t.w.Close()
+	_ = t.store.Remove(t.name)
+}
+
+// openTemp opens a complete temporary object for reading; compressed tells
+// whether it was written through --compress-program.
+func openTemp(store TempStore, name string, compressed bool, opts SortOptions) (*tempFile, error) {
+	r, err := store.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	tf, err := readTemp(r, name, compressed, opts)
+	if err != nil {
+		return nil, err
+	}
+	tf.store = store
+	return tf, nil
+}
+
+// readTemp prepares r for merging. A plain chunk is read as is, whatever bytes
+// its first record starts with. The format of a compressed one is recognised by
+// its signature, so chunks of different codecs can be mixed: gzip and
+// registered codecs are decompressed natively, other formats through "PROG -d"
+// of --compress-program.
+func readTemp(r io.ReadCloser, name string, compressed bool, opts SortOptions) (*tempFile, error) {
+	tf := &tempFile{ReadCloser: r, name: name}
+	if !compressed {
+		tf.Scanner = newTempReader(r, opts)
+		return tf, nil
+	}
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(maxMagicLen)
+	codec, _ := detectCodec(magic)
+	tf.codec = codec.name
+
+	switch {
+	case codec.open != nil:
+		dr, err := codec.open(br)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("sort: cannot read %s temporary file %s: %w", codec.name, name, err)
+		}
+		tf.Scanner = newTempReader(dr, opts)
+	default:
+		cmd := exec.Command(opts.CompressProgram, "-d")
+		cmd.Stdin = br
+		cmd.Stderr = os.Stderr
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("sort: couldn't execute compress program %s -d: %w", opts.CompressProgram, err)
+		}
+		tf.Scanner = newTempReader(&decompressReader{ReadCloser: stdout, cmd: cmd}, opts)
+		tf.cmd = cmd
+	}
+	return tf, nil
+}
+
+// tempCodec describes a compression format of temporary files.
+type tempCodec struct {
+	name  string
+	magic []byte
+	open  func(io.Reader) (io.Reader, error) // nil — распаковка через --compress-program
+}
+
+const maxMagicLen = 6
+
+// tempCodecs lists the recognised formats; gzip is decompressed natively.
+// Signatures are checked only for chunks written through --compress-program:
+// a text record may start with any bytes, "\x1f\x8b" included.
+var tempCodecs = []tempCodec{
+	{name: "gzip", magic: []byte{0x1f, 0x8b}, open: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+	{name: "zstd", magic: []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{name: "xz", magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{name: "lz4", magic: []byte{0x04, 0x22, 0x4d, 0x18}},
+}
+
+// RegisterTempCodec adds a compression format that temporary files are
+// recognised by and decompressed with, without running --compress-program.
+// A codec with the same signature replaces the previous one. Not safe to call
+// during a sort.
+func RegisterTempCodec(name string, magic []byte, open func(io.Reader) (io.Reader, error)) {
+	if len(magic) == 0 || len(magic) > maxMagicLen {
+		panic("sortutil: codec magic must be 1 to 6 bytes")
+	}
+	codec := tempCodec{name: name, magic: magic, open: open}
+	for i := range tempCodecs {
+		if bytes.Equal(tempCodecs[i].magic, magic) {
+			tempCodecs[i] = codec
+			return
+		}
+	}
+	tempCodecs = append(tempCodecs, codec)
+}
+
+func detectCodec(magic []byte) (tempCodec, bool) {
+	for _, codec := range tempCodecs {
+		if bytes.HasPrefix(magic, codec.magic) {
+			return codec, true
+		}
+	}
+	return tempCodec{}, false
+}
+
+// decompressReader reports a failed decompressor at the end of its output,
+// so a truncated temporary file is never mistaken for a short one.
+type decompressReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *decompressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		if waitErr := r.cmd.Wait(); waitErr != nil {
+			return n, fmt.Errorf("sort: compress program %s -d failed: %w", r.cmd.Path, waitErr)
+		}
+	}
+	return n, err
+}
+
+// stop kills a decompressor that was not read to the end.
+func (tf *tempFile) stop() {
+	if tf.cmd != nil && tf.cmd.ProcessState == nil {
+		_ = tf.cmd.Process.Kill()
+		_ = tf.cmd.Wait()
+	}
+}
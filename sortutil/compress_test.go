@@ -0,0 +1,203 @@
+package sortutil
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// filterScript writes an executable shell script with body to a temporary
+// directory and returns its path.
+func filterScript(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "filter")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestCompressProgram sorts through temporary files piped through an
+// external program and checks the round trip, including a multi-level merge.
+func TestCompressProgram(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+	programs := []string{
+		// Фильтр без сжатия: и запись, и чтение с -d просто копируют данные
+		filterScript(t, "exec cat"),
+	}
+	if gzip, err := exec.LookPath("gzip"); err == nil {
+		programs = append(programs, gzip)
+	}
+	lines := numberedLines("line", 5000)
+	want := joinLines(SortInMemory(slices.Clone(lines), SortOptions{}))
+	for _, program := range programs {
+		for _, limit := range []int{1 << 20, 20000, 1000} {
+			dir := t.TempDir()
+			opts := SortOptions{CompressProgram: program, TempDirs: []string{dir}}
+			var out bytes.Buffer
+			if err := ExternalSortReader(strings.NewReader(joinLines(lines)), &out, opts, limit); err != nil {
+				t.Fatalf("%s, limit %d: %v", program, limit, err)
+			}
+			if out.String() != want {
+				t.Errorf("%s, limit %d: output differs from the in-memory sort", program, limit)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("%s, limit %d: %d temporary files left", program, limit, len(entries))
+			}
+		}
+	}
+}
+
+// TestCompressProgramErrors checks that a missing program and a program that
+// fails on write or on read are reported instead of losing records.
+func TestCompressProgramErrors(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+	cases := []struct {
+		name    string
+		program string
+		want    string
+	}{
+		{"missing", filepath.Join(t.TempDir(), "missing"), "couldn't execute compress program"},
+		{"fails on write", filterScript(t, "cat >/dev/null; exit 3"), "failed"},
+		// Сжатые данные помечены сигнатурой zstd, чтобы при чтении запускался PROG -d
+		{"fails on read", filterScript(t, `if [ "$1" = -d ]; then cat >/dev/null; exit 3; fi; printf '\050\265\057\375'; exec cat`), "-d failed"},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		opts := SortOptions{CompressProgram: c.program, TempDirs: []string{dir}}
+		err := ExternalSortReader(strings.NewReader(joinLines(numberedLines("line", 2000))), &bytes.Buffer{}, opts, 5000)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.want)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("%s: %d temporary files left", c.name, len(entries))
+		}
+	}
+}
+
+// gzipped returns the records of lines compressed with gzip.
+func gzipped(t *testing.T, lines []string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := io.WriteString(zw, joinLines(lines)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// TestReadTempMixed merges a gzip chunk, a plain chunk and a chunk of a
+// registered codec, without --compress-program: each compressed chunk is
+// recognised by its signature, the plain one is read as is.
+func TestReadTempMixed(t *testing.T) {
+	saved := slices.Clone(tempCodecs)
+	defer func() { tempCodecs = saved }()
+	// Кодек-перевёртыш: сигнатура, затем строки в обратном порядке байтов
+	magic := []byte{0x00, 0xfe}
+	RegisterTempCodec("reversed", magic, func(r io.Reader) (io.Reader, error) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		data = data[len(magic):]
+		slices.Reverse(data)
+		return bytes.NewReader(data), nil
+	})
+	reversed := []byte(joinLines([]string{"b2", "d2"}))
+	slices.Reverse(reversed)
+
+	chunks := []struct {
+		data       []byte
+		compressed bool
+	}{
+		{gzipped(t, []string{"a1", "c1", "e1"}), true},
+		{[]byte(joinLines([]string{"b0", "d0"})), false},
+		{append(slices.Clone(magic), reversed...), true},
+	}
+	files := make([]*tempFile, len(chunks))
+	for i, chunk := range chunks {
+		tf, err := readTemp(io.NopCloser(bytes.NewReader(chunk.data)), fmt.Sprint("chunk", i), chunk.compressed, SortOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[i] = tf
+	}
+	var out bytes.Buffer
+	rw := newRecordWriter(&out, SortOptions{})
+	if err := mergeFiles(files, rw, SortOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "a1\nb0\nb2\nc1\nd0\nd2\ne1\n"; out.String() != want {
+		t.Errorf("merged %q, want %q", out.String(), want)
+	}
+
+	_, err := readTemp(io.NopCloser(bytes.NewReader([]byte{0x1f, 0x8b, 0})), "chunk", true, SortOptions{})
+	if want := "sort: cannot read gzip temporary file chunk: "; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("broken gzip: err = %v, want prefix %q", err, want)
+	}
+}
+
+// TestReadTempGzipProgram writes a temporary file through gzip and reads it
+// back with the native decoder instead of running "gzip -d".
+func TestReadTempGzipProgram(t *testing.T) {
+	if _, err := exec.LookPath("gzip"); err != nil {
+		t.Skip("gzip not installed")
+	}
+	opts := SortOptions{CompressProgram: "gzip"}
+	tf, err := createTempFile([]string{"a", "b", "c"}, opts, newTempDirs([]string{t.TempDir()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup([]*tempFile{tf})
+	if tf.cmd != nil || tf.codec != "gzip" {
+		t.Errorf("codec = %q: gzip chunk is not decompressed natively", tf.codec)
+	}
+	var got []string
+	for tf.Scan() {
+		got = append(got, tf.Text())
+	}
+	if want := []string{"a", "b", "c"}; !slices.Equal(got, want) {
+		t.Errorf("read back %q, want %q", got, want)
+	}
+}
+
+// TestReadTempPlainGzipMagic reads plain chunks whose first record starts with
+// the gzip signature: without --compress-program they must not be decoded.
+func TestReadTempPlainGzipMagic(t *testing.T) {
+	data := joinLines([]string{"\x1f\x8bnot gzip", "z"})
+	tf, err := readTemp(io.NopCloser(strings.NewReader(data)), "chunk", false, SortOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for tf.Scan() {
+		got = append(got, tf.Text())
+	}
+	if want := []string{"\x1f\x8bnot gzip", "z"}; !slices.Equal(got, want) || tf.codec != "" {
+		t.Errorf("read back %q (codec %q), want %q", got, tf.codec, want)
+	}
+
+	lines := append(numberedLines("line", 20000), "\x1f\x8bnot gzip")
+	want := joinLines(Sorted(lines, SortOptions{}))
+	opts := SortOptions{BufferSize: 64 << 10, TempDirs: []string{t.TempDir()}}
+	if got := sortText(t, joinLines(lines), opts); got != want {
+		t.Error("external sort differs from in-memory sort")
+	}
+}
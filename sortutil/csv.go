@@ -0,0 +1,55 @@
+package sortutil
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+)
+
+// csvFields splits a single CSV line into unquoted fields.
+// Fields with a newline inside quotes are not supported: a record is one line.
+func csvFields(line string) []string {
+	r := csv.NewReader(strings.NewReader(line))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	fields, err := r.Read()
+	if err != nil {
+		return strings.Split(line, ",")
+	}
+	return fields
+}
+
+// csvKey returns the unquoted CSV fields selected by k joined with commas.
+func csvKey(line string, k KeySpec) string {
+	fields := csvFields(line)
+	if k.StartField > len(fields) {
+		return ""
+	}
+	end := len(fields)
+	if k.EndField > 0 && k.EndField < end {
+		end = k.EndField
+	}
+	if end < k.StartField {
+		return ""
+	}
+	return strings.Join(fields[k.StartField-1:end], ",")
+}
+
+// keyByName resolves a --key-name against the header line to a single-column key.
+func keyByName(header, name string, opts SortOptions) (KeySpec, error) {
+	var columns []string
+	switch {
+	case opts.CSV:
+		columns = csvFields(header)
+	case opts.TSV:
+		columns = tsvFields(header)
+	default:
+		columns = splitFields(header, opts.Separator)
+	}
+	for i, column := range columns {
+		if strings.TrimSpace(column) == name {
+			return KeySpec{StartField: i + 1, EndField: i + 1}, nil
+		}
+	}
+	return KeySpec{}, newError(KindInvalidOption, fmt.Errorf("sort: column %q not found in header", name))
+}
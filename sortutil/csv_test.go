@@ -0,0 +1,103 @@
+package sortutil
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestCSVKey checks -k over CSV fields: quoted commas and quotes, empty and
+// missing fields, and a line with an unbalanced quote.
+func TestCSVKey(t *testing.T) {
+	cases := []struct {
+		line, spec, want string
+	}{
+		{"a,b,c", "2,2", "b"},
+		{`a,"x,y",c`, "2,2", "x,y"},
+		{`a,"say ""hi""",c`, "2,2", `say "hi"`},
+		{"a,b,c", "2", "b,c"},
+		{"a,,c", "2,2", ""},
+		{"a,b", "3,3", ""},
+		{`a,"open,b`, "2,2", `open,b`},
+	}
+	for _, c := range cases {
+		if got := extractKey(t, c.line, c.spec, SortOptions{CSV: true}); got != c.want {
+			t.Errorf("--csv -k %s of %q = %q, want %q", c.spec, c.line, got, c.want)
+		}
+	}
+}
+
+// TestCSVKeyName sorts a small CSV by a column named in its header, which
+// stays the first line.
+func TestCSVKeyName(t *testing.T) {
+	input := "name,price,qty\n\"pear, green\",10.5,3\napple,9,10\n\"plum\",100,1\nfig,8,2\n"
+	cases := []struct {
+		name string
+		opts SortOptions
+		want string
+	}{
+		{"numeric", SortOptions{KeyName: "price", Numeric: true},
+			"name,price,qty\nfig,8,2\napple,9,10\n\"pear, green\",10.5,3\n\"plum\",100,1\n"},
+		{"numeric reverse", SortOptions{KeyName: "price", Numeric: true, Reverse: true},
+			"name,price,qty\n\"plum\",100,1\n\"pear, green\",10.5,3\napple,9,10\nfig,8,2\n"},
+		{"text", SortOptions{KeyName: "qty"},
+			"name,price,qty\n\"plum\",100,1\napple,9,10\nfig,8,2\n\"pear, green\",10.5,3\n"},
+		{"quoted field", SortOptions{KeyName: "name"},
+			"name,price,qty\napple,9,10\nfig,8,2\n\"pear, green\",10.5,3\n\"plum\",100,1\n"},
+	}
+	for _, c := range cases {
+		c.opts.CSV = true
+		if got := sortText(t, input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	err := Sort(strings.NewReader("a,b\n1,2\n"), io.Discard, SortOptions{CSV: true, KeyName: "c"})
+	if err == nil || !strings.Contains(err.Error(), `column "c" not found`) {
+		t.Errorf("unknown column: err = %v", err)
+	}
+}
+
+// TestSortHeader keeps the first --header lines in place and sorts the rest.
+func TestSortHeader(t *testing.T) {
+	cases := []struct {
+		header int
+		input  string
+		want   string
+	}{
+		{0, "c\nb\na\n", "a\nb\nc\n"},
+		{1, "z\nb\na\n", "z\na\nb\n"},
+		{2, "z\ny\nb\na\n", "z\ny\na\nb\n"},
+		{3, "z\ny\n", "z\ny\n"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, c.input, SortOptions{Header: c.header}); got != c.want {
+			t.Errorf("--header=%d: got %q, want %q", c.header, got, c.want)
+		}
+	}
+}
+
+// TestSortFooter keeps the last --footer lines at the end, together with
+// --header, and writes them as is even with options that change sorted lines.
+func TestSortFooter(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"header and footer", "name\nc\na\nb\ntotal\n", SortOptions{Header: 1, Footer: 1}, "name\na\nb\nc\ntotal\n"},
+		{"two lines", "c\na\nz\ny\n", SortOptions{Footer: 2}, "a\nc\nz\ny\n"},
+		{"footer only", "b\na\n", SortOptions{Footer: 3}, "b\na\n"},
+		{"header takes first", "h\nf\n", SortOptions{Header: 1, Footer: 1}, "h\nf\n"},
+		{"no final newline", "b\na\ntotal", SortOptions{Footer: 1}, "a\nb\ntotal\n"},
+		{"zero terminated", "b\x00a\x00sum\x00", SortOptions{Footer: 1, ZeroTerminated: true}, "a\x00b\x00sum\x00"},
+		{"not padded", "10\n9\n3\n", SortOptions{Numeric: true, PadWidth: 3, Footer: 1}, "009\n010\n3\n"},
+		{"not grouped", "a\nb\na\nb\n", SortOptions{Group: true, Footer: 1}, "a\na\n\nb\nb\n"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
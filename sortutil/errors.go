@@ -0,0 +1,95 @@
+package sortutil
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorKind is the category of a SortError.
+type ErrorKind int
+
+const (
+	KindIO            ErrorKind = iota + 1 // чтение ввода, запись вывода, временные файлы
+	KindInvalidOption                      // неверный ключ, локаль, колонка --key-name
+	KindInputTooLarge                      // ввод не помещается в память, а внешняя сортировка запрещена
+	KindDisorder                           // -c нашёл строку не по порядку
+	KindDuplicate                          // --require-unique нашёл две записи с равными ключами
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindIO:
+		return "io"
+	case KindInvalidOption:
+		return "invalid option"
+	case KindInputTooLarge:
+		return "input too large"
+	case KindDisorder:
+		return "disorder"
+	case KindDuplicate:
+		return "duplicate key"
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+// SortError is the error returned by the exported functions of the package;
+// callers branch on Kind with errors.As. The underlying error is available
+// through Unwrap.
+type SortError struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *SortError) Error() string { return e.Err.Error() }
+
+func (e *SortError) Unwrap() error { return e.Err }
+
+// Disorder describes the first record found out of order by -c or by
+// --check-inputs; it is the Err of a SortError of KindDisorder.
+// The field names are those of --check-format=json.
+type Disorder struct {
+	Source   string `json:"source"`
+	Line     int    `json:"line"`     // номер записи Current, с 1
+	Previous string `json:"previous"` // запись перед Current
+	Current  string `json:"current"`
+	Key      int    `json:"key"` // ключ (с 1), решивший сравнение; 0 — равные ключи или сравнение целых строк
+}
+
+func (d *Disorder) Error() string {
+	return fmt.Sprintf("sort: %s:%d: disorder: %s", d.Source, d.Line, d.Current)
+}
+
+// newDisorder returns the error for curr at line of source following prev.
+func newDisorder(source string, line int, prev, curr string, comp *comparator) error {
+	return newError(KindDisorder, &Disorder{
+		Source:   source,
+		Line:     line,
+		Previous: prev,
+		Current:  curr,
+		Key:      comp.decidingKey(prev, curr),
+	})
+}
+
+// newDuplicate returns the error for records first and second with equal keys,
+// at positions line and line+1 of the sorted output (--require-unique).
+func newDuplicate(line int, first, second string) error {
+	return newError(KindDuplicate, fmt.Errorf("sort: duplicate key in sorted lines %d and %d: %q and %q", line, line+1, first, second))
+}
+
+// newError wraps err into a SortError of kind.
+func newError(kind ErrorKind, err error) error {
+	return &SortError{Kind: kind, Err: err}
+}
+
+// classify wraps an error leaving an exported function into a SortError:
+// ErrInputTooLarge keeps its kind, every error not classified earlier is an I/O error.
+func classify(err error) error {
+	var sortErr *SortError
+	switch {
+	case err == nil || errors.As(err, &sortErr):
+		return err
+	case errors.Is(err, ErrInputTooLarge):
+		return newError(KindInputTooLarge, err)
+	}
+	return newError(KindIO, err)
+}
@@ -0,0 +1,171 @@
+package sortutil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// failingReader returns data and then err instead of io.EOF.
+type failingReader struct {
+	data io.Reader
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, r.err
+	}
+	return n, err
+}
+
+// failingWriter accepts n bytes and fails on the next write, like a closed pipe.
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return 0, errors.New("broken pipe")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+// TestErrorKinds checks that every error path of the exported functions
+// yields a SortError of the expected kind.
+func TestErrorKinds(t *testing.T) {
+	errRead := errors.New("read failed")
+	sortWith := func(r io.Reader, w io.Writer, opts SortOptions) func() error {
+		return func() error { return Sort(r, w, opts) }
+	}
+	cases := []struct {
+		name string
+		run  func() error
+		want ErrorKind
+	}{
+		{"read error", sortWith(&failingReader{data: strings.NewReader("b\na\n"), err: errRead}, io.Discard, SortOptions{}), KindIO},
+		{"write error", sortWith(strings.NewReader("b\na\n"), &failingWriter{n: 1}, SortOptions{}), KindIO},
+		{"NUL byte", sortWith(strings.NewReader("a\x00\n"), io.Discard, SortOptions{RejectNUL: true}), KindIO},
+		{"line too long", sortWith(strings.NewReader("abcdef\n"), io.Discard, SortOptions{MaxLineLength: 3}), KindIO},
+		{"external read error", func() error {
+			r := &failingReader{data: strings.NewReader(joinLines(numberedLines("line", 100))), err: errRead}
+			return ExternalSortReader(r, io.Discard, SortOptions{TempDirs: []string{t.TempDir()}}, 100)
+		}, KindIO},
+		{"missing -m source", func() error { return MergeSorted([]string{"/nonexistent/file"}, io.Discard, SortOptions{}) }, KindIO},
+		{"missing mapped file", func() error { return SortMapped("/nonexistent/file", io.Discard, SortOptions{}) }, KindIO},
+		{"bad key", func() error { _, err := ParseKeySpec("0"); return err }, KindInvalidOption},
+		{"bad locale", func() error { return ValidateLocale("not a locale") }, KindInvalidOption},
+		{"unknown column", sortWith(strings.NewReader("a b\n1 2\n"), io.Discard, SortOptions{KeyName: "c"}), KindInvalidOption},
+		{"disorder", func() error { return checkText("b\na\n", SortOptions{}) }, KindDisorder},
+		{"strict disorder", func() error { return checkText("a\na\n", SortOptions{CheckStrict: true}) }, KindDisorder},
+		{"duplicate", sortWith(strings.NewReader("a\nb\na\n"), io.Discard, SortOptions{RequireUnique: true}), KindDuplicate},
+		{"check read error", func() error {
+			r := &failingReader{data: strings.NewReader("a\nb\n"), err: errRead}
+			return CheckSorting(NewLineReader(r, SortOptions{}), "-", SortOptions{})
+		}, KindIO},
+	}
+	for _, c := range cases {
+		err := c.run()
+		var sortErr *SortError
+		if !errors.As(err, &sortErr) {
+			t.Errorf("%s: err = %v, want a SortError", c.name, err)
+			continue
+		}
+		if sortErr.Kind != c.want {
+			t.Errorf("%s: kind %v, want %v (%v)", c.name, sortErr.Kind, c.want, err)
+		}
+	}
+
+	err := Sort(&failingReader{data: strings.NewReader(""), err: errRead}, io.Discard, SortOptions{})
+	if !errors.Is(err, errRead) {
+		t.Errorf("read error: %v does not wrap the reader's error", err)
+	}
+}
+
+// TestDisorder checks the *Disorder inside a disorder error: the line, both
+// records and the key that decided the comparison.
+func TestDisorder(t *testing.T) {
+	key := func(spec string) KeySpec {
+		k, err := ParseKeySpec(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  Disorder
+	}{
+		{"no keys", "a\nc\nb\n", SortOptions{}, Disorder{Source: "-", Line: 3, Previous: "c", Current: "b", Key: 1}}, // без -k ключ 1 — вся строка
+		{"first key", "1 b\n2 a\n0 c\n", SortOptions{Keys: []KeySpec{key("1,1n"), key("2,2")}},
+			Disorder{Source: "-", Line: 3, Previous: "2 a", Current: "0 c", Key: 1}},
+		{"second key", "1 b\n1 a\n", SortOptions{Keys: []KeySpec{key("1,1n"), key("2,2")}},
+			Disorder{Source: "-", Line: 2, Previous: "1 b", Current: "1 a", Key: 2}},
+		{"equal keys", "1 b\n1 a\n", SortOptions{Keys: []KeySpec{key("1,1n")}},
+			Disorder{Source: "-", Line: 2, Previous: "1 b", Current: "1 a"}},
+		{"strict", "a\na\n", SortOptions{CheckStrict: true}, Disorder{Source: "-", Line: 2, Previous: "a", Current: "a"}},
+	}
+	for _, c := range cases {
+		err := checkText(c.input, c.opts)
+		var disorder *Disorder
+		if !errors.As(err, &disorder) {
+			t.Errorf("%s: err = %v, want a *Disorder", c.name, err)
+			continue
+		}
+		if *disorder != c.want {
+			t.Errorf("%s: disorder = %+v, want %+v", c.name, *disorder, c.want)
+		}
+	}
+}
+
+// TestRequireUnique checks that --require-unique names the first two sorted
+// records with equal keys, in memory and through temporary files, and passes
+// input without duplicates unchanged.
+func TestRequireUnique(t *testing.T) {
+	byField := SortOptions{RequireUnique: true, Keys: []KeySpec{{StartField: 1, EndField: 1}}}
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string // пусто — ошибки нет
+	}{
+		{"no duplicates", "c\na\nb\n", SortOptions{RequireUnique: true}, ""},
+		{"whole line", "c\na\nb\na\n", SortOptions{RequireUnique: true}, `sort: duplicate key in sorted lines 1 and 2: "a" and "a"`},
+		{"key", "2 x\n1 a\n2 y\n", byField, `sort: duplicate key in sorted lines 2 and 3: "2 x" and "2 y"`},
+		{"distinct keys", "2 x\n1 a\n3 x\n", byField, ""},
+	}
+	for _, c := range cases {
+		for _, limit := range []int{1 << 20, 4} {
+			var out bytes.Buffer
+			err := ExternalSortReader(strings.NewReader(c.input), &out, c.opts, limit)
+			switch {
+			case c.want == "" && err != nil:
+				t.Errorf("%s, limit %d: %v", c.name, limit, err)
+			case c.want == "" && out.String() != sortText(t, c.input, SortOptions{Keys: c.opts.Keys}):
+				t.Errorf("%s, limit %d: output %q differs from a plain sort", c.name, limit, out.String())
+			case c.want != "" && (err == nil || err.Error() != c.want):
+				t.Errorf("%s, limit %d: err = %v, want %q", c.name, limit, err, c.want)
+			}
+		}
+	}
+}
+
+func TestErrorKindString(t *testing.T) {
+	cases := map[ErrorKind]string{
+		KindIO:            "io",
+		KindInvalidOption: "invalid option",
+		KindInputTooLarge: "input too large",
+		KindDisorder:      "disorder",
+		KindDuplicate:     "duplicate key",
+		ErrorKind(42):     "ErrorKind(42)",
+	}
+	for kind, want := range cases {
+		if got := kind.String(); got != want {
+			t.Errorf("%d.String() = %q, want %q", int(kind), got, want)
+		}
+	}
+}
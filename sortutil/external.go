@@ -2,10 +2,13 @@ package sortutil
 
 import (
 	"bufio"
-	"container/heap"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"strings"
+	"os/exec"
+	"path/filepath"
+	"syscall"
 )
 
 const (
@@ -13,384 +16,456 @@ const (
 	maxOpenFiles   = 64
 )
 
+// batchSize returns how many files a merge reads at once: BatchSize
+// (--batch-size) if set, otherwise maxOpenFiles. It is never less than two.
+func (opts SortOptions) batchSize() int {
+	if opts.BatchSize > 0 {
+		return max(opts.BatchSize, 2)
+	}
+	return maxOpenFiles
+}
+
+// tempFile is a sorted run being read back during a merge.
 type tempFile struct {
-	*os.File
+	io.ReadCloser
 	*bufio.Scanner
+	name  string
+	store TempStore // откуда удалить файл после слияния; nil — только закрыть
+	cmd   *exec.Cmd // распаковщик --compress-program, читающий ReadCloser
+	codec string    // формат сжатия, распознанный при открытии; пусто — без сжатия или PROG -d
 }
 
+// mergeItem is the current line of one merged file; index is the position
+// of the file among the merged ones.
 type mergeItem struct {
 	line  string
 	file  *tempFile
 	index int
 }
 
-type mergeHeap struct {
-	items []mergeItem
-	opts  SortOptions
+// ExternalSortReader sorts r into out from scratch, spilling sorted chunks
+// of about limit bytes to temporary files and merging them at the end.
+func ExternalSortReader(r io.Reader, out io.Writer, opts SortOptions, limit int) (err error) {
+	defer func() { err = classify(err) }()
+	rw := newOutputWriter(out, opts)
+	rw.beginSorted(opts)
+	if err = externalSort(NewLineReader(r, opts), rw, opts, limit, nil); err != nil {
+		return err
+	}
+	return rw.flush()
 }
 
-func (h *mergeHeap) Len() int { return len(h.items) }
-func (h *mergeHeap) Less(i, j int) bool {
-	a, b := h.items[i].line, h.items[j].line
-	aKey := h.getKey(a)
-	bKey := h.getKey(b)
-
-	if h.opts.IgnoreBlanks {
-		aKey = trimBlanks(aKey)
-		bKey = trimBlanks(bKey)
+// externalSort continues sorting the stream s after initialLines were already read from it.
+func externalSort(s *bufio.Scanner, out *recordWriter, opts SortOptions, limit int, initialLines []string) (err error) {
+	var tempFiles []*tempFile
+	defer func() { cleanup(tempFiles) }()
+	store := opts.tempStore()
+	prog := newProgress(opts)
+
+	// С --resume-dir готовые порции прошлого запуска сразу идут в слияние,
+	// а покрытые ими строки ввода пропускаются
+	var resume *resumeState
+	skip := 0
+	if opts.ResumeDir != "" {
+		var header string
+		if header, err = resumeHeader(opts, limit); err != nil {
+			return err
+		}
+		if resume, err = loadResume(opts.ResumeDir, header); err != nil {
+			return err
+		}
+		if tempFiles, err = resume.open(opts); err != nil {
+			return err
+		}
+		skip = resume.consumed
+		defer func() {
+			if err == nil {
+				err = resume.finish()
+			}
+		}()
 	}
 
-	var less bool
-	if h.opts.Human {
-		va, vb := humanValue(aKey), humanValue(bKey)
-		if va != vb {
-			less = va < vb
-		} else {
-			less = a < b
-		}
-	} else if h.opts.Month {
-		ma, mb := monthValue(aKey), monthValue(bKey)
-		if ma != mb {
-			less = ma < mb
+	// spill сортирует порцию и сбрасывает её во временный файл
+	spill := func(lines []string) error {
+		consumed := len(lines)
+		sortedLines := SortInMemory(lines, opts)
+		var tmpFile *tempFile
+		var err error
+		if resume != nil {
+			tmpFile, err = resume.spill(sortedLines, consumed, opts)
 		} else {
-			less = a < b
+			tmpFile, err = createTempFile(sortedLines, opts, store)
 		}
-	} else if h.opts.Numeric {
-		na, nb := numericPrefix(aKey), numericPrefix(bKey)
-		if na != nb {
-			less = na < nb
-		} else {
-			less = a < b
+		if err != nil {
+			return err
 		}
-	} else {
-		less = a < b
-	}
-	if h.opts.Reverse {
-		return !less
+		tempFiles = append(tempFiles, tmpFile)
+		prog.spilled()
+		return nil
 	}
-	return less
-}
-func (h *mergeHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
-func (h *mergeHeap) Push(x any)    { h.items = append(h.items, x.(mergeItem)) }
-func (h *mergeHeap) Pop() any {
-	old := h.items
-	n := len(old)
-	x := old[n-1]
-	h.items = old[0 : n-1]
-	return x
-}
 
-func (h *mergeHeap) getKey(line string) string {
-	if h.opts.KeyCol <= 0 {
-		return line
+	lines := initialLines
+	if n := min(skip, len(lines)); n > 0 {
+		lines = lines[n:]
+		skip -= n
 	}
-	fields := strings.Split(line, "\t")
-	if h.opts.KeyCol > len(fields) {
-		return ""
+	data := 0
+	for _, line := range lines {
+		data += len(line)
 	}
-	return fields[h.opts.KeyCol-1]
-}
-
-// ExternalSort performs external merge sort on reader.
-func ExternalSort(s *bufio.Scanner, opts SortOptions, initialLines []string) error {
-	var tempFiles []*tempFile
-	defer cleanup(tempFiles)
-
-	lines := initialLines
-	memoryUsed := estimateMemorySize(lines)
+	prog.read(len(initialLines))
 
 	for s.Scan() {
+		prog.read(1)
+		if skip > 0 {
+			skip--
+			continue
+		}
 		line := s.Text()
+		lines = append(lines, line)
+		data += len(line)
 
-		lineSize := len(line) + 16
-		// Если превысили лимит в памяти - сортируем и сбрасываем порцию
-		if memoryUsed+lineSize > maxMemoryBytes && len(lines) > 0 {
-			// Сортируем порцию
-			sortedLines := SortInMemory(lines, opts)
-			// Пишем во временный файл
-			tmpFile, err := createTempFile(sortedLines)
-			if err != nil {
+		// Если превысили лимит в памяти - сортируем и сбрасываем порцию без новой строки
+		if linesMemory(data, lines) > limit && len(lines) > 1 {
+			if err = spill(lines[:len(lines)-1]); err != nil {
 				return err
 			}
-			tempFiles = append(tempFiles, tmpFile)
-			lines = nil
-			memoryUsed = 0
+			lines = []string{line}
+			data = len(line)
 		}
-
-		lines = append(lines, line)
-		memoryUsed += lineSize
 	}
 
-	if err := s.Err(); err != nil {
+	if err = s.Err(); err != nil {
 		return err
 	}
 
 	// Последняя порция
 	if len(lines) > 0 {
-		sortedLines := SortInMemory(lines, opts)
-		tmpFile, err := createTempFile(sortedLines)
-		if err != nil {
+		if err = spill(lines); err != nil {
 			return err
 		}
-		tempFiles = append(tempFiles, tmpFile)
 	}
 
 	if len(tempFiles) == 0 {
 		return nil
 	}
 	if len(tempFiles) == 1 {
-		// Выводим напрямую
-		tf := tempFiles[0]
-		for tf.Scanner.Scan() {
-			fmt.Println(tf.Scanner.Text())
-		}
-		return tf.Scanner.Err()
+		// Единственная порция выводится тем же слиянием, что и несколько:
+		// -u, порядок и ошибки чтения обрабатываются в одном месте
+		return mergeFiles(tempFiles, out, opts)
 	}
 
-	for len(tempFiles) > maxOpenFiles {
-		var nextLevel []*tempFile
-		for i := 0; i < len(tempFiles); i += maxOpenFiles {
-			end := i + maxOpenFiles
-			if end > len(tempFiles) {
-				end = len(tempFiles)
-			}
-			chunk := tempFiles[i:end]
+	passes := mergePasses(len(tempFiles), opts.batchSize())
+	if tempFiles, err = mergeLevels(tempFiles, opts, store, prog); err != nil {
+		return err
+	}
 
-			// Слить chunk в один файл
-			mergedFile, err := mergeChunk(chunk, opts)
+	// K-путевое слияние
+	prog.pass(passes, passes, len(tempFiles))
+	if opts.mergeWorkers() > 1 {
+		return mergeParallel(tempFiles, out, opts)
+	}
+	return mergeFiles(tempFiles, out, opts)
+}
+
+// mergeLevels merges files in groups of opts.batchSize() into temporary files, level
+// by level, until at most that many remain for the final merge. Merged files
+// are closed and removed; on error all of them are closed and the result is nil.
+func mergeLevels(files []*tempFile, opts SortOptions, store TempStore, prog *progress) ([]*tempFile, error) {
+	batch := opts.batchSize()
+	passes := mergePasses(len(files), batch)
+	for pass := 1; len(files) > batch; pass++ {
+		prog.pass(pass, passes, len(files))
+		var nextLevel []*tempFile
+		for i := 0; i < len(files); i += batch {
+			// Слить группу в один файл
+			mergedFile, err := mergeChunk(files[i:min(i+batch, len(files))], opts, store)
 			if err != nil {
-				cleanup(tempFiles)
-				return err
+				cleanup(files)
+				cleanup(nextLevel)
+				return nil, err
 			}
 			nextLevel = append(nextLevel, mergedFile)
 		}
 		// Закрыть старые файлы
-		cleanup(tempFiles)
-		tempFiles = nextLevel
+		cleanup(files)
+		files = nextLevel
 	}
-
-	// K-путевое слияние
-	return mergeFiles(tempFiles, opts)
+	return files, nil
 }
 
-// mergeChunk сливает группу файлов в один временный файл.
-func mergeChunk(files []*tempFile, opts SortOptions) (*tempFile, error) {
-	h := &mergeHeap{opts: opts}
-	heap.Init(h)
-
-	// Загрузить первую строку из каждого файла
-	for i, tf := range files {
-		if tf.Scanner.Scan() {
-			heap.Push(h, mergeItem{
-				line:  tf.Scanner.Text(),
-				file:  tf,
-				index: i,
-			})
-		}
-	}
-
+// mergeChunk merges a group of files into one temporary file.
+func mergeChunk(files []*tempFile, opts SortOptions, store TempStore) (*tempFile, error) {
 	// Создать временный файл для результата
-	tmp, err := os.CreateTemp("", "merge-*.tmp")
+	out, err := newTempWriter(store, "merge-*.tmp", opts)
 	if err != nil {
 		return nil, err
 	}
-	defer tmp.Close()
-
-	// Слить в файл
-	for h.Len() > 0 {
-		item := heap.Pop(h).(mergeItem)
-		if _, err = fmt.Fprintln(tmp, item.line); err != nil {
-			return nil, err
-		}
 
-		if item.file.Scanner.Scan() {
-			heap.Push(h, mergeItem{
-				line:  item.file.Scanner.Text(),
-				file:  item.file,
-				index: item.index,
-			})
+	// Слить в файл; ошибка чтения одного из входов обрывает слияние, а не
+	// сходит за конец файла, и недописанный результат удаляется
+	err = mergeStreams(files, newComparator(opts), func(line string) error {
+		if err := out.write(line); err != nil {
+			return writeTempError(out.name, err)
 		}
-	}
-
-	// Переоткрыть для чтения
-	reopened, err := os.Open(tmp.Name())
+		return nil
+	})
 	if err != nil {
+		out.discard()
 		return nil, err
 	}
-	return &tempFile{File: reopened, Scanner: bufio.NewScanner(reopened)}, nil
+
+	return finishTempFile(out, opts)
 }
 
-// mergeFiles performs k-way merge of sorted temp files.
-func mergeFiles(files []*tempFile, opts SortOptions) error {
-	h := &mergeHeap{
-		opts: opts,
-	}
-	heap.Init(h)
+// mergeStreams performs a k-way merge of sorted files, passing every line to emit in order.
+func mergeStreams(files []*tempFile, comp *comparator, emit func(string) error) error {
+	h := newHeap(len(files), func(a, b mergeItem) bool {
+		if res := comp.compareLines(a.line, b.line); res != 0 {
+			return res < 0
+		}
+		// Равные строки выходят в порядке файлов: порции идут в порядке ввода,
+		// поэтому с -s слияние сохраняет исходный порядок. Счётчик вставок
+		// здесь не подошёл бы: строка, дочитанная из первого файла позже,
+		// обогнала бы равную строку второго
+		return a.index < b.index
+	})
 
 	// Загружаем первую строку из каждого файла
 	for i, tf := range files {
 		if tf.Scanner.Scan() {
-			heap.Push(h, mergeItem{
+			h.push(mergeItem{
 				line:  tf.Scanner.Text(),
 				file:  tf,
 				index: i,
 			})
+		} else if err := tf.Scanner.Err(); err != nil {
+			return readError(tf, err)
 		}
 	}
 
-	// Запоминаем последнюю выведенную строку для уникальности
+	for len(h.items) > 0 {
+		top := &h.items[0]
+		if err := emit(top.line); err != nil {
+			return err
+		}
+
+		// Следующая строка того же файла занимает место вершины
+		if top.file.Scanner.Scan() {
+			top.line = top.file.Scanner.Text()
+			h.fixTop()
+		} else if err := top.file.Scanner.Err(); err != nil {
+			return readError(top.file, err)
+		} else {
+			h.pop()
+		}
+	}
+	return nil
+}
+
+// mergeFiles performs k-way merge of sorted temp files.
+func mergeFiles(files []*tempFile, out *recordWriter, opts SortOptions) error {
+	comp := newComparator(opts)
+	if !opts.Unique {
+		return mergeStreams(files, comp, out.write)
+	}
+
+	// Запоминаем последнюю выведенную строку для уникальности (-u): с --epsilon
+	// дубликаты сравниваются с первой строкой группы, а не с соседней
 	var lastLine string
 	first := true
-
-	for h.Len() > 0 {
-		item := heap.Pop(h).(mergeItem)
-		current := item.line
-
-		// Обработка уникальности (-u)
-		shouldPrint := true
-		if opts.Unique {
-			if first {
-				lastLine = current
-			} else {
-				if equivalent(lastLine, current, opts) {
-					shouldPrint = false
-				} else {
-					lastLine = current
-				}
-			}
-			first = false
+	return mergeStreams(files, comp, func(current string) error {
+		if !first && equivalent(lastLine, current, comp) {
+			return nil
 		}
+		first = false
+		lastLine = current
+		return out.write(current)
+	})
+}
 
-		if shouldPrint {
-			if _, err := fmt.Println(current); err != nil {
+// MergeSorted merges already sorted sources into w without sorting them (-m).
+// The source "-" denotes stdin and may be mixed with regular files. At most
+// opts.batchSize() sources are open at once: with more of them, groups are
+// first merged into temporary files like the chunks of an external sort.
+func MergeSorted(sources []string, w io.Writer, opts SortOptions) (err error) {
+	defer func() { err = classify(err) }()
+	var inputs []*tempFile
+	defer func() { cleanup(inputs) }()
+
+	opened := 0
+	batchSize := opts.batchSize()
+	if len(sources) <= batchSize {
+		if inputs, err = openMergeInputs(sources, opts); err != nil {
+			return err
+		}
+		opened = len(inputs)
+	} else {
+		store := opts.tempStore()
+		for i := 0; i < len(sources); i += batchSize {
+			batch, err := openMergeInputs(sources[i:min(i+batchSize, len(sources))], opts)
+			if err != nil {
 				return err
 			}
+			opened += len(batch)
+			if len(batch) == 0 {
+				continue
+			}
+			merged, err := mergeChunk(batch, opts, store)
+			cleanup(batch)
+			if err != nil {
+				return err
+			}
+			inputs = append(inputs, merged)
 		}
+		if inputs, err = mergeLevels(inputs, opts, store, newProgress(opts)); err != nil {
+			return err
+		}
+	}
 
-		// Читаем следующую строку из того же файла
-		if item.file.Scanner.Scan() {
-			heap.Push(h, mergeItem{
-				line:  item.file.Scanner.Text(),
-				file:  item.file,
-				index: item.index,
-			})
+	if opened == 0 && len(sources) > 0 {
+		return fmt.Errorf("sort: none of the %d input files could be opened", len(sources))
+	}
+
+	// Пустые источники не попадут в кучу: первый Scan вернёт false
+	out := newOutputWriter(w, opts)
+	out.beginSorted(opts)
+	if err := mergeFiles(inputs, out, opts); err != nil {
+		return err
+	}
+	return out.flush()
+}
+
+// openMergeInputs opens the sources of -m; with --ignore-missing sources that
+// cannot be opened are skipped with a warning. On error the inputs already
+// opened are closed.
+func openMergeInputs(sources []string, opts SortOptions) ([]*tempFile, error) {
+	inputs := make([]*tempFile, 0, len(sources))
+	for _, source := range sources {
+		var input io.ReadCloser = io.NopCloser(os.Stdin)
+		if source != "-" {
+			file, err := os.Open(source)
+			if err != nil && opts.IgnoreMissing {
+				fmt.Fprintf(os.Stderr, "sort: cannot open '%s': %v; skipped\n", source, err)
+				continue
+			}
+			if err != nil {
+				cleanup(inputs)
+				return nil, fmt.Errorf("sort: cannot open '%s': %v", source, err)
+			}
+			input = file
+		}
+		s := NewLineReader(input, opts)
+		if opts.CheckInputs {
+			s.Split(checkSorted(opts.inputSplit(), source, newComparator(opts)))
 		}
+		inputs = append(inputs, &tempFile{ReadCloser: input, Scanner: s, name: source})
 	}
+	return inputs, nil
+}
 
-	return nil
+// checkSorted wraps split to fail on the first record of source that is out of
+// order for comp (--check-inputs). The merge stops at that record instead of
+// silently writing a wrong result; equal keys are allowed even with -u.
+func checkSorted(split bufio.SplitFunc, source string, comp *comparator) bufio.SplitFunc {
+	var prev string
+	line := 0
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if token == nil {
+			return advance, token, err
+		}
+		line++
+		curr := string(token)
+		if line > 1 && comp.compareLines(prev, curr) > 0 {
+			return 0, nil, newDisorder(source, line, prev, curr, comp)
+		}
+		prev = curr
+		return advance, token, err
+	}
 }
 
 // equivalent checks if two lines are equivalent for -u.
-func equivalent(a, b string, opts SortOptions) bool {
-	aKey := getKey(a, opts.KeyCol)
-	bKey := getKey(b, opts.KeyCol)
-	if opts.IgnoreBlanks {
-		aKey = trimBlanks(aKey)
-		bKey = trimBlanks(bKey)
-	}
-
-	if opts.Human {
-		return humanValue(aKey) == humanValue(bKey)
-	} else if opts.Month {
-		return monthValue(aKey) == monthValue(bKey)
-	} else if opts.Numeric {
-		return numericPrefix(aKey) == numericPrefix(bKey)
-	} else {
-		return aKey == bKey
-	}
+func equivalent(a, b string, comp *comparator) bool {
+	return comp.duplicate(a, b)
 }
 
-func createTempFile(lines []string) (*tempFile, error) {
-	tmp, err := os.CreateTemp("", "sort-*.tmp")
+func createTempFile(lines []string, opts SortOptions, store TempStore) (*tempFile, error) {
+	out, err := newTempWriter(store, "sort-*.tmp", opts)
 	if err != nil {
 		return nil, err
 	}
 	for _, line := range lines {
-		if _, err = fmt.Fprintln(tmp, line); err != nil {
-			tmp.Close()
-			return nil, err
+		if err = out.write(line); err != nil {
+			out.discard()
+			return nil, writeTempError(out.name, err)
 		}
 	}
-	if err = tmp.Close(); err != nil {
-		return nil, err
+	return finishTempFile(out, opts)
+}
+
+// finishTempFile flushes and closes a written temporary file and reopens it for reading.
+// On any error the partly written file is removed.
+func finishTempFile(out *tempWriter, opts SortOptions) (*tempFile, error) {
+	if err := out.close(); err != nil {
+		_ = out.store.Remove(out.name)
+		return nil, writeTempError(out.name, err)
 	}
 
-	reopened, err := os.Open(tmp.Name())
+	reopened, err := openTemp(out.store, out.name, out.cmd != nil, opts)
 	if err != nil {
+		_ = out.store.Remove(out.name)
 		return nil, err
 	}
-	return &tempFile{File: reopened, Scanner: bufio.NewScanner(reopened)}, nil
+	return reopened, nil
 }
 
-// cleanup закрывает и удаляет временные файлы.
-func cleanup(files []*tempFile) {
-	for _, tf := range files {
-		if tf != nil && tf.File != nil {
-			tf.File.Close()
-			os.Remove(tf.File.Name())
-		}
+// discardTemp closes and removes a partially written temporary file.
+func discardTemp(tmp *os.File) {
+	_ = tmp.Close()
+	_ = os.Remove(tmp.Name())
+}
+
+// readError names the merge input tf in a read error. Errors that speak for
+// themselves (a --check-inputs disorder) and unnamed streams are left as is.
+func readError(tf *tempFile, err error) error {
+	var sortErr *SortError
+	if tf.name == "" || errors.As(err, &sortErr) {
+		return err
 	}
+	return fmt.Errorf("sort: cannot read %s: %w", tf.name, err)
 }
 
-func isUnordered(prev, curr string, opts SortOptions) bool {
-	var unordered bool
-
-	if opts.Human {
-		prevVal := humanValue(prev)
-		currVal := humanValue(curr)
-		if prevVal != currVal {
-			if opts.Reverse {
-				unordered = prevVal < currVal
-			} else {
-				unordered = prevVal > currVal
-			}
-		} else {
-			if opts.Reverse {
-				unordered = prev < curr
-			} else {
-				unordered = prev > curr
-			}
-		}
-	} else if opts.Month {
-		prevMonth := monthValue(prev)
-		currMonth := monthValue(curr)
-		if prevMonth != currMonth {
-			if opts.Reverse {
-				unordered = prevMonth < currMonth
-			} else {
-				unordered = prevMonth > currMonth
-			}
-		} else {
-			if opts.Reverse {
-				unordered = prev < curr
-			} else {
-				unordered = prev > curr
-			}
-		}
-	} else if opts.Numeric {
-		prevNum := numericPrefix(prev)
-		currNum := numericPrefix(curr)
-		if prevNum != currNum {
-			if opts.Reverse {
-				unordered = prevNum < currNum
-			} else {
-				unordered = prevNum > currNum
-			}
-		} else {
-			if opts.Reverse {
-				unordered = prev < curr
-			} else {
-				unordered = prev > curr
-			}
-		}
+// writeTempError names the temporary file in a write error. When the disk is
+// full the message names the directory and suggests -T, since the temporary
+// files of the whole sort are being removed by then.
+func writeTempError(name string, err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("sort: no space left for temporary files in %s (choose another directory with -T or compress them with --compress-program): %w", filepath.Dir(name), err)
+	}
+	return fmt.Errorf("sort: cannot write temporary file %s: %w", name, err)
+}
 
-	} else {
-		if opts.Reverse {
-			unordered = prev < curr
-		} else {
-			unordered = prev > curr
+// cleanup closes files and removes the temporary ones from their store.
+func cleanup(files []*tempFile) {
+	for _, tf := range files {
+		if tf != nil && tf.ReadCloser != nil {
+			tf.stop()
+			tf.Close()
+			if tf.store != nil {
+				tf.store.Remove(tf.name)
+			}
 		}
 	}
+}
 
-	return unordered
+// isUnordered reports whether curr must not follow prev.
+// With -u or --check-strict equal keys are a disorder too. Each key has its own
+// direction: -k2,2nr -k1 checks the second field descending and the first
+// ascending.
+func isUnordered(prev, curr string, opts SortOptions, comp *comparator) bool {
+	if (opts.Unique || opts.CheckStrict) && comp.compareKeys(prev, curr) == 0 {
+		return true
+	}
+	return comp.compareLines(prev, curr) > 0
 }
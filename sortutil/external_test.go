@@ -0,0 +1,739 @@
+package sortutil
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// countingStore is a local TempStore that counts the objects created in it.
+type countingStore struct {
+	TempStore
+	created int
+}
+
+func (s *countingStore) Create(pattern string) (string, io.WriteCloser, error) {
+	s.created++
+	return s.TempStore.Create(pattern)
+}
+
+// fullStore is a local TempStore whose disk fills up after limit bytes: later
+// writes fail with ENOSPC, as on a full -T directory.
+type fullStore struct {
+	TempStore
+	limit int
+}
+
+func (s *fullStore) Create(pattern string) (string, io.WriteCloser, error) {
+	name, w, err := s.TempStore.Create(pattern)
+	if err != nil {
+		return "", nil, err
+	}
+	return name, &fullWriter{WriteCloser: w, store: s, name: name}, nil
+}
+
+type fullWriter struct {
+	io.WriteCloser
+	store *fullStore
+	name  string
+}
+
+func (w *fullWriter) Write(p []byte) (int, error) {
+	if len(p) > w.store.limit {
+		n, _ := w.WriteCloser.Write(p[:w.store.limit])
+		w.store.limit = 0
+		return n, &os.PathError{Op: "write", Path: w.name, Err: syscall.ENOSPC}
+	}
+	w.store.limit -= len(p)
+	return w.WriteCloser.Write(p)
+}
+
+// readPeak returns the memory estimate readLines reaches after reading all of
+// lines, with the same growth of the slice.
+func readPeak(lines []string) int {
+	var read []string
+	data := 0
+	for _, line := range lines {
+		read = append(read, line)
+		data += len(line)
+	}
+	return linesMemory(data, read)
+}
+
+// sortWithLimit sorts r like Sort, but with a memory limit of limit bytes
+// instead of maxMemoryBytes.
+func sortWithLimit(t *testing.T, r io.Reader, opts SortOptions, limit int) string {
+	t.Helper()
+	var out bytes.Buffer
+	rw := newRecordWriter(&out, opts)
+	s := NewLineReader(r, opts)
+	lines, err := readLines(s, limit)
+	switch {
+	case errors.Is(err, ErrInputTooLarge):
+		err = externalSort(s, rw, opts, limit, lines)
+	case err == nil:
+		for _, line := range SortInMemory(lines, opts) {
+			if err = rw.write(line); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+// TestSortMemoryLimitBoundary sorts input whose size is exactly at, just below
+// and just above the memory limit: only input over the limit goes through
+// temporary files, including the lines readLines has already read, and every
+// line appears exactly once in the same order as in memory.
+func TestSortMemoryLimitBoundary(t *testing.T) {
+	lines := numberedLines("line", 3000)
+	lines = append(lines, lines[:100]...) // повторы тоже должны остаться все
+	size := readPeak(lines)
+	cases := []struct {
+		name     string
+		limit    int
+		external bool
+	}{
+		{"one byte under", size + 1, false},
+		{"at limit", size, false},
+		{"one byte over", size - 1, true},
+		{"one line over", readPeak(lines[:len(lines)-1]), true},
+		{"tiny limit", 200, true},
+	}
+	for _, c := range cases {
+		for _, unique := range []bool{false, true} {
+			opts := SortOptions{Unique: unique}
+			want := joinLines(SortInMemory(slices.Clone(lines), opts))
+			store := &countingStore{TempStore: newTempDirs([]string{t.TempDir()})}
+			opts.TempStore = store
+
+			if got := sortWithLimit(t, strings.NewReader(joinLines(lines)), opts, c.limit); got != want {
+				t.Errorf("%s, -u %v: output differs from the in-memory sort", c.name, unique)
+			}
+			if external := store.created > 0; external != c.external {
+				t.Errorf("%s, -u %v: external sort = %v, want %v", c.name, unique, external, c.external)
+			}
+		}
+	}
+}
+
+// TestUniqueSurvivor checks which line of a group of equal keys -u keeps: the
+// smallest whole line, the same one in memory, through temporary files and
+// through a multi-level merge, and with -s the first in the input.
+func TestUniqueSurvivor(t *testing.T) {
+	const keys, copies = 300, 40
+	lines := make([]string, 0, keys*copies)
+	for i := range copies {
+		for k := range keys {
+			// Копии ключа различаются вне ключа и идут во вводе не по порядку
+			lines = append(lines, fmt.Sprintf("k%03d %02d", k, (i*7+k)%copies))
+		}
+	}
+	smallest := make(map[string]string)
+	first := make(map[string]string)
+	for _, line := range lines {
+		key := line[:4]
+		if s, ok := smallest[key]; !ok || line < s {
+			smallest[key] = line
+		}
+		if _, ok := first[key]; !ok {
+			first[key] = line
+		}
+	}
+	survivors := func(m map[string]string) string {
+		var want []string
+		for _, line := range m {
+			want = append(want, line)
+		}
+		slices.Sort(want)
+		return joinLines(want)
+	}
+
+	opts := SortOptions{Keys: []KeySpec{{StartField: 1, EndField: 1}}, Unique: true}
+	want := survivors(smallest)
+	if got := sortText(t, joinLines(lines), opts); got != want {
+		t.Error("in memory: survivors differ")
+	}
+	// Порции по ~16 КБ; при 1 КБ временных файлов больше maxOpenFiles
+	for _, limit := range []int{16 << 10, 1 << 10} {
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(joinLines(lines)), &out, opts, limit); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("external, limit %d: survivors differ", limit)
+		}
+	}
+
+	opts.Stable = true
+	want = survivors(first)
+	if got := sortText(t, joinLines(lines), opts); got != want {
+		t.Error("in memory, -s: survivors differ")
+	}
+	for _, limit := range []int{16 << 10, 1 << 10} {
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(joinLines(lines)), &out, opts, limit); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("external -s, limit %d: survivors differ", limit)
+		}
+	}
+}
+
+// pipeInput returns a reader that cannot seek or be read twice, like a piped
+// stdin, delivering input in small writes.
+func pipeInput(input string) io.Reader {
+	r, w := io.Pipe()
+	go func() {
+		for len(input) > 0 {
+			n := min(len(input), 100)
+			if _, err := io.WriteString(w, input[:n]); err != nil {
+				return
+			}
+			input = input[n:]
+		}
+		_ = w.Close()
+	}()
+	return r
+}
+
+// TestPipedInputNearLimit pipes input just under, at and just over the memory
+// limit: the lines readLines has already read are handed over to the external
+// sort together with the same scanner, so none is lost or read twice.
+func TestPipedInputNearLimit(t *testing.T) {
+	lines := numberedLines("piped", 2000)
+	want := joinLines(SortInMemory(slices.Clone(lines), SortOptions{}))
+	size := readPeak(lines)
+	for _, limit := range []int{size + 1, size, size - 1, size - 100} {
+		var out bytes.Buffer
+		if err := ExternalSortReader(pipeInput(joinLines(lines)), &out, SortOptions{}, limit); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("ExternalSortReader, limit %d of %d: output differs from the in-memory sort", limit, size)
+		}
+
+		if got := sortWithLimit(t, pipeInput(joinLines(lines)), SortOptions{}, limit); got != want {
+			t.Errorf("Sort, limit %d of %d: output differs from the in-memory sort", limit, size)
+		}
+	}
+}
+
+// withStdin runs f with os.Stdin reading content.
+func withStdin(t *testing.T, content string, f func()) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+	saved := os.Stdin
+	os.Stdin = file
+	defer func() { os.Stdin = saved }()
+	f()
+}
+
+// TestExternalSortReader sorts through temporary files with limits giving
+// one chunk, a few chunks and more chunks than maxOpenFiles, so that the last
+// case needs a multi-level merge.
+func TestExternalSortReader(t *testing.T) {
+	lines := numberedLines("line", 3000)
+	// Каждая строка занимает в оценке не меньше len+16 байт
+	lineSize := len(lines[0]) + 16
+	cases := []struct {
+		name  string
+		limit int
+		opts  SortOptions
+	}{
+		{"single chunk", len(lines) * lineSize, SortOptions{}},
+		{"few chunks", 500 * lineSize, SortOptions{}},
+		{"multi-level merge", 10 * lineSize, SortOptions{}},
+		{"multi-level merge reverse", 10 * lineSize, SortOptions{Reverse: true}},
+	}
+	for _, c := range cases {
+		want := joinLines(SortInMemory(slices.Clone(lines), c.opts))
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(joinLines(lines)), &out, c.opts, c.limit); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		} else if out.String() != want {
+			t.Errorf("%s: output differs from the in-memory sort", c.name)
+		}
+	}
+}
+
+// TestExternalSortReaderUnique removes duplicates that land in different
+// chunks and in different groups of a multi-level merge.
+func TestExternalSortReaderUnique(t *testing.T) {
+	var lines []string
+	for range 5 {
+		lines = append(lines, numberedLines("dup", 400)...)
+	}
+	want := joinLines(SortInMemory(numberedLines("dup", 400), SortOptions{}))
+	for _, limit := range []int{1 << 20, 2000, 50} {
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(joinLines(lines)), &out, SortOptions{Unique: true}, limit); err != nil {
+			t.Errorf("limit %d: %v", limit, err)
+		} else if out.String() != want {
+			t.Errorf("limit %d: duplicates survive the merge", limit)
+		}
+	}
+}
+
+func TestExternalSortReaderEmpty(t *testing.T) {
+	var out bytes.Buffer
+	if err := ExternalSortReader(strings.NewReader(""), &out, SortOptions{}, 100); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("got %q for empty input", out.String())
+	}
+}
+
+// TestSort sorts small input in memory and checks the output format.
+func TestSort(t *testing.T) {
+	cases := []struct {
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"b\na\nc\n", SortOptions{}, "a\nb\nc\n"},
+		{"b\na", SortOptions{}, "a\nb\n"},
+		{"", SortOptions{}, ""},
+		{"2\n10\n2\n", SortOptions{Numeric: true, Unique: true}, "2\n10\n"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("Sort(%q) = %q, want %q", c.input, got, c.want)
+		}
+	}
+}
+
+// TestMergeSortedWithStdin merges pre-sorted files and stdin ("-") in one
+// pass (-m), with stdin at any position and empty sources.
+func TestMergeSortedWithStdin(t *testing.T) {
+	cases := []struct {
+		name    string
+		files   []string // "-" — stdin
+		stdin   string
+		opts    SortOptions
+		want    string
+		wantErr bool
+	}{
+		{"two files and stdin", []string{"a\nd\n", "b\nf\n", "-"}, "c\ne\n", SortOptions{}, "a\nb\nc\nd\ne\nf\n", false},
+		{"stdin first", []string{"-", "b\n"}, "a\nc\n", SortOptions{}, "a\nb\nc\n", false},
+		{"empty stdin", []string{"a\n", "-", "b\n"}, "", SortOptions{}, "a\nb\n", false},
+		{"empty file", []string{"", "-", "b\n"}, "a\n", SortOptions{}, "a\nb\n", false},
+		{"all empty", []string{"", "-"}, "", SortOptions{}, "", false},
+		{"unique across sources", []string{"a\nb\n", "-"}, "b\nc\n", SortOptions{Unique: true}, "a\nb\nc\n", false},
+		{"numeric reverse", []string{"10\n2\n", "-"}, "9\n1\n", SortOptions{Numeric: true, Reverse: true}, "10\n9\n2\n1\n", false},
+		{"no final newline", []string{"a\nc", "-"}, "b", SortOptions{}, "a\nb\nc\n", false},
+		{"checked sorted inputs", []string{"a\nc\n", "-"}, "b\nb\n", SortOptions{CheckInputs: true}, "a\nb\nb\nc\n", false},
+		{"unsorted stdin", []string{"a\n", "-"}, "c\nb\n", SortOptions{CheckInputs: true}, "", true},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		sources := make([]string, len(c.files))
+		for i, content := range c.files {
+			if content == "-" {
+				sources[i] = "-"
+				continue
+			}
+			sources[i] = filepath.Join(dir, fmt.Sprint(i))
+			if err := os.WriteFile(sources[i], []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var out bytes.Buffer
+		var err error
+		withStdin(t, c.stdin, func() { err = MergeSorted(sources, &out, c.opts) })
+		switch {
+		case c.wantErr && err == nil:
+			t.Errorf("%s: no error", c.name)
+		case !c.wantErr && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case !c.wantErr && out.String() != c.want:
+			t.Errorf("%s: got %q, want %q", c.name, out.String(), c.want)
+		}
+	}
+}
+
+// TestMergeCheckInputs merges one unsorted file with --check-inputs: the
+// error names that file and the line out of order, and -u does not count
+// equal keys as disorder.
+func TestMergeCheckInputs(t *testing.T) {
+	dir := t.TempDir()
+	sources := []string{filepath.Join(dir, "sorted"), filepath.Join(dir, "unsorted")}
+	for i, content := range []string{"a\nc\ne\n", "b\nb\nd\nc\n"} {
+		if err := os.WriteFile(sources[i], []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, opts := range []SortOptions{{CheckInputs: true}, {CheckInputs: true, Unique: true}} {
+		err := MergeSorted(sources, io.Discard, opts)
+		if want := "sort: " + sources[1] + ":4: disorder: c"; err == nil || err.Error() != want {
+			t.Errorf("-u %v: err = %v, want %q", opts.Unique, err, want)
+		}
+		var disorder *Disorder
+		if !errors.As(err, &disorder) || disorder.Previous != "d" || disorder.Current != "c" {
+			t.Errorf("-u %v: err = %v, want a *Disorder of c after d", opts.Unique, err)
+		}
+	}
+	if err := MergeSorted(sources, io.Discard, SortOptions{}); err != nil {
+		t.Errorf("without --check-inputs: %v", err)
+	}
+}
+
+// TestMergeSortedManySources merges several times more sources than
+// maxOpenFiles: they are merged in batches through temporary files, which
+// are all removed afterwards, and the result is the same as one merge.
+func TestMergeSortedManySources(t *testing.T) {
+	n := 4*maxOpenFiles + 3
+	dir, tmp := t.TempDir(), t.TempDir()
+	sources := make([]string, n)
+	var all []string
+	for i := range sources {
+		lines := []string{fmt.Sprintf("%04d", i), fmt.Sprintf("%04d", n+i), fmt.Sprintf("%04d", 2*n+i)}
+		all = append(all, lines...)
+		sources[i] = filepath.Join(dir, fmt.Sprint(i))
+		if err := os.WriteFile(sources[i], []byte(joinLines(lines)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := joinLines(SortInMemory(all, SortOptions{}))
+
+	store := &countingStore{TempStore: newTempDirs([]string{tmp})}
+	var out bytes.Buffer
+	if err := MergeSorted(sources, &out, SortOptions{TempStore: store}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Error("output differs from the sorted lines of all sources")
+	}
+	if store.created == 0 {
+		t.Errorf("%d sources merged without temporary files", n)
+	}
+	if entries, err := os.ReadDir(tmp); err != nil || len(entries) != 0 {
+		t.Errorf("%d temporary files left, err %v", len(entries), err)
+	}
+
+	// Отсутствующий файл в одной из групп: ошибка или пропуск с --ignore-missing
+	missing := filepath.Join(dir, "missing")
+	sources[2*maxOpenFiles+1] = missing
+	if err := MergeSorted(sources, io.Discard, SortOptions{TempDirs: []string{tmp}}); err == nil || !strings.Contains(err.Error(), missing) {
+		t.Errorf("missing source: err = %v", err)
+	}
+	out.Reset()
+	stderr := captureStderr(t, func() {
+		if err := MergeSorted(sources, &out, SortOptions{TempDirs: []string{tmp}, IgnoreMissing: true}); err != nil {
+			t.Errorf("--ignore-missing: %v", err)
+		}
+	})
+	if got := strings.Count(out.String(), "\n"); got != 3*(n-1) || !strings.Contains(stderr, missing) {
+		t.Errorf("--ignore-missing: %d lines, stderr %q", got, stderr)
+	}
+	if entries, err := os.ReadDir(tmp); err != nil || len(entries) != 0 {
+		t.Errorf("%d temporary files left after the missing source, err %v", len(entries), err)
+	}
+}
+
+func TestMergeSortedMissingSource(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	if err := MergeSorted([]string{missing}, io.Discard, SortOptions{}); err == nil {
+		t.Error("no error for a missing source")
+	}
+}
+
+// TestExternalSortTempDirs sorts through two -T directories: the output is
+// the same as in memory and both directories are empty afterwards.
+func TestExternalSortTempDirs(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir()}
+	lines := numberedLines("line", 2000)
+	opts := SortOptions{TempDirs: dirs}
+	var out bytes.Buffer
+	if err := ExternalSortReader(strings.NewReader(joinLines(lines)), &out, opts, 2000); err != nil {
+		t.Fatal(err)
+	}
+	if want := joinLines(SortInMemory(slices.Clone(lines), SortOptions{})); out.String() != want {
+		t.Error("output differs from the in-memory sort")
+	}
+	for _, dir := range dirs {
+		if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+			t.Errorf("%s: %d entries left, err %v", dir, len(entries), err)
+		}
+	}
+}
+
+// TestTempFileErrors checks the context added to temporary file failures and
+// that a partly written file is removed.
+func TestTempFileErrors(t *testing.T) {
+	// Вместо каталога — обычный файл: создать в нём временный файл нельзя
+	notDir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notDir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := SortOptions{TempDirs: []string{notDir}}
+	err := ExternalSortReader(strings.NewReader("b\na\nc\n"), io.Discard, opts, 1)
+	if want := "sort: cannot create temporary file in " + notDir + ": "; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("create error = %v, want prefix %q", err, want)
+	}
+
+	dir := t.TempDir()
+	out, err := newTempWriter(newTempDirs([]string{dir}), "sort-*.tmp", SortOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.write("line"); err != nil {
+		t.Fatal(err)
+	}
+	// Запись в закрытый файл имитирует ошибку диска при сбросе буфера
+	_ = out.w.(*accountedFile).File.Close()
+	_, err = finishTempFile(out, SortOptions{})
+	if want := "sort: cannot write temporary file " + out.name + ": "; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("write error = %v, want prefix %q", err, want)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d partial temporary files left", len(entries))
+	}
+}
+
+// repeatReader returns n copies of line followed by a newline without holding
+// them all in memory.
+func repeatReader(line string, n int) io.Reader {
+	readers := make([]io.Reader, n)
+	for i := range readers {
+		readers[i] = strings.NewReader(line + "\n")
+	}
+	return io.MultiReader(readers...)
+}
+
+// TestInMemoryOnly sorts input just over the memory limit with
+// --in-memory-only: Sort returns ErrInputTooLarge, writes nothing and creates
+// no temporary files.
+func TestInMemoryOnly(t *testing.T) {
+	if testing.Short() {
+		t.Skip("reads over maxMemoryBytes of input")
+	}
+	line := strings.Repeat("x", 1<<20)
+	n := maxMemoryBytes/len(line) + 1
+	store := &countingStore{TempStore: newTempDirs([]string{t.TempDir()})}
+	var out bytes.Buffer
+	err := Sort(repeatReader(line, n), &out, SortOptions{InMemoryOnly: true, TempStore: store})
+	var sortErr *SortError
+	if !errors.As(err, &sortErr) || sortErr.Kind != KindInputTooLarge || !errors.Is(err, ErrInputTooLarge) {
+		t.Errorf("err = %v, want ErrInputTooLarge of KindInputTooLarge", err)
+	}
+	if out.Len() != 0 || store.created != 0 {
+		t.Errorf("wrote %d bytes and %d temporary files", out.Len(), store.created)
+	}
+
+	// Ввод в пределах лимита сортируется как обычно
+	if err := Sort(repeatReader("a", 10), &out, SortOptions{InMemoryOnly: true, TempStore: store}); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMergeChunkReadError checks that a read error of one input of an
+// intermediate merge is returned with the name of that input instead of
+// ending it like EOF, and that the partial result is removed.
+func TestMergeChunkReadError(t *testing.T) {
+	errRead := errors.New("disk on fire")
+	input := func(name string, r io.Reader) *tempFile {
+		return &tempFile{ReadCloser: io.NopCloser(r), Scanner: bufio.NewScanner(r), name: name}
+	}
+	files := []*tempFile{
+		input("good", strings.NewReader("a\nc\ne\n")),
+		input("bad", &failingReader{data: strings.NewReader("b\nd\n"), err: errRead}),
+	}
+	store := newMemStore()
+	merged, err := mergeChunk(files, SortOptions{}, store)
+	if merged != nil || !errors.Is(err, errRead) || !strings.HasPrefix(err.Error(), "sort: cannot read bad: ") {
+		t.Fatalf("mergeChunk = %v, %v; want a read error of bad", merged, err)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("%d partial merge results left in the store", len(store.objects))
+	}
+}
+
+// TestSingleTempFile sorts input that goes over the memory limit only on its
+// last line, so the external sort spills exactly one temporary file; its
+// output must match the in-memory sort, with -r and -u included.
+func TestSingleTempFile(t *testing.T) {
+	lines := []string{"b", "007", "a", "7", "b", "c", "a", "10"}
+	input := joinLines(lines)
+	limit := readPeak(lines) - 1
+	if readPeak(lines[:len(lines)-1]) > limit {
+		t.Fatal("the limit is reached before the last line")
+	}
+	cases := []struct {
+		name string
+		opts SortOptions
+	}{
+		{"plain", SortOptions{}},
+		{"reverse", SortOptions{Reverse: true}},
+		{"unique", SortOptions{Unique: true}},
+		{"reverse unique", SortOptions{Reverse: true, Unique: true}},
+		{"numeric unique", SortOptions{Numeric: true, Unique: true}},
+		{"numeric reverse unique", SortOptions{Numeric: true, Reverse: true, Unique: true}},
+	}
+	for _, c := range cases {
+		store := &countingStore{TempStore: newTempDirs([]string{t.TempDir()})}
+		opts := c.opts
+		opts.TempStore = store
+		got := sortWithLimit(t, strings.NewReader(input), opts, limit)
+		if store.created != 1 {
+			t.Errorf("%s: %d temporary files, want 1", c.name, store.created)
+		}
+		if want := sortText(t, input, c.opts); got != want {
+			t.Errorf("%s: got %q, want %q", c.name, got, want)
+		}
+	}
+}
+
+// TestStableExternalMerge spreads equal keys over many temporary files: with
+// -s the merge takes them in file order, which is input order, also through
+// intermediate merge levels and the parallel merge.
+func TestStableExternalMerge(t *testing.T) {
+	lines := make([]string, 6000)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%d %06d", i%3, i)
+	}
+	var want []string
+	for group := range 3 {
+		for _, line := range lines {
+			if line[0] == byte('0'+group) {
+				want = append(want, line)
+			}
+		}
+	}
+	key := []KeySpec{{StartField: 1, EndField: 1}}
+	cases := []struct {
+		name  string
+		opts  SortOptions
+		limit int
+	}{
+		{"one merge", SortOptions{}, 16 << 10},
+		{"multi-level merge", SortOptions{}, 1 << 10},
+		{"parallel merge", SortOptions{ParallelMerge: true, Parallel: 4}, 4 << 10},
+	}
+	for _, c := range cases {
+		opts := c.opts
+		opts.Keys, opts.Stable = key, true
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(joinLines(lines)), &out, opts, c.limit); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != joinLines(want) {
+			t.Errorf("%s: equal keys are not in input order", c.name)
+		}
+	}
+}
+
+// TestBatchSize checks that --batch-size bounds the files merged at once in an
+// external sort and in -m, and that -S moves Sort to temporary files.
+func TestBatchSize(t *testing.T) {
+	for _, c := range []struct{ batch, want int }{{0, maxOpenFiles}, {1, 2}, {2, 2}, {200, 200}} {
+		if got := (SortOptions{BatchSize: c.batch}).batchSize(); got != c.want {
+			t.Errorf("BatchSize %d: batchSize() = %d, want %d", c.batch, got, c.want)
+		}
+	}
+
+	lines := numberedLines("line", 2000)
+	want := joinLines(SortInMemory(slices.Clone(lines), SortOptions{}))
+	var created [2]int
+	for i, batch := range []int{0, 2} {
+		dir := t.TempDir()
+		store := &countingStore{TempStore: newTempDirs([]string{dir})}
+		var out bytes.Buffer
+		opts := SortOptions{BatchSize: batch, TempStore: store}
+		if err := ExternalSortReader(strings.NewReader(joinLines(lines)), &out, opts, 2048); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("batch %d: external sort output differs", batch)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("batch %d: %d temporary files left", batch, len(entries))
+		}
+		created[i] = store.created
+	}
+	// Слияние парами добавляет промежуточные файлы
+	if created[1] <= created[0] {
+		t.Errorf("--batch-size 2 created %d temporary files, the default %d", created[1], created[0])
+	}
+
+	dir := t.TempDir()
+	var sources []string
+	for i := range 5 {
+		path := filepath.Join(dir, fmt.Sprint(i))
+		if err := os.WriteFile(path, []byte(joinLines(SortInMemory(slices.Clone(lines[i*400:(i+1)*400]), SortOptions{}))), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, path)
+	}
+	store := &countingStore{TempStore: newTempDirs([]string{t.TempDir()})}
+	var out bytes.Buffer
+	if err := MergeSorted(sources, &out, SortOptions{BatchSize: 2, TempStore: store}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want || store.created == 0 {
+		t.Errorf("-m --batch-size 2: output equal %v, %d temporary files", out.String() == want, store.created)
+	}
+
+	store = &countingStore{TempStore: newTempDirs([]string{t.TempDir()})}
+	out.Reset()
+	if err := Sort(strings.NewReader(joinLines(lines)), &out, SortOptions{BufferSize: 4096, TempStore: store}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want || store.created == 0 {
+		t.Errorf("-S 4096: output equal %v, %d temporary files", out.String() == want, store.created)
+	}
+}
+
+// TestSystemLimits checks that the memory and open file limits of this system
+// are known and plausible.
+func TestSystemLimits(t *testing.T) {
+	if total, ok := TotalMemory(); ok && total < 1<<20 {
+		t.Errorf("TotalMemory() = %d, want at least a megabyte", total)
+	}
+	if limit, ok := OpenFileLimit(); ok && limit < 3 {
+		t.Errorf("OpenFileLimit() = %d, want at least stdin, stdout and stderr", limit)
+	}
+}
+
+// TestExternalSortDiskFull fills the temporary directory while writing the
+// sorted runs and at different merge levels: the sort must fail with a message
+// naming the directory and leave no temporary files behind.
+func TestExternalSortDiskFull(t *testing.T) {
+	input := joinLines(numberedLines("line", 2000))
+	for _, limit := range []int{0, 100, 15000, 30000, 60000} {
+		dir := t.TempDir()
+		store := &fullStore{TempStore: newTempDirs([]string{dir}), limit: limit}
+		opts := SortOptions{BatchSize: 2, TempStore: store}
+		err := ExternalSortReader(strings.NewReader(input), io.Discard, opts, 2048)
+		if !errors.Is(err, syscall.ENOSPC) || !strings.Contains(err.Error(), "sort: no space left for temporary files in "+dir) {
+			t.Errorf("limit %d: err %v, want no space left in %s", limit, err, dir)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("limit %d: %d temporary files left", limit, len(entries))
+		}
+	}
+}
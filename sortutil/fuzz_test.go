@@ -0,0 +1,81 @@
+package sortutil
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fuzzOptions builds the options of one fuzz case from the bits of flags.
+func fuzzOptions(mode, flags byte) SortOptions {
+	opts := SortOptions{
+		Reverse:      flags&1 != 0,
+		Unique:       flags&2 != 0,
+		IgnoreBlanks: flags&16 != 0,
+	}
+	if flags&32 != 0 {
+		opts.Keys = []KeySpec{{StartField: 2}}
+	}
+	switch mode % 4 {
+	case 1:
+		opts.Numeric = true
+	case 2:
+		opts.Month = true
+	case 3:
+		opts.Human = true
+	}
+	return opts
+}
+
+// FuzzSort checks that the output of SortInMemory is a permutation of the
+// input (a subset without duplicate keys for -u) in the order -c checks with
+// the same options, and that without options it equals sort.Strings.
+func FuzzSort(f *testing.F) {
+	f.Add("b\na\nc\n", byte(0), byte(0))
+	f.Add("10\n9\n-1\n1e3\n 2K\n", byte(1), byte(1))
+	f.Add("Feb x\nJan y\nJan y\n\nMar\n", byte(2), byte(2))
+	f.Add("1K\tb\n900\ta\n1M\tA\n", byte(3), byte(32))
+	f.Add("\t a\n a\na \n", byte(0), byte(16+2))
+	f.Fuzz(func(t *testing.T, data string, mode, flags byte) {
+		// ScanLines отрезает \r в конце строки, поэтому он не участвует в проверке перестановки
+		data = strings.ReplaceAll(data, "\r", "")
+		lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+		if data == "" {
+			lines = nil
+		}
+		opts := fuzzOptions(mode, flags)
+
+		sorted := SortInMemory(slices.Clone(lines), opts)
+
+		if err := checkText(joinLines(sorted), opts); err != nil {
+			t.Fatalf("output of %+v fails -c: %v", opts, err)
+		}
+
+		rest := slices.Clone(lines)
+		for _, line := range sorted {
+			i := slices.Index(rest, line)
+			if i < 0 {
+				t.Fatalf("output line %q is not in the input", line)
+			}
+			rest = slices.Delete(rest, i, i+1)
+		}
+		comp := newComparator(opts)
+		for _, line := range rest {
+			if !opts.Unique {
+				t.Fatalf("input line %q is missing from the output", line)
+			}
+			if !slices.ContainsFunc(sorted, func(kept string) bool { return equivalent(kept, line, comp) }) {
+				t.Fatalf("-u dropped %q without keeping an equal line", line)
+			}
+		}
+
+		if mode%4 == 0 && flags == 0 {
+			want := slices.Clone(lines)
+			sort.Strings(want)
+			if !slices.Equal(sorted, want) {
+				t.Fatalf("plain sort = %q, want %q", sorted, want)
+			}
+		}
+	})
+}
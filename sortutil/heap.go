@@ -0,0 +1,62 @@
+package sortutil
+
+// binaryHeap is a min-heap of T ordered by less. Unlike container/heap, it
+// keeps the items in a slice by value, without boxing them in any on every
+// operation.
+type binaryHeap[T any] struct {
+	items []T
+	less  func(a, b T) bool
+}
+
+func newHeap[T any](capacity int, less func(a, b T) bool) *binaryHeap[T] {
+	return &binaryHeap[T]{items: make([]T, 0, capacity), less: less}
+}
+
+func (h *binaryHeap[T]) push(item T) {
+	h.items = append(h.items, item)
+	h.up(len(h.items) - 1)
+}
+
+// pop removes the minimum item.
+func (h *binaryHeap[T]) pop() {
+	n := len(h.items) - 1
+	h.items[0] = h.items[n]
+	h.items = h.items[:n]
+	if n > 0 {
+		h.down(0)
+	}
+}
+
+// fixTop restores the order after the minimum item h.items[0] was changed in place.
+func (h *binaryHeap[T]) fixTop() {
+	h.down(0)
+}
+
+func (h *binaryHeap[T]) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !h.less(h.items[i], h.items[parent]) {
+			return
+		}
+		h.items[i], h.items[parent] = h.items[parent], h.items[i]
+		i = parent
+	}
+}
+
+func (h *binaryHeap[T]) down(i int) {
+	n := len(h.items)
+	for {
+		least := i
+		if l := 2*i + 1; l < n && h.less(h.items[l], h.items[least]) {
+			least = l
+		}
+		if r := 2*i + 2; r < n && h.less(h.items[r], h.items[least]) {
+			least = r
+		}
+		if least == i {
+			return
+		}
+		h.items[i], h.items[least] = h.items[least], h.items[i]
+		i = least
+	}
+}
@@ -0,0 +1,76 @@
+package sortutil
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestBinaryHeap pushes items in various orders and checks that they pop
+// in ascending order.
+func TestBinaryHeap(t *testing.T) {
+	cases := [][]int{
+		nil,
+		{1},
+		{2, 1},
+		{5, 5, 5},
+		{9, 3, 7, 1, 8, 2, 6, 4, 5, 0},
+		{0, 1, 2, 3, 4, 5, 6, 7},
+		{7, 6, 5, 4, 3, 2, 1, 0},
+	}
+	for _, items := range cases {
+		h := newHeap(len(items), func(a, b int) bool { return a < b })
+		for _, item := range items {
+			h.push(item)
+		}
+		var got []int
+		for len(h.items) > 0 {
+			got = append(got, h.items[0])
+			h.pop()
+		}
+		if want := slices.Sorted(slices.Values(items)); !slices.Equal(got, want) {
+			t.Errorf("%v: popped %v, want %v", items, got, want)
+		}
+	}
+}
+
+// TestBinaryHeapFixTop drains sorted runs the way a merge does: the top cursor
+// advances in place and fixTop restores the order.
+func TestBinaryHeapFixTop(t *testing.T) {
+	runs := [][]int{{1, 4, 7, 10}, {2, 5, 8}, {3, 6, 9, 11, 12}, {}}
+	h := newHeap(len(runs), func(a, b []int) bool { return a[0] < b[0] })
+	for _, run := range runs {
+		if len(run) > 0 {
+			h.push(run)
+		}
+	}
+	var got []int
+	for len(h.items) > 0 {
+		got = append(got, h.items[0][0])
+		if h.items[0] = h.items[0][1:]; len(h.items[0]) > 0 {
+			h.fixTop()
+		} else {
+			h.pop()
+		}
+	}
+	if want := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}; !slices.Equal(got, want) {
+		t.Errorf("merged %v, want %v", got, want)
+	}
+}
+
+// BenchmarkBinaryHeapFixTop replaces the top of a heap of 64 merge items with
+// the next line of its run, as a merge does for every line: it must not allocate.
+func BenchmarkBinaryHeapFixTop(b *testing.B) {
+	comp := newComparator(SortOptions{})
+	h := newHeap(64, func(a, b mergeItem) bool { return comp.compareLines(a.line, b.line) < 0 })
+	lines := numberedLines("line", 64)
+	for i, line := range lines {
+		h.push(mergeItem{line: line, index: i})
+	}
+	b.ReportAllocs()
+	i := 0
+	for b.Loop() {
+		h.items[0].line = lines[i%len(lines)]
+		h.fixTop()
+		i++
+	}
+}
@@ -0,0 +1,70 @@
+package sortutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// fieldHint counts records that lack the field a -k key starts in, to hint at
+// a missing -t: without -t fields are separated by blanks, so on input with
+// another separator -k2 gives almost every line an empty key and the sort
+// silently falls back to whole lines.
+type fieldHint struct {
+	key     KeySpec
+	active  bool // заголовок (--header) не считается
+	lines   int
+	missing int
+}
+
+// newFieldHint returns the hint for opts, or nil when keys are not blank-separated fields.
+func newFieldHint(opts SortOptions) *fieldHint {
+	if len(opts.Keys) == 0 || opts.Separator != "" || opts.CSV || opts.TSV || opts.Columns != nil {
+		return nil
+	}
+	for _, k := range newComparator(opts).keys {
+		if k.StartField > 1 && k.Separator == "" {
+			return &fieldHint{key: k}
+		}
+	}
+	return nil
+}
+
+// observe wraps split so that every record it returns is checked for the field.
+func (h *fieldHint) observe(split bufio.SplitFunc) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if token != nil && h.active {
+			h.add(string(token))
+		}
+		return advance, token, err
+	}
+}
+
+func (h *fieldHint) add(line string) {
+	h.lines++
+	if h.key.recordLine > 0 {
+		line = nthLine(line, h.key.recordLine)
+	}
+	// Без -t поле включает ведущие пробелы, поэтому поле из одних пробелов — тоже отсутствующее
+	if start, end, ok := h.key.field(line, h.key.StartField); !ok || skipBlanks(line, start, end) == end {
+		h.missing++
+	}
+}
+
+// report prints the hint when more than half of the records lack the field.
+func (h *fieldHint) report(w io.Writer) {
+	if h.missing*2 <= h.lines {
+		return
+	}
+	fmt.Fprintf(w, "sort: hint: field %d is missing in %d of %d lines; fields are separated by blanks, use -t to set the separator\n",
+		h.key.StartField, h.missing, h.lines)
+}
+
+// reportHint prints the hint of h, if any, to stderr.
+func reportHint(h *fieldHint) {
+	if h != nil {
+		h.report(os.Stderr)
+	}
+}
@@ -0,0 +1,41 @@
+package sortutil
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestFieldHint checks when Sort hints at -t: only when -k starts past the
+// first blank-separated field and most lines lack that field.
+func TestFieldHint(t *testing.T) {
+	second := []KeySpec{{StartField: 2, EndField: 2}}
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		hint  bool
+	}{
+		{"single column", "b\na\nc\n", SortOptions{Keys: second}, true},
+		{"comma separated", "b,2\na,1\n", SortOptions{Keys: second}, true},
+		{"trailing blanks", "b  \na \n", SortOptions{Keys: second}, true},
+		{"two columns", "b 2\na 1\n", SortOptions{Keys: second}, false},
+		{"half the lines", "b\na 1\n", SortOptions{Keys: second}, false},
+		{"first field", "b\na\n", SortOptions{Keys: []KeySpec{{StartField: 1, EndField: 1}}}, false},
+		{"explicit separator", "b\na\n", SortOptions{Keys: second, Separator: ","}, false},
+		{"csv", "b\na\n", SortOptions{Keys: second, CSV: true}, false},
+		{"fixed columns", "b\na\n", SortOptions{Keys: second, Columns: []int{1, 1}}, false},
+		{"no keys", "b\na\n", SortOptions{}, false},
+		{"header not counted", "h x\nb\na\n", SortOptions{Keys: second, Header: 1}, true},
+	}
+	for _, c := range cases {
+		report := captureStderr(t, func() { sortText(t, c.input, c.opts) })
+		if got := strings.Contains(report, "sort: hint: field 2 is missing"); got != c.hint {
+			t.Errorf("%s: hint %v, want %v (stderr %q)", c.name, got, c.hint, report)
+		}
+	}
+
+	report := captureStderr(t, func() { sortText(t, "b\na\nc\n", SortOptions{Keys: second}) })
+	if want := "sort: hint: field 2 is missing in 3 of 3 lines; fields are separated by blanks, use -t to set the separator\n"; report != want {
+		t.Errorf("hint = %q, want %q", report, want)
+	}
+}
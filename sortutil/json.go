@@ -0,0 +1,73 @@
+package sortutil
+
+import (
+	"cmp"
+	"encoding/json"
+	"strings"
+)
+
+// jsonValue extracts the value at the dotted path from a JSON object line.
+// ok is false when the line is not valid JSON or the path is missing.
+func jsonValue(line string, path []string) (any, bool) {
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, false
+	}
+	for _, name := range path {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[name]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// compareJSON compares two lines by the value at c.jsonPath:
+// numbers numerically, strings lexically, numbers before strings.
+// Lines without a value go first, or last with --json-invalid-last.
+func (c *comparator) compareJSON(a, b string) int {
+	a, b = a[c.tagLen:], b[c.tagLen:]
+	va, okA := jsonValue(a, c.jsonPath)
+	vb, okB := jsonValue(b, c.jsonPath)
+	if res := cmp.Compare(c.jsonRank(va, okA), c.jsonRank(vb, okB)); res != 0 {
+		return res
+	}
+	if !okA {
+		return 0
+	}
+
+	switch x := va.(type) {
+	case json.Number:
+		fa, _ := x.Float64()
+		fb, _ := vb.(json.Number).Float64()
+		return cmp.Compare(fa, fb)
+	case string:
+		return strings.Compare(x, vb.(string))
+	}
+	// Прочие значения сравниваются по их JSON-представлению
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return strings.Compare(string(ja), string(jb))
+}
+
+func (c *comparator) jsonRank(v any, ok bool) int {
+	if !ok {
+		if c.jsonInvalidLast {
+			return 4
+		}
+		return 0
+	}
+	switch v.(type) {
+	case json.Number:
+		return 1
+	case string:
+		return 2
+	}
+	return 3
+}
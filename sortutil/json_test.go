@@ -0,0 +1,60 @@
+package sortutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONValue(t *testing.T) {
+	cases := []struct {
+		line string
+		path string
+		want any
+		ok   bool
+	}{
+		{`{"a":"x"}`, "a", "x", true},
+		{`{"meta":{"ts":"2024"}}`, "meta.ts", "2024", true},
+		{`{"meta":{"ts":"2024"}}`, "meta.id", nil, false},
+		{`{"meta":"flat"}`, "meta.ts", nil, false},
+		{`not json`, "a", nil, false},
+		{`[1,2]`, "a", nil, false},
+		{`{"a":null}`, "a", nil, true},
+	}
+	for _, c := range cases {
+		got, ok := jsonValue(c.line, strings.Split(c.path, "."))
+		if ok != c.ok || got != c.want {
+			t.Errorf("jsonValue(%q, %q) = %v, %v, want %v, %v", c.line, c.path, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+// TestSortJSONKey sorts JSONL by a string and a numeric field: numbers by
+// value, numbers before strings, and lines without the field first or last.
+func TestSortJSONKey(t *testing.T) {
+	input := `{"id":10,"name":"bob"}` + "\n" +
+		`{"id":9,"name":"alice"}` + "\n" +
+		`broken` + "\n" +
+		`{"id":"x","name":"carol"}` + "\n" +
+		`{"id":1.5,"meta":{"name":"dave"}}` + "\n"
+	cases := []struct {
+		name string
+		opts SortOptions
+		want []int // номера строк ввода с 0
+	}{
+		{"number", SortOptions{JSONKey: "id"}, []int{2, 4, 1, 0, 3}},
+		{"number reverse", SortOptions{JSONKey: "id", Reverse: true}, []int{3, 0, 1, 4, 2}},
+		{"number invalid last", SortOptions{JSONKey: "id", JSONInvalidLast: true}, []int{4, 1, 0, 3, 2}},
+		{"string", SortOptions{JSONKey: "name"}, []int{2, 4, 1, 0, 3}},
+		{"nested", SortOptions{JSONKey: "meta.name", JSONInvalidLast: true}, []int{4, 2, 3, 0, 1}},
+	}
+	lines := strings.Split(strings.TrimSuffix(input, "\n"), "\n")
+	for _, c := range cases {
+		want := make([]string, len(c.want))
+		for i, n := range c.want {
+			want[i] = lines[n]
+		}
+		if got := sortText(t, input, c.opts); got != joinLines(want) {
+			t.Errorf("%s: got %q, want %q", c.name, got, joinLines(want))
+		}
+	}
+}
@@ -0,0 +1,481 @@
+package sortutil
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// KeySpec describes a sort key given as -k POS1[,POS2], where POS is F[.C][OPTS].
+type KeySpec struct {
+	StartField      int  // поле начала ключа (с 1); 0 — вся строка
+	StartChar       int  // символ в поле начала (с 1); 0 — начало поля
+	EndField        int  // поле конца ключа; 0 — до конца строки
+	EndChar         int  // последний символ в поле конца; 0 — конец поля
+	SkipStartBlanks bool // модификатор b в POS1
+	SkipEndBlanks   bool // модификатор b в POS2
+
+	Numeric           bool // n
+	GeneralNumeric    bool // g
+	Human             bool // h
+	Month             bool // M
+	Version           bool // V
+	Random            bool // R
+	Reverse           bool // r
+	FoldCase          bool // f
+	Dictionary        bool // d
+	IgnoreNonprinting bool // i
+	Lexical           bool // l: сравнивать как текст, отменяя --key-default-numeric и глобальный -n
+
+	Separator string // tSEP: свой разделитель полей ключа вместо -t (расширение GNU)
+
+	trimBlanks bool           // унаследованный глобальный -b: обрезать пробелы с обеих сторон
+	stripChars string         // --strip-chars: символы, обрезаемые с обеих сторон ключа
+	csv        bool           // --csv: поля разбираются как CSV
+	transform  []keyTransform // --transform: шаги нормализации ключа по порядку
+	tsv        bool           // --tsv: поля делятся табуляцией, \t, \n и \\ в них раскрываются
+	sep        string         // -t: разделитель полей; пусто — переход от непробела к пробелу
+	regex      *regexp.Regexp // --key-regex: ключ — первая группа совпадения
+	template   *KeyTemplate   // --key-template: ключ собирается из полей по шаблону
+	fromByte   int            // --key-from-byte: ключ — строка с этого байта (с 1) до конца
+	accents    bool           // --ignore-accents: диакритика снимается перед сравнением (café = cafe)
+	squeeze    bool           // --squeeze-blanks: пробельные промежутки ключа сравниваются как один пробел
+	comment    string         // --ignore-comment: с этого символа до конца строки — комментарий, не ключ
+	quote      string         // --unquote: кавычка, снимаемая с ключа вместе с парной; пусто — не снимать
+	reverseBy  string         // --reverse-key: ключ переворачивается по символам (chars) или по точкам (dots)
+	comparer   KeyComparer    // сравнение по режиму ключа, выбирается один раз
+	runes      bool           // --runes: позиции .C считаются в рунах, а не в байтах
+	ip         bool           // унаследованный --ip: ключ сравнивается как IP-адрес
+	hex        bool           // унаследованный --hex: ключ сравнивается как шестнадцатеричное число
+	radix      int            // унаследованный --radix: основание чисел ключа (2-36); 0 — не задано
+	epoch      string         // унаследованный --epoch: единица меток времени Unix (s, ms, auto)
+	autoUnit   bool           // унаследованный --auto-unit: размер, длительность или число — по виду ключа
+	natural    bool           // унаследованный --natural: числа в ключе по значению, текст по локали
+	duration   bool           // унаследованный --duration: ключ — длительность Go вроде 1h2m или 500ms
+	money      bool           // унаследованный --money: ключ — денежная сумма с символом валюты и группами
+	rightAlign bool           // унаследованный --right-align: ключи сравниваются выровненными вправо
+	scale      float64        // --scale: множитель числа ключа; 0 — без масштаба
+	numeric    numberFormat   // десятичный разделитель и группы разрядов чисел -n и -h
+	boolean    bool           // унаследованный --bool: ключ — логическое значение, false раньше true
+	order      bool           // унаследованный --order: ключ сравнивается по месту в списке значений
+	recordLine int            // --record-key-line: строка многострочной записи для ключа; 0 — вся запись
+	widths     []int          // --columns: ширины колонок фиксированной ширины вместо разделителя
+	trimSep    bool           // --trim-trailing-separator: разделитель в конце строки не даёт пустого поля
+	tagLen     int            // --assert-stable: длина метки номера перед записью, не входящей в ключ
+}
+
+// ParseKeySpec parses a -k argument such as "2", "2,3n", "2.3b,2.5br" or "3,3nt:".
+// The b modifier applies only to the position it is attached to,
+// the ordering letters (bdfghiMnRrV) apply to the whole key.
+// An invalid key is a SortError of KindInvalidOption.
+func ParseKeySpec(spec string) (KeySpec, error) {
+	k, err := parseKeySpec(spec)
+	if err != nil {
+		return KeySpec{}, newError(KindInvalidOption, err)
+	}
+	return k, nil
+}
+
+func parseKeySpec(spec string) (KeySpec, error) {
+	var k KeySpec
+
+	start, end, hasEnd := strings.Cut(spec, ",")
+	field, char, mods, err := parseKeyPos(start)
+	if err != nil {
+		return KeySpec{}, fmt.Errorf("invalid key %q: %v", spec, err)
+	}
+	if char == 0 && strings.Contains(start, ".") {
+		return KeySpec{}, fmt.Errorf("invalid key %q: character offset is zero", spec)
+	}
+	k.StartField, k.StartChar = field, char
+	if err = k.applyModifiers(mods, false); err != nil {
+		return KeySpec{}, fmt.Errorf("invalid key %q: %v", spec, err)
+	}
+
+	if !hasEnd {
+		return k, nil
+	}
+	field, char, mods, err = parseKeyPos(end)
+	if err != nil {
+		return KeySpec{}, fmt.Errorf("invalid key %q: %v", spec, err)
+	}
+	k.EndField, k.EndChar = field, char
+	if err = k.applyModifiers(mods, true); err != nil {
+		return KeySpec{}, fmt.Errorf("invalid key %q: %v", spec, err)
+	}
+	return k, nil
+}
+
+// applyModifiers applies the modifier letters of one key position.
+// The t is followed by the field separator of this key, exactly one
+// character: -k 2,2t: .
+func (k *KeySpec) applyModifiers(mods string, atEnd bool) error {
+	for len(mods) > 0 {
+		m, size := utf8.DecodeRuneInString(mods)
+		mods = mods[size:]
+		if m != 't' {
+			if err := k.applyModifier(m, atEnd); err != nil {
+				return err
+			}
+			continue
+		}
+		if mods == "" {
+			return fmt.Errorf("modifier 't' needs a separator")
+		}
+		_, size = utf8.DecodeRuneInString(mods)
+		k.Separator, mods = mods[:size], mods[size:]
+	}
+	return nil
+}
+
+// applyModifier sets the option denoted by a key modifier letter.
+func (k *KeySpec) applyModifier(m rune, atEnd bool) error {
+	switch m {
+	case 'b':
+		if atEnd {
+			k.SkipEndBlanks = true
+		} else {
+			k.SkipStartBlanks = true
+		}
+	case 'n':
+		k.Numeric = true
+	case 'g':
+		k.GeneralNumeric = true
+	case 'h':
+		k.Human = true
+	case 'M':
+		k.Month = true
+	case 'V':
+		k.Version = true
+	case 'R':
+		k.Random = true
+	case 'r':
+		k.Reverse = true
+	case 'f':
+		k.FoldCase = true
+	case 'd':
+		k.Dictionary = true
+	case 'i':
+		k.IgnoreNonprinting = true
+	case 'l':
+		k.Lexical = true
+	default:
+		return fmt.Errorf("unknown modifier '%c'", m)
+	}
+	return nil
+}
+
+// hasOrdering reports whether the key has options of its own.
+// A key without modifiers of its own inherits the global flags.
+func (k KeySpec) hasOrdering() bool {
+	return k.SkipStartBlanks || k.SkipEndBlanks || k.Numeric || k.GeneralNumeric ||
+		k.Human || k.Month || k.Version || k.Random || k.Reverse ||
+		k.FoldCase || k.Dictionary || k.IgnoreNonprinting || k.Lexical
+}
+
+// hasMode reports whether the key selects its own ordering mode.
+func (k KeySpec) hasMode() bool {
+	return k.Numeric || k.GeneralNumeric || k.Human || k.Month ||
+		k.Version || k.Random || k.Lexical
+}
+
+// parseKeyPos splits a key position F[.C][OPTS] into its numbers and modifiers.
+func parseKeyPos(pos string) (field, char int, mods string, err error) {
+	i := 0
+	for i < len(pos) && (pos[i] >= '0' && pos[i] <= '9' || pos[i] == '.' || i == 0 && pos[i] == '-') {
+		i++
+	}
+	num, mods := pos[:i], pos[i:]
+
+	fieldStr, charStr, hasChar := strings.Cut(num, ".")
+	if field, err = strconv.Atoi(fieldStr); err != nil {
+		return 0, 0, "", fmt.Errorf("invalid field number %q", fieldStr)
+	}
+	// Поля нумеруются с 1: -k 0 и -k -1 — ошибка, а не вся строка
+	if field < 1 {
+		return 0, 0, "", fmt.Errorf("field number must be positive, got %d", field)
+	}
+	if hasChar {
+		if char, err = strconv.Atoi(charStr); err != nil {
+			return 0, 0, "", fmt.Errorf("invalid character offset %q", charStr)
+		}
+	}
+	return field, char, mods, nil
+}
+
+// getKey extracts the part of line described by k.
+// Fields are separated by -t, by runs of blanks or by the rules of CSV and
+// TSV; a key beyond the end of the line is empty.
+func getKey(line string, k KeySpec) string {
+	if k.csv && k.StartField > 0 {
+		return csvKey(line, k)
+	}
+	if k.tsv && k.StartField > 0 {
+		return tsvKey(line, k)
+	}
+	start, end := keySpan(line, k)
+	return line[start:end]
+}
+
+// keySpan returns the byte offsets of the key k in line (without CSV parsing);
+// a missing key is an empty span.
+func keySpan(line string, k KeySpec) (int, int) {
+	if k.StartField <= 0 {
+		return 0, len(line)
+	}
+
+	start, limit, ok := k.field(line, k.StartField)
+	if !ok {
+		return len(line), len(line)
+	}
+	if k.SkipStartBlanks {
+		start = skipBlanks(line, start, limit)
+	}
+	if k.StartChar > 0 {
+		start = k.advance(line, start, k.StartChar-1, limit)
+	}
+
+	end := len(line)
+	if k.EndField > 0 {
+		if endStart, endLimit, ok := k.field(line, k.EndField); ok {
+			end = endLimit
+			if k.EndChar > 0 {
+				if k.SkipEndBlanks {
+					endStart = skipBlanks(line, endStart, end)
+				}
+				end = k.advance(line, endStart, k.EndChar, end)
+			}
+		}
+	}
+
+	if end < start {
+		return start, start
+	}
+	return start, end
+}
+
+// extract returns the part of line compared for this key.
+func (k KeySpec) extract(line string) string {
+	line = line[k.tagLen:]
+	if k.recordLine > 0 {
+		line = nthLine(line, k.recordLine)
+	}
+	if k.comment != "" {
+		line = stripComment(line, k.comment)
+	}
+	var key string
+	if k.regex != nil {
+		key = regexKey(line, k.regex)
+	} else if k.fromByte > 0 {
+		key = line[min(k.fromByte-1, len(line)):]
+	} else if k.template != nil {
+		if k.trimSep {
+			line = k.trimTrailingSeparator(line)
+		}
+		key = k.template.expand(line, k)
+	} else {
+		if k.trimSep {
+			line = k.trimTrailingSeparator(line)
+		}
+		key = getKey(line, k)
+	}
+	if k.trimBlanks {
+		key = trimBlanks(key)
+	}
+	if k.quote != "" {
+		key = unquote(key, k.quote)
+	}
+	if k.stripChars != "" {
+		key = strings.Trim(key, k.stripChars)
+	}
+	if k.reverseBy != "" {
+		key = reverseKey(key, k.reverseBy)
+	}
+	return key
+}
+
+// trimTrailingSeparator drops a single trailing field separator from line, so that
+// "a,b," has two fields rather than an empty third one. Without -t the
+// separator is a run of blanks, so all trailing spaces and tabs are dropped.
+func (k KeySpec) trimTrailingSeparator(line string) string {
+	switch {
+	case k.csv:
+		return strings.TrimSuffix(line, ",")
+	case k.tsv:
+		return strings.TrimSuffix(line, "\t")
+	case k.sep != "":
+		return strings.TrimSuffix(line, k.sep)
+	}
+	return strings.TrimRight(line, " \t")
+}
+
+// stripComment cuts line before the first mark and drops the blanks before it,
+// so that "a 1  # note" is compared as "a 1" (--ignore-comment).
+func stripComment(line, mark string) string {
+	if i := strings.Index(line, mark); i >= 0 {
+		return strings.TrimRight(line[:i], " \t")
+	}
+	return line
+}
+
+// unquote strips a matching pair of quote marks around key (--unquote), ignoring
+// the blanks outside them, and unescapes the quotes inside: both the doubled "" of
+// CSV and \" mean one quote. A key without a pair of quotes is returned as is.
+func unquote(key, quote string) string {
+	inner := trimBlanks(key)
+	if len(inner) < 2*len(quote) || !strings.HasPrefix(inner, quote) || !strings.HasSuffix(inner, quote) {
+		return key
+	}
+	inner = inner[len(quote) : len(inner)-len(quote)]
+	if !strings.Contains(inner, quote) {
+		return inner
+	}
+	inner = strings.ReplaceAll(inner, `\`+quote, quote)
+	return strings.ReplaceAll(inner, quote+quote, quote)
+}
+
+// Values of SortOptions.ReverseKey.
+const (
+	ReverseChars = "chars" // ключ переворачивается по символам (рунам)
+	ReverseDots  = "dots"  // переворачивается порядок частей между точками
+)
+
+// reverseKey reverses key for --reverse-key: the dot-separated labels with "dots",
+// so that a.example.com is compared as com.example.a, and the runes with "chars".
+func reverseKey(key, by string) string {
+	if by == ReverseDots {
+		labels := strings.Split(key, ".")
+		slices.Reverse(labels)
+		return strings.Join(labels, ".")
+	}
+	runes := []rune(key)
+	slices.Reverse(runes)
+	return string(runes)
+}
+
+// nthLine returns the n-th line (from 1) of a multi-line record, or "" if there is none.
+func nthLine(record string, n int) string {
+	for ; n > 1; n-- {
+		i := strings.IndexByte(record, '\n')
+		if i < 0 {
+			return ""
+		}
+		record = record[i+1:]
+	}
+	line, _, _ := strings.Cut(record, "\n")
+	return line
+}
+
+// regexKey returns the first capture group of re in line (or the whole match
+// when re has no groups). Lines without a match get an empty key and go first.
+func regexKey(line string, re *regexp.Regexp) string {
+	m := re.FindStringSubmatch(line)
+	switch {
+	case m == nil:
+		return ""
+	case len(m) > 1:
+		return m[1]
+	}
+	return m[0]
+}
+
+// field returns the byte offsets where the n-th field of line begins and ends.
+// With --columns fields are given by widths rather than a separator, and
+// everything after the last column is one more field. ok is false when the
+// line has fewer than n fields.
+func (k KeySpec) field(line string, n int) (start, end int, ok bool) {
+	if k.widths == nil {
+		start, ok = fieldStart(line, n, k.sep)
+		return start, fieldEnd(line, start, k.sep), ok
+	}
+	if n > len(k.widths)+1 {
+		return len(line), len(line), false
+	}
+	for _, width := range k.widths[:n-1] {
+		start = k.advance(line, start, width, len(line))
+	}
+	end = len(line)
+	if n <= len(k.widths) {
+		end = k.advance(line, start, k.widths[n-1], len(line))
+	}
+	return start, end, start < len(line) || n == 1
+}
+
+// fieldStart returns the byte offset where the n-th field of line begins.
+// Without -t a field begins at a non-blank to blank transition and includes
+// its leading blanks, as in GNU sort; tabs and spaces count alike.
+// With -t every separator ends exactly one field: ":a" has an empty
+// first field, "a:" an empty second one, and in "a::b" the second is empty
+// and "b" is the third.
+// ok is false when the line has fewer than n fields; such a key is empty.
+func fieldStart(line string, n int, sep string) (int, bool) {
+	pos := 0
+	for i := 1; i < n; i++ {
+		if sep == "" {
+			pos = skipNonBlanks(line, skipBlanks(line, pos, len(line)))
+			continue
+		}
+		idx := strings.Index(line[pos:], sep)
+		if idx < 0 {
+			return len(line), false
+		}
+		pos += idx + len(sep)
+	}
+	return pos, true
+}
+
+// fieldEnd returns the byte offset where the field starting at from ends.
+func fieldEnd(line string, from int, sep string) int {
+	if sep == "" {
+		return skipNonBlanks(line, skipBlanks(line, from, len(line)))
+	}
+	if idx := strings.Index(line[from:], sep); idx >= 0 {
+		return from + idx
+	}
+	return len(line)
+}
+
+// splitFields splits line into fields the same way key positions count them.
+func splitFields(line, sep string) []string {
+	if sep != "" {
+		return strings.Split(line, sep)
+	}
+	var fields []string
+	for pos := 0; pos < len(line); {
+		end := fieldEnd(line, pos, sep)
+		fields = append(fields, line[pos:end])
+		pos = end
+	}
+	return fields
+}
+
+// advance moves from by n characters without crossing limit.
+// Characters are bytes, or UTF-8 runes with --runes.
+func (k KeySpec) advance(line string, from, n, limit int) int {
+	if !k.runes {
+		return min(from+n, limit)
+	}
+	for ; n > 0 && from < limit; n-- {
+		_, size := utf8.DecodeRuneInString(line[from:limit])
+		from += size
+	}
+	return from
+}
+
+// skipBlanks advances i past blanks without crossing limit.
+func skipBlanks(line string, i, limit int) int {
+	for i < limit && isBlank(line[i]) {
+		i++
+	}
+	return i
+}
+
+func skipNonBlanks(line string, i int) int {
+	for i < len(line) && !isBlank(line[i]) {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,846 @@
+package sortutil
+
+import (
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// extractKey returns the first key of opts extracted from line.
+func extractKey(t *testing.T, line, spec string, opts SortOptions) string {
+	t.Helper()
+	k, err := ParseKeySpec(spec)
+	if err != nil {
+		t.Fatalf("-k %s: %v", spec, err)
+	}
+	opts.Keys = []KeySpec{k}
+	return newComparator(opts).keys[0].extract(line)
+}
+
+func TestParseKeySpec(t *testing.T) {
+	cases := []struct {
+		spec string
+		want KeySpec
+	}{
+		{"2", KeySpec{StartField: 2}},
+		{"2,3", KeySpec{StartField: 2, EndField: 3}},
+		{"2.3,2.5", KeySpec{StartField: 2, StartChar: 3, EndField: 2, EndChar: 5}},
+		{"1.2b,3", KeySpec{StartField: 1, StartChar: 2, EndField: 3, SkipStartBlanks: true}},
+	}
+	for _, c := range cases {
+		got, err := ParseKeySpec(c.spec)
+		if err != nil {
+			t.Errorf("-k %s: %v", c.spec, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("-k %s = %+v, want %+v", c.spec, got, c.want)
+		}
+	}
+	for _, spec := range []string{"", "x", "2.", "2,x"} {
+		if _, err := ParseKeySpec(spec); err == nil {
+			t.Errorf("-k %q: no error", spec)
+		}
+	}
+}
+
+// TestKeyModifierLetters checks that every ordering letter is a per-key
+// modifier at either position and that an unknown letter is named in the error.
+func TestKeyModifierLetters(t *testing.T) {
+	cases := []struct {
+		spec string
+		want KeySpec
+	}{
+		{"2n", KeySpec{StartField: 2, Numeric: true}},
+		{"2,2g", KeySpec{StartField: 2, EndField: 2, GeneralNumeric: true}},
+		{"2h", KeySpec{StartField: 2, Human: true}},
+		{"2M", KeySpec{StartField: 2, Month: true}},
+		{"2V", KeySpec{StartField: 2, Version: true}},
+		{"2R", KeySpec{StartField: 2, Random: true}},
+		{"2r", KeySpec{StartField: 2, Reverse: true}},
+		{"2f", KeySpec{StartField: 2, FoldCase: true}},
+		{"2d", KeySpec{StartField: 2, Dictionary: true}},
+		{"2i", KeySpec{StartField: 2, IgnoreNonprinting: true}},
+		{"2l", KeySpec{StartField: 2, Lexical: true}},
+		{"2bn,3r", KeySpec{StartField: 2, EndField: 3, SkipStartBlanks: true, Numeric: true, Reverse: true}},
+	}
+	for _, c := range cases {
+		got, err := ParseKeySpec(c.spec)
+		if err != nil {
+			t.Errorf("-k %s: %v", c.spec, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("-k %s = %+v, want %+v", c.spec, got, c.want)
+		}
+	}
+	for _, c := range []struct{ spec, letter string }{{"2q", "'q'"}, {"2,3z", "'z'"}, {"2nX", "'X'"}} {
+		_, err := ParseKeySpec(c.spec)
+		if err == nil || !strings.Contains(err.Error(), c.letter) {
+			t.Errorf("-k %s: err = %v, want it to name %s", c.spec, err, c.letter)
+		}
+	}
+}
+
+// TestKeyChainModes sorts by -k2,2h -k1,1V: sizes first, versions among equal
+// sizes.
+func TestKeyChainModes(t *testing.T) {
+	keys := make([]KeySpec, 2)
+	for i, spec := range []string{"2,2h", "1,1V"} {
+		k, err := ParseKeySpec(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = k
+	}
+	input := []string{"v1.10\t2K", "v1.9\t2K", "v2\t512", "v1.2\t1M", "v1.1\t5K"}
+	want := []string{"v2\t512", "v1.9\t2K", "v1.10\t2K", "v1.1\t5K", "v1.2\t1M"}
+	if got := SortInMemory(slices.Clone(input), SortOptions{Keys: keys}); !slices.Equal(got, want) {
+		t.Errorf("-k2,2h -k1,1V: got %q, want %q", got, want)
+	}
+}
+
+// TestKeyDefaultNumeric checks global -n and --key-default-numeric over
+// several keys: the l modifier turns a key back to text, and a key that
+// selects its own mode keeps it.
+func TestKeyDefaultNumeric(t *testing.T) {
+	parse := func(specs ...string) []KeySpec {
+		keys := make([]KeySpec, len(specs))
+		for i, spec := range specs {
+			k, err := ParseKeySpec(spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys[i] = k
+		}
+		return keys
+	}
+	input := []string{"r 10 2", "r 9 10", "r 10 10", "r 9 9"}
+	cases := []struct {
+		name  string
+		opts  SortOptions
+		modes []Mode
+		want  []string
+	}{
+		{"global -n", SortOptions{Numeric: true, Keys: parse("2,2", "3,3")},
+			[]Mode{ModeNumeric, ModeNumeric}, []string{"r 9 9", "r 9 10", "r 10 2", "r 10 10"}},
+		{"global -n, text key", SortOptions{Numeric: true, Keys: parse("2,2", "3,3l")},
+			[]Mode{ModeNumeric, ModeText}, []string{"r 9 10", "r 9 9", "r 10 10", "r 10 2"}},
+		// Модификатор r отменяет наследование -n, но не --key-default-numeric
+		{"default numeric", SortOptions{KeyDefaultNumeric: true, Keys: parse("2,2r", "3,3")},
+			[]Mode{ModeNumeric, ModeNumeric}, []string{"r 10 2", "r 10 10", "r 9 9", "r 9 10"}},
+		{"default numeric, text key", SortOptions{KeyDefaultNumeric: true, Keys: parse("2,2b", "3,3l")},
+			[]Mode{ModeNumeric, ModeText}, []string{"r 9 10", "r 9 9", "r 10 10", "r 10 2"}},
+		{"default numeric, own modes", SortOptions{KeyDefaultNumeric: true, Keys: parse("2,2h", "3,3M")},
+			[]Mode{ModeHuman, ModeMonth}, []string{"r 9 10", "r 9 9", "r 10 10", "r 10 2"}},
+	}
+	for _, c := range cases {
+		for i, k := range newComparator(c.opts).keys {
+			if k.mode() != c.modes[i] {
+				t.Errorf("%s: key %d has mode %s, want %s", c.name, i+1, k.mode(), c.modes[i])
+			}
+		}
+		if got := SortInMemory(slices.Clone(input), c.opts); !slices.Equal(got, c.want) {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// TestKeyBlankModifier checks where the b modifier applies: to the start
+// position, to the end position or to both, and that global -b trims both
+// ends of every key.
+func TestKeyBlankModifier(t *testing.T) {
+	parse := []struct {
+		spec       string
+		start, end bool
+	}{
+		{"2", false, false},
+		{"2b", true, false},
+		{"2,2b", false, true},
+		{"2b,2b", true, true},
+		{"2.2b,3.1b", true, true},
+	}
+	for _, c := range parse {
+		k, err := ParseKeySpec(c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k.SkipStartBlanks != c.start || k.SkipEndBlanks != c.end {
+			t.Errorf("-k %s: b at start %v, at end %v, want %v, %v", c.spec, k.SkipStartBlanks, k.SkipEndBlanks, c.start, c.end)
+		}
+	}
+
+	keys := []struct {
+		line, spec string
+		opts       SortOptions
+		want       string
+	}{
+		{"x,  ab ", "2,2", SortOptions{}, "  ab "},
+		{"x,  ab ", "2b,2", SortOptions{}, "ab "},
+		{"x,  ab ", "2,2b", SortOptions{}, "  ab "},
+		{"x,  ab ", "2.1,2.1", SortOptions{}, " "},
+		{"x,  ab ", "2.1,2.1b", SortOptions{}, "  a"},
+		{"x,  ab ", "2.1b,2.1b", SortOptions{}, "a"},
+		{"x,  ab ", "2.2b,2.2b", SortOptions{}, "b"},
+		{"x,  ab ", "2,2", SortOptions{IgnoreBlanks: true}, "ab"},
+	}
+	for _, c := range keys {
+		c.opts.Separator = ","
+		if got := extractKey(t, c.line, c.spec, c.opts); got != c.want {
+			t.Errorf("-t, -k %s (-b %v) of %q = %q, want %q", c.spec, c.opts.IgnoreBlanks, c.line, got, c.want)
+		}
+	}
+
+	// Ведущие пробелы меняют порядок дополненных ключей, только пока их не пропускает b
+	input := []string{"x,  b", "y, a", "z,c"}
+	order := []struct {
+		spec string
+		opts SortOptions
+		want []string
+	}{
+		{"2,2", SortOptions{}, []string{"x,  b", "y, a", "z,c"}},
+		{"2b,2", SortOptions{}, []string{"y, a", "x,  b", "z,c"}},
+		{"2,2b", SortOptions{}, []string{"x,  b", "y, a", "z,c"}},
+		{"2,2", SortOptions{IgnoreBlanks: true}, []string{"y, a", "x,  b", "z,c"}},
+	}
+	for _, c := range order {
+		k, err := ParseKeySpec(c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.opts.Keys = []KeySpec{k}
+		c.opts.Separator = ","
+		if got := SortInMemory(slices.Clone(input), c.opts); !slices.Equal(got, c.want) {
+			t.Errorf("-t, -k %s (-b %v): got %q, want %q", c.spec, c.opts.IgnoreBlanks, strings.Join(got, "|"), strings.Join(c.want, "|"))
+		}
+	}
+}
+
+// TestStripChars trims the --strip-chars set from both ends of the key only:
+// characters inside the key and the emitted line stay intact.
+func TestStripChars(t *testing.T) {
+	cases := []struct {
+		line, spec, set string
+		want            string
+	}{
+		{"x\t\"key\"", "2", `"`, "key"},
+		{"x\t[key]", "2", "[]", "key"},
+		{"x\t[\"a[b]\"]", "2", `"[]`, "a[b"},
+		{"\"whole line\"", "1", `"`, "whole line"},
+		{"x\t\"key\"", "2", "", "\"key\""},
+		{"x\t\"\"", "2", `"`, ""},
+		{"x\t\"key\"", "1", `"`, "x\t\"key"},
+	}
+	for _, c := range cases {
+		got := extractKey(t, c.line, c.spec, SortOptions{StripChars: c.set, Separator: "\t"})
+		if got != c.want {
+			t.Errorf("-k %s --strip-chars=%q on %q = %q, want %q", c.spec, c.set, c.line, got, c.want)
+		}
+	}
+
+	input := []string{"3\t\"b\"", "1\t[c]", "2\ta", "4\t'd'"}
+	opts := SortOptions{Keys: []KeySpec{{StartField: 2}}, StripChars: `"[]'`, Separator: "\t"}
+	want := []string{"2\ta", "3\t\"b\"", "1\t[c]", "4\t'd'"}
+	if got := SortInMemory(slices.Clone(input), opts); !slices.Equal(got, want) {
+		t.Errorf("--strip-chars sort: got %q, want %q", got, want)
+	}
+}
+
+func TestRegexKey(t *testing.T) {
+	cases := []struct {
+		line, regex, want string
+	}{
+		{"request took 123ms", `took (\d+)ms`, "123"},
+		{"request took 123ms", `\d+`, "123"},
+		{"request failed", `took (\d+)ms`, ""},
+		{"a=1 b=2", `b=(\d)`, "2"},
+		{"x", `(y)?x`, ""},
+	}
+	for _, c := range cases {
+		if got := regexKey(c.line, regexp.MustCompile(c.regex)); got != c.want {
+			t.Errorf("regexKey(%q, %q) = %q, want %q", c.line, c.regex, got, c.want)
+		}
+	}
+}
+
+// TestKeyRegexModes sorts by a number captured from unstructured lines, with
+// the ordering options applied to the match and unmatched lines first.
+func TestKeyRegexModes(t *testing.T) {
+	cases := []struct {
+		name  string
+		regex string
+		opts  SortOptions
+		input string
+		want  string
+	}{
+		{"numeric", `took ([0-9.]+)`, SortOptions{Numeric: true},
+			"took 12.5s\ntook 9s\nfailed\ntook 100s\n",
+			"failed\ntook 9s\ntook 12.5s\ntook 100s\n"},
+		{"numeric reverse", `took ([0-9.]+)`, SortOptions{Numeric: true, Reverse: true},
+			"took 12.5s\ntook 9s\ntook 100s\n",
+			"took 100s\ntook 12.5s\ntook 9s\n"},
+		{"human", `size=(\S+)`, SortOptions{Human: true},
+			"x size=1M\ny size=512K\nz size=2G\n",
+			"y size=512K\nx size=1M\nz size=2G\n"},
+		{"duration", `took (\S+)`, SortOptions{Duration: true},
+			"b took 1.2s\na took 900ms\nc took 1m\nd took 1500ms\nno match\n",
+			"no match\na took 900ms\nb took 1.2s\nd took 1500ms\nc took 1m\n"},
+		{"duration reverse", `took (\S+)`, SortOptions{Duration: true, Reverse: true},
+			"b took 1.2s\na took 900ms\nc took 1m\n",
+			"c took 1m\nb took 1.2s\na took 900ms\n"},
+		{"general numeric", `v=(\S+)`, SortOptions{GeneralNumeric: true},
+			"a v=1e3\nb v=5\nc v=-inf\nd v=2.5e-1\n",
+			"c v=-inf\nd v=2.5e-1\nb v=5\na v=1e3\n"},
+		{"text", `user=(\w+)`, SortOptions{},
+			"3 user=bob\n1 user=carol\n2 user=alice\n",
+			"2 user=alice\n3 user=bob\n1 user=carol\n"},
+	}
+	for _, c := range cases {
+		c.opts.KeyRegex = regexp.MustCompile(c.regex)
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// TestBlankFieldModel checks that without -t any run of spaces and tabs
+// separates fields and the leading blanks belong to the field.
+func TestBlankFieldModel(t *testing.T) {
+	cases := []struct {
+		line, spec string
+		want       string
+	}{
+		{"a b c", "2,2", " b"},
+		{"a\tb c", "2,2", "\tb"},
+		{"a \t b\t \tc", "2,2", " \t b"},
+		{"a \t b\t \tc", "3,3", "\t \tc"},
+		{"a \t b\t \tc", "2b,2", "b"},
+		{"  a b", "1,1", "  a"},
+		{"  a b", "2", " b"},
+		{"a \t b", "3", ""},
+		{"a b ", "2,2", " b"},
+		{"a b ", "3,3", " "},
+	}
+	for _, c := range cases {
+		if got := extractKey(t, c.line, c.spec, SortOptions{}); got != c.want {
+			t.Errorf("-k %s of %q = %q, want %q", c.spec, c.line, got, c.want)
+		}
+	}
+
+	// Смешанные пробелы и табуляции между полями: -k2n берёт одно и то же поле
+	input := []string{"c \t 10 z", "a\t2\ty", "b  \t1 x", "d 3\t\tw"}
+	want := []string{"b  \t1 x", "a\t2\ty", "d 3\t\tw", "c \t 10 z"}
+	key, err := ParseKeySpec("2,2n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := SortInMemory(slices.Clone(input), SortOptions{Keys: []KeySpec{key}}); !slices.Equal(got, want) {
+		t.Errorf("-k2,2n: got %q, want %q", got, want)
+	}
+}
+
+func TestSeparatorFields(t *testing.T) {
+	cases := []struct {
+		line, spec, sep string
+		want            string
+	}{
+		{"a:b:c", "2,2", ":", "b"},
+		{"a:b:c", "2", ":", "b:c"},
+		{"a::c", "2,2", ":", ""},
+		{"a::c", "3,3", ":", "c"},
+		{"a b:c d", "2,2", ":", "c d"},
+		{"a<>b<>c", "3,3", "<>", "c"},
+		{"a:b", "3,3", ":", ""},
+	}
+	for _, c := range cases {
+		if got := extractKey(t, c.line, c.spec, SortOptions{Separator: c.sep}); got != c.want {
+			t.Errorf("-t %q -k %s of %q = %q, want %q", c.sep, c.spec, c.line, got, c.want)
+		}
+	}
+
+	split := []struct {
+		line, sep string
+		want      []string
+	}{
+		{"a b\tc", "", []string{"a", " b", "\tc"}},
+		{"  a  b", "", []string{"  a", "  b"}},
+		{"", "", nil},
+		{"a:b::c", ":", []string{"a", "b", "", "c"}},
+	}
+	for _, c := range split {
+		if got := splitFields(c.line, c.sep); !slices.Equal(got, c.want) {
+			t.Errorf("splitFields(%q, %q) = %q, want %q", c.line, c.sep, got, c.want)
+		}
+	}
+}
+
+// TestKeyOffsetsBytesAndRunes checks .C positions in multibyte fields: by
+// default they count bytes, with --runes they count runes, and in both modes
+// they stay within the field.
+func TestKeyOffsetsBytesAndRunes(t *testing.T) {
+	cases := []struct {
+		line, spec string
+		bytes      string
+		runes      string
+	}{
+		{"aéb", "1.2,1.3", "é", "éb"},
+		{"aéb", "1.3,1.3", "\xa9", "b"},
+		{"x éèb", "2.2,2.2", "\xc3", "é"}, // без -b пробел перед полем входит в него
+		{"x éè y", "2.3,2.9", "\xa9è", "è"},
+		{"x éè y", "2.9,3", " y", " y"},
+		{"日本語 z", "1.4,1.6", "本", ""},
+	}
+	for _, c := range cases {
+		if got := extractKey(t, c.line, c.spec, SortOptions{}); got != c.bytes {
+			t.Errorf("-k %s of %q = %q, want %q", c.spec, c.line, got, c.bytes)
+		}
+		if got := extractKey(t, c.line, c.spec, SortOptions{Runes: true}); got != c.runes {
+			t.Errorf("--runes -k %s of %q = %q, want %q", c.spec, c.line, got, c.runes)
+		}
+	}
+}
+
+// TestHumanKeyColumn sorts by the human-readable size in the second column:
+// the size is read from the extracted field, not the whole line, and lines
+// with equal sizes fall back to the whole line unless -s is given.
+func TestHumanKeyColumn(t *testing.T) {
+	input := "f 10K\nb 1K\nz 2M\na 1K\nc 512\n"
+	key, err := ParseKeySpec("2h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		opts SortOptions
+		want string
+	}{
+		{"ascending", SortOptions{}, "c 512\na 1K\nb 1K\nf 10K\nz 2M\n"},
+		// Как в GNU, глобальный -r не действует на ключ со своим режимом h,
+		// но переворачивает последнее сравнение целых строк
+		{"global reverse", SortOptions{Reverse: true}, "c 512\nb 1K\na 1K\nf 10K\nz 2M\n"},
+		{"stable", SortOptions{Stable: true}, "c 512\nb 1K\na 1K\nf 10K\nz 2M\n"},
+		{"unique", SortOptions{Unique: true}, "c 512\na 1K\nf 10K\nz 2M\n"},
+	}
+	for _, c := range cases {
+		c.opts.Keys = []KeySpec{key}
+		if got := sortText(t, input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	reverse, err := ParseKeySpec("2hr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := sortText(t, input, SortOptions{Keys: []KeySpec{reverse}})
+	if want := "z 2M\nf 10K\na 1K\nb 1K\nc 512\n"; got != want {
+		t.Errorf("-k2hr: got %q, want %q", got, want)
+	}
+}
+
+// TestKeyOffsetsClamp checks that character offsets never leave their field,
+// counted in bytes and in runes, that an end offset of 0 means the end of the
+// field, and that offsets too large for an int are rejected.
+func TestKeyOffsetsClamp(t *testing.T) {
+	cases := []struct {
+		line, spec string
+		bytes      string
+		runes      string
+	}{
+		{"ab cd", "1.5,1.9", "", ""},           // начало за концом поля — пустой ключ
+		{"ab cd", "1.2,2.100", "b cd", "b cd"}, // конец ограничен полем 2
+		{"ab cd", "1.2,2.0", "b cd", "b cd"},   // .0 — до конца поля
+		{"ab cd ef", "2,2.0", " cd", " cd"},
+		{"aé cd", "1.3,1.3", "\xa9", ""},
+		{"x 日本", "2.3,2.3", "\x97", "本"},
+		{"x 日本", "2.4,2.9", "\xa5本", ""},
+		{"x 日本 z", "2.2,3.1", "日本 ", "日本 "},
+	}
+	for _, c := range cases {
+		if got := extractKey(t, c.line, c.spec, SortOptions{}); got != c.bytes {
+			t.Errorf("-k %s of %q = %q, want %q", c.spec, c.line, got, c.bytes)
+		}
+		if got := extractKey(t, c.line, c.spec, SortOptions{Runes: true}); got != c.runes {
+			t.Errorf("--runes -k %s of %q = %q, want %q", c.spec, c.line, got, c.runes)
+		}
+	}
+
+	overflow := []struct{ spec, want string }{
+		{"99999999999999999999", "invalid field number"},
+		{"1.99999999999999999999", "invalid character offset"},
+		{"1,1.99999999999999999999", "invalid character offset"},
+		{"1,99999999999999999999", "invalid field number"},
+	}
+	for _, c := range overflow {
+		_, err := ParseKeySpec(c.spec)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("-k %s: err = %v, want %q", c.spec, err, c.want)
+		}
+	}
+}
+
+// TestParseKeySpecNonPositive rejects zero and negative field numbers and a
+// zero start character instead of silently keying on the whole line.
+func TestParseKeySpecNonPositive(t *testing.T) {
+	cases := []struct {
+		spec string
+		want string // подстрока ошибки; пусто — ключ допустим
+	}{
+		{"0", "field number must be positive, got 0"},
+		{"-1", "field number must be positive, got -1"},
+		{"0,1", "field number must be positive, got 0"},
+		{"1,0", "field number must be positive, got 0"},
+		{"2,-3", "field number must be positive, got -3"},
+		{"1.0", "character offset is zero"},
+		{"1.0n,2", "character offset is zero"},
+		{"1", ""},
+		{"1,1.0", ""}, // .0 в конце ключа означает конец поля
+	}
+	for _, c := range cases {
+		_, err := ParseKeySpec(c.spec)
+		switch {
+		case c.want == "" && err != nil:
+			t.Errorf("-k %s: %v", c.spec, err)
+		case c.want != "" && (err == nil || !strings.Contains(err.Error(), c.want)):
+			t.Errorf("-k %s: err = %v, want %q", c.spec, err, c.want)
+		}
+	}
+}
+
+// TestSeparatorAtLineEdges checks -t fields around separators at the start and
+// the end of a line and between adjacent separators.
+func TestSeparatorAtLineEdges(t *testing.T) {
+	cases := []struct {
+		line, spec, want string
+	}{
+		{":a", "1,1", ""},
+		{":a", "2,2", "a"},
+		{":a", "3,3", ""},
+		{"a:", "1,1", "a"},
+		{"a:", "2,2", ""},
+		{"a:", "2", ""},
+		{"a::b", "2,2", ""},
+		{"a::b", "3,3", "b"},
+		{"a::b", "2", ":b"},
+		{"a::b", "1.2,3", "::b"}, // начало за концом поля 1 — конец этого поля, как в GNU
+		{"::", "3,3", ""},
+	}
+	for _, c := range cases {
+		if got := extractKey(t, c.line, c.spec, SortOptions{Separator: ":"}); got != c.want {
+			t.Errorf("-t: -k %s of %q = %q, want %q", c.spec, c.line, got, c.want)
+		}
+	}
+
+	// Пустая колонка сравнивается как пустая строка и идёт первой
+	got := sortText(t, "a:c\n:b\na:\na::b\n", SortOptions{Separator: ":", Keys: []KeySpec{{StartField: 2, EndField: 2}}})
+	if want := "a:\na::b\n:b\na:c\n"; got != want {
+		t.Errorf("sort -t: -k2,2 = %q, want %q", got, want)
+	}
+}
+
+// TestTrimTrailingSeparator checks that with --trim-trailing-separator a line
+// with one trailing separator gives the same keys as the line without it.
+func TestTrimTrailingSeparator(t *testing.T) {
+	cases := []struct {
+		line, spec string
+		opts       SortOptions
+		plain      string // ключ без --trim-trailing-separator
+		trimmed    string
+	}{
+		{"a,b,c", "3", SortOptions{Separator: ","}, "c", "c"},
+		{"a,b,c,", "3", SortOptions{Separator: ","}, "c,", "c"},
+		{"a,b,c,,", "3", SortOptions{Separator: ","}, "c,,", "c,"},
+		{"a,b,", "2", SortOptions{Separator: ","}, "b,", "b"},
+		{"a,b,", "3", SortOptions{Separator: ","}, "", ""},
+		{"a::b::", "2", SortOptions{Separator: "::"}, "b::", "b"},
+		{"a b", "2", SortOptions{}, " b", " b"},
+		{"a b \t ", "2", SortOptions{}, " b \t ", " b"},
+		{"a,b,", "2", SortOptions{CSV: true}, "b,", "b"},
+		{"a,b,", "2,2", SortOptions{CSV: true}, "b", "b"},
+		{`a,"b,",`, "2", SortOptions{CSV: true}, "b,,", "b,"},
+	}
+	for _, c := range cases {
+		if got := extractKey(t, c.line, c.spec, c.opts); got != c.plain {
+			t.Errorf("-k %s of %q = %q, want %q", c.spec, c.line, got, c.plain)
+		}
+		c.opts.TrimTrailingSep = true
+		if got := extractKey(t, c.line, c.spec, c.opts); got != c.trimmed {
+			t.Errorf("--trim-trailing-separator -k %s of %q = %q, want %q", c.spec, c.line, got, c.trimmed)
+		}
+	}
+}
+
+// TestKeySeparator checks the t modifier: the key splits the line by its own
+// separator, other keys keep -t, and a t without a separator is an error.
+func TestKeySeparator(t *testing.T) {
+	cases := []struct {
+		spec string
+		want KeySpec
+		err  string
+	}{
+		{"3,3nt:", KeySpec{StartField: 3, EndField: 3, Numeric: true, Separator: ":"}, ""},
+		{"2t§", KeySpec{StartField: 2, Separator: "§"}, ""},
+		{"2t:r", KeySpec{StartField: 2, Separator: ":", Reverse: true}, ""},
+		{"2tt", KeySpec{StartField: 2, Separator: "t"}, ""},
+		{"2t", KeySpec{}, "modifier 't' needs a separator"},
+		{"2,2t", KeySpec{}, "modifier 't' needs a separator"},
+	}
+	for _, c := range cases {
+		got, err := ParseKeySpec(c.spec)
+		switch {
+		case c.err != "":
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("-k %s: err = %v, want %q", c.spec, err, c.err)
+			}
+		case err != nil:
+			t.Errorf("-k %s: %v", c.spec, err)
+		case !reflect.DeepEqual(got, c.want):
+			t.Errorf("-k %s = %+v, want %+v", c.spec, got, c.want)
+		}
+	}
+
+	if got := extractKey(t, "a\tb:2:x", "2.1,2.1t:", SortOptions{Separator: "\t"}); got != "2" {
+		t.Errorf("-t '\\t' -k 2,2t: of %q = %q, want %q", "a\tb:2:x", got, "2")
+	}
+
+	// Первая колонка — по табуляции, при равенстве второе поле по двоеточию
+	first, err := ParseKeySpec("1,1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ParseKeySpec("2,2nt:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := "b\tx:1\na\tx:10\na\ty:9\n"
+	got := sortText(t, input, SortOptions{Separator: "\t", Keys: []KeySpec{first, second}})
+	if want := "a\ty:9\na\tx:10\nb\tx:1\n"; got != want {
+		t.Errorf("sort -t '\\t' -k 1,1 -k 2,2nt: = %q, want %q", got, want)
+	}
+}
+
+// TestFixedWidthColumns checks --columns: fields are cut by width, the rest of
+// the line after the last column is one more field, and a short line simply
+// lacks the fields it does not reach.
+func TestFixedWidthColumns(t *testing.T) {
+	cases := []struct {
+		widths     []int
+		runes      bool
+		line, spec string
+		want       string
+	}{
+		{[]int{3, 2}, false, "abcdeXYZ", "1,1", "abc"},
+		{[]int{3, 2}, false, "abcdeXYZ", "2,2", "de"},
+		{[]int{3, 2}, false, "abcdeXYZ", "3,3", "XYZ"},
+		{[]int{3, 2}, false, "abcdeXYZ", "4,4", ""},
+		{[]int{3, 2}, false, "abcdeXYZ", "2", "deXYZ"},
+		{[]int{3, 2}, false, "abcdeXYZ", "2.2,2.2", "e"},
+		{[]int{3, 2}, false, "ab", "1,1", "ab"},
+		{[]int{3, 2}, false, "ab", "2,2", ""},
+		{[]int{3, 2}, false, "", "1,1", ""},
+		{[]int{3, 2}, false, "a b c", "2,2", " c"}, // пробелы не разделяют колонки
+		{[]int{4, 2}, false, "éèab", "2,2", "ab"},
+		{[]int{4, 2}, false, "éèxab", "1,1", "éè"},
+		{[]int{3, 2}, true, "éèxab", "1,1", "éèx"},
+		{[]int{3, 2}, true, "éèxab", "2,2", "ab"},
+	}
+	for _, c := range cases {
+		opts := SortOptions{Columns: c.widths, Runes: c.runes}
+		if got := extractKey(t, c.line, c.spec, opts); got != c.want {
+			t.Errorf("--columns %v (--runes %v) -k %s of %q = %q, want %q", c.widths, c.runes, c.spec, c.line, got, c.want)
+		}
+	}
+
+	// Отчёт с колонками 10,5,8: сортировка по второй колонке как по числу
+	input := "" +
+		"alice     00042london  \n" +
+		"bob       00007paris   \n" +
+		"carol     00100berlin  \n"
+	k, err := ParseKeySpec("2,2n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := sortText(t, input, SortOptions{Columns: []int{10, 5, 8}, Keys: []KeySpec{k}})
+	want := "" +
+		"bob       00007paris   \n" +
+		"alice     00042london  \n" +
+		"carol     00100berlin  \n"
+	if got != want {
+		t.Errorf("sort --columns 10,5,8 -k 2,2n = %q, want %q", got, want)
+	}
+}
+
+// TestIgnoreComment checks that a trailing comment takes no part in the keys:
+// lines are ordered by what precedes the mark, and the output keeps it.
+func TestIgnoreComment(t *testing.T) {
+	cases := []struct {
+		line, want string
+	}{
+		{"a 1  # note", "a 1"},
+		{"a 1#note", "a 1"},
+		{"# only a comment", ""},
+		{"no comment", "no comment"},
+		{"a # one # two", "a"},
+	}
+	for _, c := range cases {
+		if got := stripComment(c.line, "#"); got != c.want {
+			t.Errorf("stripComment(%q) = %q, want %q", c.line, got, c.want)
+		}
+	}
+
+	sorts := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		// Без комментариев строки равны, и -s оставляет их в порядке ввода
+		{"whole line", "b # 1\na # 3\nb # 0\n", SortOptions{IgnoreComment: "#", Stable: true}, "a # 3\nb # 1\nb # 0\n"},
+		{"numeric key", "x 10 # 1\ny 9 # 2\n", SortOptions{IgnoreComment: "#", Keys: []KeySpec{{StartField: 2, Numeric: true}}}, "y 9 # 2\nx 10 # 1\n"},
+		{"comment is not a field", "a ; 2\nb\n", SortOptions{IgnoreComment: ";", Keys: []KeySpec{{StartField: 2, EndField: 2}}}, "a ; 2\nb\n"},
+		{"unique", "a # x\na # y\nb\n", SortOptions{IgnoreComment: "#", Unique: true, Stable: true}, "a # x\nb\n"},
+		{"multi-byte mark", "b§a\na§b\n", SortOptions{IgnoreComment: "§"}, "a§b\nb§a\n"},
+	}
+	for _, c := range sorts {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// TestKeyFromByte checks that --key-from-byte takes the rest of the line from
+// byte N as the key, regardless of fields, and that lines shorter than N have
+// an empty key.
+func TestKeyFromByte(t *testing.T) {
+	cases := []struct {
+		line string
+		n    int
+		want string
+	}{
+		{"2024-01-02 b", 12, "b"},
+		{"abc", 1, "abc"},
+		{"abc", 3, "c"},
+		{"abc", 4, ""},
+		{"abc", 10, ""},
+		{"", 1, ""},
+		{"a b\tc", 2, " b\tc"},
+	}
+	for _, c := range cases {
+		k := newComparator(SortOptions{KeyFromByte: c.n}).keys[0]
+		if got := k.extract(c.line); got != c.want {
+			t.Errorf("extract(%q) from byte %d = %q, want %q", c.line, c.n, got, c.want)
+		}
+	}
+
+	sorts := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"suffix", "10:00 z\n09:00 a\n11:00 m\n", SortOptions{KeyFromByte: 7}, "09:00 a\n11:00 m\n10:00 z\n"},
+		// Короткие строки получают пустой ключ и идут первыми
+		{"short lines", "10:00 z\nab\n09:00 a\n", SortOptions{KeyFromByte: 7}, "ab\n09:00 a\n10:00 z\n"},
+		{"numeric", "id=10\nid=9\nid=100\n", SortOptions{KeyFromByte: 4, Numeric: true}, "id=9\nid=10\nid=100\n"},
+		{"reverse", "x a\ny b\n", SortOptions{KeyFromByte: 3, Reverse: true}, "y b\nx a\n"},
+		{"replaces -k", "1 b\n2 a\n", SortOptions{KeyFromByte: 3, Keys: []KeySpec{{StartField: 1, EndField: 1}}}, "2 a\n1 b\n"},
+		{"unique", "1 a\n2 a\n3 b\n", SortOptions{KeyFromByte: 3, Unique: true, Stable: true}, "1 a\n3 b\n"},
+	}
+	for _, c := range sorts {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+	if opts := (SortOptions{KeyFromByte: 1}); newComparator(opts).plainBytes(opts) {
+		t.Error("--key-from-byte compares plain bytes")
+	}
+}
+
+// TestUnquote checks that --unquote strips a matching pair of quotes and
+// unescapes the quotes inside, and that "10" then sorts numerically after "9".
+func TestUnquote(t *testing.T) {
+	cases := []struct {
+		key, quote, want string
+	}{
+		{`"10"`, `"`, "10"},
+		{` "a b" `, `"`, "a b"},
+		{`"say ""hi"""`, `"`, `say "hi"`},
+		{`"say \"hi\""`, `"`, `say "hi"`},
+		{`""`, `"`, ""},
+		{`"`, `"`, `"`},
+		{`"open`, `"`, `"open`},
+		{`plain`, `"`, "plain"},
+		{`'x'`, `"`, `'x'`},
+		{`'it''s'`, `'`, "it's"},
+		{`«a»`, `«`, `«a»`}, // пара — одна и та же кавычка с обеих сторон
+	}
+	for _, c := range cases {
+		if got := unquote(c.key, c.quote); got != c.want {
+			t.Errorf("unquote(%q, %q) = %q, want %q", c.key, c.quote, got, c.want)
+		}
+	}
+
+	sorts := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"numeric", "\"10\"\n\"9\"\n\"100\"\n", SortOptions{Unquote: `"`, Numeric: true}, "\"9\"\n\"10\"\n\"100\"\n"},
+		{"numeric without", "\"10\"\n\"9\"\n", SortOptions{Numeric: true}, "\"10\"\n\"9\"\n"},
+		{"field", "b\t\"2\"\na\t\"10\"\n", SortOptions{Unquote: `"`, Separator: "\t", Keys: []KeySpec{{StartField: 2, EndField: 2, Numeric: true}}},
+			"b\t\"2\"\na\t\"10\"\n"},
+		{"mixed quoting", "\"b\"\na\n\"c\"\n", SortOptions{Unquote: `"`}, "a\n\"b\"\n\"c\"\n"},
+		{"unique", "\"a\"\na\n", SortOptions{Unquote: `"`, Unique: true}, "\"a\"\n"},
+	}
+	for _, c := range sorts {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+	if opts := (SortOptions{Unquote: `"`}); newComparator(opts).plainBytes(opts) {
+		t.Error("--unquote compares plain bytes")
+	}
+}
+
+// TestReverseKey checks that --reverse-key reverses keys by labels or runes
+// and that hostnames of one domain sort together.
+func TestReverseKey(t *testing.T) {
+	cases := []struct {
+		key, by, want string
+	}{
+		{"a.example.com", ReverseDots, "com.example.a"},
+		{"example.com.", ReverseDots, ".com.example"},
+		{"localhost", ReverseDots, "localhost"},
+		{"", ReverseDots, ""},
+		{"x.gif", ReverseChars, "fig.x"},
+		{"café", ReverseChars, "éfac"},
+	}
+	for _, c := range cases {
+		if got := reverseKey(c.key, c.by); got != c.want {
+			t.Errorf("reverseKey(%q, %q) = %q, want %q", c.key, c.by, got, c.want)
+		}
+	}
+
+	hosts := "c.example.org\nb.example.com\nexample.com\nwww.other.org\na.example.com\n"
+	sorts := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"dots", hosts, SortOptions{ReverseKey: ReverseDots},
+			"example.com\na.example.com\nb.example.com\nc.example.org\nwww.other.org\n"},
+		{"dots reverse", hosts, SortOptions{ReverseKey: ReverseDots, Reverse: true},
+			"www.other.org\nc.example.org\nb.example.com\na.example.com\nexample.com\n"},
+		{"chars", "x.gif\ny.png\nz.gif\n", SortOptions{ReverseKey: ReverseChars}, "x.gif\nz.gif\ny.png\n"},
+		{"field", "2 b.example.org\n1 a.example.com\n", SortOptions{ReverseKey: ReverseDots, Keys: []KeySpec{{StartField: 2, EndField: 2}}},
+			"1 a.example.com\n2 b.example.org\n"},
+	}
+	for _, c := range sorts {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+	if opts := (SortOptions{ReverseKey: ReverseChars}); newComparator(opts).plainBytes(opts) {
+		t.Error("--reverse-key compares plain bytes")
+	}
+}
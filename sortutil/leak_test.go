@@ -0,0 +1,152 @@
+package sortutil
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// tempLeakDir returns a new directory for the temporary files of a test and
+// fails the test at its end if any sort-* or merge-* file is left in it, or
+// if the directory holds anything that was not there before.
+func tempLeakDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	before := dirEntries(t, dir)
+	t.Cleanup(func() {
+		after := dirEntries(t, dir)
+		for _, name := range after {
+			if strings.HasPrefix(name, "sort-") || strings.HasPrefix(name, "merge-") || !slices.Contains(before, name) {
+				t.Errorf("temporary file %s left behind", name)
+			}
+		}
+	})
+	return dir
+}
+
+// dirEntries returns the names of the files in dir.
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names
+}
+
+// TestExternalSortLeavesNoTempFiles runs the external sort to the end and into
+// every kind of failure: each run must remove all of its temporary files.
+func TestExternalSortLeavesNoTempFiles(t *testing.T) {
+	lines := numberedLines("line", 20000)
+	input := joinLines(lines)
+	errRead := errors.New("read failed")
+
+	cases := []struct {
+		name    string
+		opts    SortOptions
+		input   func() io.Reader
+		out     func() io.Writer
+		store   func(dir string) TempStore
+		wantErr bool
+	}{
+		{name: "normal", opts: SortOptions{BufferSize: 32 << 10}},
+		{name: "unique", opts: SortOptions{BufferSize: 32 << 10, Unique: true}},
+		{name: "multi-level", opts: SortOptions{BufferSize: 16 << 10, BatchSize: 2}},
+		{name: "parallel-merge", opts: SortOptions{BufferSize: 16 << 10, Parallel: 4, ParallelMerge: true}},
+		{
+			name:    "read error",
+			opts:    SortOptions{BufferSize: 16 << 10, BatchSize: 2},
+			input:   func() io.Reader { return &failingReader{data: strings.NewReader(input), err: errRead} },
+			wantErr: true,
+		},
+		{
+			name:    "disk full",
+			opts:    SortOptions{BufferSize: 16 << 10, BatchSize: 2},
+			store:   func(dir string) TempStore { return &fullStore{TempStore: newTempDirs([]string{dir}), limit: 200 << 10} },
+			wantErr: true,
+		},
+		{
+			name:    "line too long",
+			opts:    SortOptions{BufferSize: 16 << 10, MaxLineLength: 20},
+			input:   func() io.Reader { return strings.NewReader(input + strings.Repeat("x", 100) + "\n") },
+			wantErr: true,
+		},
+		{
+			// Прерванный вывод — как закрытый читатель конвейера
+			name:    "output closed",
+			opts:    SortOptions{BufferSize: 16 << 10, BatchSize: 2},
+			out:     func() io.Writer { return &failingWriter{n: 1000} },
+			wantErr: true,
+		},
+		{
+			name:    "output closed during parallel merge",
+			opts:    SortOptions{BufferSize: 16 << 10, Parallel: 4, ParallelMerge: true},
+			out:     func() io.Writer { return &failingWriter{n: 1000} },
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := tempLeakDir(t)
+			c.opts.TempDirs = []string{dir}
+			if c.store != nil {
+				c.opts.TempStore = c.store(dir)
+			}
+			var r io.Reader = strings.NewReader(input)
+			if c.input != nil {
+				r = c.input()
+			}
+			var w io.Writer = io.Discard
+			if c.out != nil {
+				w = c.out()
+			}
+			err := Sort(r, w, c.opts)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("Sort error = %v, want error %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+// TestCheckAndMergeLeaveNoTempFiles checks -m with more inputs than
+// --batch-size, also when the output or an input fails.
+func TestCheckAndMergeLeaveNoTempFiles(t *testing.T) {
+	dir := tempLeakDir(t)
+	sources := t.TempDir()
+	var paths []string
+	for i := range 5 {
+		path := filepath.Join(sources, string(rune('a'+i)))
+		lines := Sorted(numberedLines(string(rune('a'+i)), 1000), SortOptions{})
+		if err := os.WriteFile(path, []byte(joinLines(lines)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	opts := SortOptions{TempDirs: []string{dir}, BatchSize: 2}
+
+	// -m с большим числом входов, чем --batch-size, сливает группы через временные файлы
+	var merged bytes.Buffer
+	if err := MergeSorted(paths, &merged, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSorting(bufio.NewScanner(&merged), "merged", opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := MergeSorted(paths, &failingWriter{n: 100}, opts); err == nil {
+		t.Fatal("MergeSorted into a failing writer succeeded")
+	}
+	missing := append(slices.Clone(paths), filepath.Join(sources, "missing"))
+	if err := MergeSorted(missing, io.Discard, opts); err == nil {
+		t.Fatal("MergeSorted of a missing file succeeded")
+	}
+}
@@ -0,0 +1,105 @@
+package sortutil
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// LocaleFromEnv returns the collation locale chosen by the environment the way
+// GNU sort does: the first non-empty of LC_ALL, LC_COLLATE and LANG.
+func LocaleFromEnv() string {
+	for _, name := range []string{"LC_ALL", "LC_COLLATE", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// ValidateLocale reports whether name can be used as SortOptions.Locale.
+func ValidateLocale(name string) error {
+	if _, _, err := localeTag(name); err != nil {
+		return newError(KindInvalidOption, err)
+	}
+	return nil
+}
+
+// localeTag converts a POSIX locale name such as "de_DE.UTF-8@euro" into a
+// language tag. ok is false for C and POSIX, which compare bytes.
+func localeTag(name string) (tag language.Tag, ok bool, err error) {
+	// Кодировка и модификатор на порядок сравнения не влияют
+	base, _, _ := strings.Cut(name, "@")
+	base, _, _ = strings.Cut(base, ".")
+	if base == "" || base == "C" || base == "POSIX" {
+		return language.Und, false, nil
+	}
+	tag, err = language.Parse(strings.ReplaceAll(base, "_", "-"))
+	if err != nil {
+		return language.Und, false, fmt.Errorf("sort: unknown locale %q", name)
+	}
+	return tag, true, nil
+}
+
+// newTextComparer compares text keys byte by byte in the C locale
+// and by the collation rules of opts.Locale otherwise.
+func newTextComparer(opts SortOptions) KeyComparer {
+	tag, ok, err := localeTag(opts.Locale)
+	if err != nil || !ok {
+		return KeyComparerFunc(strings.Compare)
+	}
+	// Collator не потокобезопасен, но у каждого comparator он свой
+	return KeyComparerFunc(collate.New(tag).CompareString)
+}
+
+// NumericLocaleFromEnv returns the numeric locale chosen by the environment:
+// the first non-empty of LC_ALL and LC_NUMERIC. Unlike LocaleFromEnv it ignores
+// LANG, so that the usual LANG=en_US.UTF-8 does not change how -n parses.
+func NumericLocaleFromEnv() string {
+	for _, name := range []string{"LC_ALL", "LC_NUMERIC"} {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// numberFormat is how numbers of -n and -h are written in a numeric locale.
+type numberFormat struct {
+	decimal byte   // десятичный разделитель
+	group   string // разделитель групп разрядов между цифрами; пусто — группы не допускаются
+}
+
+// cNumeric is the number format of the C locale: a decimal dot and no grouping.
+var cNumeric = numberFormat{decimal: '.'}
+
+// numberFormats lists the number formats of languages, as in glibc; a language
+// missing here is read as in the C locale. French groups digits with a narrow
+// no-break space, the others with a no-break space.
+var numberFormats = map[string]numberFormat{
+	"en": {'.', ","}, "ja": {'.', ","}, "zh": {'.', ","}, "ko": {'.', ","}, "he": {'.', ","},
+	"de": {',', "."}, "es": {',', "."}, "it": {',', "."}, "nl": {',', "."}, "pt": {',', "."},
+	"da": {',', "."}, "id": {',', "."}, "tr": {',', "."}, "el": {',', "."}, "ro": {',', "."},
+	"fr": {',', "\u202f"}, "ru": {',', "\u00a0"}, "uk": {',', "\u00a0"}, "pl": {',', "\u00a0"},
+	"cs": {',', "\u00a0"}, "sv": {',', "\u00a0"}, "fi": {',', "\u00a0"}, "nb": {',', "\u00a0"},
+}
+
+// numberFormat returns the number format of opts: the decimal comma without
+// grouping with DecimalComma, otherwise that of NumericLocale.
+func (opts SortOptions) numberFormat() numberFormat {
+	if opts.DecimalComma {
+		return numberFormat{decimal: ','}
+	}
+	tag, ok, err := localeTag(opts.NumericLocale)
+	if err != nil || !ok {
+		return cNumeric
+	}
+	base, _ := tag.Base()
+	if f, ok := numberFormats[base.String()]; ok {
+		return f
+	}
+	return cNumeric
+}
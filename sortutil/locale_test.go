@@ -0,0 +1,159 @@
+package sortutil
+
+import "testing"
+
+// TestLocaleFromEnv checks the precedence of LC_ALL, LC_COLLATE and LANG.
+func TestLocaleFromEnv(t *testing.T) {
+	cases := []struct {
+		name               string
+		all, collate, lang string
+		want               string
+	}{
+		{"nothing", "", "", "", ""},
+		{"lang", "", "", "de_DE.UTF-8", "de_DE.UTF-8"},
+		{"collate over lang", "", "fr_FR.UTF-8", "de_DE.UTF-8", "fr_FR.UTF-8"},
+		{"all over collate", "C", "fr_FR.UTF-8", "de_DE.UTF-8", "C"},
+	}
+	for _, c := range cases {
+		t.Setenv("LC_ALL", c.all)
+		t.Setenv("LC_COLLATE", c.collate)
+		t.Setenv("LANG", c.lang)
+		if got := LocaleFromEnv(); got != c.want {
+			t.Errorf("%s: LocaleFromEnv() = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// TestValidateLocale accepts POSIX locale names with an encoding and a
+// modifier and rejects names that are not language tags.
+func TestValidateLocale(t *testing.T) {
+	for _, name := range []string{"", "C", "POSIX", "C.UTF-8", "de_DE.UTF-8", "sv_SE.UTF-8@euro", "en"} {
+		if err := ValidateLocale(name); err != nil {
+			t.Errorf("ValidateLocale(%q): %v", name, err)
+		}
+	}
+	for _, name := range []string{"not a locale", "xx_YY_ZZ_123"} {
+		if err := ValidateLocale(name); err == nil {
+			t.Errorf("ValidateLocale(%q): no error", name)
+		}
+	}
+}
+
+// TestSortLocale checks the collation order of locales taken from LC_COLLATE
+// and that C and POSIX compare bytes.
+func TestSortLocale(t *testing.T) {
+	input := "b\nÄ\na\nB\nz\nä\n"
+	cases := []struct {
+		locale string
+		want   string
+	}{
+		{"C", "B\na\nb\nz\nÄ\nä\n"},
+		{"POSIX", "B\na\nb\nz\nÄ\nä\n"},
+		{"de_DE.UTF-8", "a\nä\nÄ\nb\nB\nz\n"},
+		{"sv_SE.UTF-8", "a\nb\nB\nz\nä\nÄ\n"},
+	}
+	for _, c := range cases {
+		t.Setenv("LC_ALL", "")
+		t.Setenv("LC_COLLATE", c.locale)
+		if got := sortText(t, input, SortOptions{Locale: LocaleFromEnv()}); got != c.want {
+			t.Errorf("LC_COLLATE=%s: got %q, want %q", c.locale, got, c.want)
+		}
+	}
+	// В локали C "B" (0x42) меньше "a" (0x61), при сортировке по правилам языка — больше
+	for _, c := range []struct {
+		locale string
+		want   int
+	}{{"", -1}, {"C", -1}, {"POSIX.UTF-8", -1}, {"de_DE", 1}} {
+		if got := newTextComparer(SortOptions{Locale: c.locale}).Compare("B", "a"); got != c.want {
+			t.Errorf("locale %q: compare(B, a) = %d, want %d", c.locale, got, c.want)
+		}
+	}
+	if !newComparator(SortOptions{Locale: "C"}).plainBytes(SortOptions{Locale: "C"}) {
+		t.Error("the C locale does not compare plain bytes")
+	}
+	if newComparator(SortOptions{Locale: "de_DE"}).plainBytes(SortOptions{Locale: "de_DE"}) {
+		t.Error("de_DE compares plain bytes")
+	}
+}
+
+// TestNumericLocaleFromEnv checks that LC_ALL wins over LC_NUMERIC and that
+// LANG is ignored.
+func TestNumericLocaleFromEnv(t *testing.T) {
+	cases := []struct {
+		name               string
+		all, numeric, lang string
+		want               string
+	}{
+		{"nothing", "", "", "", ""},
+		{"lang ignored", "", "", "de_DE.UTF-8", ""},
+		{"numeric", "", "de_DE.UTF-8", "en_US.UTF-8", "de_DE.UTF-8"},
+		{"all over numeric", "C", "de_DE.UTF-8", "", "C"},
+	}
+	for _, c := range cases {
+		t.Setenv("LC_ALL", c.all)
+		t.Setenv("LC_NUMERIC", c.numeric)
+		t.Setenv("LANG", c.lang)
+		if got := NumericLocaleFromEnv(); got != c.want {
+			t.Errorf("%s: NumericLocaleFromEnv() = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// TestNumberFormat checks the decimal separator and digit grouping of numeric
+// locales and of DecimalComma, and that unknown languages read numbers as C.
+func TestNumberFormat(t *testing.T) {
+	cases := []struct {
+		name string
+		opts SortOptions
+		key  string
+		want float64
+		ok   bool
+	}{
+		{"C dot", SortOptions{}, "1.5", 1.5, true},
+		{"C no grouping", SortOptions{}, "1,234", 1, true},
+		{"en grouping", SortOptions{NumericLocale: "en_US.UTF-8"}, "-1,234.5", -1234.5, true},
+		{"en group needs a digit", SortOptions{NumericLocale: "en_US"}, "12,x", 12, true},
+		{"de comma", SortOptions{NumericLocale: "de_DE.UTF-8"}, "1.234,5", 1234.5, true},
+		{"de leading comma", SortOptions{NumericLocale: "de_DE"}, ",5", 0.5, true},
+		{"de trailing group", SortOptions{NumericLocale: "de_DE"}, "7.", 7, true},
+		{"ru no-break space", SortOptions{NumericLocale: "ru_RU.UTF-8"}, "1 000 000,25", 1000000.25, true},
+		{"fr narrow space", SortOptions{NumericLocale: "fr_FR"}, "2 500", 2500, true},
+		{"unknown language", SortOptions{NumericLocale: "xx_XX"}, "1,5", 1, true},
+		{"POSIX", SortOptions{NumericLocale: "POSIX"}, "1,5", 1, true},
+		{"decimal comma", SortOptions{DecimalComma: true}, "1,5", 1.5, true},
+		{"decimal comma no grouping", SortOptions{DecimalComma: true}, "1.234,5", 1, true},
+		{"decimal comma over locale", SortOptions{NumericLocale: "en_US", DecimalComma: true}, "1,5", 1.5, true},
+		{"no number", SortOptions{NumericLocale: "de_DE"}, ",", 0, false},
+	}
+	for _, c := range cases {
+		got, ok := c.opts.numberFormat().numericKey(c.key)
+		if ok != c.ok || got != c.want {
+			t.Errorf("%s: numericKey(%q) = %v, %v, want %v, %v", c.name, c.key, got, ok, c.want, c.ok)
+		}
+	}
+	if got := (SortOptions{NumericLocale: "de_DE"}).numberFormat().humanValue("1,5K"); got != 1500 {
+		t.Errorf("de_DE humanValue(1,5K) = %g, want 1500", got)
+	}
+}
+
+// TestSortNumericLocale checks -n and -h sorting in a locale with a decimal
+// comma, for whole lines and for keys.
+func TestSortNumericLocale(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"C", "2\n1,5\n1.25\n", SortOptions{Numeric: true}, "1,5\n1.25\n2\n"},
+		{"de", "2\n1,5\n1.25\n", SortOptions{Numeric: true, NumericLocale: "de_DE.UTF-8"}, "1,5\n2\n1.25\n"},
+		{"decimal comma", "1,5\n1,25\n2\n", SortOptions{Numeric: true, DecimalComma: true}, "1,25\n1,5\n2\n"},
+		{"human", "1,5M\n900K\n1,25M\n", SortOptions{Human: true, NumericLocale: "de_DE"}, "900K\n1,25M\n1,5M\n"},
+		{"key", "b 1,5\na 1,25\n", SortOptions{Keys: []KeySpec{{StartField: 2, EndField: 2, Numeric: true}}, NumericLocale: "de_DE"}, "a 1,25\nb 1,5\n"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
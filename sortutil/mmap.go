@@ -0,0 +1,79 @@
+package sortutil
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"unsafe"
+)
+
+var errMmapUnsupported = errors.New("mmap is not supported on this platform")
+
+// SortMapped sorts the file at path in memory by mapping it instead of copying
+// every line into its own string: lines are slices of the mapping itself.
+// Records are split and observed exactly as by Sort. A file over the memory
+// limit (-S) is sorted by the plain Sort with an external sort, as is a file
+// with a header (--header, --key-name) and any file where mmap is unavailable.
+func SortMapped(path string, w io.Writer, opts SortOptions) (err error) {
+	defer func() { err = classify(err) }()
+	if opts.Header > 0 || opts.KeyName != "" {
+		return sortFile(path, w, opts)
+	}
+
+	data, unmap, err := mmapFile(path)
+	if errors.Is(err, errMmapUnsupported) {
+		return sortFile(path, w, opts)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = unmap() }()
+	if len(data) > opts.memoryLimit() {
+		return sortFile(path, w, opts)
+	}
+
+	out := newOutputWriter(w, opts)
+	in, split := newInputObservers(out, opts)
+	out.beginSorted(opts)
+	opts = in.start(opts)
+	lines, err := mappedLines(data, split, opts)
+	if err != nil {
+		return err
+	}
+	// Строки указывают в отображение, поэтому всё выводится до unmap
+	if err = writeSorted(out, lines, opts); err != nil {
+		return err
+	}
+	return in.finish()
+}
+
+// mappedLines splits data into records with split, like a bufio.Scanner at the
+// end of input, without copying them: records that split leaves unchanged are
+// slices of the mapping itself.
+func mappedLines(data []byte, split bufio.SplitFunc, opts SortOptions) ([]string, error) {
+	lines := make([]string, 0, bytes.Count(data, []byte(opts.terminator()))+1)
+	for {
+		advance, token, err := split(data, true)
+		if err != nil && err != bufio.ErrFinalToken {
+			return nil, err
+		}
+		if token != nil {
+			lines = append(lines, unsafe.String(unsafe.SliceData(token), len(token)))
+		}
+		if err == bufio.ErrFinalToken || advance == 0 && token == nil {
+			return lines, nil
+		}
+		data = data[advance:]
+	}
+}
+
+func sortFile(path string, w io.Writer, opts SortOptions) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+	return Sort(file, w, opts)
+}
@@ -0,0 +1,7 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package sortutil
+
+func mmapFile(string) ([]byte, func() error, error) {
+	return nil, nil, errMmapUnsupported
+}
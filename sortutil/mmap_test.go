@@ -0,0 +1,121 @@
+package sortutil
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestMappedLines splits mappings into records without the observers of Sort.
+func TestMappedLines(t *testing.T) {
+	cases := []struct {
+		data string
+		opts SortOptions
+		want []string
+	}{
+		{"", SortOptions{}, []string{}},
+		{"a\nb\n", SortOptions{}, []string{"a", "b"}},
+		{"a\nb", SortOptions{}, []string{"a", "b"}},
+		{"a\n\nb\n", SortOptions{}, []string{"a", "", "b"}},
+		{"a\nb\x00c\x00", SortOptions{ZeroTerminated: true}, []string{"a\nb", "c"}},
+		{"a\nb", SortOptions{DropPartial: true}, []string{"a"}},
+		{"a\nb\n", SortOptions{DropPartial: true}, []string{"a", "b"}},
+		{"a\x00b\n", SortOptions{ZeroTerminated: true, DropPartial: true}, []string{"a"}},
+		{"a\r\nb\r\n", SortOptions{}, []string{"a", "b"}},
+	}
+	for _, c := range cases {
+		got, err := mappedLines([]byte(c.data), c.opts.inputSplit(), c.opts)
+		if err != nil || !slices.Equal(got, c.want) {
+			t.Errorf("mappedLines(%q) = %q, %v, want %q", c.data, got, err, c.want)
+		}
+	}
+}
+
+// TestSortMappedMatchesSort checks that --mmap splits and outputs records
+// exactly like the ordinary read path.
+func TestSortMappedMatchesSort(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+	}{
+		{"lf", "b\na\nc\n", SortOptions{}},
+		{"crlf", "b\r\na\r\nc\r\n", SortOptions{}},
+		{"crlf without final terminator", "b\r\na\r\nc", SortOptions{}},
+		{"without final terminator", "b\na\nc", SortOptions{}},
+		{"empty", "", SortOptions{}},
+		{"numeric unique", "10\n2\n10\n1\n", SortOptions{Numeric: true, Unique: true}},
+		{"zero terminated", "b\x00a\nx\x00c\x00", SortOptions{ZeroTerminated: true}},
+		{"drop partial", "b\na\nc", SortOptions{DropPartial: true}},
+		{"header falls back", "name\nb\na\n", SortOptions{Header: 1}},
+		{"footer", "b\na\ntotal\n", SortOptions{Footer: 1}},
+		{"prepend index", "b\na\n", SortOptions{PrependIndex: true}},
+		{"truncate long", "bbbbbbbb\naaaaaaaa\n", SortOptions{MaxLineLength: 4, TruncateLong: true}},
+		{"skip blank", "b\n\n  \na\n", SortOptions{SkipBlank: true, IgnoreBlanks: true}},
+		{"strip nul", "b\x00b\na\n", SortOptions{StripNUL: true}},
+		{"verify", "b\na\nb\n", SortOptions{Verify: true}},
+		{"assert stable", "b 2\na 1\nb 1\n", SortOptions{Stable: true, AssertStable: true, Keys: []KeySpec{{StartField: 1, EndField: 1}}}},
+		{"many lines", joinLines(numberedLines("line", 5000)), SortOptions{}},
+		{"over the memory limit", joinLines(numberedLines("line", 5000)), SortOptions{BufferSize: 16 << 10, TempDirs: []string{t.TempDir()}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "input")
+			if err := os.WriteFile(path, []byte(c.input), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			want := sortText(t, c.input, c.opts)
+			var got bytes.Buffer
+			if err := SortMapped(path, &got, c.opts); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != want {
+				t.Errorf("SortMapped = %q, want %q", got.String(), want)
+			}
+		})
+	}
+
+	if err := SortMapped(filepath.Join(t.TempDir(), "missing"), &bytes.Buffer{}, SortOptions{}); err == nil {
+		t.Error("no error for a missing file")
+	}
+}
+
+func TestSortMappedLineTooLong(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input")
+	if err := os.WriteFile(path, []byte("a\nbbbbbbbb\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SortMapped(path, &bytes.Buffer{}, SortOptions{MaxLineLength: 4}); err == nil {
+		t.Fatal("SortMapped accepted a line over --max-line-length")
+	}
+}
+
+// BenchmarkSortMapped compares sorting a file through mmap with reading it into strings.
+func BenchmarkSortMapped(b *testing.B) {
+	input := joinLines(benchFixture("string", benchSize()))
+	path := filepath.Join(b.TempDir(), "input")
+	if err := os.WriteFile(path, []byte(input), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	var out bytes.Buffer
+	b.Run("mmap", func(b *testing.B) {
+		b.SetBytes(int64(len(input)))
+		for b.Loop() {
+			out.Reset()
+			if err := SortMapped(path, &out, SortOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("read", func(b *testing.B) {
+		b.SetBytes(int64(len(input)))
+		for b.Loop() {
+			out.Reset()
+			if err := sortFile(path, &out, SortOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
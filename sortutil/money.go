@@ -0,0 +1,123 @@
+package sortutil
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// moneyValue parses an amount such as "$1,234.50", "€2.000,00", "-£3" or
+// "1 234,5 руб." for --money. ok is false when s has no amount.
+// Currency symbols (Unicode category Sc) before the number are skipped and
+// everything after it is ignored. When both a dot and a comma occur, the last
+// of them is the decimal separator; a repeated separator (1.000.000) and a
+// single one that parts the last three digits from a group of 1-3 digits
+// (1,234 and 2.000, but not 0.125) group thousands.
+func moneyValue(s string) (float64, bool) {
+	s = strings.TrimLeft(s, " \t")
+	negative := false
+	for s != "" {
+		r, size := utf8.DecodeRuneInString(s)
+		switch {
+		case r == '-' && !negative:
+			negative = true
+		case r == ' ' || r == '\u00a0' || unicode.Is(unicode.Sc, r):
+		default:
+			return parseAmount(s, negative)
+		}
+		s = s[size:]
+	}
+	return 0, false
+}
+
+// parseAmount parses the grouped number at the start of s.
+func parseAmount(s string, negative bool) (float64, bool) {
+	// Число — цифры вперемешку с разделителями; разделитель в конце не входит
+	end := 0
+	for i := 0; i < len(s); i++ {
+		if isDigit(s[i]) {
+			end = i + 1
+		} else if !isGroupSeparator(s[i]) || s[i] == ' ' && !isDigitGroup(s[i+1:]) {
+			break
+		}
+	}
+	number := s[:end]
+	if number == "" || !isDigit(number[0]) {
+		return 0, false
+	}
+
+	decimal := byte(0)
+	lastDot, lastComma := strings.LastIndexByte(number, '.'), strings.LastIndexByte(number, ',')
+	switch {
+	case lastDot >= 0 && lastComma >= 0:
+		decimal = number[max(lastDot, lastComma)]
+	case lastDot >= 0:
+		decimal = decimalOrGroup(number, '.')
+	case lastComma >= 0:
+		decimal = decimalOrGroup(number, ',')
+	}
+
+	var b strings.Builder
+	if negative {
+		b.WriteByte('-')
+	}
+	for i := 0; i < len(number); i++ {
+		switch c := number[i]; {
+		case isDigit(c):
+			b.WriteByte(c)
+		case c == decimal:
+			b.WriteByte('.')
+		}
+	}
+	f, err := strconv.ParseFloat(b.String(), 64)
+	return f, err == nil
+}
+
+// decimalOrGroup returns sep if it is the decimal separator of number, or 0 if
+// it groups thousands. A single separator groups only with exactly three
+// digits after it and one to three before it, other than a lone zero: 1.234
+// is 1234, while 0.125, 1.5 and 1234.567 are fractions.
+func decimalOrGroup(number string, sep byte) byte {
+	first := strings.IndexByte(number, sep)
+	if strings.Count(number, string(sep)) > 1 {
+		return 0
+	}
+	// Цифры перед разделителем, после других разделителей групп (1'234.567)
+	lead := number[strings.LastIndexAny(number[:first], "' ")+1 : first]
+	if lead == "0" || len(lead) > 3 || len(number)-first-1 != 3 {
+		return sep
+	}
+	return 0
+}
+
+// isDigitGroup reports whether s starts with exactly three digits, so that
+// a space inside "1 234" groups digits while "5 7" is two numbers.
+func isDigitGroup(s string) bool {
+	if len(s) < 3 || !isDigit(s[0]) || !isDigit(s[1]) || !isDigit(s[2]) {
+		return false
+	}
+	return len(s) == 3 || !isDigit(s[3])
+}
+
+func isGroupSeparator(c byte) bool {
+	return c == ',' || c == '.' || c == '\'' || c == ' '
+}
+
+// compareMoney compares amounts like -n: keys without an amount go first.
+func compareMoney(a, b string) int {
+	numA, okA := moneyValue(a)
+	numB, okB := moneyValue(b)
+	switch {
+	case okA != okB:
+		if okA {
+			return 1
+		}
+		return -1
+	case numA < numB:
+		return -1
+	case numA > numB:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+package sortutil
+
+import "testing"
+
+// TestMoneyValue checks currency symbols, signs and grouping conventions.
+func TestMoneyValue(t *testing.T) {
+	cases := []struct {
+		key  string
+		want float64
+		ok   bool
+	}{
+		{"0.125", 0.125, true},
+		{"0,125", 0.125, true},
+		{"1.234", 1234, true},
+		{"1,234", 1234, true},
+		{"1,234.5", 1234.5, true},
+		{"1.234,5", 1234.5, true},
+		{"$1,234.50", 1234.5, true},
+		{"€2.000,00", 2000, true},
+		{"€ 2.000", 2000, true},
+		{"-£3", -3, true},
+		{"12.5", 12.5, true},
+		{"1.5", 1.5, true},
+		{"1234.567", 1234.567, true},
+		{"1.000.000", 1000000, true},
+		{"1'234.5", 1234.5, true},
+		{"1'234", 1234, true},
+		{"1 234,5 руб.", 1234.5, true},
+		{"5 7", 5, true},
+		{"100.", 100, true},
+		{"¥", 0, false},
+		{"n/a", 0, false},
+	}
+	for _, c := range cases {
+		got, ok := moneyValue(c.key)
+		if got != c.want || ok != c.ok {
+			t.Errorf("moneyValue(%q) = %v, %v; want %v, %v", c.key, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+// TestSortMoney sorts amounts in $, € and £ with mixed grouping conventions.
+func TestSortMoney(t *testing.T) {
+	input := "$1,234.50\n€2.000,00\n0.125\n$1.234\n-£3\nn/a\n$0.5\n"
+	want := "n/a\n-£3\n0.125\n$0.5\n$1.234\n$1,234.50\n€2.000,00\n"
+	if got := sortText(t, input, SortOptions{Money: true}); got != want {
+		t.Errorf("sort --money = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,101 @@
+package sortutil
+
+import (
+	"cmp"
+	"os"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// monthName is one name of a month that -M recognises at the start of a key.
+type monthName struct {
+	name  string // в нижнем регистре
+	runes int
+	month int
+}
+
+// cMonths are the month abbreviations of the C locale.
+var cMonths = [12]string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+
+// monthNames lists the month abbreviations of languages, as abmon in LC_TIME of
+// glibc but lower-case and without the final dot, so that "févr" matches both
+// "févr." and "février". Other spellings of the same month follow after "|"
+// when the abbreviation is not the start of the full name or its case ("mrt"
+// and "maart").
+var monthNames = map[string][12]string{
+	"fr": {"janv", "févr", "mars", "avr", "mai", "juin", "juil", "août", "sept", "oct", "nov", "déc"},
+	"de": {"jan", "feb", "mär|maer", "apr", "mai", "jun", "jul", "aug", "sep", "okt", "nov", "dez"},
+	"es": {"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sep", "oct", "nov", "dic"},
+	"it": {"gen", "feb", "mar", "apr", "mag", "giu", "lug", "ago", "set", "ott", "nov", "dic"},
+	"pt": {"jan", "fev", "mar", "abr", "mai", "jun", "jul", "ago", "set", "out", "nov", "dez"},
+	"nl": {"jan", "feb", "mrt|maart", "apr", "mei", "jun", "jul", "aug", "sep", "okt", "nov", "dec"},
+	"sv": {"jan", "feb", "mar", "apr", "maj", "jun", "jul", "aug", "sep", "okt", "nov", "dec"},
+	"da": {"jan", "feb", "mar", "apr", "maj", "jun", "jul", "aug", "sep", "okt", "nov", "dec"},
+	"nb": {"jan", "feb", "mar", "apr", "mai", "jun", "jul", "aug", "sep", "okt", "nov", "des"},
+	"pl": {"sty", "lut", "mar", "kwi", "maj", "cze", "lip", "sie", "wrz", "paź", "lis", "gru"},
+	"ru": {"янв", "фев", "мар", "апр", "май|мая", "июн", "июл", "авг", "сен", "окт", "ноя", "дек"},
+	"uk": {"січ", "лют", "бер", "кві", "тра", "чер", "лип", "сер", "вер", "жов", "лис", "гру"},
+	"fi": {"tammi", "helmi", "maalis", "huhti", "touko", "kesä", "heinä", "elo", "syys", "loka", "marras", "joulu"},
+}
+
+// TimeLocaleFromEnv returns the locale of -M month names chosen by the
+// environment: the first non-empty of LC_ALL and LC_TIME.
+func TimeLocaleFromEnv() string {
+	for _, name := range []string{"LC_ALL", "LC_TIME"} {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// monthTable returns the month names of opts.TimeLocale, longest first, followed
+// by those of the C locale: English abbreviations are recognised in any locale,
+// but a locale name with the same start takes precedence.
+func (opts SortOptions) monthTable() []monthName {
+	var table []monthName
+	add := func(names [12]string) {
+		for i, spellings := range names {
+			for _, name := range strings.Split(spellings, "|") {
+				table = append(table, monthName{name: name, runes: utf8.RuneCountInString(name), month: i + 1})
+			}
+		}
+	}
+	if tag, ok, err := localeTag(opts.TimeLocale); err == nil && ok {
+		base, _ := tag.Base()
+		if names, ok := monthNames[base.String()]; ok {
+			add(names)
+		}
+	}
+	local := len(table)
+	add(cMonths)
+	// Среди названий локали самое длинное совпадение важнее короткого
+	slices.SortStableFunc(table[:local], func(a, b monthName) int { return cmp.Compare(b.runes, a.runes) })
+	return table
+}
+
+// monthValue returns the month (1-12) whose name in table starts s after
+// leading blanks, or 0. As in GNU sort, "JANUARY" and "jan" are January; case
+// is folded by Unicode, so "FÉVRIER" is February with the French table.
+func monthValue(table []monthName, s string) int {
+	s = strings.TrimLeft(s, " \t")
+	for _, m := range table {
+		end := 0
+		for n := 0; n < m.runes && end < len(s); n++ {
+			_, size := utf8.DecodeRuneInString(s[end:])
+			end += size
+		}
+		if strings.EqualFold(s[:end], m.name) {
+			return m.month
+		}
+	}
+	return 0
+}
+
+func newMonthComparer(opts SortOptions) KeyComparer {
+	table := opts.monthTable()
+	return KeyComparerFunc(func(a, b string) int {
+		return cmp.Compare(monthValue(table, a), monthValue(table, b))
+	})
+}
@@ -0,0 +1,64 @@
+package sortutil
+
+import "testing"
+
+// TestMonthValue checks the month lookup: case-insensitive, by the longest
+// name of the locale after leading blanks, with English names in any locale.
+func TestMonthValue(t *testing.T) {
+	cases := []struct {
+		locale string
+		key    string
+		want   int
+	}{
+		{"", "Jan", 1},
+		{"", "  JANUARY", 1},
+		{"", "dec.", 12},
+		{"", "\tfeb", 2},
+		{"", "ja", 0},
+		{"", "", 0},
+		{"", "nope", 0},
+		{"", "février", 0},
+		{"fr_FR.UTF-8", "février", 2},
+		{"fr_FR.UTF-8", "FÉVRIER", 2},
+		{"fr_FR.UTF-8", "Févr.", 2},
+		{"fr_FR.UTF-8", "juin", 6},
+		{"fr_FR.UTF-8", "juillet", 7},
+		{"fr_FR.UTF-8", "AOÛT", 8},
+		{"fr_FR.UTF-8", "décembre", 12},
+		{"fr_FR.UTF-8", "Feb", 2},
+		{"de_DE", "MÄRZ", 3},
+		{"de_DE", "Dezember", 12},
+		{"nl_NL", "maart", 3},
+		{"ru_RU.UTF-8", "ЯНВАРЯ", 1},
+		{"ru_RU.UTF-8", "мая", 5},
+		{"ru_RU.UTF-8", "Май", 5},
+		{"ru_RU.UTF-8", "март", 3},
+		{"fi_FI", "Kesäkuu", 6},
+		{"pl_PL", "październik", 10},
+		{"ja_JP", "Mar", 3},
+	}
+	for _, c := range cases {
+		table := SortOptions{TimeLocale: c.locale}.monthTable()
+		if got := monthValue(table, c.key); got != c.want {
+			t.Errorf("monthValue(%q) in %q = %d, want %d", c.key, c.locale, got, c.want)
+		}
+	}
+}
+
+// TestSortMonthLocale sorts French month names with --time-locale.
+func TestSortMonthLocale(t *testing.T) {
+	input := "DÉCEMBRE\nmars\nFÉVRIER\nnope\njanvier\nAoût\n"
+	want := "nope\njanvier\nFÉVRIER\nmars\nAoût\nDÉCEMBRE\n"
+	if got := sortText(t, input, SortOptions{Month: true, TimeLocale: "fr_FR.UTF-8"}); got != want {
+		t.Errorf("sort -M in fr_FR = %q, want %q", got, want)
+	}
+}
+
+// TestSortMonthCase sorts month names in any case with -M; unknown names
+// come first.
+func TestSortMonthCase(t *testing.T) {
+	input := "DEC\nmarch\nFeb\nnope\njAn\n"
+	if got, want := sortText(t, input, SortOptions{Month: true}), "nope\njAn\nFeb\nmarch\nDEC\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
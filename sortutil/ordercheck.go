@@ -0,0 +1,78 @@
+package sortutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	orderCheckSample    = 64 // каждое N-е сравнение проверяется на транзитивность
+	orderCheckMaxReport = 10
+)
+
+// orderChecker verifies that every comparison of a comparator is consistent
+// with a strict weak ordering (--total-order-check): swapping the arguments
+// must flip the sign, and sampled triples must be transitive.
+type orderChecker struct {
+	w        io.Writer
+	calls    int
+	sample   string // строка из предыдущей выборки для проверки тройки
+	sampled  bool
+	reported int
+}
+
+func newOrderChecker() *orderChecker {
+	return &orderChecker{w: os.Stderr}
+}
+
+// check is called by compareLines with the result res of comparing a and b.
+func (o *orderChecker) check(c *comparator, a, b string, res int) {
+	if back := c.order(b, a); back != -res {
+		o.report("compare(%q, %q) = %d, but compare(%q, %q) = %d", a, b, res, b, a, back)
+	}
+
+	o.calls++
+	if o.calls%orderCheckSample != 0 {
+		return
+	}
+	if o.sampled {
+		o.checkTriple(c, o.sample, a, b)
+	}
+	o.sample, o.sampled = a, true
+}
+
+// checkTriple checks that p <= a and a <= b imply p <= b,
+// strictly if either step is strict.
+func (o *orderChecker) checkTriple(c *comparator, p, a, b string) {
+	// Упорядочиваем тройку так, чтобы проверялась цепочка x <= y <= z
+	x, y, z := p, a, b
+	if c.order(x, y) > 0 {
+		x, y = y, x
+	}
+	if c.order(y, z) > 0 {
+		y, z = z, y
+		if c.order(x, y) > 0 {
+			x, y = y, x
+		}
+	}
+	xy, yz, xz := c.order(x, y), c.order(y, z), c.order(x, z)
+	if xy > 0 || yz > 0 {
+		o.report("no consistent order for %q, %q and %q", x, y, z)
+		return
+	}
+	if want := min(xy, yz); xz > 0 || want < 0 && xz == 0 || want == 0 && xz != 0 {
+		o.report("not transitive: compare(%q, %q) = %d, compare(%q, %q) = %d, but compare(%q, %q) = %d",
+			x, y, xy, y, z, yz, x, z, xz)
+	}
+}
+
+func (o *orderChecker) report(format string, args ...any) {
+	o.reported++
+	switch {
+	case o.reported <= orderCheckMaxReport:
+		fmt.Fprintf(o.w, "sort: total order violation: "+format+"\n", args...)
+	case o.reported == orderCheckMaxReport+1:
+		fmt.Fprintln(o.w, "sort: total order violation: further violations are not reported")
+	}
+}
@@ -0,0 +1,89 @@
+package sortutil
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// brokenComparator returns a --total-order-check comparator of whole lines
+// whose key comparison is compare, reporting to w.
+func brokenComparator(compare func(a, b string) int, w *bytes.Buffer) *comparator {
+	c := newComparator(SortOptions{TotalOrderCheck: true, NoLastResort: true})
+	c.keys[0].comparer = KeyComparerFunc(compare)
+	c.orderCheck.w = w
+	return c
+}
+
+// TestTotalOrderCheck feeds deliberately inconsistent comparisons to the
+// check: a comparer that is not antisymmetric and one that is not transitive
+// are reported, a consistent one is not, and the reports are capped.
+func TestTotalOrderCheck(t *testing.T) {
+	rank := map[string]int{"rock": 0, "paper": 1, "scissors": 2}
+	cases := []struct {
+		name    string
+		compare func(a, b string) int
+		want    string // начало первого сообщения; пусто — нарушений нет
+	}{
+		{"consistent", strings.Compare, ""},
+		{"always less", func(a, b string) int { return -1 }, "sort: total order violation: compare("},
+		{"ignores equality", func(a, b string) int {
+			if a == b {
+				return 1
+			}
+			return strings.Compare(a, b)
+		}, "sort: total order violation: compare("},
+		{"not transitive", func(a, b string) int {
+			// Камень, ножницы, бумага: каждый бьёт следующего по кругу
+			switch (rank[b] - rank[a] + 3) % 3 {
+			case 0:
+				return 0
+			case 1:
+				return -1
+			}
+			return 1
+		}, "sort: total order violation: not transitive"},
+	}
+	lines := []string{"rock", "paper", "scissors"}
+	for _, c := range cases {
+		var report bytes.Buffer
+		comp := brokenComparator(c.compare, &report)
+		for i := range 3 * orderCheckSample {
+			comp.compareLines(lines[i%3], lines[(i+1+i/3)%3])
+		}
+		got := report.String()
+		if c.want == "" && got != "" || !strings.HasPrefix(got, c.want) {
+			t.Errorf("%s: report %q, want it to start with %q", c.name, got, c.want)
+		}
+		if n := strings.Count(got, "\n"); n > orderCheckMaxReport+1 {
+			t.Errorf("%s: %d report lines, want at most %d", c.name, n, orderCheckMaxReport+1)
+		}
+	}
+}
+
+// TestSortTotalOrderCheck sorts with --total-order-check: the output is that
+// of the plain sort and a consistent comparator reports nothing.
+func TestSortTotalOrderCheck(t *testing.T) {
+	lines := mixedLines(1000)
+	cases := []struct {
+		name string
+		opts SortOptions
+	}{
+		{"default", SortOptions{}},
+		{"numeric", SortOptions{Numeric: true}},
+		{"human reverse", SortOptions{Human: true, Reverse: true}},
+		{"stable fold case", SortOptions{Stable: true, FoldCase: true}},
+	}
+	for _, c := range cases {
+		want := sortText(t, joinLines(lines), c.opts)
+		c.opts.TotalOrderCheck = true
+		var got string
+		report := captureStderr(t, func() { got = sortText(t, joinLines(lines), c.opts) })
+		if got != want {
+			t.Errorf("%s: output differs from the sort without the check", c.name)
+		}
+		if report != "" {
+			t.Errorf("%s: unexpected report %q", c.name, report)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package sortutil
+
+import "strings"
+
+// padNumber zero-pads the integer part of the leading number of the first key
+// of line to width characters (the sign counts, as in printf %05d) for --pad-width.
+// The rest of the line is unchanged. Keys of CSV and TSV, --key-regex,
+// --key-template, --key-from-byte and multi-line records are not padded.
+func padNumber(line string, k KeySpec, width int) string {
+	if k.csv || k.tsv || k.regex != nil || k.template != nil || k.fromByte > 0 || k.recordLine > 0 {
+		return line
+	}
+	start, end := keySpan(line, k)
+	start = skipBlanks(line, start, end)
+
+	i := start
+	if i < end && line[i] == '-' {
+		i++
+	}
+	digits := i
+	for i < end && isDigit(line[i]) {
+		i++
+	}
+	if i == digits {
+		return line
+	}
+	pad := width - (i - start)
+	if pad <= 0 {
+		return line
+	}
+	return line[:digits] + strings.Repeat("0", pad) + line[digits:]
+}
@@ -0,0 +1,42 @@
+package sortutil
+
+import "testing"
+
+// TestPadNumber checks which numbers --pad-width pads and where the zeros go.
+func TestPadNumber(t *testing.T) {
+	cases := []struct {
+		line  string
+		opts  SortOptions
+		width int
+		want  string
+	}{
+		{"42 x", SortOptions{}, 5, "00042 x"},
+		{"-42 x", SortOptions{}, 5, "-0042 x"},
+		{"3.5 x", SortOptions{}, 5, "00003.5 x"},
+		{"123456 x", SortOptions{}, 5, "123456 x"},
+		{"12345", SortOptions{}, 5, "12345"},
+		{"  7 x", SortOptions{}, 3, "  007 x"},
+		{"abc", SortOptions{}, 5, "abc"},
+		{"", SortOptions{}, 5, ""},
+		{"a 7 b", SortOptions{Keys: []KeySpec{{StartField: 2, EndField: 2}}}, 3, "a 007 b"},
+		{"a,7", SortOptions{Separator: ",", Keys: []KeySpec{{StartField: 2, EndField: 2}}}, 2, "a,07"},
+		{"a,7", SortOptions{CSV: true, Keys: []KeySpec{{StartField: 2, EndField: 2}}}, 2, "a,7"},
+		{"a 7", SortOptions{KeyFromByte: 3}, 3, "a 7"},
+	}
+	for _, c := range cases {
+		k := newComparator(c.opts).keys[0]
+		if got := padNumber(c.line, k, c.width); got != c.want {
+			t.Errorf("padNumber(%q, %d) = %q, want %q", c.line, c.width, got, c.want)
+		}
+	}
+}
+
+// TestSortPadWidth pads a numeric first field to width 5 after sorting: the
+// order is that of the unpadded numbers.
+func TestSortPadWidth(t *testing.T) {
+	input := "100 c\n7 a\n-3 z\n20 b\n"
+	got := sortText(t, input, SortOptions{Numeric: true, PadWidth: 5})
+	if want := "-0003 z\n00007 a\n00020 b\n00100 c\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,171 @@
+package sortutil
+
+import (
+	"bufio"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// workers returns the number of goroutines allowed by --parallel.
+func (opts SortOptions) workers() int {
+	if opts.Parallel > 0 {
+		return opts.Parallel
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// mergeWorkers returns the number of groups merged concurrently in the final
+// merge: --threads-for-merge, or --parallel with --parallel-merge, or 1.
+func (opts SortOptions) mergeWorkers() int {
+	switch {
+	case opts.MergeThreads > 0:
+		return opts.MergeThreads
+	case opts.ParallelMerge:
+		return opts.workers()
+	}
+	return 1
+}
+
+// mergeParallel splits files into groups merged concurrently, each into a pipe,
+// and merges the pipes into out. The group merges keep duplicates, since -u
+// applies only to the final one, so the output matches mergeFiles.
+// The group goroutines finish before it returns, so the caller may close and
+// remove files right after it, on error as well.
+func mergeParallel(files []*tempFile, out *recordWriter, opts SortOptions) error {
+	groups := min(opts.mergeWorkers(), len(files)/2)
+	if groups < 2 {
+		return mergeFiles(files, out, opts)
+	}
+
+	streams := make([]*tempFile, groups)
+	readers := make([]*io.PipeReader, groups)
+	var wg sync.WaitGroup
+	// Закрытие читающих концов освобождает горутины, если итоговое слияние прервалось
+	defer func() {
+		for _, pr := range readers {
+			pr.Close()
+		}
+		wg.Wait()
+	}()
+	for i := range groups {
+		// Группы — непрерывные отрезки files примерно равного размера
+		group := files[i*len(files)/groups : (i+1)*len(files)/groups]
+		pr, pw := io.Pipe()
+		readers[i] = pr
+		streams[i] = &tempFile{Scanner: newTempReader(pr, opts)}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := newRecordWriter(pw, opts)
+			err := mergeStreams(group, newComparator(opts), w.write)
+			if err == nil {
+				err = w.flush()
+			}
+			pw.CloseWithError(err)
+		}()
+	}
+
+	if err := mergeFiles(streams, out, opts); err != nil {
+		return err
+	}
+	for _, s := range streams {
+		if err := s.Scanner.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SortInputs sorts every input on its own, at most --parallel of them at a time,
+// into sorted runs in temporary files and merges all runs into w (--parallel-files).
+// The output is that of Sort over JoinInputs(inputs): with equal keys and -s
+// the lines of the earlier input go first. The memory limit is shared by the
+// concurrent sorts, and opts.TempStore is used from several goroutines at once.
+func SortInputs(inputs []io.Reader, w io.Writer, opts SortOptions) (err error) {
+	defer func() { err = classify(err) }()
+	store := opts.tempStore()
+	workers := min(opts.workers(), len(inputs))
+	limit := max(opts.memoryLimit()/workers, 1)
+
+	runs := make([][]*tempFile, len(inputs))
+	errs := make([]error, len(inputs))
+	slots := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, r := range inputs {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-slots; wg.Done() }()
+			runs[i], errs[i] = sortRuns(NewLineReader(r, opts), opts, store, limit)
+		}()
+	}
+	wg.Wait()
+
+	// Порции идут в порядке входов: слияние при равенстве берёт более раннюю
+	var files []*tempFile
+	for _, run := range runs {
+		files = append(files, run...)
+	}
+	for _, err := range errs {
+		if err != nil {
+			cleanup(files)
+			return err
+		}
+	}
+	if files, err = mergeLevels(files, opts, store, newProgress(opts)); err != nil {
+		return err
+	}
+	defer func() { cleanup(files) }()
+
+	out := newOutputWriter(w, opts)
+	out.beginSorted(opts)
+	if err = mergeFiles(files, out, opts); err != nil {
+		return err
+	}
+	if err = out.flush(); err != nil {
+		return err
+	}
+	out.reportTies()
+	return nil
+}
+
+// sortRuns reads s to the end and returns it as sorted runs of about limit bytes,
+// in the order they were read. On error the chunks already written are removed.
+func sortRuns(s *bufio.Scanner, opts SortOptions, store TempStore, limit int) ([]*tempFile, error) {
+	var runs []*tempFile
+	spill := func(lines []string) error {
+		run, err := createTempFile(SortInMemory(lines, opts), opts, store)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, run)
+		return nil
+	}
+
+	var lines []string
+	data := 0
+	for s.Scan() {
+		line := s.Text()
+		lines = append(lines, line)
+		data += len(line)
+		if linesMemory(data, lines) > limit && len(lines) > 1 {
+			if err := spill(lines[:len(lines)-1]); err != nil {
+				cleanup(runs)
+				return nil, err
+			}
+			lines = []string{line}
+			data = len(line)
+		}
+	}
+	err := s.Err()
+	if err == nil && len(lines) > 0 {
+		err = spill(lines)
+	}
+	if err != nil {
+		cleanup(runs)
+		return nil, err
+	}
+	return runs, nil
+}
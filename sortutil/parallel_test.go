@@ -0,0 +1,297 @@
+package sortutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memoryRuns returns n sorted in-memory runs of lines for a merge.
+func memoryRuns(lines []string, n int, opts SortOptions) []*tempFile {
+	runs := make([]*tempFile, n)
+	for i := range runs {
+		run := SortInMemory(slices.Clone(lines[i*len(lines)/n:(i+1)*len(lines)/n]), opts)
+		runs[i] = &tempFile{Scanner: NewLineReader(strings.NewReader(joinLines(run)), opts)}
+	}
+	return runs
+}
+
+func TestMergeParallelMatchesMergeFiles(t *testing.T) {
+	// Повторы ключей в разных отрезках проверяют -u
+	var lines []string
+	for i := range 5000 {
+		lines = append(lines, fmt.Sprintf("%d %d", i%97, i))
+	}
+	cases := []SortOptions{
+		{},
+		{Unique: true, Keys: []KeySpec{{StartField: 1, EndField: 1}}},
+		{Reverse: true, Numeric: true},
+		{Stable: true, Keys: []KeySpec{{StartField: 1, EndField: 1}}},
+	}
+	for _, opts := range cases {
+		var serial, parallel bytes.Buffer
+		out := newRecordWriter(&serial, opts)
+		if err := mergeFiles(memoryRuns(lines, 16, opts), out, opts); err != nil {
+			t.Fatal(err)
+		}
+		out.flush()
+		for _, workers := range []int{1, 2, 3, 8, 16} {
+			opts.ParallelMerge, opts.Parallel = true, workers
+			parallel.Reset()
+			out = newRecordWriter(&parallel, opts)
+			if err := mergeParallel(memoryRuns(lines, 16, opts), out, opts); err != nil {
+				t.Fatal(err)
+			}
+			out.flush()
+			if parallel.String() != serial.String() {
+				t.Errorf("%+v: parallel merge with %d groups differs from serial merge", opts, workers)
+			}
+		}
+	}
+}
+
+// TestExternalSortParallelMerge runs the whole external sort with
+// --parallel-merge and compares it with the in-memory sort.
+func TestExternalSortParallelMerge(t *testing.T) {
+	lines := numberedLines("line", 5000)
+	want := joinLines(SortInMemory(slices.Clone(lines), SortOptions{}))
+	opts := SortOptions{ParallelMerge: true, Parallel: 4}
+	var out bytes.Buffer
+	if err := ExternalSortReader(strings.NewReader(joinLines(lines)), &out, opts, 5000); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Error("--parallel-merge output differs from the in-memory sort")
+	}
+}
+
+// TestMergeWorkers checks how many groups the final merge uses: set by
+// --threads-for-merge apart from --parallel, by --parallel with
+// --parallel-merge, and one otherwise.
+func TestMergeWorkers(t *testing.T) {
+	cases := []struct {
+		opts SortOptions
+		want int
+	}{
+		{SortOptions{}, 1},
+		{SortOptions{Parallel: 8}, 1},
+		{SortOptions{Parallel: 8, ParallelMerge: true}, 8},
+		{SortOptions{Parallel: 8, MergeThreads: 2}, 2},
+		{SortOptions{Parallel: 2, ParallelMerge: true, MergeThreads: 3}, 3},
+		{SortOptions{MergeThreads: 1, ParallelMerge: true, Parallel: 4}, 1},
+	}
+	for _, c := range cases {
+		if got := c.opts.mergeWorkers(); got != c.want {
+			t.Errorf("%+v: mergeWorkers() = %d, want %d", c.opts, got, c.want)
+		}
+	}
+
+	// Вывод с любым числом групп слияния совпадает с сортировкой в памяти
+	lines := numberedLines("line", 5000)
+	want := joinLines(SortInMemory(slices.Clone(lines), SortOptions{}))
+	for _, threads := range []int{1, 2, 5} {
+		opts := SortOptions{Parallel: 8, MergeThreads: threads}
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(joinLines(lines)), &out, opts, 5000); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("--threads-for-merge=%d: output differs from the in-memory sort", threads)
+		}
+	}
+}
+
+func BenchmarkMergeParallel(b *testing.B) {
+	lines := benchFixture("string", benchSize())
+	for _, workers := range []int{1, 2, 4} {
+		b.Run(fmt.Sprint("groups=", workers), func(b *testing.B) {
+			opts := SortOptions{ParallelMerge: true, Parallel: workers}
+			for b.Loop() {
+				b.StopTimer()
+				runs := memoryRuns(lines, 64, opts)
+				out := newRecordWriter(io.Discard, opts)
+				b.StartTimer()
+				if err := mergeParallel(runs, out, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// splitInputs returns lines as n inputs; the last one lacks its final newline.
+func splitInputs(lines []string, n int) []io.Reader {
+	inputs := make([]io.Reader, n)
+	for i := range inputs {
+		text := joinLines(lines[i*len(lines)/n : (i+1)*len(lines)/n])
+		if i == n-1 {
+			text = strings.TrimSuffix(text, "\n")
+		}
+		inputs[i] = strings.NewReader(text)
+	}
+	return inputs
+}
+
+// TestSortInputsMatchesSort checks that --parallel-files gives the output of
+// the serial sort of the concatenated inputs, including -u and the order of
+// equal lines with -s, and leaves no temporary files.
+func TestSortInputsMatchesSort(t *testing.T) {
+	var lines []string
+	for i := range 3000 {
+		lines = append(lines, fmt.Sprintf("%d %d", i%53, i))
+	}
+	cases := []SortOptions{
+		{},
+		{Unique: true, Keys: []KeySpec{{StartField: 1, EndField: 1}}},
+		{Reverse: true, Numeric: true},
+		{Stable: true, Keys: []KeySpec{{StartField: 1, EndField: 1, Numeric: true}}},
+	}
+	for _, opts := range cases {
+		var want bytes.Buffer
+		if err := Sort(JoinInputs(splitInputs(lines, 7), opts), &want, opts); err != nil {
+			t.Fatal(err)
+		}
+		for _, workers := range []int{1, 3, 16} {
+			dir := t.TempDir()
+			opts.Parallel = workers
+			opts.TempDirs = []string{dir}
+			var got bytes.Buffer
+			if err := SortInputs(splitInputs(lines, 7), &got, opts); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != want.String() {
+				t.Errorf("%+v: --parallel-files with %d workers differs from the serial sort", opts, workers)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("%+v: %d temporary files left", opts, len(entries))
+			}
+		}
+	}
+
+	var got bytes.Buffer
+	if err := SortInputs([]io.Reader{strings.NewReader(""), strings.NewReader("")}, &got, SortOptions{}); err != nil || got.Len() != 0 {
+		t.Errorf("empty inputs: %q, %v", got.String(), err)
+	}
+}
+
+// TestSortRuns checks that sortRuns splits an input into sorted runs by the
+// memory limit and removes them when the input fails.
+func TestSortRuns(t *testing.T) {
+	dir := t.TempDir()
+	store := newTempDirs([]string{dir})
+	lines := numberedLines("line", 2000)
+	runs, err := sortRuns(NewLineReader(strings.NewReader(joinLines(lines)), SortOptions{}), SortOptions{}, store, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) < 2 {
+		t.Fatalf("%d runs, want several with a 4 KB limit", len(runs))
+	}
+	var read []string
+	for _, run := range runs {
+		var chunk []string
+		for run.Scan() {
+			chunk = append(chunk, run.Text())
+		}
+		if !slices.IsSorted(chunk) {
+			t.Errorf("run %s is not sorted", run.name)
+		}
+		read = append(read, chunk...)
+	}
+	cleanup(runs)
+	if !slices.Equal(SortInMemory(read, SortOptions{}), SortInMemory(slices.Clone(lines), SortOptions{})) {
+		t.Error("the runs do not hold the lines of the input")
+	}
+
+	boom := errors.New("boom")
+	r := &failingReader{data: strings.NewReader(joinLines(lines)), err: boom}
+	if _, err := sortRuns(NewLineReader(r, SortOptions{}), SortOptions{}, store, 4096); !errors.Is(err, boom) {
+		t.Errorf("failing input: err = %v, want %v", err, boom)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d temporary files left", len(entries))
+	}
+
+	r = &failingReader{data: strings.NewReader("b\na\n"), err: boom}
+	inputs := []io.Reader{strings.NewReader("c\n"), r, strings.NewReader("d\n")}
+	if err := SortInputs(inputs, io.Discard, SortOptions{TempDirs: []string{dir}}); !errors.Is(err, boom) {
+		t.Errorf("SortInputs with a failing input: err = %v, want %v", err, boom)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("SortInputs: %d temporary files left", len(entries))
+	}
+}
+
+// BenchmarkSortInputs compares --parallel-files on many medium inputs with the
+// serial sort of their concatenation.
+func BenchmarkSortInputs(b *testing.B) {
+	lines := benchFixture("string", benchSize())
+	const files = 16
+	b.Run("serial", func(b *testing.B) {
+		for b.Loop() {
+			if err := Sort(JoinInputs(splitInputs(lines, files), SortOptions{}), io.Discard, SortOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprint("parallel-files=", workers), func(b *testing.B) {
+			opts := SortOptions{Parallel: workers, TempDirs: []string{b.TempDir()}}
+			for b.Loop() {
+				if err := SortInputs(splitInputs(lines, files), io.Discard, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// guardedReader reads slowly, a few bytes at a time, and counts reads that
+// happen after closed is set, when the files of a merge may already be removed.
+type guardedReader struct {
+	r      io.Reader
+	closed *atomic.Bool
+	late   *atomic.Int32
+}
+
+func (g *guardedReader) Read(p []byte) (int, error) {
+	if g.closed.Load() {
+		g.late.Add(1)
+	}
+	time.Sleep(100 * time.Microsecond)
+	return g.r.Read(p[:min(len(p), 64)])
+}
+
+// TestMergeParallelWaitsForGroups checks that a merge aborted by its output
+// returns only after the group goroutines stopped reading their files.
+func TestMergeParallelWaitsForGroups(t *testing.T) {
+	opts := SortOptions{MergeThreads: 4}
+	var closed atomic.Bool
+	var late atomic.Int32
+	runs := memoryRuns(numberedLines("line", 20000), 16, opts)
+	for _, run := range runs {
+		// Каждый отрезок перечитывается через медленный читатель
+		var data bytes.Buffer
+		for run.Scan() {
+			data.WriteString(run.Text() + "\n")
+		}
+		run.Scanner = newTempReader(&guardedReader{r: &data, closed: &closed, late: &late}, opts)
+	}
+	out := newRecordWriter(&failingWriter{n: 100}, opts)
+	out.flushEach = true
+	if err := mergeParallel(runs, out, opts); err == nil {
+		t.Fatal("merge into a failing writer succeeded")
+	}
+	closed.Store(true)
+	time.Sleep(50 * time.Millisecond)
+	if n := late.Load(); n > 0 {
+		t.Errorf("merge inputs were read %d times after mergeParallel returned", n)
+	}
+}
@@ -0,0 +1,66 @@
+package sortutil
+
+import (
+	"cmp"
+	"encoding/binary"
+	"slices"
+	"strings"
+)
+
+// prefixedLine is a line with its first 8 bytes packed into an integer.
+type prefixedLine struct {
+	prefix uint64
+	line   string
+}
+
+// linePrefix packs the first 8 bytes of s big-endian, padding with zeros,
+// so that comparing prefixes orders lines like their first bytes do.
+func linePrefix(s string) uint64 {
+	var b [8]byte
+	copy(b[:], s)
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// plainBytes reports whether c orders lines simply by their bytes: the whole
+// line is the only key, compared as text in the C locale without transformations.
+func (c *comparator) plainBytes(opts SortOptions) bool {
+	if len(c.keys) != 1 || c.jsonPath != nil || c.orderCheck != nil || c.exactKeys {
+		return false
+	}
+	k := c.keys[0]
+	if k.StartField > 0 || k.regex != nil || k.template != nil || k.fromByte > 0 || k.comment != "" || k.quote != "" || k.reverseBy != "" || k.recordLine > 0 || k.tagLen > 0 || k.trimBlanks || k.squeeze || k.accents || k.stripChars != "" || k.transform != nil ||
+		k.FoldCase || k.Dictionary || k.IgnoreNonprinting || k.mode() != ModeText {
+		return false
+	}
+	_, localized, _ := localeTag(opts.Locale)
+	return !localized
+}
+
+// sortPlainBytes sorts lines by their bytes, like SortInMemory with a plainBytes
+// comparator, but compares the packed prefixes first: lines that differ in their
+// first 8 bytes take one integer comparison, without following the pointer to
+// the string data. The order is that of strings.Compare.
+func sortPlainBytes(lines []string, reverse, stable bool) {
+	items := make([]prefixedLine, len(lines))
+	for i, line := range lines {
+		items[i] = prefixedLine{prefix: linePrefix(line), line: line}
+	}
+	compare := func(a, b prefixedLine) int {
+		res := cmp.Compare(a.prefix, b.prefix)
+		if res == 0 {
+			res = strings.Compare(a.line, b.line)
+		}
+		if reverse {
+			return -res
+		}
+		return res
+	}
+	if stable {
+		slices.SortStableFunc(items, compare)
+	} else {
+		slices.SortFunc(items, compare)
+	}
+	for i := range items {
+		lines[i] = items[i].line
+	}
+}
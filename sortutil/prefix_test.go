@@ -0,0 +1,95 @@
+package sortutil
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestSortPlainBytes checks that comparing packed prefixes first gives the
+// order of strings.Compare on lines shorter than the prefix, lines that differ
+// only after it, NUL and high bytes, with -r and -s.
+func TestSortPlainBytes(t *testing.T) {
+	cases := []struct {
+		name  string
+		lines []string
+	}{
+		{"short", []string{"b", "", "ab", "a", "abc"}},
+		{"trailing nul", []string{"a\x00", "a", "a\x00\x00", ""}},
+		{"shared prefix", []string{"prefix--2", "prefix--10", "prefix--1", "prefix--"}},
+		{"high bytes", []string{"\xff", "\x80a", "z", "é", "\x7f"}},
+		{"long shared prefix", longSharedPrefix(200, 1000)},
+	}
+	for _, c := range cases {
+		for _, reverse := range []bool{false, true} {
+			for _, stable := range []bool{false, true} {
+				want := slices.Clone(c.lines)
+				sort.SliceStable(want, func(i, j int) bool {
+					if reverse {
+						return want[i] > want[j]
+					}
+					return want[i] < want[j]
+				})
+				got := slices.Clone(c.lines)
+				sortPlainBytes(got, reverse, stable)
+				if !slices.Equal(got, want) {
+					t.Errorf("%s, -r %v, -s %v: got %q, want %q", c.name, reverse, stable, got, want)
+				}
+			}
+		}
+	}
+
+	// SortInMemory выбирает этот путь и даёт тот же вывод, что и компаратор
+	lines := longSharedPrefix(100, 500)
+	comp := newComparator(SortOptions{})
+	if !comp.plainBytes(SortOptions{}) {
+		t.Fatal("whole lines in the C locale are not sorted as plain bytes")
+	}
+	want := slices.Clone(lines)
+	sort.Slice(want, func(i, j int) bool { return comp.compareLines(want[i], want[j]) < 0 })
+	if got := SortInMemory(slices.Clone(lines), SortOptions{}); !slices.Equal(got, want) {
+		t.Error("SortInMemory differs from sorting with the comparator")
+	}
+}
+
+// longSharedPrefix returns n lines that share a prefix of length bytes and
+// differ only at the end.
+func longSharedPrefix(length, n int) []string {
+	rng := rand.New(rand.NewSource(1))
+	prefix := strings.Repeat("x", length)
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%s%08d", prefix, rng.Intn(n))
+	}
+	return lines
+}
+
+// BenchmarkSortLongSharedPrefix compares the packed-prefix sort of whole
+// lines with the general comparator on long lines that differ only at the
+// end, and on short random lines where the prefix decides.
+func BenchmarkSortLongSharedPrefix(b *testing.B) {
+	fixtures := map[string][]string{
+		"shared-prefix": longSharedPrefix(256, benchSize()),
+		"random":        benchFixture("string", benchSize()),
+	}
+	for name, fixture := range fixtures {
+		b.Run(name+"/prefix", func(b *testing.B) {
+			lines := make([]string, len(fixture))
+			for b.Loop() {
+				copy(lines, fixture)
+				sortPlainBytes(lines, false, false)
+			}
+		})
+		b.Run(name+"/comparator", func(b *testing.B) {
+			lines := make([]string, len(fixture))
+			comp := newComparator(SortOptions{})
+			for b.Loop() {
+				copy(lines, fixture)
+				sort.Slice(lines, func(i, j int) bool { return comp.compareLines(lines[i], lines[j]) < 0 })
+			}
+		})
+	}
+}
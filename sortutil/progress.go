@@ -0,0 +1,73 @@
+package sortutil
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// progressInterval is the minimum time between two status lines of --progress.
+const progressInterval = time.Second
+
+// progress reports the state of a long external sort to opts.Progress.
+// The counters are kept even without output; a nil progress does nothing.
+type progress struct {
+	w      io.Writer
+	lines  int
+	chunks int
+	last   time.Time
+}
+
+func newProgress(opts SortOptions) *progress {
+	if opts.Progress == nil {
+		return nil
+	}
+	return &progress{w: opts.Progress, last: time.Now()}
+}
+
+// read counts n more input lines and prints the status at most once per progressInterval.
+func (p *progress) read(n int) {
+	if p == nil {
+		return
+	}
+	p.lines += n
+	p.tick()
+}
+
+// spilled counts a sorted chunk written to a temporary file.
+func (p *progress) spilled() {
+	if p == nil {
+		return
+	}
+	p.chunks++
+	p.tick()
+}
+
+// pass reports the start of merge pass n of total over files temporary files.
+func (p *progress) pass(n, total, files int) {
+	if p == nil {
+		return
+	}
+	fmt.Fprintf(p.w, "sort: read %d lines, spilled %d chunks; merge pass %d of %d: %d files\n",
+		p.lines, p.chunks, n, total, files)
+	p.last = time.Now()
+}
+
+// tick prints the read status unless it was printed less than progressInterval ago.
+func (p *progress) tick() {
+	if time.Since(p.last) < progressInterval {
+		return
+	}
+	fmt.Fprintf(p.w, "sort: read %d lines, spilled %d chunks\n", p.lines, p.chunks)
+	p.last = time.Now()
+}
+
+// mergePasses returns how many merge passes files temporary files need
+// with at most batch open at once, the final merge included.
+func mergePasses(files, batch int) int {
+	passes := 1
+	for ; files > batch; passes++ {
+		files = (files + batch - 1) / batch
+	}
+	return passes
+}
@@ -0,0 +1,65 @@
+package sortutil
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMergePasses(t *testing.T) {
+	cases := []struct {
+		files, batch, want int
+	}{
+		{0, maxOpenFiles, 1},
+		{1, maxOpenFiles, 1},
+		{maxOpenFiles, maxOpenFiles, 1},
+		{maxOpenFiles + 1, maxOpenFiles, 2},
+		{maxOpenFiles * maxOpenFiles, maxOpenFiles, 2},
+		{maxOpenFiles*maxOpenFiles + 1, maxOpenFiles, 3},
+		{8, 2, 3},
+		{9, 2, 4},
+		{9, 3, 2},
+	}
+	for _, c := range cases {
+		if got := mergePasses(c.files, c.batch); got != c.want {
+			t.Errorf("mergePasses(%d, %d) = %d, want %d", c.files, c.batch, got, c.want)
+		}
+	}
+}
+
+// TestSortProgress checks that an external sort prints no progress without
+// SortOptions.Progress and one line per merge pass with it.
+func TestSortProgress(t *testing.T) {
+	lines := numberedLines("line", 3000)
+	input := joinLines(lines)
+	// Порции в несколько строк: больше maxOpenFiles временных файлов, слияние в два прохода
+	limit := 10 * (len(lines[0]) + 16)
+	externalText := func(opts SortOptions) string {
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(input), &out, opts, limit); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	if report := captureStderr(t, func() { externalText(SortOptions{}) }); report != "" {
+		t.Errorf("progress without --progress: %q", report)
+	}
+
+	var progress bytes.Buffer
+	if got := externalText(SortOptions{Progress: &progress}); got != sortText(t, input, SortOptions{}) {
+		t.Error("output with --progress differs")
+	}
+	report := strings.Split(strings.TrimSpace(progress.String()), "\n")
+	if passes := strings.Count(progress.String(), "merge pass "); passes != 2 {
+		t.Errorf("%d merge pass lines, want 2:\n%s", passes, progress.String())
+	}
+	if last := report[len(report)-1]; !strings.HasPrefix(last, "sort: read 3000 lines, spilled ") || !strings.Contains(last, "merge pass 2 of 2") {
+		t.Errorf("last progress line %q", last)
+	}
+	for _, line := range report {
+		if !strings.HasPrefix(line, "sort: read ") {
+			t.Errorf("unexpected progress line %q", line)
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package sortutil
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// terminator returns the string that ends every input record: '\n', NUL for -z
+// or --input-zero, or the --record-separator. Temporary files use it too,
+// because newTempReader reads them back with the same separator.
+func (opts SortOptions) terminator() string {
+	switch {
+	case opts.RecordSeparator != "":
+		return opts.RecordSeparator
+	case opts.zeroInput():
+		return "\x00"
+	}
+	return "\n"
+}
+
+// outputTerminator returns the string that ends every output record:
+// like terminator, but NUL for -z or --output-zero and CRLF for --output-crlf.
+func (opts SortOptions) outputTerminator() string {
+	switch {
+	case opts.RecordSeparator != "":
+		return opts.RecordSeparator
+	case opts.ZeroTerminated || opts.OutputZero:
+		return "\x00"
+	case opts.OutputCRLF:
+		return "\r\n"
+	}
+	return "\n"
+}
+
+func (opts SortOptions) zeroInput() bool {
+	return opts.ZeroTerminated || opts.InputZero
+}
+
+// NewLineReader returns the scanner every input is read with: the input of Sort,
+// -m inputs and -c. The record separator (\n, NUL for -z, --record-separator),
+// the buffer size and --max-line-length are set here once.
+func NewLineReader(r io.Reader, opts SortOptions) *bufio.Scanner {
+	s := newTempReader(r, opts)
+	s.Split(opts.inputSplit())
+	return s
+}
+
+// newTempReader returns the scanner for records written by the sort itself:
+// temporary files and merge pipes. --max-line-length does not apply to them:
+// the records were checked on input, and --prepend-index may have lengthened
+// them.
+func newTempReader(r io.Reader, opts SortOptions) *bufio.Scanner {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, lineReaderBuffer), opts.maxRecordSize())
+	s.Split(opts.splitFunc())
+	return s
+}
+
+// lineReaderBuffer is the initial buffer size of NewLineReader; it grows for longer records.
+const lineReaderBuffer = 64 * 1024
+
+// maxRecordSize returns the longest record NewLineReader accepts.
+// By default a record may take the whole memory limit of the sort rather than
+// the 64 KB of bufio.
+func (opts SortOptions) maxRecordSize() int {
+	if opts.MaxRecordSize > 0 {
+		return opts.MaxRecordSize
+	}
+	return maxMemoryBytes
+}
+
+// splitFunc returns the bufio.SplitFunc for records of opts.
+func (opts SortOptions) splitFunc() bufio.SplitFunc {
+	split := bufio.ScanLines
+	switch {
+	case opts.RecordSeparator != "":
+		split = scanSeparated(opts.RecordSeparator)
+	case opts.zeroInput():
+		split = scanZeroTerminated
+	}
+	if opts.DropPartial {
+		split = dropPartial(split, opts.terminator())
+	}
+	if (opts.StripNUL || opts.RejectNUL) && !opts.zeroInput() {
+		split = embeddedNUL(split, opts.RejectNUL)
+	}
+	return split
+}
+
+// inputSplit returns splitFunc with the options that apply to input records only:
+// --max-line-length and --skip-blank.
+func (opts SortOptions) inputSplit() bufio.SplitFunc {
+	split := opts.splitFunc()
+	if opts.MaxLineLength > 0 {
+		split = lineLimit(split, opts.terminator(), opts.MaxLineLength, opts.TruncateLong)
+	}
+	if opts.SkipBlank {
+		split = skipBlankLines(split, opts.IgnoreBlanks)
+	}
+	return split
+}
+
+// skipBlankLines wraps split to drop empty records and, with allBlank (-b),
+// records of only spaces and tabs (--skip-blank).
+func skipBlankLines(split bufio.SplitFunc, allBlank bool) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		// Сканер не вызывает split повторно после пропущенной записи, поэтому пропуск — в цикле
+		consumed := 0
+		for {
+			advance, token, err := split(data[consumed:], atEOF)
+			consumed += advance
+			if token == nil || err != nil {
+				return consumed, token, err
+			}
+			if len(token) > 0 && (!allBlank || len(bytes.Trim(token, " \t")) > 0) {
+				return consumed, token, nil
+			}
+			if advance == 0 {
+				return consumed, nil, nil
+			}
+		}
+	}
+}
+
+// lineLimit wraps split to fail on the first record longer than n bytes or,
+// with truncate, to cut such records to n bytes. A long record is detected
+// before it is read in full, so the scanner buffer never grows past n: the
+// rest of a truncated record is skipped up to the separator.
+func lineLimit(split bufio.SplitFunc, term string, n int, truncate bool) bufio.SplitFunc {
+	record := 0
+	skipping := false
+	tooLong := func() error {
+		return fmt.Errorf("sort: line %d is longer than %d bytes (--max-line-length)", record, n)
+	}
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		// Как и в footer.hold, пропуск без записи на выход продолжается здесь же
+		consumed := 0
+		for {
+			rest := data[consumed:]
+			if skipping {
+				i := bytes.Index(rest, []byte(term))
+				if i < 0 {
+					return len(data), nil, nil
+				}
+				consumed += i + len(term)
+				skipping = false
+				continue
+			}
+			advance, token, err := split(rest, atEOF)
+			switch {
+			case err != nil:
+				return consumed + advance, token, err
+			case token != nil:
+				record++
+				if len(token) <= n {
+					return consumed + advance, token, nil
+				}
+				if !truncate {
+					return 0, nil, tooLong()
+				}
+				return consumed + advance, token[:n], nil
+			case advance == 0:
+				// Разделителя ещё нет, но запись уже длиннее n
+				if len(rest) <= n+len(term) {
+					return consumed, nil, nil
+				}
+				record++
+				if !truncate {
+					return 0, nil, tooLong()
+				}
+				skipping = true
+				return len(data), rest[:n], nil
+			}
+			consumed += advance
+		}
+	}
+}
+
+// embeddedNUL wraps split to strip NUL bytes from records or, with reject,
+// to fail on the first record that contains one. Without -z a NUL inside a line
+// usually means binary input or a forgotten -z.
+func embeddedNUL(split bufio.SplitFunc, reject bool) bufio.SplitFunc {
+	record := 0
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if token == nil {
+			return advance, token, err
+		}
+		record++
+		if bytes.IndexByte(token, 0) < 0 {
+			return advance, token, err
+		}
+		if reject {
+			return 0, nil, fmt.Errorf("sort: line %d contains a NUL byte (use -z for NUL-terminated input)", record)
+		}
+		return advance, bytes.ReplaceAll(token, []byte{0}, nil), err
+	}
+}
+
+// dropPartial wraps split so that a final record without the terminator,
+// such as a line still being appended to a log, is skipped.
+func dropPartial(split bufio.SplitFunc, term string) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) > 0 && !bytes.Contains(data, []byte(term)) {
+			return len(data), nil, nil
+		}
+		return split(data, atEOF)
+	}
+}
+
+// scanZeroTerminated is a bufio.SplitFunc for NUL-terminated records.
+func scanZeroTerminated(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// scanSeparated returns a bufio.SplitFunc for records ending with sep,
+// such as "\n\n" for paragraphs; records may span several lines.
+func scanSeparated(sep string) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.Index(data, []byte(sep)); i >= 0 {
+			return i + len(sep), data[:i], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// indexSeparator returns what --prepend-index puts after the index and
+// --strip-index removes with it: the -t separator or a tab.
+func (opts SortOptions) indexSeparator() string {
+	if opts.Separator != "" {
+		return opts.Separator
+	}
+	return "\t"
+}
+
+// prependIndex wraps split to prefix every record with its number in the input
+// (from 1) and sep, so that the original order can be restored by sorting on field 1.
+func prependIndex(split bufio.SplitFunc, sep string) bufio.SplitFunc {
+	record := 0
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if token == nil {
+			return advance, token, err
+		}
+		record++
+		indexed := strconv.AppendInt(nil, int64(record), 10)
+		indexed = append(indexed, sep...)
+		return advance, append(indexed, token...), err
+	}
+}
+
+// stripIndex removes the first field and the separator after it from record
+// (--strip-index). Without -t the field ends at the first space or tab after it.
+func stripIndex(record, sep string) string {
+	if sep != "\t" || strings.Contains(record, sep) {
+		if _, rest, ok := strings.Cut(record, sep); ok {
+			return rest
+		}
+		return ""
+	}
+	end := skipNonBlanks(record, skipBlanks(record, 0, len(record)))
+	if end < len(record) {
+		end++
+	}
+	return record[end:]
+}
+
+// footer holds back the last n records of a stream for --footer: they are
+// known only at the end, so each record is released n records late.
+type footer struct {
+	n     int
+	lines []string
+}
+
+// hold wraps split so that the scanner never returns the last f.n records;
+// after the end of input they are left in f.lines.
+func (f *footer) hold(split bufio.SplitFunc) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		// Сканер не вызывает split повторно, если запись не вернули, поэтому
+		// удержанные записи пропускаются здесь же, пока не найдётся запись на выход
+		consumed := 0
+		for {
+			advance, token, err := split(data[consumed:], atEOF)
+			consumed += advance
+			if token == nil || err != nil {
+				return consumed, token, err
+			}
+			f.lines = append(f.lines, string(token))
+			if len(f.lines) > f.n {
+				released := f.lines[0]
+				f.lines = f.lines[1:]
+				return consumed, []byte(released), nil
+			}
+			if advance == 0 {
+				return consumed, nil, nil
+			}
+		}
+	}
+}
+
+// JoinInputs concatenates the inputs into one stream of records, like GNU sort
+// does with several files: a file whose last record lacks the terminator does
+// not run into the first record of the next file.
+func JoinInputs(inputs []io.Reader, opts SortOptions) io.Reader {
+	if len(inputs) == 1 {
+		return inputs[0]
+	}
+	readers := make([]io.Reader, len(inputs))
+	for i, r := range inputs {
+		readers[i] = &terminatedReader{r: r, term: opts.terminator()}
+	}
+	return io.MultiReader(readers...)
+}
+
+// terminatedReader appends term to r if r is not empty and does not end with it.
+type terminatedReader struct {
+	r       io.Reader
+	term    string
+	tail    []byte // последние len(term) прочитанных байт
+	eof     bool
+	pending string // недописанный терминатор после конца r
+}
+
+func (t *terminatedReader) Read(p []byte) (int, error) {
+	if t.eof {
+		if t.pending == "" {
+			return 0, io.EOF
+		}
+		n := copy(p, t.pending)
+		t.pending = t.pending[n:]
+		return n, nil
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.tail = append(t.tail, p[:n]...)
+		if extra := len(t.tail) - len(t.term); extra > 0 {
+			t.tail = append(t.tail[:0], t.tail[extra:]...)
+		}
+	}
+	if err == io.EOF {
+		t.eof = true
+		if len(t.tail) > 0 && string(t.tail) != t.term {
+			t.pending = t.term
+		}
+		err = nil
+	}
+	return n, err
+}
+
+// recordWriter is the single place where output records get their terminator.
+// Every output (stdout, temporary files) writes through it only.
+type recordWriter struct {
+	w         *bufio.Writer
+	term      string
+	flushEach bool // --unbuffered: сбрасывать буфер после каждой записи
+
+	group    *comparator // --group: пустая запись между группами равных ключей
+	unique   *comparator // --require-unique: равные ключи соседних записей — ошибка
+	numbered *comparator // --enumerate-groups: перед записью номер группы равных ключей
+	groupNum int
+	groupSep string
+	prev     string
+	hasPrev  bool
+	sorted   int         // число выведенных отсортированных записей, для сообщения --require-unique
+	ties     *comparator // --warn-ties: равные ключи соседних разных записей без последнего сравнения
+	tieCount int         // число таких пар
+	stable   *stability  // --assert-stable: проверка порядка ввода для равных ключей с -s
+	keysOnly *comparator // --only-keys: вместо записи выводятся её ключи
+	padKey   KeySpec     // --pad-width: ключ, число в котором дополняется нулями
+	padWidth int
+	stripSep string // --strip-index: первое поле до этого разделителя удаляется
+	tally    *tally // --verify: учёт записей до всех преобразований вывода
+}
+
+func newRecordWriter(w io.Writer, opts SortOptions) *recordWriter {
+	return &recordWriter{w: bufio.NewWriter(w), term: opts.terminator()}
+}
+
+// newOutputWriter returns the writer for the final output, which honours
+// --unbuffered and --output-zero; temporary files are always buffered.
+func newOutputWriter(w io.Writer, opts SortOptions) *recordWriter {
+	rw := newRecordWriter(w, opts)
+	rw.term = opts.outputTerminator()
+	rw.flushEach = opts.Unbuffered
+	return rw
+}
+
+// beginSorted switches on the options that apply to sorted records only:
+// --require-unique, --group, --enumerate-groups, --only-keys, --pad-width, --strip-index
+// (or --key-prefix) and --warn-ties. It is called after the header, which is
+// written as is.
+func (rw *recordWriter) beginSorted(opts SortOptions) {
+	// Порядок равных ключей не определён, только если нет ни последнего сравнения, ни -s
+	if opts.WarnTies && opts.NoLastResort && !opts.Stable {
+		rw.ties = newComparator(opts)
+		rw.hasPrev = false
+	}
+	if opts.Group {
+		rw.group = newComparator(opts)
+		rw.hasPrev = false
+	}
+	if opts.RequireUnique {
+		rw.unique = newComparator(opts)
+		rw.hasPrev = false
+	}
+	if opts.EnumerateGroups {
+		rw.numbered = newComparator(opts)
+		rw.groupNum, rw.groupSep = 0, opts.indexSeparator()
+		rw.hasPrev = false
+	}
+	if opts.OnlyKeys {
+		rw.keysOnly = newComparator(opts)
+	}
+	if opts.PadWidth > 0 {
+		rw.padKey = newComparator(opts).keys[0]
+		rw.padWidth = opts.PadWidth
+	}
+	if opts.StripIndex || opts.KeyPrefix {
+		rw.stripSep = opts.indexSeparator()
+	}
+}
+
+// endSorted switches the options of beginSorted off again for the --footer,
+// which is written as is, like the header.
+func (rw *recordWriter) endSorted() {
+	rw.group, rw.unique, rw.numbered, rw.keysOnly, rw.padWidth, rw.stripSep = nil, nil, nil, nil, 0, ""
+	rw.ties, rw.stable = nil, nil
+}
+
+// write outputs one record followed by the terminator.
+func (rw *recordWriter) write(record string) error {
+	if rw.stable != nil {
+		record = rw.stable.output(record)
+	}
+	if rw.tally != nil {
+		rw.tally.add([]byte(record))
+	}
+	if rw.unique != nil {
+		rw.sorted++
+		if rw.hasPrev && rw.unique.duplicate(rw.prev, record) {
+			return newDuplicate(rw.sorted-1, rw.prev, record)
+		}
+	}
+	if rw.group != nil && rw.hasPrev && rw.group.compareKeys(rw.prev, record) != 0 {
+		if _, err := rw.w.WriteString(rw.term); err != nil {
+			return err
+		}
+	}
+	if rw.numbered != nil && (!rw.hasPrev || rw.numbered.compareKeys(rw.prev, record) != 0) {
+		rw.groupNum++
+	}
+	if rw.ties != nil && rw.hasPrev && rw.prev != record && rw.ties.compareKeys(rw.prev, record) == 0 {
+		rw.tieCount++
+	}
+	if rw.group != nil || rw.unique != nil || rw.numbered != nil || rw.ties != nil {
+		rw.prev, rw.hasPrev = record, true
+	}
+	if rw.padWidth > 0 {
+		record = padNumber(record, rw.padKey, rw.padWidth)
+	}
+	if rw.stripSep != "" {
+		record = stripIndex(record, rw.stripSep)
+	}
+	if rw.keysOnly != nil {
+		record = rw.keysOnly.keyText(record)
+	}
+	if rw.numbered != nil {
+		record = strconv.Itoa(rw.groupNum) + rw.groupSep + record
+	}
+	if _, err := rw.w.WriteString(record); err != nil {
+		return err
+	}
+	if _, err := rw.w.WriteString(rw.term); err != nil {
+		return err
+	}
+	if rw.flushEach {
+		return rw.w.Flush()
+	}
+	return nil
+}
+
+func (rw *recordWriter) flush() error {
+	return rw.w.Flush()
+}
+
+// reportTies warns on stderr about the adjacent records counted by --warn-ties.
+func (rw *recordWriter) reportTies() {
+	if rw.tieCount > 0 {
+		fmt.Fprintf(os.Stderr, "sort: warning: %d pairs of adjacent lines have equal keys but differ; "+
+			"without a last-resort comparison their order may change between runs, use -s or a more specific key\n", rw.tieCount)
+	}
+}
@@ -0,0 +1,778 @@
+package sortutil
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestScanZeroTerminated(t *testing.T) {
+	cases := []struct {
+		input string
+		want  []string
+	}{
+		{"", nil},
+		{"a\x00b\x00", []string{"a", "b"}},
+		{"a\x00b", []string{"a", "b"}},
+		{"a\nb\x00\x00c\n\x00", []string{"a\nb", "", "c\n"}},
+	}
+	for _, c := range cases {
+		s := bufio.NewScanner(strings.NewReader(c.input))
+		s.Split(scanZeroTerminated)
+		var got []string
+		for s.Scan() {
+			got = append(got, s.Text())
+		}
+		if strings.Join(got, "|") != strings.Join(c.want, "|") || len(got) != len(c.want) {
+			t.Errorf("scan %q = %q, want %q", c.input, got, c.want)
+		}
+	}
+}
+
+// TestZeroTerminatedRoundTrip sorts NUL-terminated records with embedded
+// newlines in memory, through temporary files and through a multi-level merge:
+// every record keeps its newlines and ends with NUL, with no '\n' added.
+func TestZeroTerminatedRoundTrip(t *testing.T) {
+	var input, want bytes.Buffer
+	lines := numberedLines("rec\nline", 1000)
+	for _, line := range lines {
+		input.WriteString(line + "\x00")
+	}
+	for _, line := range SortInMemory(append([]string(nil), lines...), SortOptions{}) {
+		want.WriteString(line + "\x00")
+	}
+	opts := SortOptions{ZeroTerminated: true}
+	for _, limit := range []int{1 << 20, 5000, 100} {
+		var out bytes.Buffer
+		if err := ExternalSortReader(bytes.NewReader(input.Bytes()), &out, opts, limit); err != nil {
+			t.Fatalf("limit %d: %v", limit, err)
+		}
+		if out.String() != want.String() {
+			t.Errorf("limit %d: -z output differs from the expected records", limit)
+		}
+	}
+	if got := sortText(t, input.String(), opts); got != want.String() {
+		t.Errorf("Sort -z: output differs from the expected records")
+	}
+
+	// Последняя запись без NUL всё равно выводится с ним
+	if got := sortText(t, "b\x00a", opts); got != "a\x00b\x00" {
+		t.Errorf("Sort -z without a final NUL = %q", got)
+	}
+}
+
+// TestTerminatorCombinations checks all four combinations of input and output
+// terminators, in memory and through temporary files.
+func TestTerminatorCombinations(t *testing.T) {
+	lines := numberedLines("rec", 500)
+	sorted := SortInMemory(slices.Clone(lines), SortOptions{})
+	join := func(lines []string, term string) string {
+		return strings.Join(lines, term) + term
+	}
+	tests := []struct {
+		name    string
+		opts    SortOptions
+		in, out string
+	}{
+		{"newline to newline", SortOptions{}, "\n", "\n"},
+		{"newline to NUL", SortOptions{OutputZero: true}, "\n", "\x00"},
+		{"NUL to newline", SortOptions{InputZero: true}, "\x00", "\n"},
+		{"NUL to NUL", SortOptions{InputZero: true, OutputZero: true}, "\x00", "\x00"},
+	}
+	for _, tt := range tests {
+		input, want := join(lines, tt.in), join(sorted, tt.out)
+		if got := sortText(t, input, tt.opts); got != want {
+			t.Errorf("%s: in-memory output differs from the expected records", tt.name)
+		}
+		for _, limit := range []int{5000, 100} {
+			var out bytes.Buffer
+			if err := ExternalSortReader(strings.NewReader(input), &out, tt.opts, limit); err != nil {
+				t.Fatalf("%s, limit %d: %v", tt.name, limit, err)
+			}
+			if out.String() != want {
+				t.Errorf("%s, limit %d: external output differs from the expected records", tt.name, limit)
+			}
+		}
+	}
+}
+
+// TestDropPartial simulates a log caught in the middle of an append: the
+// final line has no terminator yet. By default it is kept as a whole record,
+// with --partial-line drop it is skipped, in memory and through temporary files.
+func TestDropPartial(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"keep", "b\na\nc", SortOptions{}, "a\nb\nc\n"},
+		{"drop", "b\na\nc", SortOptions{DropPartial: true}, "a\nb\n"},
+		{"drop complete input", "b\na\nc\n", SortOptions{DropPartial: true}, "a\nb\nc\n"},
+		{"drop the only line", "partial", SortOptions{DropPartial: true}, ""},
+		{"drop -z", "b\x00a\x00c\n", SortOptions{DropPartial: true, ZeroTerminated: true}, "a\x00b\x00"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: Sort = %q, want %q", c.name, got, c.want)
+		}
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(c.input), &out, c.opts, 2); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != c.want {
+			t.Errorf("%s: ExternalSortReader = %q, want %q", c.name, out.String(), c.want)
+		}
+	}
+}
+
+// TestUnbufferedMerge streams lines through a merge of stdin into an io.Pipe:
+// with --unbuffered every line comes out as soon as it has been read, while
+// the buffered output only appears once the input ends.
+func TestUnbufferedMerge(t *testing.T) {
+	for _, unbuffered := range []bool{true, false} {
+		inR, inW, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved := os.Stdin
+		os.Stdin = inR
+		outR, outW := io.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			err := MergeSorted([]string{"-"}, outW, SortOptions{Unbuffered: unbuffered})
+			outW.CloseWithError(err)
+			done <- err
+		}()
+
+		lines := make(chan string)
+		go func() {
+			defer close(lines)
+			s := bufio.NewScanner(outR)
+			for s.Scan() {
+				lines <- s.Text()
+			}
+		}()
+		for _, line := range []string{"a", "b", "c"} {
+			if _, err := io.WriteString(inW, line+"\n"); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case got := <-lines:
+				if !unbuffered {
+					t.Errorf("buffered: %q came out before the end of input", got)
+				} else if got != line {
+					t.Errorf("unbuffered: got %q, want %q", got, line)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if unbuffered {
+					t.Errorf("unbuffered: %q did not come out", line)
+				}
+			}
+		}
+		_ = inW.Close()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		var rest []string
+		for line := range lines {
+			rest = append(rest, line)
+		}
+		want := 0
+		if !unbuffered {
+			want = 3
+		}
+		if len(rest) != want {
+			t.Errorf("unbuffered=%v: %d lines after the end of input, want %d", unbuffered, len(rest), want)
+		}
+		os.Stdin = saved
+		_ = inR.Close()
+	}
+}
+
+// TestGroup checks that --group puts an empty record exactly between groups
+// of equal keys, by the same comparison as the sort, in memory and through
+// temporary files.
+func TestGroup(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"lines", "b\na\nb\nc\na\n", SortOptions{Group: true}, "a\na\n\nb\nb\n\nc\n"},
+		{"one group", "a\na\n", SortOptions{Group: true}, "a\na\n"},
+		{"empty", "", SortOptions{Group: true}, ""},
+		{"key", "x 2\ny 1\nz 2\n", SortOptions{Group: true, Keys: []KeySpec{{StartField: 2, Numeric: true}}},
+			"y 1\n\nx 2\nz 2\n"},
+		{"numeric values", "07\n7\n8\n", SortOptions{Group: true, Numeric: true}, "07\n7\n\n8\n"},
+		{"unique", "b\na\nb\n", SortOptions{Group: true, Unique: true}, "a\n\nb\n"},
+		{"header", "h\nb\na\na\n", SortOptions{Group: true, Header: 1}, "h\na\na\n\nb\n"},
+		{"zero terminated", "b\x00a\x00b\x00", SortOptions{Group: true, ZeroTerminated: true}, "a\x00\x00b\x00b\x00"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: Sort = %q, want %q", c.name, got, c.want)
+		}
+		if c.opts.Header > 0 {
+			continue
+		}
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(c.input), &out, c.opts, 2); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != c.want {
+			t.Errorf("%s: ExternalSortReader = %q, want %q", c.name, out.String(), c.want)
+		}
+	}
+}
+
+// TestEnumerateGroups checks that --enumerate-groups numbers groups from 1
+// and increments the number only where the key changes, in memory and
+// through temporary files.
+func TestEnumerateGroups(t *testing.T) {
+	byField := []KeySpec{{StartField: 2, Numeric: true}}
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"lines", "b\na\nb\nc\na\n", SortOptions{EnumerateGroups: true}, "1\ta\n1\ta\n2\tb\n2\tb\n3\tc\n"},
+		{"one group", "a\na\n", SortOptions{EnumerateGroups: true}, "1\ta\n1\ta\n"},
+		{"empty", "", SortOptions{EnumerateGroups: true}, ""},
+		{"key", "x 2\ny 1\nz 2\nw 3\n", SortOptions{EnumerateGroups: true, Keys: byField},
+			"1\ty 1\n2\tx 2\n2\tz 2\n3\tw 3\n"},
+		{"separator", "b,2\na,1\nc,2\n", SortOptions{EnumerateGroups: true, Separator: ",", Keys: byField},
+			"1,a,1\n2,b,2\n2,c,2\n"},
+		{"unique", "b\na\nb\n", SortOptions{EnumerateGroups: true, Unique: true}, "1\ta\n2\tb\n"},
+		{"reverse", "a\nb\na\n", SortOptions{EnumerateGroups: true, Reverse: true}, "1\tb\n2\ta\n2\ta\n"},
+		{"with group", "b\na\nb\n", SortOptions{EnumerateGroups: true, Group: true}, "1\ta\n\n2\tb\n2\tb\n"},
+		{"header", "h\nb\na\n", SortOptions{EnumerateGroups: true, Header: 1}, "h\n1\ta\n2\tb\n"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: Sort = %q, want %q", c.name, got, c.want)
+		}
+		if c.opts.Header > 0 {
+			continue
+		}
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(c.input), &out, c.opts, 2); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != c.want {
+			t.Errorf("%s: ExternalSortReader = %q, want %q", c.name, out.String(), c.want)
+		}
+	}
+}
+
+// TestJoinInputs checks that joined inputs keep their records apart: a
+// missing terminator is added between inputs, an empty input adds nothing,
+// and a terminator split across reads is still recognised.
+func TestJoinInputs(t *testing.T) {
+	cases := []struct {
+		name   string
+		inputs []string
+		opts   SortOptions
+		want   string
+	}{
+		{"terminated", []string{"a\n", "b\n"}, SortOptions{}, "a\nb\n"},
+		{"unterminated", []string{"a", "b"}, SortOptions{}, "a\nb\n"},
+		{"empty input", []string{"a", "", "b\n"}, SortOptions{}, "a\nb\n"},
+		{"zero terminated", []string{"a\n", "b"}, SortOptions{ZeroTerminated: true}, "a\n\x00b\x00"},
+		{"record separator", []string{"a\n", "b\n\n"}, SortOptions{RecordSeparator: "\n\n"}, "a\n\n\nb\n\n"},
+	}
+	for _, c := range cases {
+		readers := make([]io.Reader, len(c.inputs))
+		for i, input := range c.inputs {
+			// По байту за чтение: терминатор из двух байт приходит по частям
+			readers[i] = iotest.OneByteReader(strings.NewReader(input))
+		}
+		got, err := io.ReadAll(JoinInputs(readers, c.opts))
+		if err != nil || string(got) != c.want {
+			t.Errorf("%s: join %q = %q (%v), want %q", c.name, c.inputs, got, err, c.want)
+		}
+	}
+
+	// Последняя строка первого файла не сливается с первой строкой второго
+	joined := JoinInputs([]io.Reader{strings.NewReader("c\nb"), strings.NewReader("a\n")}, SortOptions{})
+	var out bytes.Buffer
+	if err := Sort(joined, &out, SortOptions{}); err != nil || out.String() != "a\nb\nc\n" {
+		t.Errorf("sort of joined inputs = %q (%v), want %q", out.String(), err, "a\nb\nc\n")
+	}
+}
+
+// TestPrependIndex checks that --prepend-index numbers records in input order
+// before sorting, that the number restores that order, and that --strip-index
+// removes it again from the output.
+func TestPrependIndex(t *testing.T) {
+	key := func(spec string) []KeySpec {
+		k, err := ParseKeySpec(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []KeySpec{k}
+	}
+	input := "b 2\na 1\nc 2\n"
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"prepend", input, SortOptions{PrependIndex: true, Keys: key("3,3n")}, "2\ta 1\n1\tb 2\n3\tc 2\n"},
+		{"prepend and strip", input, SortOptions{PrependIndex: true, StripIndex: true, Keys: key("3,3n")}, "a 1\nb 2\nc 2\n"},
+		{"restore order", "2\ta 1\n1\tb 2\n3\tc 2\n", SortOptions{StripIndex: true, Keys: key("1,1n")}, input},
+		{"separator", "b:2\na:1\n", SortOptions{PrependIndex: true, Separator: ":", Keys: key("3,3n")}, "2:a:1\n1:b:2\n"},
+		{"strip with separator", "2:a:1\n1:b:2\n", SortOptions{StripIndex: true, Separator: ":"}, "b:2\na:1\n"},
+		{"zero terminated", "b\x00a\x00", SortOptions{PrependIndex: true, ZeroTerminated: true}, "1\tb\x002\ta\x00"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// TestStripIndex removes the first field: up to the -t separator, or without
+// -t up to the first blank after it.
+func TestStripIndex(t *testing.T) {
+	cases := []struct {
+		record, sep, want string
+	}{
+		{"12\tline", "\t", "line"},
+		{"12 line with spaces", "\t", "line with spaces"},
+		{"  12  line", "\t", " line"},
+		{"12", "\t", ""},
+		{"12:a:b", ":", "a:b"},
+		{"12", ":", ""},
+	}
+	for _, c := range cases {
+		if got := stripIndex(c.record, c.sep); got != c.want {
+			t.Errorf("stripIndex(%q, %q) = %q, want %q", c.record, c.sep, got, c.want)
+		}
+	}
+}
+
+// TestKeyPrefix sorts by a precomputed first column and outputs the lines
+// without it.
+func TestKeyPrefix(t *testing.T) {
+	input := "10\tten apples\n9\tnine\n100\thundred\n9\tanother nine\n"
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"numeric", input, SortOptions{Numeric: true}, "another nine\nnine\nten apples\nhundred\n"},
+		{"numeric reverse", input, SortOptions{Numeric: true, Reverse: true}, "hundred\nten apples\nnine\nanother nine\n"},
+		{"text", input, SortOptions{}, "ten apples\nhundred\nanother nine\nnine\n"},
+		{"stable", input, SortOptions{Numeric: true, Stable: true}, "nine\nanother nine\nten apples\nhundred\n"},
+		{"separator", "b:x:1\na:y:2\n", SortOptions{Separator: ":"}, "y:2\nx:1\n"},
+		{"external", input, SortOptions{Numeric: true, BufferSize: 1, TempDirs: []string{t.TempDir()}}, "another nine\nnine\nten apples\nhundred\n"},
+	}
+	for _, c := range cases {
+		c.opts.KeyPrefix = true
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// TestScanSeparated splits input on a multi-byte record separator.
+func TestScanSeparated(t *testing.T) {
+	cases := []struct {
+		input, sep string
+		want       []string
+	}{
+		{"", "\n\n", nil},
+		{"a\nb\n\nc\n\n", "\n\n", []string{"a\nb", "c"}},
+		{"a\n\nb", "\n\n", []string{"a", "b"}},
+		{"a\n\n\n\nb\n\n", "\n\n", []string{"a", "", "b"}},
+		{"x--y--", "--", []string{"x", "y"}},
+		{"x-y--z", "--", []string{"x-y", "z"}},
+	}
+	for _, c := range cases {
+		s := bufio.NewScanner(strings.NewReader(c.input))
+		s.Buffer(make([]byte, 1), 64) // маленький буфер: разделитель попадает на границу чтения
+		s.Split(scanSeparated(c.sep))
+		var got []string
+		for s.Scan() {
+			got = append(got, s.Text())
+		}
+		if s.Err() != nil || !slices.Equal(got, c.want) {
+			t.Errorf("scan %q by %q = %q (%v), want %q", c.input, c.sep, got, s.Err(), c.want)
+		}
+	}
+}
+
+// TestSortParagraphs sorts blank-line separated records as wholes, keyed by
+// the first line, by another line or by the whole record, in memory and
+// through temporary files.
+func TestSortParagraphs(t *testing.T) {
+	input := "name: bob\nage: 42\n\nname: alice\nage: 7\n\nname: carol\nage: 30\n\n"
+	cases := []struct {
+		name string
+		opts SortOptions
+		want string
+	}{
+		{"first line", SortOptions{RecordKeyLine: 1},
+			"name: alice\nage: 7\n\nname: bob\nage: 42\n\nname: carol\nage: 30\n\n"},
+		{"second line numeric", SortOptions{RecordKeyLine: 2, Keys: []KeySpec{{StartField: 2, Numeric: true}}},
+			"name: alice\nage: 7\n\nname: carol\nage: 30\n\nname: bob\nage: 42\n\n"},
+		{"whole record reverse", SortOptions{RecordKeyLine: 0, Reverse: true},
+			"name: carol\nage: 30\n\nname: bob\nage: 42\n\nname: alice\nage: 7\n\n"},
+		// Третьей строки нет: ключи пусты и решает сравнение целых записей
+		{"missing line", SortOptions{RecordKeyLine: 3, Keys: []KeySpec{{StartField: 2, Numeric: true}}},
+			"name: alice\nage: 7\n\nname: bob\nage: 42\n\nname: carol\nage: 30\n\n"},
+	}
+	for _, c := range cases {
+		c.opts.RecordSeparator = "\n\n"
+		var got string
+		// Без третьей строки Sort подсказывает про -t; здесь это не проверяется
+		captureStderr(t, func() { got = sortText(t, input, c.opts) })
+		if got != c.want {
+			t.Errorf("%s: Sort = %q, want %q", c.name, got, c.want)
+		}
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(input), &out, c.opts, 40); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != c.want {
+			t.Errorf("%s: ExternalSortReader = %q, want %q", c.name, out.String(), c.want)
+		}
+	}
+}
+
+// TestOnlyKeys checks that --only-keys prints the keys of the sorted lines,
+// in memory, through temporary files and when merging.
+func TestOnlyKeys(t *testing.T) {
+	input := "x 3\ny 1\nz 2\n"
+	// Как в GNU, поле без -b начинается с предшествующего пробела
+	want := " 1\n 2\n 3\n"
+	opts := SortOptions{Keys: []KeySpec{{StartField: 2}}, OnlyKeys: true}
+	if got := sortText(t, input, opts); got != want {
+		t.Errorf("Sort: got %q, want %q", got, want)
+	}
+
+	var out bytes.Buffer
+	if err := ExternalSortReader(strings.NewReader(input), &out, opts, 8); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("ExternalSortReader: got %q, want %q", out.String(), want)
+	}
+
+	// Ключей два: они выводятся через разделитель -t
+	opts = SortOptions{Keys: []KeySpec{{StartField: 3, EndField: 3}, {StartField: 1, EndField: 1}}, Separator: ":", OnlyKeys: true}
+	if got := sortText(t, "a:x:2\nb:y:1\n", opts); got != "1:b\n2:a\n" {
+		t.Errorf("two keys: got %q, want %q", got, "1:b\n2:a\n")
+	}
+}
+
+// TestUniqueOnlyKeys checks -u --only-keys: the output is the sorted set of
+// distinct keys, each once, in memory and through temporary files.
+func TestUniqueOnlyKeys(t *testing.T) {
+	input := "a red\nb green\nc red\nd blue\ne green\nf Red\n"
+	parse := func(spec string) []KeySpec {
+		k, err := ParseKeySpec(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []KeySpec{k}
+	}
+	cases := []struct {
+		name string
+		opts SortOptions
+		want string
+	}{
+		{"blank key", SortOptions{IgnoreBlanks: true, Keys: parse("2,2")}, "Red\nblue\ngreen\nred\n"},
+		{"key with blank", SortOptions{Keys: parse("2,2")}, " Red\n blue\n green\n red\n"},
+		{"separator", SortOptions{Separator: " ", Keys: parse("2,2")}, "Red\nblue\ngreen\nred\n"},
+		{"fold case", SortOptions{IgnoreBlanks: true, FoldCase: true, Keys: parse("2,2")}, "blue\ngreen\nred\n"},
+		{"reverse", SortOptions{Keys: parse("2b,2r")}, "red\ngreen\nblue\nRed\n"},
+		{"to the end of line", SortOptions{Separator: " ", Keys: parse("2")}, "Red\nblue\ngreen\nred\n"},
+	}
+	for _, c := range cases {
+		for _, limit := range []int{0, 64} {
+			opts := c.opts
+			opts.Unique, opts.OnlyKeys, opts.BufferSize = true, true, limit
+			opts.TempDirs = []string{t.TempDir()}
+			if got := sortText(t, input, opts); got != c.want {
+				t.Errorf("%s, -S %d: got %q, want %q", c.name, limit, got, c.want)
+			}
+		}
+	}
+}
+
+// TestEmbeddedNUL checks the three treatments of a NUL byte inside a line
+// without -z, and that with -z NUL stays the terminator.
+func TestEmbeddedNUL(t *testing.T) {
+	input := "b\x00x\na\nc\x00\n"
+	cases := []struct {
+		name string
+		opts SortOptions
+		want string
+		err  string
+	}{
+		{"keep", SortOptions{}, "a\nb\x00x\nc\x00\n", ""},
+		{"strip", SortOptions{StripNUL: true}, "a\nbx\nc\n", ""},
+		{"reject", SortOptions{RejectNUL: true}, "", "sort: line 1 contains a NUL byte"},
+		{"reject with -z", SortOptions{RejectNUL: true, ZeroTerminated: true}, "\n\x00b\x00x\na\nc\x00", ""},
+	}
+	for _, c := range cases {
+		var out bytes.Buffer
+		err := Sort(strings.NewReader(input), &out, c.opts)
+		switch {
+		case c.err != "":
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: err = %v, want %q", c.name, err, c.err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case out.String() != c.want:
+			t.Errorf("%s: got %q, want %q", c.name, out.String(), c.want)
+		}
+	}
+}
+
+// TestLineReaderConsumers reads the same records, one of them longer than the
+// 64 KB of a default bufio.Scanner, through every consumer of NewLineReader:
+// Sort, the temporary files of the external sort, -m and -c.
+func TestLineReaderConsumers(t *testing.T) {
+	long := strings.Repeat("x", 200_000)
+	cases := []struct {
+		name    string
+		records []string
+		opts    SortOptions
+	}{
+		{"newline", []string{"b", long, "a", "c"}, SortOptions{}},
+		{"NUL", []string{"b\nb", long, "a", "c\n"}, SortOptions{ZeroTerminated: true}},
+		{"record separator", []string{"b\nb", long + "\n" + long, "a", "c"}, SortOptions{RecordSeparator: "\n\n"}},
+	}
+	for _, c := range cases {
+		term := c.opts.terminator()
+		input := strings.Join(c.records, term) + term
+		sorted := SortInMemory(slices.Clone(c.records), c.opts)
+		want := strings.Join(sorted, term) + term
+
+		if got := sortText(t, input, c.opts); got != want {
+			t.Errorf("%s: Sort output differs", c.name)
+		}
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(input), &out, c.opts, 1000); err != nil {
+			t.Errorf("%s: ExternalSortReader: %v", c.name, err)
+		} else if out.String() != want {
+			t.Errorf("%s: ExternalSortReader output differs", c.name)
+		}
+
+		path := filepath.Join(t.TempDir(), "sorted")
+		if err := os.WriteFile(path, []byte(want), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		out.Reset()
+		if err := MergeSorted([]string{path, path}, &out, SortOptions{Unique: true, ZeroTerminated: c.opts.ZeroTerminated, RecordSeparator: c.opts.RecordSeparator}); err != nil {
+			t.Errorf("%s: MergeSorted: %v", c.name, err)
+		} else if out.String() != want {
+			t.Errorf("%s: MergeSorted output differs", c.name)
+		}
+
+		if err := CheckSorting(NewLineReader(strings.NewReader(want), c.opts), "-", c.opts); err != nil {
+			t.Errorf("%s: CheckSorting: %v", c.name, err)
+		}
+	}
+}
+
+// TestMaxRecordSize checks that a record longer than MaxRecordSize is an
+// error rather than a silently truncated input.
+func TestMaxRecordSize(t *testing.T) {
+	input := "short\n" + strings.Repeat("x", 150_000) + "\n"
+	opts := SortOptions{MaxRecordSize: 100_000}
+	if err := Sort(strings.NewReader(input), io.Discard, opts); !errors.Is(err, bufio.ErrTooLong) {
+		t.Errorf("Sort: err = %v, want %v", err, bufio.ErrTooLong)
+	}
+	opts.MaxRecordSize = 200_000
+	if err := Sort(strings.NewReader(input), io.Discard, opts); err != nil {
+		t.Errorf("Sort with a larger limit: %v", err)
+	}
+}
+
+// TestMaxLineLength checks --max-line-length at the boundary: a record of
+// exactly N bytes passes, one byte more fails with its line number or, with
+// truncate, is cut to N bytes. Records are also fed one byte at a time, so
+// that the limit is found before the separator is read.
+func TestMaxLineLength(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+		err   string
+	}{
+		{"at the limit", "bbbb\naaaa\n", SortOptions{MaxLineLength: 4}, "aaaa\nbbbb\n", ""},
+		{"one byte over", "bbbb\na\nccccc\n", SortOptions{MaxLineLength: 4}, "", "sort: line 3 is longer than 4 bytes (--max-line-length)"},
+		{"no final newline", "a\nbbbbb", SortOptions{MaxLineLength: 4}, "", "sort: line 2 is longer than 4 bytes"},
+		{"truncate at the limit", "bbbb\naaaa\n", SortOptions{MaxLineLength: 4, TruncateLong: true}, "aaaa\nbbbb\n", ""},
+		{"truncate one byte over", "bbbbX\naaaaY\nc\n", SortOptions{MaxLineLength: 4, TruncateLong: true}, "aaaa\nbbbb\nc\n", ""},
+		{"truncate without final newline", "c\nbbbbbbbb", SortOptions{MaxLineLength: 4, TruncateLong: true}, "bbbb\nc\n", ""},
+		{"zero terminated", "bb\nb\x00a\x00", SortOptions{MaxLineLength: 3, ZeroTerminated: true}, "", "sort: line 1 is longer than 3 bytes"},
+		{"truncate zero terminated", "bb\nbb\x00a\x00", SortOptions{MaxLineLength: 3, TruncateLong: true, ZeroTerminated: true}, "a\x00bb\n\x00", ""},
+		{"unlimited", "bbbbbbbb\na\n", SortOptions{}, "a\nbbbbbbbb\n", ""},
+	}
+	for _, c := range cases {
+		for _, oneByte := range []bool{false, true} {
+			var r io.Reader = strings.NewReader(c.input)
+			if oneByte {
+				r = iotest.OneByteReader(r)
+			}
+			var out bytes.Buffer
+			err := Sort(r, &out, c.opts)
+			switch {
+			case c.err != "":
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Errorf("%s, one byte %v: err = %v, want %q", c.name, oneByte, err, c.err)
+				}
+			case err != nil:
+				t.Errorf("%s, one byte %v: %v", c.name, oneByte, err)
+			case out.String() != c.want:
+				t.Errorf("%s, one byte %v: got %q, want %q", c.name, oneByte, out.String(), c.want)
+			}
+		}
+	}
+
+	// Усечённая запись не читается целиком: буфер не растёт дальше MaxRecordSize
+	long := strings.Repeat("x", 200_000)
+	opts := SortOptions{MaxLineLength: 10, TruncateLong: true, MaxRecordSize: 1000}
+	if got := sortText(t, "b\n"+long+"\na\n", opts); got != "a\nb\n"+long[:10]+"\n" {
+		t.Errorf("truncated long line: got %d bytes", len(got))
+	}
+
+	// Временные файлы не проверяются: --prepend-index удлиняет записи после проверки
+	opts = SortOptions{MaxLineLength: 8, PrependIndex: true}
+	var out bytes.Buffer
+	if err := ExternalSortReader(strings.NewReader(joinLines(numberedLines("ab", 200))), &out, opts, 100); err != nil {
+		t.Errorf("external sort with --prepend-index: %v", err)
+	}
+
+	// -c читает через тот же NewLineReader
+	if err := checkText("a\nbbbbb\n", SortOptions{MaxLineLength: 4}); err == nil || !strings.Contains(err.Error(), "line 2 is longer") {
+		t.Errorf("-c: err = %v, want the line limit", err)
+	}
+}
+
+// TestSkipBlank checks that --skip-blank drops empty records, and with -b
+// records of only blanks, in memory, through temporary files and when the
+// input arrives one byte at a time.
+func TestSkipBlank(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"empty lines", "b\n\na\n\n\nc\n", SortOptions{SkipBlank: true}, "a\nb\nc\n"},
+		{"blank lines kept", "b\n  \na\n\t\n", SortOptions{SkipBlank: true}, "\t\n  \na\nb\n"},
+		{"blank lines with -b", "b\n  \na\n\t\n", SortOptions{SkipBlank: true, IgnoreBlanks: true}, "a\nb\n"},
+		{"only blank", "\n\n\n", SortOptions{SkipBlank: true}, ""},
+		{"leading and final", "\nb\na\n\n", SortOptions{SkipBlank: true}, "a\nb\n"},
+		{"zero terminated", "b\x00\x00a\x00", SortOptions{SkipBlank: true, ZeroTerminated: true}, "a\x00b\x00"},
+		{"without", "b\n\na\n", SortOptions{}, "\na\nb\n"},
+	}
+	for _, c := range cases {
+		for _, oneByte := range []bool{false, true} {
+			var r io.Reader = strings.NewReader(c.input)
+			if oneByte {
+				r = iotest.OneByteReader(r)
+			}
+			var out bytes.Buffer
+			if err := Sort(r, &out, c.opts); err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			if out.String() != c.want {
+				t.Errorf("%s, one byte %v: got %q, want %q", c.name, oneByte, out.String(), c.want)
+			}
+		}
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(c.input), &out, c.opts, 2); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if out.String() != c.want {
+			t.Errorf("%s: ExternalSortReader = %q, want %q", c.name, out.String(), c.want)
+		}
+	}
+
+	// Много пустых строк подряд пропускаются за один вызов split
+	input := strings.Repeat("\n", 100_000) + "a\n"
+	if got := sortText(t, input, SortOptions{SkipBlank: true}); got != "a\n" {
+		t.Errorf("long run of empty lines: got %q", got)
+	}
+}
+
+// TestWarnTies checks that --warn-ties counts adjacent different lines with
+// equal keys only when their order is not determined, in memory and through
+// temporary files.
+func TestWarnTies(t *testing.T) {
+	input := "b 1\na 1\nc 2\nc 2\nd 3\ne 3\n"
+	key := []KeySpec{{StartField: 2, EndField: 2}}
+	warning := "sort: warning: 2 pairs of adjacent lines have equal keys but differ"
+	cases := []struct {
+		name string
+		opts SortOptions
+		want string // пусто — без предупреждения
+	}{
+		{"no last resort", SortOptions{WarnTies: true, NoLastResort: true, Keys: key}, warning},
+		{"external", SortOptions{WarnTies: true, NoLastResort: true, Keys: key, BufferSize: 8}, warning},
+		{"last resort", SortOptions{WarnTies: true, Keys: key}, ""},
+		{"stable", SortOptions{WarnTies: true, NoLastResort: true, Stable: true, Keys: key}, ""},
+		{"unique", SortOptions{WarnTies: true, NoLastResort: true, Unique: true, Keys: key}, ""},
+		{"whole lines", SortOptions{WarnTies: true, NoLastResort: true}, ""},
+		{"off", SortOptions{NoLastResort: true, Keys: key}, ""},
+	}
+	for _, c := range cases {
+		c.opts.TempDirs = []string{t.TempDir()}
+		stderr := captureStderr(t, func() { sortText(t, input, c.opts) })
+		if c.want == "" && stderr != "" || !strings.Contains(stderr, c.want) {
+			t.Errorf("%s: stderr %q, want %q", c.name, stderr, c.want)
+		}
+	}
+
+	// Заголовок не входит в пары
+	opts := SortOptions{WarnTies: true, NoLastResort: true, Header: 1, Keys: key}
+	if stderr := captureStderr(t, func() { sortText(t, "h 2\nz 2\n", opts) }); stderr != "" {
+		t.Errorf("header: stderr %q, want none", stderr)
+	}
+}
+
+// TestOutputCRLF checks that --output-crlf ends every output line with \r\n
+// whatever the input line endings, also for --group and the external sort.
+func TestOutputCRLF(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"lf", "b\na\n", SortOptions{}, "a\r\nb\r\n"},
+		{"crlf", "b\r\na\r\n", SortOptions{}, "a\r\nb\r\n"},
+		{"mixed", "c\r\nb\na", SortOptions{}, "a\r\nb\r\nc\r\n"},
+		{"empty line", "b\n\na\n", SortOptions{}, "\r\na\r\nb\r\n"},
+		{"group", "a 1\nb 2\nc 1\n", SortOptions{Group: true, Keys: []KeySpec{{StartField: 2, EndField: 2}}}, "a 1\r\nc 1\r\n\r\nb 2\r\n"},
+		{"input zero", "b\x00a\x00", SortOptions{InputZero: true}, "a\r\nb\r\n"},
+		{"external", "d\nc\r\nb\na\r\n", SortOptions{BufferSize: 1, TempDirs: []string{t.TempDir()}}, "a\r\nb\r\nc\r\nd\r\n"},
+	}
+	for _, c := range cases {
+		c.opts.OutputCRLF = true
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
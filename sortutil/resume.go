@@ -0,0 +1,206 @@
+package sortutil
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// manifestName is the file in --resume-dir that lists completed chunks.
+const manifestName = "manifest"
+
+// resumeState persists sorted chunks in --resume-dir so that an interrupted
+// external sort can skip the input they cover when restarted with the same
+// input and options. The manifest starts with the header of resumeHeader,
+// followed by lines such as "chunk-000001.tmp 12345", where the number is how
+// many input lines the chunk covers. A manifest with another header is dropped
+// together with its chunks.
+type resumeState struct {
+	dir      string
+	chunks   []string // имена готовых порций в порядке записи
+	consumed int      // строк ввода, покрытых готовыми порциями
+}
+
+// resumeHeader returns the header lines of the manifest of a sort with opts and
+// the memory limit limit: a hash of the options that shape the chunks and the
+// path, size and modification time of every input file in opts.ResumeInputs.
+// Standard input cannot be checked and is recorded as "-".
+func resumeHeader(opts SortOptions, limit int) (string, error) {
+	shape := opts
+	// Поля, от которых порции не зависят, и указатели, чей %#v меняется от запуска к запуску
+	shape.Progress, shape.TempStore, shape.TempDirs, shape.CompressProgram = nil, nil, nil, ""
+	shape.ResumeDir, shape.ResumeInputs, shape.BufferSize, shape.Auto = "", nil, 0, false
+	shape.Parallel, shape.ParallelThreshold, shape.ParallelMerge, shape.MergeThreads, shape.BatchSize = 0, 0, false, 0, 0
+	shape.KeyRegex, shape.KeyTemplate = nil, nil
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%#v %d", shape, limit)
+	if opts.KeyRegex != nil {
+		fmt.Fprintf(h, " %s", opts.KeyRegex)
+	}
+	if opts.KeyTemplate != nil {
+		fmt.Fprintf(h, " %#v", *opts.KeyTemplate)
+	}
+
+	var header strings.Builder
+	fmt.Fprintf(&header, "# options %016x\n", h.Sum64())
+	for _, path := range opts.ResumeInputs {
+		if path == "-" {
+			header.WriteString("# input -\n")
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		fmt.Fprintf(&header, "# input %d %d %s\n", info.Size(), info.ModTime().UnixNano(), path)
+	}
+	return header.String(), nil
+}
+
+// loadResume reads the manifest in dir. A manifest without header, or with one
+// other than header, belongs to another input or other options: its chunks are
+// removed with a warning and the sort starts over with a new manifest.
+func loadResume(dir, header string) (*resumeState, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	r := &resumeState{dir: dir}
+	manifest := filepath.Join(dir, manifestName)
+
+	data, err := os.ReadFile(manifest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return r, os.WriteFile(manifest, []byte(header), 0o644)
+	}
+	if err != nil {
+		return nil, err
+	}
+	body, ok := strings.CutPrefix(string(data), header)
+	if !ok || strings.HasPrefix(body, "#") {
+		fmt.Fprintf(os.Stderr, "sort: %s was written for other input or options; sorting from the start\n", manifest)
+		if err := r.discard(data); err != nil {
+			return nil, err
+		}
+		return r, os.WriteFile(manifest, []byte(header), 0o644)
+	}
+	for _, line := range strings.Split(body, "\n") {
+		if line == "" {
+			continue
+		}
+		var name string
+		var consumed int
+		if _, err := fmt.Sscanf(line, "%s %d", &name, &consumed); err != nil {
+			return nil, fmt.Errorf("sort: corrupt manifest in %s: %q", dir, line)
+		}
+		r.chunks = append(r.chunks, name)
+		r.consumed += consumed
+	}
+	return r, nil
+}
+
+// discard removes the chunks listed in the stale manifest data.
+func (r *resumeState) discard(data []byte) error {
+	for _, line := range strings.Split(string(data), "\n") {
+		name, _, _ := strings.Cut(line, " ")
+		// Имена порций только свои: строка манифеста не может указать за пределы каталога
+		if strings.HasPrefix(name, "chunk-") && filepath.Base(name) == name {
+			_ = os.Remove(filepath.Join(r.dir, name))
+		}
+	}
+	return os.Remove(filepath.Join(r.dir, manifestName))
+}
+
+// open reopens the completed chunks for merging.
+func (r *resumeState) open(opts SortOptions) ([]*tempFile, error) {
+	files := make([]*tempFile, 0, len(r.chunks))
+	for _, name := range r.chunks {
+		file, err := os.Open(filepath.Join(r.dir, name))
+		if err != nil {
+			cleanup(files)
+			return nil, fmt.Errorf("sort: cannot resume: %w", err)
+		}
+		tf, err := readTemp(file, file.Name(), false, opts)
+		if err != nil {
+			cleanup(files)
+			return nil, err
+		}
+		files = append(files, tf)
+	}
+	return files, nil
+}
+
+// spill writes a sorted chunk under a deterministic name and records it in the manifest.
+// A chunk enters the manifest only once fully written and renamed.
+func (r *resumeState) spill(lines []string, consumed int, opts SortOptions) (*tempFile, error) {
+	name := fmt.Sprintf("chunk-%06d.tmp", len(r.chunks)+1)
+	path := filepath.Join(r.dir, name)
+
+	part, err := os.Create(path + ".part")
+	if err != nil {
+		return nil, fmt.Errorf("sort: cannot create chunk in %s: %w", r.dir, err)
+	}
+	out := newRecordWriter(part, opts)
+	for _, line := range lines {
+		if err = out.write(line); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = out.flush()
+	}
+	if err == nil {
+		err = part.Sync()
+	}
+	if err != nil {
+		discardTemp(part)
+		return nil, writeTempError(part.Name(), err)
+	}
+	if err = part.Close(); err != nil {
+		_ = os.Remove(part.Name())
+		return nil, writeTempError(part.Name(), err)
+	}
+	if err = os.Rename(part.Name(), path); err != nil {
+		return nil, err
+	}
+
+	manifest, err := os.OpenFile(filepath.Join(r.dir, manifestName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	_, err = fmt.Fprintf(manifest, "%s %d\n", name, consumed)
+	if err == nil {
+		err = manifest.Sync()
+	}
+	if closeErr := manifest.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.chunks = append(r.chunks, name)
+	r.consumed += consumed
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &tempFile{ReadCloser: file, Scanner: newTempReader(file, opts), name: path}, nil
+}
+
+// finish removes the chunks and the manifest after a successful sort.
+func (r *resumeState) finish() error {
+	for _, name := range r.chunks {
+		_ = os.Remove(filepath.Join(r.dir, name))
+	}
+	err := os.Remove(filepath.Join(r.dir, manifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
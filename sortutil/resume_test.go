@@ -0,0 +1,214 @@
+package sortutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// resumeOptions are the options of every run of the resume tests.
+func resumeOptions(dir, input string) SortOptions {
+	return SortOptions{BufferSize: 16 << 10, ResumeDir: dir, ResumeInputs: []string{input}}
+}
+
+// killingReader kills its own process once more than n bytes were read,
+// like a crash in the middle of an external sort.
+type killingReader struct {
+	r io.Reader
+	n int
+}
+
+func (k *killingReader) Read(p []byte) (int, error) {
+	if k.n <= 0 {
+		self, _ := os.FindProcess(os.Getpid())
+		_ = self.Kill()
+		select {}
+	}
+	n, err := k.r.Read(p[:min(len(p), k.n)])
+	k.n -= n
+	return n, err
+}
+
+// sortKilled runs a sort of input with opts in a child process that is killed
+// after reading half of input, and returns the number of chunks in the manifest.
+func sortKilled(t *testing.T, dir, input string) int {
+	t.Helper()
+	data, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestResumeAfterKill$")
+	cmd.Env = append(os.Environ(), "SORT_RESUME_DIR="+dir, "SORT_RESUME_INPUT="+input,
+		"SORT_RESUME_KILL="+strconv.Itoa(len(data)/2))
+	if out, err := cmd.CombinedOutput(); err == nil {
+		t.Fatalf("sort was not killed: %s", out)
+	}
+	manifest, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := strings.Count(string(manifest), "chunk-")
+	if chunks == 0 {
+		t.Fatalf("no chunks completed before the kill:\n%s", manifest)
+	}
+	return chunks
+}
+
+// resumeSort sorts input with opts in this process, checks that the output is
+// the in-memory sort of the current input and that dir is cleaned up, and returns
+// how many chunks it spilled itself and how many the final merge read.
+func resumeSort(t *testing.T, dir, input string, opts SortOptions) (spilled, merged int) {
+	t.Helper()
+	data, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var out, progress bytes.Buffer
+	opts.Progress = &progress
+	if err := Sort(file, &out, opts); err != nil {
+		t.Fatal(err)
+	}
+	opts.Progress = nil
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if want := joinLines(Sorted(lines, opts)); out.String() != want {
+		t.Fatalf("resumed sort differs from the sort of the current input")
+	}
+	if entries := dirEntries(t, dir); len(entries) > 0 {
+		t.Fatalf("resume directory not cleaned up: %v", entries)
+	}
+
+	// Последняя строка хода: "sort: read N lines, spilled K chunks; merge pass P of P: F files"
+	status := strings.Split(strings.TrimSpace(progress.String()), "\n")
+	var read, pass, passes int
+	if _, err := fmt.Sscanf(status[len(status)-1], "sort: read %d lines, spilled %d chunks; merge pass %d of %d: %d files",
+		&read, &spilled, &pass, &passes, &merged); err != nil {
+		t.Fatalf("unexpected progress %q: %v", status[len(status)-1], err)
+	}
+	return spilled, merged
+}
+
+// TestResumeAfterKill kills a sort half-way through and resumes it: the chunks
+// of the killed run are reused only for the same input, options and memory limit.
+func TestResumeAfterKill(t *testing.T) {
+	if dir := os.Getenv("SORT_RESUME_DIR"); dir != "" {
+		input := os.Getenv("SORT_RESUME_INPUT")
+		limit, _ := strconv.Atoi(os.Getenv("SORT_RESUME_KILL"))
+		file, err := os.Open(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = Sort(&killingReader{r: file, n: limit}, io.Discard, resumeOptions(dir, input))
+		t.Fatal("sort finished before it was killed")
+	}
+
+	input := filepath.Join(t.TempDir(), "input")
+	write := func(lines []string) {
+		if err := os.WriteFile(input, []byte(joinLines(lines)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("same input and options", func(t *testing.T) {
+		dir := t.TempDir()
+		write(numberedLines("line", 20000))
+		done := sortKilled(t, dir, input)
+		spilled, merged := resumeSort(t, dir, input, resumeOptions(dir, input))
+		if merged != done+spilled {
+			t.Errorf("merged %d chunks, want %d completed before the kill and %d new", merged, done, spilled)
+		}
+	})
+	// Порции прошлого запуска не подходят: все порции слияния должны быть новыми
+	restart := func(t *testing.T, dir string, opts SortOptions) {
+		t.Helper()
+		if spilled, merged := resumeSort(t, dir, input, opts); spilled != merged {
+			t.Errorf("merged %d chunks, but only %d are from this run", merged, spilled)
+		}
+	}
+	t.Run("changed input", func(t *testing.T) {
+		dir := t.TempDir()
+		write(numberedLines("line", 20000))
+		sortKilled(t, dir, input)
+		write(numberedLines("other", 20000))
+		restart(t, dir, resumeOptions(dir, input))
+	})
+	t.Run("changed options", func(t *testing.T) {
+		dir := t.TempDir()
+		write(numberedLines("line", 20000))
+		sortKilled(t, dir, input)
+		opts := resumeOptions(dir, input)
+		opts.Reverse = true
+		restart(t, dir, opts)
+	})
+	t.Run("changed memory limit", func(t *testing.T) {
+		dir := t.TempDir()
+		write(numberedLines("line", 20000))
+		sortKilled(t, dir, input)
+		opts := resumeOptions(dir, input)
+		opts.BufferSize *= 2
+		restart(t, dir, opts)
+	})
+}
+
+// TestLoadResume reads a manifest with the expected header, rejects a corrupt
+// one and starts over when the header belongs to other input or options.
+func TestLoadResume(t *testing.T) {
+	dir := t.TempDir()
+	header := "# options 0123456789abcdef\n# input -\n"
+	manifest := header + "chunk-000001.tmp 120\nchunk-000002.tmp 80\n"
+	if err := os.WriteFile(filepath.Join(dir, manifestName), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadResume(dir, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"chunk-000001.tmp", "chunk-000002.tmp"}; !slices.Equal(state.chunks, want) || state.consumed != 200 {
+		t.Errorf("loadResume = %q covering %d lines, want %q covering 200", state.chunks, state.consumed, want)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, manifestName), []byte(header+"chunk-000001.tmp many\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadResume(dir, header); err == nil || !strings.Contains(err.Error(), "corrupt manifest") {
+		t.Errorf("corrupt manifest: err = %v", err)
+	}
+
+	// Чужой заголовок: порция удаляется, манифест начинается заново
+	chunk := filepath.Join(dir, "chunk-000001.tmp")
+	if err := os.WriteFile(chunk, []byte("a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, manifestName), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	other := "# options fedcba9876543210\n# input -\n"
+	var stale *resumeState
+	stderr := captureStderr(t, func() { stale, err = loadResume(dir, other) })
+	if err != nil || len(stale.chunks) != 0 || !strings.Contains(stderr, "sorting from the start") {
+		t.Errorf("stale manifest: %v, %d chunks, stderr %q", err, len(stale.chunks), stderr)
+	}
+	if _, err := os.Stat(chunk); !os.IsNotExist(err) {
+		t.Errorf("stale chunk not removed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, manifestName)); string(data) != other {
+		t.Errorf("new manifest %q, want %q", data, other)
+	}
+
+	// Каталог без манифеста создаётся, и сортировка начинается с нуля
+	state, err = loadResume(filepath.Join(dir, "new"), header)
+	if err != nil || len(state.chunks) != 0 {
+		t.Errorf("new directory: %v, %d chunks", err, len(state.chunks))
+	}
+}
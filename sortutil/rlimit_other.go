@@ -0,0 +1,7 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package sortutil
+
+func OpenFileLimit() (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package sortutil
+
+import "syscall"
+
+// OpenFileLimit returns the soft limit on open files of the process (RLIMIT_NOFILE),
+// or false when it is unknown; --batch-size 50% is a share of it.
+func OpenFileLimit() (int, bool) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, false
+	}
+	return int(min(limit.Cur, 1<<30)), true
+}
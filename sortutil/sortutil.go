@@ -2,9 +2,14 @@ package sortutil
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -12,47 +17,300 @@ import (
 
 var ErrInputTooLarge = errors.New("input too large for in-memory sort")
 
-var monthMap = map[string]int{
-	"Jan": 1,
-	"Feb": 2,
-	"Mar": 3,
-	"Apr": 4,
-	"May": 5,
-	"Jun": 6,
-	"Jul": 7,
-	"Aug": 8,
-	"Sep": 9,
-	"Oct": 10,
-	"Nov": 11,
-	"Dec": 12,
+type SortOptions struct {
+	Reverse           bool
+	Numeric           bool
+	GeneralNumeric    bool
+	Month             bool
+	Human             bool
+	Version           bool
+	Random            bool
+	FoldCase          bool
+	Dictionary        bool
+	IgnoreNonprinting bool
+	Keys              []KeySpec
+	IgnoreBlanks      bool
+	StripChars        string // символы, обрезаемые с краёв каждого ключа перед сравнением
+	JSONKey           string // путь через точку к полю JSON-объекта, заменяет -k
+	JSONInvalidLast   bool   // строки без поля JSONKey идут в конце, а не в начале
+	Unique            bool
+	EnumerateGroups   bool           // перед каждой строкой — номер её группы равных ключей, с 1
+	RequireUnique     bool           // записи с равными ключами — ошибка KindDuplicate, а не удаление
+	UniqueExact       bool           // -u различает равные по значению, но разные по записи ключи (007 и 7)
+	Epsilon           float64        // -u считает числовые ключи с разницей не больше Epsilon равными
+	Locale            string         // локаль сравнения текста (de_DE.UTF-8); пусто, C, POSIX — побайтно
+	NumericLocale     string         // локаль чисел -n и -h: десятичный разделитель и группы разрядов
+	TimeLocale        string         // локаль названий месяцев -M; английские распознаются всегда
+	DecimalComma      bool           // десятичная запятая без групп разрядов, вместо NumericLocale
+	Stable            bool           // -s: без сравнения целых строк, равные ключи в порядке ввода
+	AssertStable      bool           // с -s проверить, что строки с равными ключами выведены в порядке ввода
+	WarnTies          bool           // предупреждать о соседних строках с равными ключами, порядок которых не определён
+	NoLastResort      bool           // без сравнения целых строк, порядок равных ключей не определён
+	KeyTieBreak       bool           // равные по значению ключи упорядочиваются по их тексту (--tiebreak=key)
+	ZeroTerminated    bool           // записи завершаются NUL, а не переводом строки
+	InputZero         bool           // только входные записи завершаются NUL (--input-zero)
+	OutputZero        bool           // только выводимые записи завершаются NUL (--output-zero)
+	OutputCRLF        bool           // выводимые записи завершаются \r\n (--output-crlf)
+	RecordSeparator   string         // записи завершаются этой строкой и могут занимать несколько строк
+	RecordKeyLine     int            // строка записи (с 1), из которой берутся ключи; 0 — вся запись
+	Unbuffered        bool           // сбрасывать вывод после каждой записи
+	MaxRecordSize     int            // самая длинная допустимая запись в байтах; 0 — лимит памяти
+	SkipBlank         bool           // пустые строки (с -b и из одних пробелов) не попадают в вывод
+	MaxLineLength     int            // --max-line-length: входная запись длиннее — ошибка; 0 — без ограничения
+	TruncateLong      bool           // записи длиннее MaxLineLength обрезаются, а не вызывают ошибку
+	DropPartial       bool           // пропускать последнюю запись без завершающего разделителя
+	StripNUL          bool           // без -z удалять байты NUL внутри записей
+	RejectNUL         bool           // без -z считать запись с байтом NUL ошибкой
+	Summary           bool           // вывести в stderr count/min/max/sum/mean числовых ключей
+	Group             bool           // разделять группы равных ключей пустой записью
+	OnlyKeys          bool           // выводить только ключи, по которым шло сравнение
+	PadWidth          int            // дополнять нулями число в первом ключе до этой ширины при выводе
+	CheckInputs       bool           // при -m проверять, что каждый вход отсортирован
+	CheckStrict       bool           // при -c равные соседние ключи тоже считаются нарушением
+	TotalOrderCheck   bool           // проверять, что сравнение задаёт строгий слабый порядок
+	ResumeDir         string         // каталог с манифестом готовых порций для продолжения внешней сортировки
+	ResumeInputs      []string       // входные файлы для манифеста --resume-dir ("-" — stdin); другие файлы или их изменение отменяют продолжение
+	IgnoreMissing     bool           // -m пропускает неоткрывающиеся входы с предупреждением
+	Auto              bool           // --auto: лимит памяти по доступной RAM, большой ввод сортируется параллельно
+	InMemoryOnly      bool           // при превышении лимита памяти вернуть ErrInputTooLarge, а не писать временные файлы
+	TempDirs          []string       // каталоги для временных файлов (-T); пусто — системный
+	TempStore         TempStore      // хранилище временных файлов; nil — локальные файлы в TempDirs
+	CompressProgram   string         // программа сжатия временных файлов; распаковка — PROG -d
+	Parallel          int            // --parallel: число горутин; 0 — GOMAXPROCS
+	ParallelThreshold int            // --parallel-threshold: меньший ввод сортируется в одной горутине; 0 — 65536 строк
+	BufferSize        int            // -S: байт ввода в памяти до внешней сортировки; 0 — 100 МБ или --auto
+	BatchSize         int            // --batch-size: сколько файлов сливается за раз; 0 — 64
+	ParallelMerge     bool           // сливать временные файлы группами параллельно
+	MergeThreads      int            // --threads-for-merge: групп в параллельном слиянии независимо от Parallel; 0 — 1 или --parallel с ParallelMerge
+	Progress          io.Writer      // куда писать ход внешней сортировки; nil — не писать
+	Separator         string         // -t: разделитель полей; пусто — пробельные промежутки
+	Columns           []int          // --columns: поля — колонки этих ширин, а не разделённые -t
+	TrimTrailingSep   bool           // один разделитель в конце строки не образует пустого последнего поля
+	Runes             bool           // позиции символов в -k считаются в рунах UTF-8
+	CSV               bool           // поля ключей разбираются как CSV
+	Transform         []string       // --transform: имена шагов нормализации ключей по порядку (ParseTransforms)
+	TSV               bool           // поля ключей делятся табуляцией, экранирование \t, \n, \\ раскрывается
+	PrependIndex      bool           // перед сортировкой приписывать к записи её номер во вводе и разделитель
+	StripIndex        bool           // при выводе удалять первое поле записи вместе с разделителем
+	KeyPrefix         bool           // --key-prefix: ключ — первое поле (до -t или табуляции), при выводе оно удаляется
+	Verify            bool           // сверить число и хеши строк ввода и вывода после сортировки
+	Footer            int            // число последних строк, выводимых в конце без сортировки
+	Header            int            // число строк заголовка, выводимых первыми без сортировки
+	KeyName           string         // имя колонки из заголовка, заменяет -k
+	KeyRegex          *regexp.Regexp // ключ — первая группа совпадения, заменяет -k
+	KeyTemplate       *KeyTemplate   // ключ собирается из полей по шаблону, заменяет -k
+	KeyFromByte       int            // ключ — строка с байта N (с 1) до конца, заменяет -k
+	IgnoreAccents     bool           // диакритика в ключах не учитывается: café и cafe равны
+	SqueezeBlanks     bool           // пробельные промежутки внутри ключей сравниваются как один пробел
+	IgnoreComment     string         // символ начала комментария в конце строки, не входящего в ключи
+	Unquote           string         // кавычка, снимаемая с обеих сторон ключей вместе с парной; пусто — не снимать
+	ReverseKey        string         // ключи сравниваются перевёрнутыми: ReverseChars или ReverseDots; пусто — нет
+	IP                bool           // --ip: ключи сравниваются как адреса IPv4/IPv6
+	Duration          bool           // --duration: ключи — длительности Go (1h2m3s, 500ms)
+	Order             []string       // --order: ключи упорядочиваются по месту в этом списке
+	OrderUnknownFirst bool           // ключи не из --order идут первыми, а не последними
+	Bool              bool           // --bool: ключи — yes/no, true/false, 1/0; false раньше true
+	RightAlign        bool           // --right-align: короткий ключ дополняется пробелами слева
+	Money             bool           // --money: ключи — суммы вроде $1,234.50 и €2.000,00
+	Hex               bool           // --hex: ключи — шестнадцатеричные числа, 0x необязателен
+	Radix             int            // --radix: ключи — числа по этому основанию (2-36); 0 — нет
+	Epoch             string         // --epoch: ключи — метки Unix: EpochSeconds, EpochMillis или EpochAuto; пусто — нет
+	AutoUnit          bool           // --auto-unit: ключи — размеры, длительности или числа, вид определяется по ключу
+	Natural           bool           // --natural: числа в ключах по значению, текст между ними по --locale
+	KeyDefaultNumeric bool           // -n для всех ключей, кроме выбравших свой режим
+	IPInvalidLast     bool           // ключи, не являющиеся адресом, идут в конце, а не в начале
+	HumanUnitTies     bool           // -h: равные по значению ключи упорядочиваются по единице (1000 < 1K)
+
+	// Scale maps a field to the factor that numeric keys starting in it are
+	// multiplied by before comparing (--scale).
+	Scale map[int]float64
+
+	// seqTagged is set for the sorting stage of Sort when --assert-stable tags
+	// every record with its input sequence number (see stability).
+	seqTagged bool
 }
 
-type SortOptions struct {
-	Reverse      bool
-	Numeric      bool
-	Month        bool
-	Human        bool
-	KeyCol       int
-	IgnoreBlanks bool
-	Unique       bool
+// Sort sorts r into w in memory, switching to external sort
+// when the input turns out to exceed the memory limit.
+func Sort(r io.Reader, w io.Writer, opts SortOptions) (err error) {
+	defer func() { err = classify(err) }()
+	out := newOutputWriter(w, opts)
+	s := NewLineReader(r, opts)
+	in, split := newInputObservers(out, opts)
+	s.Split(split)
+
+	// Заголовок выводится как есть
+	opts, _, err = readHeader(s, opts, out.write)
+	if err != nil {
+		return err
+	}
+	out.beginSorted(opts)
+	opts = in.start(opts)
+
+	limit := opts.memoryLimit()
+	lines, err := readLines(s, limit)
+	switch {
+	case errors.Is(err, ErrInputTooLarge) && opts.InMemoryOnly:
+		return newError(KindInputTooLarge, fmt.Errorf("sort: %w (%d MB) and --in-memory-only forbids temporary files", err, limit>>20))
+	case errors.Is(err, ErrInputTooLarge):
+		// Уже прочитанные строки и живой сканер продолжают один поток
+		err = externalSort(s, out, opts, limit, lines)
+	case err == nil:
+		err = writeSorted(out, lines, opts)
+	}
+	if err != nil {
+		return err
+	}
+	return in.finish()
+}
+
+// writeSorted sorts lines in memory and writes them to out.
+func writeSorted(out *recordWriter, lines []string, opts SortOptions) error {
+	for _, line := range SortInMemory(lines, opts) {
+		if err := out.write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inputObservers are the wrappers that Sort puts around the split function of
+// its input: they see every record exactly once, whichever path (in memory,
+// external sort, --mmap) consumes it.
+type inputObservers struct {
+	out    *recordWriter
+	check  *verifier
+	tail   *footer
+	stats  *summary
+	hint   *fieldHint
+	stable *stability
+}
+
+// newInputObservers returns the observers of opts for the output out and the
+// input split function wrapped with them: --prepend-index, --verify, --footer,
+// --summary, the -t hint and --assert-stable.
+func newInputObservers(out *recordWriter, opts SortOptions) (*inputObservers, bufio.SplitFunc) {
+	in := &inputObservers{out: out}
+	split := opts.inputSplit()
+	if opts.PrependIndex {
+		split = prependIndex(split, opts.indexSeparator())
+	}
+	if opts.Verify {
+		// Заголовок тоже сверяется, поэтому учёт вывода начинается сразу
+		in.check = &verifier{}
+		split = in.check.observe(split)
+		out.tally = &in.check.out
+	}
+	if opts.Footer > 0 {
+		in.tail = &footer{n: opts.Footer}
+		split = in.tail.hold(split)
+	}
+	if opts.Summary {
+		in.stats = newSummary(opts)
+		split = in.stats.observe(split)
+	}
+	in.hint = newFieldHint(opts)
+	if in.hint != nil {
+		split = in.hint.observe(split)
+	}
+	if opts.AssertStable && opts.Stable {
+		in.stable = newStability()
+		split = in.stable.observe(split)
+	}
+	return in, split
+}
+
+// start switches the observers on after the header, with opts that already
+// have the key of --key-name, and returns the options of the sorting stage.
+func (in *inputObservers) start(opts SortOptions) SortOptions {
+	if in.stats != nil {
+		in.stats.active = true
+		// Ключ мог появиться только что, из --key-name
+		in.stats.key = newComparator(opts).keys[0]
+	}
+	if in.hint != nil {
+		in.hint.active = true
+	}
+	if in.stable != nil {
+		in.stable.active = true
+		in.stable.comp = newComparator(opts)
+		in.out.stable = in.stable
+		// Записи дальше несут метку номера, которую сравнения пропускают
+		opts.seqTagged = true
+	}
+	return opts
+}
+
+// finish writes the --footer after the sorted records, flushes the output and
+// reports what the observers found.
+func (in *inputObservers) finish() error {
+	out := in.out
+	if in.tail != nil {
+		out.endSorted()
+		for _, line := range in.tail.lines {
+			if err := out.write(line); err != nil {
+				return err
+			}
+		}
+	}
+	if err := out.flush(); err != nil {
+		return err
+	}
+	if in.check != nil {
+		if err := in.check.check(); err != nil {
+			return err
+		}
+	}
+	reportSummary(in.stats)
+	reportHint(in.hint)
+	out.reportTies()
+	in.stable.report()
+	return nil
+}
+
+// SortToTempFile sorts r like Sort into a new temporary file and returns its path,
+// for a next stage that needs a seekable file. The file is created in the first
+// -T directory (or the system one) and removing it is up to the caller; on
+// error it is removed at once.
+func SortToTempFile(r io.Reader, opts SortOptions) (string, error) {
+	dir := ""
+	if len(opts.TempDirs) > 0 {
+		dir = opts.TempDirs[0]
+	}
+	file, err := os.CreateTemp(dir, "sorted-*")
+	if err != nil {
+		return "", newError(KindIO, fmt.Errorf("sort: cannot create temporary file: %w", err))
+	}
+	err = Sort(r, file, opts)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
 }
 
 // ReadLinesWithLimit reads lines from r until memory limit is reached.
 // Returns error if input exceeds maxBytes (and at least one line was read).
+// With ErrInputTooLarge it returns the lines read so far: stdin cannot be read
+// again, so the caller goes on with them and the same scanner, as Sort does.
 func ReadLinesWithLimit(s *bufio.Scanner) ([]string, error) {
+	return readLines(s, maxMemoryBytes)
+}
+
+func readLines(s *bufio.Scanner, limit int) ([]string, error) {
 	var lines []string
-	totalSize := 0
+	data := 0
 
 	for s.Scan() {
-		line := scanner.Text()
-		// Оценка памяти: длина строки + накладные расходы среза и строки
-		lineSize := len(line) + 16
-		if totalSize+lineSize > maxMemoryBytes {
-			lines = append(lines, line)
+		line := s.Text()
+		lines = append(lines, line)
+		data += len(line)
+		if linesMemory(data, lines) > limit {
 			return lines, ErrInputTooLarge
 		}
-		lines = append(lines, line)
-		totalSize += lineSize
 	}
 
 	if err := s.Err(); err != nil {
@@ -61,246 +319,400 @@ func ReadLinesWithLimit(s *bufio.Scanner) ([]string, error) {
 	return lines, nil
 }
 
-// estimateMemorySize returns an approximate memory footprint of a []string in bytes.
-func estimateMemorySize(lines []string) int {
-	size := 0
-	for _, s := range lines {
-		// len(s) — байты данных строки
-		// +16 — overhead на строку в Go (header + выравнивание)
-		size += len(s) + 16
-	}
-	return size
+// stringHeaderSize is the size of a string header (pointer and length) in a []string.
+const stringHeaderSize = 16
+
+// linesMemory estimates the memory footprint of lines whose data takes data bytes.
+// String headers are counted by the capacity of the slice rather than its
+// length, since after growing append keeps up to twice as many cells as used.
+func linesMemory(data int, lines []string) int {
+	return data + stringHeaderSize*cap(lines)
 }
 
+// SortInMemory sorts lines by opts and returns them, without duplicates for -u.
+// With opts.Stable the sort is stable, so sequential passes compose: sorting by
+// A and then, stably, by B gives the same order as one sort by -k B -k A.
+//
+// SortInMemory sorts lines in place: the caller's slice is reordered, and with
+// -u the result shares its backing array. Use Sorted to keep lines unchanged.
 func SortInMemory(lines []string, opts SortOptions) []string {
-	sort.SliceStable(lines, func(i, j int) bool {
-		a := getKey(lines[i], opts.KeyCol)
-		b := getKey(lines[j], opts.KeyCol)
-		if opts.IgnoreBlanks {
-			a = trimBlanks(a)
-			b = trimBlanks(b)
-		}
-		if opts.Human {
-			valA := humanValue(a)
-			valB := humanValue(b)
-			if valA != valB {
-				return valA < valB
-			}
-			return a < b
-		} else if opts.Month {
-			monthA := monthValue(a)
-			monthB := monthValue(b)
-			if monthA != monthB {
-				return monthA < monthB
-			}
-			return a < b
-		} else if opts.Numeric {
-			numA := numericPrefix(a)
-			numB := numericPrefix(b)
-			if numA != numB {
-				return numA < numB
-			}
-			return a < b
-		}
-		return a < b
-	})
+	comp := newComparator(opts)
+	less := func(i, j int) bool {
+		return comp.compareLines(lines[i], lines[j]) < 0
+	}
+	// Устойчивость нужна только для -s: при сравнении целых строк равны лишь
+	// одинаковые строки, а с --no-last-resort порядок равных не гарантируется
+	switch {
+	case comp.plainBytes(opts):
+		sortPlainBytes(lines, comp.keys[0].Reverse, opts.Stable)
+	case opts.sortParallel(lines):
+		sortSegments(lines, opts)
+	case opts.Stable:
+		sort.SliceStable(lines, less)
+	default:
+		sort.Slice(lines, less)
+	}
 
 	if opts.Unique {
-		var uniqueLines []string
-		if len(lines) > 0 {
-			uniqueLines = []string{lines[0]}
-			for i := 1; i < len(lines); i++ {
-				prev := getKey(lines[i-1], opts.KeyCol)
-				curr := getKey(lines[i], opts.KeyCol)
-				if opts.IgnoreBlanks {
-					prev = trimBlanks(prev)
-					curr = trimBlanks(curr)
-				}
-				equal := false
-				if opts.Human {
-					equal = humanValue(prev) == humanValue(curr)
-				} else if opts.Month {
-					equal = monthValue(prev) == monthValue(curr)
-				} else if opts.Numeric {
-					equal = numericPrefix(prev) == numericPrefix(curr)
-				} else {
-					equal = prev == curr
-				}
-				if !equal {
-					uniqueLines = append(uniqueLines, lines[i])
-				}
-			}
-			lines = uniqueLines
-		}
+		lines = uniqueLines(lines, comp)
 	}
+	return lines
+}
 
-	if opts.Reverse {
-		for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
-			lines[i], lines[j] = lines[j], lines[i]
+// uniqueLines keeps the first of every group of sorted lines with equal keys (-u).
+// The kept lines are moved to the front of lines, so the result is a prefix of it.
+func uniqueLines(lines []string, comp *comparator) []string {
+	if len(lines) == 0 {
+		return lines
+	}
+	unique := lines[:1]
+	for i := 1; i < len(lines); i++ {
+		// Остаётся первая строка группы в полном порядке (с крайним
+		// сравнением целых строк), как и в mergeFiles. Сравнение с оставленной
+		// строкой: с --epsilon цепочка близких соседей не сливается в одну группу
+		if !equivalent(unique[len(unique)-1], lines[i], comp) {
+			unique = append(unique, lines[i])
 		}
 	}
+	return unique
+}
 
-	return lines
+// Sorted is like SortInMemory but sorts a copy and leaves lines unchanged.
+func Sorted(lines []string, opts SortOptions) []string {
+	return SortInMemory(slices.Clone(lines), opts)
+}
+
+// CountKeys sorts r and returns the number of distinct keys by opts: the number
+// of records -u would output, with the same equality (including --epsilon and
+// --unique-exact). No output is kept: the sorted records are only counted, and
+// a large input goes to the external sort as in Sort. Header and --footer lines
+// are not counted, and output options (--group, --summary, --verify) have no
+// effect.
+func CountKeys(r io.Reader, opts SortOptions) (int, error) {
+	opts.Unique = true
+	opts.Group, opts.Summary, opts.Verify, opts.WarnTies, opts.Unbuffered = false, false, false, false, false
+	opts.AssertStable = false
+	// Выводимая запись не содержит входного разделителя, поэтому записи
+	// считаются по нему; с --output-zero строка могла бы содержать NUL
+	opts.ZeroTerminated = opts.zeroInput()
+	opts.InputZero, opts.OutputZero = false, false
+	counter := &recordCounter{term: []byte(opts.outputTerminator())}
+	if err := Sort(r, counter, opts); err != nil {
+		return 0, err
+	}
+	header := opts.Header
+	if opts.KeyName != "" && header == 0 {
+		header = 1
+	}
+	return max(counter.count-header-opts.Footer, 0), nil
+}
+
+// recordCounter is an io.Writer that counts the terminators written to it.
+// A terminator may straddle two calls to Write, so the end of the previous call
+// is kept in tail.
+type recordCounter struct {
+	term  []byte
+	tail  []byte
+	count int
 }
 
+func (c *recordCounter) Write(p []byte) (int, error) {
+	data := append(c.tail, p...)
+	c.count += bytes.Count(data, c.term)
+	keep := min(len(c.term)-1, len(data))
+	if i := bytes.LastIndex(data, c.term); i >= 0 {
+		keep = min(keep, len(data)-i-len(c.term))
+	}
+	c.tail = append(c.tail[:0], data[len(data)-keep:]...)
+	return len(p), nil
+}
+
+// MergeSlices merges the slices of sorted, each already sorted by opts, into one new sorted
+// slice, like -m for files but in memory; with opts.Unique only the first line
+// of every group of equal keys is kept. Equal lines are taken from the earlier
+// slice first, so with opts.Stable the merge is stable. The input slices are
+// not modified.
+func MergeSlices(sorted [][]string, opts SortOptions) []string {
+	comp := newComparator(opts)
+	merged := mergeSegments(sorted, comp)
+	if opts.Unique {
+		merged = uniqueLines(merged, comp)
+	}
+	return merged
+}
+
+// readHeader reads the header of s (--header, or the line of column names for
+// --key-name) and passes every header line to emit. It returns opts with the
+// key of --key-name resolved from the first line, and the number of lines read.
+func readHeader(s *bufio.Scanner, opts SortOptions, emit func(string) error) (SortOptions, int, error) {
+	header := opts.Header
+	if opts.KeyName != "" && header == 0 {
+		header = 1
+	}
+	n := 0
+	for ; n < header && s.Scan(); n++ {
+		line := s.Text()
+		if n == 0 && opts.KeyName != "" {
+			key, err := keyByName(line, opts.KeyName, opts)
+			if err != nil {
+				return opts, n, err
+			}
+			opts.Keys = []KeySpec{key}
+		}
+		if err := emit(line); err != nil {
+			return opts, n, err
+		}
+	}
+	return opts, n, nil
+}
+
+// CheckSorting reports the first record of s that breaks the order of opts (-c).
+// Adjacent lines are compared with the comparator of the sort itself, so the
+// whole key chain (-k 2n -k 1) is checked, not only the first key.
+// Header lines (--header, --key-name) are not checked, but line numbers count them.
+// A disorder is returned as a SortError of KindDisorder wrapping a *Disorder.
+// s must read records with the terminator of opts (NewLineReader), so that -cz
+// checks NUL-terminated records rather than lines.
 func CheckSorting(s *bufio.Scanner, source string, opts SortOptions) error {
+	opts, header, err := readHeader(s, opts, func(string) error { return nil })
+	if err != nil {
+		return classify(err)
+	}
+	comp := newComparator(opts)
+	if comp.plainBytes(opts) {
+		return checkPlainBytes(s, source, header, opts, comp)
+	}
+	if !s.Scan() {
+		return classify(s.Err())
+	}
 	prevLine := s.Text()
 
-	lineNum := 2
+	lineNum := header + 2
 	for s.Scan() {
 		currLine := s.Text()
-		prev := getKey(prevLine, opts.KeyCol)
-		curr := getKey(currLine, opts.KeyCol)
-		if opts.IgnoreBlanks {
-			prev = trimBlanks(prev)
-			curr = trimBlanks(curr)
-		}
-		unordered := isUnordered(prev, curr, opts)
+		unordered := isUnordered(prevLine, currLine, opts, comp)
 		if unordered {
-			fmt.Fprintf(os.Stderr, "sort: %s:%d: disorder: %s\n", source, lineNum, currLine)
-			os.Exit(1)
+			return newDisorder(source, lineNum, prevLine, currLine, comp)
 		}
 
 		prevLine = currLine
 		lineNum++
 	}
-	return nil
+	return classify(s.Err())
 }
 
-func monthValue(s string) int {
-	if val, ok := monthMap[s]; ok {
-		return val
+// checkPlainBytes is CheckSorting for a plainBytes comparator: records are
+// compared as bytes right in the scanner buffer and the previous one is copied
+// into a reused slice, so checking sorted input allocates nothing per line.
+func checkPlainBytes(s *bufio.Scanner, source string, header int, opts SortOptions, comp *comparator) error {
+	reverse := comp.keys[0].Reverse
+	strict := opts.Unique || opts.CheckStrict
+	var prev []byte
+	for lineNum := header + 1; s.Scan(); lineNum++ {
+		curr := s.Bytes()
+		if lineNum > header+1 {
+			c := bytes.Compare(prev, curr)
+			if reverse {
+				c = -c
+			}
+			if c > 0 || strict && c == 0 {
+				return newDisorder(source, lineNum, string(prev), string(curr), comp)
+			}
+		}
+		prev = append(prev[:0], curr...)
 	}
-	return 0
+	return classify(s.Err())
 }
 
-// humanValue parses a human-readable number.
-func humanValue(s string) float64 {
-	number, rest := parseFloat(s)
-	if rest == s {
-		return 0.0
+// CountUnique writes every run of adjacent records of the already sorted s with
+// equal keys once, as the size of the run, the -t separator (or a tab) and its first
+// record (--uniq-count), like uniq -c with the keys and modes of opts. The input
+// is not sorted: a record whose key is less than the previous one is a
+// SortError of KindDisorder, as with -c.
+func CountUnique(s *bufio.Scanner, w io.Writer, source string, opts SortOptions) (err error) {
+	defer func() { err = classify(err) }()
+	out := newOutputWriter(w, opts)
+	opts, lineNum, err := readHeader(s, opts, out.write)
+	if err != nil {
+		return err
 	}
+	comp := newComparator(opts)
+	sep := opts.indexSeparator()
 
-	// Остаток строки - суффикс
-	suffix := strings.TrimSpace(rest)
-	if suffix == "" {
-		return number
+	var first, prev string
+	count := 0
+	emit := func() error { return out.write(strconv.Itoa(count) + sep + first) }
+	for s.Scan() {
+		line := s.Text()
+		lineNum++
+		if count > 0 {
+			// Порядок равных ключей не важен: вход мог быть отсортирован с -s
+			if comp.compareKeys(prev, line) > 0 {
+				return newDisorder(source, lineNum, prev, line, comp)
+			}
+			prev = line
+			if equivalent(first, line, comp) {
+				count++
+				continue
+			}
+			if err := emit(); err != nil {
+				return err
+			}
+		}
+		first, prev, count = line, line, 1
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if count > 0 {
+		if err := emit(); err != nil {
+			return err
+		}
 	}
+	return out.flush()
+}
 
-	var (
-		multiplier float64
-		isBinary   bool
-	)
-
-	if len(suffix) >= 2 && suffix[len(suffix)-1] == 'i' {
-		isBinary = true
-		suffix = suffix[:len(suffix)-1]
-	}
-
-	switch suffix {
-	case "K":
-		multiplier = 1000
-	case "M":
-		multiplier = 1000 * 1000
-	case "G":
-		multiplier = 1000 * 1000 * 1000
-	case "T":
-		multiplier = 1e12
-	case "P":
-		multiplier = 1e15
-	case "E":
-		multiplier = 1e18
-	default:
+// humanValue parses a human-readable number written in the format f.
+// The suffix is the character right after the number (with an optional i after
+// it); as in GNU sort, anything else is ignored: "5foo" and "5 K" are just 5.
+func (f numberFormat) humanValue(s string) float64 {
+	number, rest, ok := f.parse(s)
+	if !ok {
 		return 0.0
 	}
+	if rest == "" {
+		return number
+	}
 
-	if isBinary {
-		switch multiplier {
-		case 1000:
-			multiplier = 1024
-		case 1e6:
-			multiplier = 1024 * 1024
-		case 1e9:
-			multiplier = 1024 * 1024 * 1024
-		case 1e12:
-			multiplier = 1024 * 1024 * 1024 * 1024
-		case 1e15:
-			multiplier = 1 << 50 // ~1.126e15
-		}
+	// Степень суффикса: K — 1, M — 2, ..., Q — 10
+	power := strings.IndexByte(humanSuffixes, rest[0]) + 1
+	if power == 0 {
+		return number
 	}
+	isBinary := len(rest) > 1 && rest[1] == 'i'
 
-	return number * multiplier
+	// Множители считаются во float64: 1024^10 (Qi) не переполняет и не теряет точность
+	if isBinary {
+		return number * math.Ldexp(1, 10*power)
+	}
+	return number * math.Pow(1000, float64(power))
 }
 
-// numericPrefix теперь просто обертка над parseLeadingFloat,
-// которая возвращает 0.0, если число не найдено.
-func numericPrefix(s string) float64 {
-	number, _ := parseFloat(s)
-	return number
+// humanSuffixes lists the -h suffixes in increasing order, like GNU sort.
+const humanSuffixes = "KMGTPEZYRQ"
+
+// numericKey returns the leading number of s; ok is false if there is none.
+func (f numberFormat) numericKey(s string) (float64, bool) {
+	number, _, ok := f.parse(s)
+	return number, ok
 }
 
-func parseFloat(s string) (float64, string) {
-	if len(s) == 0 {
-		return 0.0, s
+// compareNumeric compares the leading numbers of a and b.
+// Keys without a number ("", "-", ".") are less than any number.
+func (f numberFormat) compareNumeric(a, b string) int {
+	numA, okA := f.numericKey(a)
+	numB, okB := f.numericKey(b)
+	switch {
+	case okA != okB:
+		if okA {
+			return 1
+		}
+		return -1
+	case numA < numB:
+		return -1
+	case numA > numB:
+		return 1
 	}
+	return 0
+}
 
+// parse parses the leading number of s and returns it with the rest of s.
+// ok is false when s has no leading number: "", "-", "+", ".", "-." and so on.
+// The decimal point is f.decimal, and f.group may separate digits of the
+// integer part ("1,234").
+func (f numberFormat) parse(s string) (float64, string, bool) {
 	// Skip leading blanks (space and tab in C locale)
 	i := 0
 	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
 		i++
 	}
 
-	if i == len(s) {
-		return 0.0, s // only blanks
-	}
-
 	// Optional minus
 	start := i
-	if s[i] == '-' {
+	if i < len(s) && s[i] == '-' {
 		i++
-		if i == len(s) {
-			return 0.0, s
-		}
 	}
 
-	// Digits before decimal point
-	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
-		i++
+	// Digits before and after the optional decimal point
+	digits := 0
+	grouped := false
+	for {
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+			digits++
+		}
+		// Разделитель групп допустим только между цифрами
+		if digits == 0 || f.group == "" || !strings.HasPrefix(s[i:], f.group) ||
+			i+len(f.group) >= len(s) || !isDigit(s[i+len(f.group)]) {
+			break
+		}
+		i += len(f.group)
+		grouped = true
 	}
-
-	// Optional decimal point and digits
-	if i < len(s) && s[i] == '.' {
+	decimal := false
+	if i < len(s) && s[i] == f.decimal {
 		i++
+		decimal = true
 		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
 			i++
+			digits++
 		}
 	}
 
-	if i == start || (i == start+1 && s[start] == '-') {
-		// No digits found
-		return 0.0, s
+	if digits == 0 {
+		return 0.0, s, false
 	}
 
-	prefix := s[start:i]
-	f, err := strconv.ParseFloat(prefix, 64)
+	// strconv понимает только точку и числа без групп разрядов
+	number := s[start:i]
+	if grouped {
+		number = strings.ReplaceAll(number, f.group, "")
+	}
+	if decimal && f.decimal != '.' {
+		number = strings.Replace(number, string(f.decimal), ".", 1)
+	}
+	value, err := strconv.ParseFloat(number, 64)
 	if err != nil {
-		return 0.0, s
+		return 0.0, s, false
 	}
-
-	return f, s[i:]
+	return value, s[i:], true
 }
 
-func trimBlanks(s string) string {
-	return strings.Trim(s, " \t")
-}
+// parseHex parses the leading hexadecimal number of s with an optional 0x prefix
+// and returns its digits with the rest of s. ok is false when there are no digits.
+func parseHex(s string) (string, string, bool) {
+	i := 0
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i++
+	}
+	if i+1 < len(s) && s[i] == '0' && (s[i+1] == 'x' || s[i+1] == 'X') && i+2 < len(s) && isHexDigit(s[i+2]) {
+		i += 2
+	}
 
-func getKey(line string, col int) string {
-	if col <= 0 {
-		return line
+	start := i
+	for i < len(s) && isHexDigit(s[i]) {
+		i++
 	}
-	fields := strings.Split(line, "\t")
-	if col > len(fields) {
-		return ""
+	if i == start {
+		return "", s, false
 	}
-	return fields[col-1]
+	return s[start:i], s[i:], true
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func trimBlanks(s string) string {
+	return strings.Trim(s, " \t")
 }
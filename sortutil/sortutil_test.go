@@ -0,0 +1,1015 @@
+package sortutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// sortText runs Sort on input and returns its output.
+func sortText(t *testing.T, input string, opts SortOptions) string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := Sort(strings.NewReader(input), &out, opts); err != nil {
+		t.Fatalf("Sort: %v", err)
+	}
+	return out.String()
+}
+
+// numberedLines returns n lines from prefix followed by n-1 down to prefix
+// followed by 0, so that every sort has to move every line.
+func numberedLines(prefix string, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%s%06d", prefix, n-1-i)
+	}
+	return lines
+}
+
+// joinLines joins lines into input with a terminator after every line.
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// checkText runs CheckSorting on input read with the record terminator of opts.
+func checkText(input string, opts SortOptions) error {
+	return CheckSorting(NewLineReader(strings.NewReader(input), opts), "-", opts)
+}
+
+// mixedLines returns n pseudo-random lines with repeated keys, mixed case,
+// numbers, sizes, month names and blanks, the same for every call.
+func mixedLines(n int) []string {
+	words := []string{"apple", "Apple", "APPLE", "banana", "b-anana", "Cherry", "  date", "éclair", "", "z"}
+	sizes := []string{"1K", "512", "2M", "1024", "1k", "-3", "0.5G", "", "1e3", "x"}
+	months := []string{"jan", "FEB", "Mar", "dec", "foo", "", " aug", "Jun", "may", "JAN"}
+	lines := make([]string, n)
+	state := uint32(1)
+	next := func(m int) int {
+		state = state*1664525 + 1013904223
+		return int(state>>16) % m
+	}
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%s %s\t%s %d.%d", words[next(len(words))], sizes[next(len(sizes))],
+			months[next(len(months))], next(7)-3, next(10))
+	}
+	return lines
+}
+
+// captureStderr returns what f writes to os.Stderr.
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "stderr-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+	saved := os.Stderr
+	os.Stderr = file
+	defer func() { os.Stderr = saved }()
+	f()
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestNumericNoNumber checks that sign-only and dot-only keys have no number
+// under -n and sort before every number, zero included.
+func TestNumericNoNumber(t *testing.T) {
+	cases := []struct {
+		key  string
+		want float64
+		ok   bool
+	}{
+		{"", 0, false},
+		{"-", 0, false},
+		{"+", 0, false},
+		{".", 0, false},
+		{"-.", 0, false},
+		{"  -", 0, false},
+		{"x1", 0, false},
+		{"0", 0, true},
+		{"-0", 0, true},
+		{".5", 0.5, true},
+		{"-.5x", -0.5, true},
+		{"5.", 5, true},
+		{" 12 apples", 12, true},
+	}
+	for _, c := range cases {
+		got, ok := cNumeric.numericKey(c.key)
+		if ok != c.ok || got != c.want {
+			t.Errorf("numericKey(%q) = %v, %v, want %v, %v", c.key, got, ok, c.want, c.ok)
+		}
+	}
+
+	lines := []string{"3", "-", "0", ".", "-1", "", "-.", "+"}
+	want := []string{"", "+", "-", "-.", ".", "-1", "0", "3"}
+	if got := SortInMemory(slices.Clone(lines), SortOptions{Numeric: true}); !slices.Equal(got, want) {
+		t.Errorf("-n: got %q, want %q", got, want)
+	}
+	unique := SortInMemory(slices.Clone(lines), SortOptions{Numeric: true, Unique: true})
+	if want := []string{"", "-1", "0", "3"}; !slices.Equal(unique, want) {
+		t.Errorf("-n -u: got %q, want %q", unique, want)
+	}
+}
+
+// TestNumericSignsBothPaths sorts negative and positive numbers ascending and
+// with -r in memory and through temporary files: both paths must agree.
+func TestNumericSignsBothPaths(t *testing.T) {
+	input := joinLines([]string{"3", "-2", "100", "0", "-10", "-2.5"})
+	cases := []struct {
+		opts SortOptions
+		want []string
+	}{
+		{SortOptions{Numeric: true}, []string{"-10", "-2.5", "-2", "0", "3", "100"}},
+		{SortOptions{Numeric: true, Reverse: true}, []string{"100", "3", "0", "-2", "-2.5", "-10"}},
+		{SortOptions{GeneralNumeric: true}, []string{"-10", "-2.5", "-2", "0", "3", "100"}},
+		{SortOptions{GeneralNumeric: true, Reverse: true}, []string{"100", "3", "0", "-2", "-2.5", "-10"}},
+	}
+	for _, c := range cases {
+		want := joinLines(c.want)
+		if got := sortText(t, input, c.opts); got != want {
+			t.Errorf("%+v in memory: got %q, want %q", c.opts, got, want)
+		}
+		// Лимит в один байт кладёт каждую строку в отдельный временный файл
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(input), &out, c.opts, 1); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("%+v external: got %q, want %q", c.opts, out.String(), want)
+		}
+	}
+}
+
+// TestReverseTieBreak checks that -r reverses the last-resort comparison of
+// lines with equal keys too, the same in memory and in the merge.
+func TestReverseTieBreak(t *testing.T) {
+	input := joinLines([]string{"1 b", "2 x", "1 a", "1 c"})
+	opts := SortOptions{Numeric: true, Reverse: true}
+	want := joinLines([]string{"2 x", "1 c", "1 b", "1 a"})
+	if got := sortText(t, input, opts); got != want {
+		t.Errorf("in memory: got %q, want %q", got, want)
+	}
+	var out bytes.Buffer
+	if err := ExternalSortReader(strings.NewReader(input), &out, opts, 1); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("external: got %q, want %q", out.String(), want)
+	}
+}
+
+// TestCheckStrict distinguishes the default -c, which allows equal adjacent
+// keys, from -c -u and --check-strict, which treat them as disorder.
+func TestCheckStrict(t *testing.T) {
+	cases := []struct {
+		prev, curr string
+		opts       SortOptions
+		want       bool
+	}{
+		{"a", "b", SortOptions{}, false},
+		{"a", "a", SortOptions{}, false},
+		{"b", "a", SortOptions{}, true},
+		{"a", "a", SortOptions{Unique: true}, true},
+		{"a", "a", SortOptions{CheckStrict: true}, true},
+		{"a", "b", SortOptions{CheckStrict: true}, false},
+		{"b", "a", SortOptions{CheckStrict: true}, true},
+		{"1 x", "1 y", SortOptions{Numeric: true}, false},
+		{"1 x", "1 y", SortOptions{Numeric: true, CheckStrict: true}, true},
+		{"01", "1", SortOptions{Numeric: true, CheckStrict: true}, true},
+		{"b", "a", SortOptions{Reverse: true, CheckStrict: true}, false},
+		{"b", "b", SortOptions{Reverse: true, CheckStrict: true}, true},
+	}
+	for _, c := range cases {
+		if got := isUnordered(c.prev, c.curr, c.opts, newComparator(c.opts)); got != c.want {
+			t.Errorf("isUnordered(%q, %q, %+v) = %v, want %v", c.prev, c.curr, c.opts, got, c.want)
+		}
+	}
+
+	for _, input := range []string{"", "a\n", "a\nb\nc\n"} {
+		if err := checkText(input, SortOptions{CheckStrict: true}); err != nil {
+			t.Errorf("--check-strict on %q: %v", input, err)
+		}
+	}
+}
+
+// TestCheckKeyChain checks -c with -k 2n -k 1r against a file that is ordered
+// by the composite key but by neither key alone.
+func TestCheckKeyChain(t *testing.T) {
+	key := func(spec string) KeySpec {
+		k, err := ParseKeySpec(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	input := "c 1\nb 1\nb 2\na 2\n"
+	cases := []struct {
+		name string
+		keys []KeySpec
+		want string // пусто — порядок не нарушен
+	}{
+		{"-k2n -k1r", []KeySpec{key("2n"), key("1r")}, ""},
+		{"-k2n", []KeySpec{key("2n")}, "sort: -:2: disorder: b 1"},
+		{"-k1r", []KeySpec{key("1r")}, "sort: -:3: disorder: b 2"},
+	}
+	for _, c := range cases {
+		err := checkText(input, SortOptions{Keys: c.keys})
+		var sortErr *SortError
+		switch {
+		case c.want == "" && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.want == "":
+		case !errors.As(err, &sortErr) || sortErr.Kind != KindDisorder || err.Error() != c.want:
+			t.Errorf("%s: err = %v, want a disorder %q", c.name, err, c.want)
+		}
+	}
+}
+
+// TestCheckHeader checks that -c skips the lines of --header and --key-name
+// and still counts them in the line number of a disorder.
+func TestCheckHeader(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string // пусто — порядок не нарушен
+	}{
+		{"no header", "name\na\nb\n", SortOptions{}, "sort: -:2: disorder: a"},
+		{"header", "name\na\nb\n", SortOptions{Header: 1}, ""},
+		{"two header lines", "z\ny\na\nb\n", SortOptions{Header: 2}, ""},
+		{"disorder after header", "name\na\nc\nb\n", SortOptions{Header: 1}, "sort: -:4: disorder: b"},
+		{"header only", "z\ny\n", SortOptions{Header: 3}, ""},
+		{"key name", "name,n\nb,1\na,2\n", SortOptions{CSV: true, KeyName: "n", Numeric: true}, ""},
+		{"key name disorder", "name,n\na,2\nb,1\n", SortOptions{CSV: true, KeyName: "n", Numeric: true}, "sort: -:3: disorder: b,1"},
+		{"unknown key name", "name,n\na,1\n", SortOptions{CSV: true, KeyName: "x"}, `column "x" not found`},
+	}
+	for _, c := range cases {
+		err := checkText(c.input, c.opts)
+		switch {
+		case c.want == "" && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.want != "" && (err == nil || !strings.Contains(err.Error(), c.want)):
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.want)
+		}
+	}
+}
+
+// TestHumanValue checks the decimal and binary multipliers of -h up to the
+// largest suffix, with no overflow and no suffix silently worth 0.
+func TestHumanValue(t *testing.T) {
+	cases := []struct {
+		key  string
+		want float64
+	}{
+		{"512", 512},
+		{"1K", 1e3},
+		{"1Ki", 1 << 10},
+		{"1.5Mi", 1.5 * (1 << 20)},
+		{"1P", 1e15},
+		{"1Pi", 1 << 50},
+		{"2E", 2e18},
+		{"2Ei", 2 * (1 << 60)},
+		{"1Z", 1e21},
+		{"1Yi", math.Ldexp(1, 80)},
+		{"1Q", 1e30},
+		{"1Qi", math.Ldexp(1, 100)},
+		{"1X", 1},
+		{"1KB", 1e3},
+	}
+	for _, c := range cases {
+		if got := cNumeric.humanValue(c.key); got != c.want {
+			t.Errorf("humanValue(%q) = %g, want %g", c.key, got, c.want)
+		}
+	}
+
+	input := []string{"2Ei", "1Pi", "3E", "1E", "1024Ti", "1P", "1Zi", "1Z"}
+	want := []string{"1P", "1024Ti", "1Pi", "1E", "2Ei", "3E", "1Z", "1Zi"}
+	if got := SortInMemory(slices.Clone(input), SortOptions{Human: true}); !slices.Equal(got, want) {
+		t.Errorf("-h: got %q, want %q", got, want)
+	}
+}
+
+// TestHumanJunkSuffix checks that -h keys with an unknown suffix compare by
+// their leading number instead of as zero.
+func TestHumanJunkSuffix(t *testing.T) {
+	cases := []struct {
+		key  string
+		want float64
+	}{
+		{"5foo", 5},
+		{"5 K", 5}, // суффикс должен идти сразу за числом
+		{"2.5x", 2.5},
+		{"-3abc", -3},
+		{"7KiB", 7 << 10},
+		{"foo", 0},
+	}
+	for _, c := range cases {
+		if got := cNumeric.humanValue(c.key); got != c.want {
+			t.Errorf("humanValue(%q) = %g, want %g", c.key, got, c.want)
+		}
+	}
+
+	input := []string{"5foo", "1K", "10bar", "-3abc", "7", "junk"}
+	want := []string{"-3abc", "junk", "5foo", "7", "10bar", "1K"}
+	if got := SortInMemory(slices.Clone(input), SortOptions{Human: true}); !slices.Equal(got, want) {
+		t.Errorf("-h: got %q, want %q", got, want)
+	}
+}
+
+// TestUniqueExact checks -n -u on keys that are equal by value but written
+// differently: by default one line is kept per value, with --unique-exact one
+// per distinct text, in memory and through temporary files.
+func TestUniqueExact(t *testing.T) {
+	input := "7\n007\n8\n7.0\n7\n08\n"
+	cases := []struct {
+		name string
+		opts SortOptions
+		want string
+	}{
+		{"by value", SortOptions{Numeric: true, Unique: true}, "007\n08\n"},
+		{"exact", SortOptions{Numeric: true, Unique: true, UniqueExact: true}, "007\n7\n7.0\n08\n8\n"},
+		{"exact key", SortOptions{Unique: true, UniqueExact: true, Keys: []KeySpec{{StartField: 1, Numeric: true}}},
+			"007\n7\n7.0\n08\n8\n"},
+		{"exact reverse", SortOptions{Numeric: true, Reverse: true, Unique: true, UniqueExact: true}, "8\n08\n7.0\n7\n007\n"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, input, c.opts); got != c.want {
+			t.Errorf("%s: Sort = %q, want %q", c.name, got, c.want)
+		}
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(input), &out, c.opts, 4); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != c.want {
+			t.Errorf("%s: ExternalSortReader = %q, want %q", c.name, out.String(), c.want)
+		}
+	}
+}
+
+// TestEpsilonUnique dedups near-equal floats with -u --epsilon: a group is
+// measured from its first line, so a chain of close neighbours is not merged
+// into one, and keys that are not numeric ignore the tolerance.
+func TestEpsilonUnique(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"no epsilon", "1.0000002\n1.0000001\n1.5\n", SortOptions{Numeric: true, Unique: true},
+			"1.0000001\n1.0000002\n1.5\n"},
+		{"epsilon", "1.0000002\n2.0000009\n1.0000001\n1.5\n2\n", SortOptions{Numeric: true, Unique: true, Epsilon: 1e-6},
+			"1.0000001\n1.5\n2\n"},
+		{"chain", "1.0\n1.0000006\n1.0000012\n", SortOptions{Numeric: true, Unique: true, Epsilon: 1e-6},
+			"1.0\n1.0000012\n"},
+		{"general numeric", "1e-9\n2e-9\n1\n", SortOptions{GeneralNumeric: true, Unique: true, Epsilon: 1e-6},
+			"1e-9\n1\n"},
+		{"text keys", "a\nb\n", SortOptions{Unique: true, Epsilon: 1}, "a\nb\n"},
+		{"second key", "x 1.01\nx 1.02\ny 1.01\n",
+			SortOptions{Unique: true, Epsilon: 0.05, Keys: []KeySpec{{StartField: 1, EndField: 1}, {StartField: 2, Numeric: true}}},
+			"x 1.01\ny 1.01\n"},
+	}
+	for _, c := range cases {
+		if got := sortText(t, c.input, c.opts); got != c.want {
+			t.Errorf("%s: Sort = %q, want %q", c.name, got, c.want)
+		}
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(c.input), &out, c.opts, 4); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != c.want {
+			t.Errorf("%s: ExternalSortReader = %q, want %q", c.name, out.String(), c.want)
+		}
+	}
+}
+
+// TestSortToTempFile checks that the sorted output stays in a file in the -T
+// directory until the caller removes it, and that on error no file is left.
+func TestSortToTempFile(t *testing.T) {
+	dir := t.TempDir()
+	path, err := SortToTempFile(strings.NewReader("b\nc\na\n"), SortOptions{TempDirs: []string{dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(path) != dir {
+		t.Errorf("file %s is not in the -T directory %s", path, dir)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "a\nb\nc\n" {
+		t.Errorf("file holds %q", data)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Errorf("caller cannot remove the file: %v", err)
+	}
+
+	_, err = SortToTempFile(strings.NewReader("a\x00\n"), SortOptions{TempDirs: []string{dir}, RejectNUL: true})
+	if err == nil {
+		t.Error("no error for a NUL byte with RejectNUL")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d files left after an error", len(entries))
+	}
+}
+
+// TestLinesMemoryMatchesHeap checks the estimate against the heap: when
+// readLines gives up at the limit, the lines it holds take about the limit.
+func TestLinesMemoryMatchesHeap(t *testing.T) {
+	const limit = 8 << 20
+	// Строки по 16 байт: заголовки строк в срезе весят столько же, сколько данные
+	input := strings.Repeat(strings.Repeat("x", 15)+"\n", limit/16)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	lines, err := readLines(NewLineReader(strings.NewReader(input), SortOptions{}), limit)
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(lines)
+	runtime.KeepAlive(input)
+
+	if !errors.Is(err, ErrInputTooLarge) {
+		t.Fatalf("readLines: err = %v, want ErrInputTooLarge", err)
+	}
+	used := int(after.HeapAlloc) - int(before.HeapAlloc)
+	if used < limit*9/10 || used > limit*11/10 {
+		t.Errorf("%d lines take %d bytes of heap at a limit of %d", len(lines), used, limit)
+	}
+}
+
+// TestReverseStableKeepsGroups checks that -r -s reverses the comparison of
+// keys only: lines with equal keys stay in input order, in memory and through
+// temporary files.
+func TestReverseStableKeepsGroups(t *testing.T) {
+	key := []KeySpec{{StartField: 2, EndField: 2}}
+	got := sortText(t, "a 1\nb 2\nc 1\nd 2\n", SortOptions{Keys: key, Reverse: true, Stable: true})
+	if want := "b 2\nd 2\na 1\nc 1\n"; got != want {
+		t.Errorf("-k2,2 -r -s: got %q, want %q", got, want)
+	}
+
+	lines := make([]string, 6000)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%06d %d", i, i%5)
+	}
+	var want []string
+	for group := 4; group >= 0; group-- {
+		for _, line := range lines {
+			if strings.HasSuffix(line, fmt.Sprint(group)) {
+				want = append(want, line)
+			}
+		}
+	}
+	opts := SortOptions{Keys: key, Reverse: true, Stable: true}
+	if got := sortText(t, joinLines(lines), opts); got != joinLines(want) {
+		t.Error("in memory: equal keys are not in input order")
+	}
+	opts.TempDirs = []string{t.TempDir()}
+	for _, parallel := range []bool{false, true} {
+		opts.ParallelMerge = parallel
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(joinLines(lines)), &out, opts, 16<<10); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != joinLines(want) {
+			t.Errorf("external, --parallel-merge %v: equal keys are not in input order", parallel)
+		}
+	}
+}
+
+// TestCheckSortingKeyDirections checks -c with a mixed-direction chain
+// -k2,2nr -k1,1: each key is checked in its own direction, and the key that
+// decided a disorder is reported.
+func TestCheckSortingKeyDirections(t *testing.T) {
+	keys := make([]KeySpec, 2)
+	for i, spec := range []string{"2,2nr", "1,1"} {
+		k, err := ParseKeySpec(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = k
+	}
+	cases := []struct {
+		name  string
+		input string
+		line  int // номер записи с нарушением; 0 — порядок верен
+		key   int
+	}{
+		{"ordered", "a 3\nb 3\na 1\nc 1\n", 0, 0},
+		{"second key descending", "b 3\na 3\na 1\nc 1\n", 2, 2},
+		{"first key ascending", "a 1\nc 1\na 3\nb 3\n", 3, 1},
+		{"both keys reversed", "c 1\na 1\nb 3\na 3\n", 2, 2},
+	}
+	for _, c := range cases {
+		err := checkText(c.input, SortOptions{Keys: keys})
+		var disorder *Disorder
+		switch {
+		case c.line == 0 && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.line > 0 && !errors.As(err, &disorder):
+			t.Errorf("%s: err = %v, want a disorder", c.name, err)
+		case c.line > 0 && (disorder.Line != c.line || disorder.Key != c.key):
+			t.Errorf("%s: disorder at line %d by key %d, want line %d by key %d", c.name, disorder.Line, disorder.Key, c.line, c.key)
+		}
+	}
+}
+
+// TestStablePassesCompose checks that sorting by A and then stably by B gives
+// the order of one sort by -k B -k A.
+func TestStablePassesCompose(t *testing.T) {
+	lines := mixedLines(3000)
+	parse := func(spec string) KeySpec {
+		k, err := ParseKeySpec(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	cases := []struct{ a, b string }{
+		{"1,1", "2,2h"},
+		{"3,3M", "1,1f"},
+		{"2,2nr", "3b,3"},
+		{"4,4g", "1,1r"},
+	}
+	for _, c := range cases {
+		a, b := parse(c.a), parse(c.b)
+		first := SortInMemory(slices.Clone(lines), SortOptions{Stable: true, Keys: []KeySpec{a}})
+		passes := SortInMemory(first, SortOptions{Stable: true, Keys: []KeySpec{b}})
+		composite := SortInMemory(slices.Clone(lines), SortOptions{Stable: true, Keys: []KeySpec{b, a}})
+		if !slices.Equal(passes, composite) {
+			t.Errorf("-k %s, then -s -k %s differs from -s -k %s -k %s", c.a, c.b, c.b, c.a)
+		}
+	}
+}
+
+// TestUniqueFoldsKeyCase checks -f -u -k2: keys that differ only in case are
+// duplicates in memory, through temporary files and in -m, and the survivor is
+// the first line of the group.
+func TestUniqueFoldsKeyCase(t *testing.T) {
+	input := "c FOO\nb foo\nd bar\na Foo\ne Bar\n"
+	fold, err := ParseKeySpec("2,2f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := []KeySpec{{StartField: 2, EndField: 2}}
+	cases := []struct {
+		name string
+		opts SortOptions
+		want string
+	}{
+		{"global -f", SortOptions{FoldCase: true, Keys: plain}, "d bar\na Foo\n"},
+		{"key f", SortOptions{Keys: []KeySpec{fold}}, "d bar\na Foo\n"},
+		{"stable", SortOptions{FoldCase: true, Stable: true, Keys: plain}, "d bar\nc FOO\n"},
+		{"without -f", SortOptions{Keys: plain}, "e Bar\nc FOO\na Foo\nd bar\nb foo\n"},
+	}
+	for _, c := range cases {
+		opts := c.opts
+		opts.Unique = true
+		opts.TempDirs = []string{t.TempDir()}
+		if got := sortText(t, input, opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+		// Лимит в 64 байта раскладывает ввод по нескольким временным файлам
+		var out bytes.Buffer
+		if err := ExternalSortReader(strings.NewReader(input), &out, opts, 64); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != c.want {
+			t.Errorf("%s, external: got %q, want %q", c.name, out.String(), c.want)
+		}
+	}
+
+	// -m: дубликаты из разных файлов тоже равны без учёта регистра
+	dir := t.TempDir()
+	var sources []string
+	for i, content := range []string{"b bar\nc foo\n", "a BAR\nd FOO\n"} {
+		path := filepath.Join(dir, fmt.Sprint(i))
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, path)
+	}
+	var out bytes.Buffer
+	if err := MergeSorted(sources, &out, SortOptions{Unique: true, FoldCase: true, Keys: plain}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "a BAR\nc foo\n"; out.String() != want {
+		t.Errorf("-m: got %q, want %q", out.String(), want)
+	}
+}
+
+// TestCountUnique checks --uniq-count: runs of equal keys in sorted input are
+// written once with their size, and a key out of order is a disorder.
+func TestCountUnique(t *testing.T) {
+	keyN := []KeySpec{{StartField: 2, EndField: 2, Numeric: true}}
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+		err   string // пусто — без ошибки
+	}{
+		{"lines", "a\na\nb\n", SortOptions{}, "2\ta\n1\tb\n", ""},
+		{"numeric key", "a 1\nb 1\nc 2\nd 10\ne 10\nf 10\n", SortOptions{Keys: keyN}, "2\ta 1\n1\tc 2\n3\td 10\n", ""},
+		{"equal keys any order", "b 1\na 1\nc 2\n", SortOptions{Keys: keyN}, "2\tb 1\n1\tc 2\n", ""},
+		{"numeric values", "x 007\ny 7.0\nz 7\n", SortOptions{Keys: keyN}, "3\tx 007\n", ""},
+		{"separator", "a:1\nb:1\n", SortOptions{Separator: ":", Keys: []KeySpec{{StartField: 2, EndField: 2}}}, "2:a:1\n", ""},
+		{"fold case", "A\na\nb\n", SortOptions{FoldCase: true}, "2\tA\n1\tb\n", ""},
+		{"header", "name\na\na\n", SortOptions{Header: 1}, "name\n2\ta\n", ""},
+		{"empty", "", SortOptions{}, "", ""},
+		{"disorder", "a\nc\nb\n", SortOptions{}, "", "sort: -:3: disorder: b"},
+		{"disorder after header", "z\nb\na\n", SortOptions{Header: 1}, "", "sort: -:3: disorder: a"},
+	}
+	for _, c := range cases {
+		var out bytes.Buffer
+		err := CountUnique(NewLineReader(strings.NewReader(c.input), c.opts), &out, "-", c.opts)
+		var sortErr *SortError
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.err != "" && (!errors.As(err, &sortErr) || sortErr.Kind != KindDisorder || err.Error() != c.err):
+			t.Errorf("%s: err = %v, want a disorder %q", c.name, err, c.err)
+		}
+		if out.String() != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, out.String(), c.want)
+		}
+	}
+}
+
+// TestSortInMemoryMutatesSorted checks the mutation contract: SortInMemory
+// reorders the caller's slice (with -u the result is its prefix), Sorted leaves
+// it unchanged.
+func TestSortInMemoryMutatesSorted(t *testing.T) {
+	input := []string{"c", "a", "b", "a"}
+	cases := []struct {
+		name string
+		opts SortOptions
+	}{
+		{"default", SortOptions{}},
+		{"reverse", SortOptions{Reverse: true}},
+		{"unique", SortOptions{Unique: true}},
+		{"stable numeric", SortOptions{Stable: true, Numeric: true}},
+	}
+	for _, c := range cases {
+		lines := slices.Clone(input)
+		got := Sorted(lines, c.opts)
+		if !slices.Equal(lines, input) {
+			t.Errorf("%s: Sorted changed its input to %q", c.name, lines)
+		}
+
+		inPlace := SortInMemory(lines, c.opts)
+		if !slices.Equal(inPlace, got) {
+			t.Errorf("%s: SortInMemory = %q, Sorted = %q", c.name, inPlace, got)
+		}
+		if &inPlace[0] != &lines[0] || !slices.Equal(lines[:len(inPlace)], got) {
+			t.Errorf("%s: SortInMemory left its input as %q, want it to start with %q", c.name, lines, got)
+		}
+	}
+}
+
+// TestCheckSortingZeroTerminated checks -cz: records end with NUL, a newline
+// inside a record is part of it, and a disorder reports the whole record.
+func TestCheckSortingZeroTerminated(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   string
+		opts    SortOptions
+		line    int // номер записи с нарушением; 0 — порядок верен
+		current string
+	}{
+		{"sorted", "a\nz\x00b\x00c\n\x00", SortOptions{}, 0, ""},
+		{"sorted by key", "x 1\ny 9\x00a 2\x00", SortOptions{Numeric: true, Keys: []KeySpec{{StartField: 2, EndField: 2}}}, 0, ""},
+		{"unsorted", "b\x00a\nz\x00c\x00", SortOptions{}, 2, "a\nz"},
+		{"unsorted by key", "a 2\x00b 1\nc 3\x00", SortOptions{Numeric: true, Keys: []KeySpec{{StartField: 2, EndField: 2}}}, 2, "b 1\nc 3"},
+		{"newline is not a terminator", "b\na\x00", SortOptions{}, 0, ""},
+	}
+	for _, c := range cases {
+		opts := c.opts
+		opts.ZeroTerminated = true
+		err := checkText(c.input, opts)
+		var disorder *Disorder
+		switch {
+		case c.line == 0 && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.line > 0 && !errors.As(err, &disorder):
+			t.Errorf("%s: err = %v, want a disorder", c.name, err)
+		case c.line > 0 && (disorder.Line != c.line || disorder.Current != c.current):
+			t.Errorf("%s: disorder at %d in %q, want %d in %q", c.name, disorder.Line, disorder.Current, c.line, c.current)
+		}
+	}
+}
+
+// TestCheckPlainBytes checks the byte fast path of -c: order, reverse, strict
+// checks, headers and line numbers, and that checking sorted input does not
+// allocate per record.
+func TestCheckPlainBytes(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		line  int // номер строки с нарушением; 0 — порядок верен
+		prev  string
+		curr  string
+	}{
+		{"sorted", "a\nb\nb\nc\n", SortOptions{}, 0, "", ""},
+		{"empty", "", SortOptions{}, 0, "", ""},
+		{"one line", "z\n", SortOptions{}, 0, "", ""},
+		{"bytes", "B\na\nz\né\n", SortOptions{}, 0, "", ""},
+		{"unsorted", "a\nc\nb\n", SortOptions{}, 3, "c", "b"},
+		{"prefix", "ab\na\n", SortOptions{}, 2, "ab", "a"},
+		{"utf-8 after ascii", "é\nz\n", SortOptions{}, 2, "é", "z"},
+		{"reverse", "c\nb\nb\na\n", SortOptions{Reverse: true}, 0, "", ""},
+		{"reverse unsorted", "b\nc\n", SortOptions{Reverse: true}, 2, "b", "c"},
+		{"strict", "a\na\n", SortOptions{CheckStrict: true}, 2, "a", "a"},
+		{"unique", "a\nb\nb\n", SortOptions{Unique: true}, 3, "b", "b"},
+		{"header", "z\na\nb\n", SortOptions{Header: 1}, 0, "", ""},
+		{"header unsorted", "z\nb\na\n", SortOptions{Header: 1}, 3, "b", "a"},
+		{"zero terminated", "b\x00a\x00", SortOptions{ZeroTerminated: true}, 2, "b", "a"},
+	}
+	for _, c := range cases {
+		if !newComparator(c.opts).plainBytes(c.opts) {
+			t.Fatalf("%s: %+v does not take the byte fast path", c.name, c.opts)
+		}
+		err := checkText(c.input, c.opts)
+		var disorder *Disorder
+		switch {
+		case c.line == 0 && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.line > 0 && !errors.As(err, &disorder):
+			t.Errorf("%s: err = %v, want a disorder", c.name, err)
+		case c.line > 0 && (disorder.Line != c.line || disorder.Previous != c.prev || disorder.Current != c.curr):
+			t.Errorf("%s: disorder %d %q %q, want %d %q %q", c.name, disorder.Line, disorder.Previous, disorder.Current, c.line, c.prev, c.curr)
+		}
+	}
+
+	// Число выделений не растёт с числом строк
+	input := joinLines(SortInMemory(numberedLines("line", 10000), SortOptions{}))
+	allocs := testing.AllocsPerRun(5, func() {
+		if err := checkText(input, SortOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 50 {
+		t.Errorf("checking 10000 sorted lines made %.0f allocations, want a constant few", allocs)
+	}
+}
+
+// TestEmptyInput checks that empty input (sort </dev/null) gives empty output
+// and no error in every mode, and that --split-lines creates no files.
+func TestEmptyInput(t *testing.T) {
+	empty := func() io.Reader { return strings.NewReader("") }
+	sortCases := []struct {
+		name string
+		opts SortOptions
+	}{
+		{"default", SortOptions{}},
+		{"unique", SortOptions{Unique: true}},
+		{"numeric reverse", SortOptions{Numeric: true, Reverse: true}},
+		{"key", SortOptions{Keys: []KeySpec{{StartField: 2, EndField: 2}}}},
+		{"zero terminated", SortOptions{ZeroTerminated: true}},
+		{"header", SortOptions{Header: 1}},
+		{"footer", SortOptions{Footer: 1}},
+		{"verify", SortOptions{Verify: true}},
+		{"group", SortOptions{Group: true}},
+		{"require unique", SortOptions{RequireUnique: true}},
+		{"external", SortOptions{BufferSize: 1, TempDirs: []string{t.TempDir()}}},
+		{"parallel", SortOptions{Parallel: 4, ParallelThreshold: 1}},
+	}
+	for _, c := range sortCases {
+		if got := sortText(t, "", c.opts); got != "" {
+			t.Errorf("%s: got %q, want no output", c.name, got)
+		}
+	}
+
+	for _, strict := range []bool{false, true} {
+		if err := checkText("", SortOptions{CheckStrict: strict}); err != nil {
+			t.Errorf("-c, strict %v: %v", strict, err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := CountUnique(NewLineReader(empty(), SortOptions{}), &out, "-", SortOptions{}); err != nil || out.Len() != 0 {
+		t.Errorf("--uniq-count: output %q, err %v", out.String(), err)
+	}
+	out.Reset()
+	if err := SortInputs([]io.Reader{empty(), empty()}, &out, SortOptions{}); err != nil || out.Len() != 0 {
+		t.Errorf("SortInputs: output %q, err %v", out.String(), err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "empty")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := MergeSorted([]string{path, path}, &out, SortOptions{Unique: true}); err != nil || out.Len() != 0 {
+		t.Errorf("-m: output %q, err %v", out.String(), err)
+	}
+	out.Reset()
+	if err := SortMapped(path, &out, SortOptions{}); err != nil || out.Len() != 0 {
+		t.Errorf("--mmap: output %q, err %v", out.String(), err)
+	}
+
+	split := NewSplitWriter(filepath.Join(dir, "part"), 10, 0, SortOptions{})
+	if err := Sort(empty(), split, SortOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := split.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("--split-lines created %d files, want none", len(entries)-1)
+	}
+}
+
+// TestCountKeys counts distinct keys with the equality of -u, in memory and in
+// the external sort, and checks that header and footer lines are not counted.
+func TestCountKeys(t *testing.T) {
+	numericKey := []KeySpec{{StartField: 2, EndField: 2, Numeric: true}}
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  int
+	}{
+		{"-k2 -n", "a 1\nb 2\nc 01\nd 2\ne 3\nf 1.0\n", SortOptions{Keys: numericKey}, 3},
+		{"-k2 -n unique exact", "a 1\nb 2\nc 01\nd 2\ne 3\nf 1.0\n", SortOptions{Keys: numericKey, UniqueExact: true}, 5},
+		{"epsilon", "a 1\nb 1.05\nc 2\n", SortOptions{Keys: numericKey, Epsilon: 0.1}, 2},
+		{"whole line", "b\na\nb\nc\na\n", SortOptions{}, 3},
+		{"fold case", "B\nb\na\n", SortOptions{FoldCase: true}, 2},
+		{"empty", "", SortOptions{}, 0},
+		{"header", "name n\na 1\nb 1\n", SortOptions{Keys: numericKey, Header: 1}, 1},
+		{"footer", "a 1\nb 2\ntotal 3\n", SortOptions{Keys: numericKey, Footer: 1}, 2},
+		{"zero terminated", "b\x00a\nx\x00b\x00", SortOptions{ZeroTerminated: true}, 2},
+		{"record separator", "b\n\na\n\nb\n\n", SortOptions{RecordSeparator: "\n\n"}, 2},
+		{"external", joinLines(slices.Concat(numberedLines("x", 2000), numberedLines("x", 2000))), SortOptions{BufferSize: 4096, TempDirs: []string{t.TempDir()}}, 2000},
+	}
+	for _, c := range cases {
+		n, err := CountKeys(strings.NewReader(c.input), c.opts)
+		if err != nil || n != c.want {
+			t.Errorf("%s: CountKeys = %d, %v, want %d", c.name, n, err, c.want)
+		}
+	}
+}
+
+// TestRecordCounter writes terminators split across calls to Write.
+func TestRecordCounter(t *testing.T) {
+	c := &recordCounter{term: []byte("\n\n")}
+	for _, p := range []string{"a\n", "\nb", "\n", "\n", "\n\nc\n\n\n"} {
+		if _, err := c.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.count != 4 {
+		t.Errorf("count = %d, want 4", c.count)
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// TestCheckSortingStopsEarly checks that CheckSorting returns at the first
+// disorder, having read only a bounded prefix of a large input, and that it
+// reads sorted input to the end.
+func TestCheckSortingStopsEarly(t *testing.T) {
+	sorted := slices.Sorted(slices.Values(numberedLines("line", 200000)))
+	unsorted := slices.Clone(sorted)
+	unsorted[10], unsorted[11] = unsorted[11], unsorted[10]
+
+	r := &countingReader{r: strings.NewReader(joinLines(unsorted))}
+	var disorder *Disorder
+	if err := CheckSorting(NewLineReader(r, SortOptions{}), "-", SortOptions{}); !errors.As(err, &disorder) {
+		t.Fatalf("CheckSorting of unsorted input = %v, want a disorder", err)
+	}
+	if r.n > 1<<16 {
+		t.Errorf("read %d bytes up to a disorder at line 12, want at most %d", r.n, 1<<16)
+	}
+
+	input := joinLines(sorted)
+	r = &countingReader{r: strings.NewReader(input)}
+	if err := CheckSorting(NewLineReader(r, SortOptions{}), "-", SortOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if r.n != len(input) {
+		t.Errorf("read %d bytes of sorted input, want all %d", r.n, len(input))
+	}
+}
+
+// TestMergeSlices merges pre-sorted slices with -n and -u, takes equal lines
+// from the earlier slice first and leaves the input slices unchanged.
+func TestMergeSlices(t *testing.T) {
+	numericKey := []KeySpec{{StartField: 1, EndField: 1, Numeric: true}}
+	cases := []struct {
+		name   string
+		slices [][]string
+		opts   SortOptions
+		want   []string
+	}{
+		{"text", [][]string{{"a", "c", "e"}, {"b", "d"}, {"f"}}, SortOptions{}, []string{"a", "b", "c", "d", "e", "f"}},
+		{"numeric", [][]string{{"2", "10", "100"}, {"1", "9"}, {"3", "20"}}, SortOptions{Numeric: true}, []string{"1", "2", "3", "9", "10", "20", "100"}},
+		{"numeric unique", [][]string{{"1", "02", "10"}, {"1.0", "2", "9"}}, SortOptions{Numeric: true, Unique: true}, []string{"1", "02", "9", "10"}},
+		{"unique text", [][]string{{"a", "b"}, {"a", "b", "c"}, {"c"}}, SortOptions{Unique: true}, []string{"a", "b", "c"}},
+		{"stable", [][]string{{"1 x", "2 z"}, {"1 a", "2 b"}}, SortOptions{Stable: true, Keys: numericKey}, []string{"1 x", "1 a", "2 z", "2 b"}},
+		{"reverse", [][]string{{"c", "a"}, {"d", "b"}}, SortOptions{Reverse: true}, []string{"d", "c", "b", "a"}},
+		{"empty slices", [][]string{nil, {"a"}, {}}, SortOptions{}, []string{"a"}},
+		{"nothing", nil, SortOptions{}, []string{}},
+	}
+	for _, c := range cases {
+		before := make([][]string, len(c.slices))
+		for i, s := range c.slices {
+			before[i] = slices.Clone(s)
+		}
+		got := MergeSlices(c.slices, c.opts)
+		if !slices.Equal(got, c.want) {
+			t.Errorf("%s: MergeSlices = %q, want %q", c.name, got, c.want)
+		}
+		for i := range c.slices {
+			if !slices.Equal(c.slices[i], before[i]) {
+				t.Errorf("%s: slice %d changed to %q", c.name, i, c.slices[i])
+			}
+		}
+	}
+}
+
+// TestSortOutputPassesCheck sorts the same input with many option sets, in
+// memory and through temporary files, and checks the output with -c and the
+// same options: it must always pass, and with -u even the strict check.
+func TestSortOutputPassesCheck(t *testing.T) {
+	lines := mixedLines(2000)
+	key := func(spec string) KeySpec {
+		k, err := ParseKeySpec(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	cases := []struct {
+		name string
+		opts SortOptions
+	}{
+		{"default", SortOptions{}},
+		{"reverse", SortOptions{Reverse: true}},
+		{"numeric", SortOptions{Numeric: true}},
+		{"general numeric", SortOptions{GeneralNumeric: true}},
+		{"fold case", SortOptions{FoldCase: true}},
+		{"dictionary", SortOptions{Dictionary: true, IgnoreBlanks: true}},
+		{"stable key", SortOptions{Stable: true, Keys: []KeySpec{key("1,1f")}}},
+		{"no last resort", SortOptions{NoLastResort: true, Keys: []KeySpec{key("1,1")}}},
+		{"tiebreak key", SortOptions{KeyTieBreak: true, Numeric: true, Keys: []KeySpec{key("3,3")}}},
+		{"human key", SortOptions{Keys: []KeySpec{key("2,2h")}}},
+		{"month key", SortOptions{Keys: []KeySpec{key("3b,3M")}}},
+		{"version key", SortOptions{Keys: []KeySpec{key("2,2V")}}},
+		{"mixed directions", SortOptions{Keys: []KeySpec{key("2,2nr"), key("1,1f"), key("3,3M")}}},
+		{"separator", SortOptions{Separator: "\t", Keys: []KeySpec{key("2,2r"), key("1.2,1.4")}}},
+		{"random", SortOptions{Random: true}},
+		{"locale", SortOptions{Locale: "fr_FR.UTF-8"}},
+		{"natural", SortOptions{Natural: true}},
+		{"epoch key", SortOptions{Epoch: EpochAuto, Keys: []KeySpec{key("3,3")}}},
+		{"transform", SortOptions{Transform: []string{"lower", "strip-punct"}}},
+	}
+	for _, c := range cases {
+		for _, unique := range []bool{false, true} {
+			for _, limit := range []int{0, 16 << 10} {
+				opts := c.opts
+				opts.Unique = unique
+				opts.BufferSize = limit
+				opts.TempDirs = []string{t.TempDir()}
+				out := sortText(t, joinLines(lines), opts)
+				if err := checkText(out, opts); err != nil {
+					t.Errorf("%s, -u %v, -S %d: %v", c.name, unique, limit, err)
+				}
+			}
+		}
+	}
+}
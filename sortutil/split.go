@@ -0,0 +1,112 @@
+package sortutil
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// SplitWriter writes the sorted output into files PREFIX000, PREFIX001, ...
+// (--split-lines, --split-bytes), like split(1) with -l or -C: a record is never
+// split between files, and a new file starts before the record that would exceed
+// the limit. A record longer than the byte limit takes a file of its own. Close
+// must be called after the sort to write the last file.
+type SplitWriter struct {
+	prefix   string
+	term     []byte
+	maxLines int
+	maxBytes int64
+
+	file    *os.File
+	w       *bufio.Writer
+	files   int
+	lines   int   // записей в текущем файле
+	size    int64 // байт в текущем файле
+	pending []byte
+}
+
+// NewSplitWriter returns a SplitWriter putting at most maxLines records and
+// maxBytes bytes into every file; zero means no limit. Records are split at the
+// output terminator of opts: a newline, NUL or the --record-separator.
+func NewSplitWriter(prefix string, maxLines int, maxBytes int64, opts SortOptions) *SplitWriter {
+	return &SplitWriter{prefix: prefix, term: []byte(opts.outputTerminator()), maxLines: maxLines, maxBytes: maxBytes}
+}
+
+// Write buffers p and writes every complete record in it; the tail without
+// a terminator waits for the next Write or for Close.
+func (s *SplitWriter) Write(p []byte) (int, error) {
+	s.pending = append(s.pending, p...)
+	rest := s.pending
+	for {
+		i := bytes.Index(rest, s.term)
+		if i < 0 {
+			break
+		}
+		end := i + len(s.term)
+		if err := s.record(rest[:end]); err != nil {
+			return 0, err
+		}
+		rest = rest[end:]
+	}
+	s.pending = append(s.pending[:0], rest...)
+	return len(p), nil
+}
+
+func (s *SplitWriter) record(rec []byte) error {
+	if s.file != nil && s.full(len(rec)) {
+		if err := s.closeFile(); err != nil {
+			return err
+		}
+	}
+	if s.file == nil {
+		name := fmt.Sprintf("%s%03d", s.prefix, s.files)
+		file, err := os.Create(name)
+		if err != nil {
+			return newError(KindIO, fmt.Errorf("sort: cannot create '%s': %v", name, err))
+		}
+		s.file, s.w = file, bufio.NewWriter(file)
+		s.files++
+		s.lines, s.size = 0, 0
+	}
+	s.lines++
+	s.size += int64(len(rec))
+	_, err := s.w.Write(rec)
+	return err
+}
+
+// full reports whether the current file cannot take one more record of n bytes.
+func (s *SplitWriter) full(n int) bool {
+	if s.maxLines > 0 && s.lines >= s.maxLines {
+		return true
+	}
+	return s.maxBytes > 0 && s.size+int64(n) > s.maxBytes
+}
+
+func (s *SplitWriter) closeFile() error {
+	err := s.w.Flush()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	name := s.file.Name()
+	s.file, s.w = nil, nil
+	if err != nil {
+		return newError(KindIO, fmt.Errorf("sort: write failed: %s: %v", name, err))
+	}
+	return nil
+}
+
+// Close writes the last record, even one without a terminator, and closes
+// the last file. Empty output creates no files.
+func (s *SplitWriter) Close() error {
+	if len(s.pending) > 0 {
+		if err := s.record(s.pending); err != nil {
+			return err
+		}
+		s.pending = nil
+	}
+	if s.file == nil {
+		return nil
+	}
+	return s.closeFile()
+}
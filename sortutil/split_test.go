@@ -0,0 +1,108 @@
+package sortutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// splitFiles returns the contents of the files PREFIX000, PREFIX001, ... in dir.
+func splitFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	for i := 0; ; i++ {
+		data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("x%03d", i)))
+		if os.IsNotExist(err) {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, string(data))
+	}
+}
+
+// TestSplitWriter sorts into files of at most N lines or N bytes: the files
+// concatenate to the full sorted output, and no record is split between files.
+func TestSplitWriter(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		maxLines int
+		maxBytes int64
+		opts     SortOptions
+		want     []string
+	}{
+		{"lines", "e\nd\nc\nb\na\n", 2, 0, SortOptions{}, []string{"a\nb\n", "c\nd\n", "e\n"}},
+		{"lines exact", "d\nc\nb\na\n", 2, 0, SortOptions{}, []string{"a\nb\n", "c\nd\n"}},
+		{"bytes", "ccc\nbb\na\n", 0, 5, SortOptions{}, []string{"a\nbb\n", "ccc\n"}},
+		{"record longer than limit", "long record\na\n", 0, 4, SortOptions{}, []string{"a\n", "long record\n"}},
+		{"lines and bytes", "d\nc\nbbbb\na\n", 3, 6, SortOptions{}, []string{"a\n", "bbbb\n", "c\nd\n"}},
+		{"zero terminated", "b\x00a\nx\x00c\x00", 1, 0, SortOptions{ZeroTerminated: true}, []string{"a\nx\x00", "b\x00", "c\x00"}},
+		{"output zero", "b\na\n", 1, 0, SortOptions{OutputZero: true}, []string{"a\x00", "b\x00"}},
+		{"record separator", "b;\na;\n", 1, 0, SortOptions{RecordSeparator: ";\n"}, []string{"a;\n", "b;\n"}},
+		{"empty", "", 1, 0, SortOptions{}, nil},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		split := NewSplitWriter(filepath.Join(dir, "x"), c.maxLines, c.maxBytes, c.opts)
+		if err := Sort(strings.NewReader(c.input), split, c.opts); err != nil {
+			t.Fatal(err)
+		}
+		if err := split.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got := splitFiles(t, dir)
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", c.want) {
+			t.Errorf("%s: files %q, want %q", c.name, got, c.want)
+		}
+		if joined := strings.Join(got, ""); joined != sortText(t, c.input, c.opts) {
+			t.Errorf("%s: files concatenate to %q, not the sorted output", c.name, joined)
+		}
+	}
+}
+
+// TestSplitWriterManyFiles sorts 100 lines into files of 7: 15 files that
+// concatenate to the sorted output, through the external sort as well.
+func TestSplitWriterManyFiles(t *testing.T) {
+	input := joinLines(numberedLines("line", 100))
+	for _, limit := range []int{1 << 20, 200} {
+		dir := t.TempDir()
+		split := NewSplitWriter(filepath.Join(dir, "x"), 7, 0, SortOptions{})
+		if err := ExternalSortReader(strings.NewReader(input), split, SortOptions{}, limit); err != nil {
+			t.Fatal(err)
+		}
+		if err := split.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got := splitFiles(t, dir)
+		if len(got) != 15 || strings.Count(got[14], "\n") != 2 {
+			t.Errorf("limit %d: %d files, want 14 of 7 lines and one of 2", limit, len(got))
+		}
+		if strings.Join(got, "") != sortText(t, input, SortOptions{}) {
+			t.Errorf("limit %d: files do not concatenate to the sorted output", limit)
+		}
+	}
+}
+
+// TestSplitWriterPartialWrites feeds the output one byte at a time, so that
+// records and terminators are split between Write calls, and ends without a
+// final terminator.
+func TestSplitWriterPartialWrites(t *testing.T) {
+	dir := t.TempDir()
+	split := NewSplitWriter(filepath.Join(dir, "x"), 2, 0, SortOptions{RecordSeparator: ";\n"})
+	for _, b := range []byte("a;\nb;\nc;\nd") {
+		if _, err := split.Write([]byte{b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := split.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a;\nb;\n", "c;\nd"}
+	if got := splitFiles(t, dir); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+		t.Errorf("files %q, want %q", got, want)
+	}
+}
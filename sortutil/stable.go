@@ -0,0 +1,71 @@
+package sortutil
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// seqTagLen is the length of the input sequence tag that --assert-stable puts
+// before every record: a fixed-width hexadecimal number, which holds neither a
+// newline nor a NUL and so survives temporary files.
+const seqTagLen = 16
+
+// stability asserts that -s keeps lines with equal keys in their input order
+// (--assert-stable). Every input record is tagged with its sequence number,
+// which sorting and merging skip when comparing; the tag is removed on output,
+// and adjacent records with equal keys must have ascending numbers. Memory does
+// not depend on the input size, so the check works with an external sort too.
+type stability struct {
+	comp    *comparator
+	active  bool // заголовок (--header) не учитывается
+	read    uint64
+	prev    string
+	prevSeq uint64
+	hasPrev bool
+
+	violations  int
+	first, next string // первая пара, нарушившая порядок ввода
+}
+
+func newStability() *stability {
+	return &stability{}
+}
+
+// observe wraps split to tag every record with its input sequence number.
+func (c *stability) observe(split bufio.SplitFunc) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if token != nil && c.active {
+			c.read++
+			tagged := make([]byte, 0, seqTagLen+len(token))
+			tagged = fmt.Appendf(tagged, "%0*x", seqTagLen, c.read)
+			token = append(tagged, token...)
+		}
+		return advance, token, err
+	}
+}
+
+// output checks the tagged record against the previous output record and
+// returns it without the tag.
+func (c *stability) output(tagged string) string {
+	seq, _ := strconv.ParseUint(tagged[:seqTagLen], 16, 64)
+	record := tagged[seqTagLen:]
+	if c.hasPrev && c.prevSeq > seq && c.comp.compareKeys(c.prev, record) == 0 {
+		if c.violations == 0 {
+			c.first, c.next = c.prev, record
+		}
+		c.violations++
+	}
+	c.prev, c.prevSeq, c.hasPrev = record, seq, true
+	return record
+}
+
+// report warns on stderr about lines with equal keys output out of input order.
+func (c *stability) report() {
+	if c != nil && c.violations > 0 {
+		fmt.Fprintf(os.Stderr, "sort: --assert-stable: %d pairs of lines with equal keys are out of input order, first %q before %q\n",
+			c.violations, c.first, c.next)
+	}
+}
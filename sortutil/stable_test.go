@@ -0,0 +1,119 @@
+package sortutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stableLines returns n lines with few distinct keys in the first field and
+// the input position in the second, so that equal keys have to keep their order.
+func stableLines(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("k%02d %06d", (n-i)%7, i)
+	}
+	return lines
+}
+
+// TestAssertStable sorts with --assert-stable in memory, in parallel, through
+// temporary files and through mmap: the output must be the plain -s output,
+// without the sequence tags, and no violation may be reported.
+func TestAssertStable(t *testing.T) {
+	lines := stableLines(20000)
+	key := []KeySpec{{StartField: 1, EndField: 1}}
+	cases := []struct {
+		name string
+		opts SortOptions
+	}{
+		{"in memory", SortOptions{Keys: key}},
+		{"parallel", SortOptions{Keys: key, Parallel: 4, ParallelThreshold: 1}},
+		{"external", SortOptions{Keys: key, BufferSize: 16 << 10}},
+		{"multi-level merge", SortOptions{Keys: key, BufferSize: 16 << 10, BatchSize: 2}},
+		{"parallel merge", SortOptions{Keys: key, BufferSize: 16 << 10, MergeThreads: 4}},
+		{"unique", SortOptions{Keys: key, Unique: true, BufferSize: 16 << 10}},
+		{"verify", SortOptions{Keys: key, Verify: true}},
+		{"only keys", SortOptions{Keys: key, OnlyKeys: true}},
+		{"whole line", SortOptions{}},
+		{"key from byte", SortOptions{KeyFromByte: 2}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			opts := c.opts
+			opts.Stable = true
+			opts.TempDirs = []string{tempLeakDir(t)}
+			want := sortText(t, joinLines(lines), opts)
+
+			opts.AssertStable = true
+			var got string
+			warning := captureStderr(t, func() { got = sortText(t, joinLines(lines), opts) })
+			if got != want {
+				t.Errorf("output with --assert-stable differs from -s")
+			}
+			if warning != "" {
+				t.Errorf("unexpected warning: %s", warning)
+			}
+		})
+	}
+
+	t.Run("mmap", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "input")
+		if err := os.WriteFile(path, []byte(joinLines(lines)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		opts := SortOptions{Keys: key, Stable: true, AssertStable: true}
+		var out strings.Builder
+		warning := captureStderr(t, func() {
+			if err := SortMapped(path, &out, opts); err != nil {
+				t.Fatal(err)
+			}
+		})
+		opts.AssertStable = false
+		if want := sortText(t, joinLines(lines), opts); out.String() != want {
+			t.Errorf("output with --assert-stable differs from -s")
+		}
+		if warning != "" {
+			t.Errorf("unexpected warning: %s", warning)
+		}
+	})
+}
+
+// TestStabilityOutput feeds tagged records straight to the check: only equal
+// keys out of input order are violations, identical lines included.
+func TestStabilityOutput(t *testing.T) {
+	tag := func(seq int, record string) string {
+		return fmt.Sprintf("%0*x%s", seqTagLen, seq, record)
+	}
+	cases := []struct {
+		name   string
+		output []string
+		want   int
+	}{
+		{"input order", []string{tag(1, "a 1"), tag(3, "a 2"), tag(2, "b 1")}, 0},
+		{"other keys", []string{tag(3, "a 1"), tag(2, "b 1"), tag(1, "c 1")}, 0},
+		{"equal keys swapped", []string{tag(2, "a 2"), tag(1, "a 1"), tag(3, "b 1")}, 1},
+		{"identical lines swapped", []string{tag(2, "a 1"), tag(1, "a 1")}, 1},
+		{"every pair", []string{tag(3, "a 3"), tag(2, "a 2"), tag(1, "a 1")}, 2},
+	}
+	for _, c := range cases {
+		check := newStability()
+		check.comp = newComparator(SortOptions{Stable: true, Keys: []KeySpec{{StartField: 1, EndField: 1}}})
+		for i, tagged := range c.output {
+			if record := check.output(tagged); record != tagged[seqTagLen:] {
+				t.Errorf("%s: output %d = %q, want it without the tag", c.name, i, record)
+			}
+		}
+		if check.violations != c.want {
+			t.Errorf("%s: %d violations, want %d", c.name, check.violations, c.want)
+		}
+	}
+
+	check := newStability()
+	check.violations, check.first, check.next = 2, "a 2", "a 1"
+	warning := captureStderr(t, check.report)
+	if want := `sort: --assert-stable: 2 pairs of lines with equal keys are out of input order, first "a 2" before "a 1"`; !strings.Contains(warning, want) {
+		t.Errorf("report: %q, want %q", warning, want)
+	}
+}
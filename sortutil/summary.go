@@ -0,0 +1,100 @@
+package sortutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// summary accumulates count, min, max and sum of the numeric keys read (--summary).
+// The key is the first sort key; lines without a number in it are not counted.
+type summary struct {
+	key      KeySpec
+	active   bool // заголовок (--header) в статистику не входит
+	count    int
+	min, max float64
+	sum      float64
+}
+
+func newSummary(opts SortOptions) *summary {
+	return &summary{key: newComparator(opts).keys[0], min: math.Inf(1), max: math.Inf(-1)}
+}
+
+// observe wraps split so that every record it returns is added to the summary
+// during the read pass, whichever path (in memory or external) consumes it.
+func (s *summary) observe(split bufio.SplitFunc) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if token != nil && s.active {
+			s.add(string(token))
+		}
+		return advance, token, err
+	}
+}
+
+func (s *summary) add(line string) {
+	value, ok := s.key.number(s.key.extract(line))
+	if !ok {
+		return
+	}
+	s.count++
+	s.min = min(s.min, value)
+	s.max = max(s.max, value)
+	s.sum += value
+}
+
+func (s *summary) report(w io.Writer) {
+	if s.count == 0 {
+		fmt.Fprintln(w, "sort: summary: count=0")
+		return
+	}
+	fmt.Fprintf(w, "sort: summary: count=%d min=%g max=%g sum=%g mean=%g\n",
+		s.count, s.min, s.max, s.sum, s.sum/float64(s.count))
+}
+
+// numericMode reports whether keys of k are compared as numbers.
+func (k KeySpec) numericMode() bool {
+	switch k.mode() {
+	case ModeNumeric, ModeGeneralNumeric, ModeHuman, ModeMoney, ModeDuration:
+		return true
+	}
+	return false
+}
+
+// number parses key as a number in the mode of k: -g, -h, --money and --duration
+// (in seconds) have their own syntax, every other mode reads the leading number like -n.
+// The number is multiplied by the --scale factor of the key.
+func (k KeySpec) number(key string) (float64, bool) {
+	value, ok := k.unscaled(key)
+	if k.scale != 0 {
+		value *= k.scale
+	}
+	return value, ok
+}
+
+func (k KeySpec) unscaled(key string) (float64, bool) {
+	switch k.mode() {
+	case ModeGeneralNumeric:
+		return generalValue(key)
+	case ModeHuman:
+		if _, _, ok := k.numeric.parse(key); !ok {
+			return 0, false
+		}
+		return k.numeric.humanValue(key), true
+	case ModeMoney:
+		return moneyValue(key)
+	case ModeDuration:
+		d, ok := durationValue(key)
+		return d.Seconds(), ok
+	}
+	return k.numeric.numericKey(key)
+}
+
+// reportSummary prints the summary of s, if any, to stderr.
+func reportSummary(s *summary) {
+	if s != nil {
+		s.report(os.Stderr)
+	}
+}
@@ -0,0 +1,97 @@
+package sortutil
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSortSummary checks the --summary line for a numeric fixture; lines
+// without a number and the header are not counted, and the output itself is
+// unchanged.
+func TestSortSummary(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+		want  string
+	}{
+		{"numeric", "5\n-2\nx\n10\n3\n", SortOptions{Numeric: true},
+			"sort: summary: count=4 min=-2 max=10 sum=16 mean=4\n"},
+		{"key", "a 1.5\nb 2.5\nc\n", SortOptions{Keys: []KeySpec{{StartField: 2, EndField: 2, Numeric: true}}},
+			"sort: summary: count=2 min=1.5 max=2.5 sum=4 mean=2\n"},
+		{"human", "1K\n512\n2M\n", SortOptions{Human: true},
+			"sort: summary: count=3 min=512 max=2e+06 sum=2.001512e+06 mean=667170.6666666666\n"},
+		{"duration", "1m\n500ms\nn/a\n1.5s\n", SortOptions{Duration: true},
+			"sort: summary: count=3 min=0.5 max=60 sum=62 mean=20.666666666666668\n"},
+		{"header", "n\n3\n1\n", SortOptions{Numeric: true, Header: 1},
+			"sort: summary: count=2 min=1 max=3 sum=4 mean=2\n"},
+		{"no numbers", "a\nb\n", SortOptions{Numeric: true}, "sort: summary: count=0\n"},
+	}
+	for _, c := range cases {
+		want := sortText(t, c.input, c.opts)
+		opts := c.opts
+		opts.Summary = true
+		var got string
+		report := captureStderr(t, func() { got = sortText(t, c.input, opts) })
+		if got != want {
+			t.Errorf("%s: output %q, want %q", c.name, got, want)
+		}
+		if report != c.want {
+			t.Errorf("%s: summary %q, want %q", c.name, report, c.want)
+		}
+
+		// --mmap считает статистику по отображённым строкам
+		if c.opts.Header > 0 {
+			continue // с --header SortMapped уходит в Sort, это проверено выше
+		}
+		path := filepath.Join(t.TempDir(), "input")
+		if err := os.WriteFile(path, []byte(c.input), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var mapped bytes.Buffer
+		report = captureStderr(t, func() {
+			if err := SortMapped(path, &mapped, opts); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if mapped.String() != want || report != c.want {
+			t.Errorf("%s: --mmap output %q summary %q, want %q and %q", c.name, mapped.String(), report, want, c.want)
+		}
+	}
+
+	var b bytes.Buffer
+	(&summary{count: 1, min: 0.5, max: 0.5, sum: 0.5}).report(&b)
+	if want := "sort: summary: count=1 min=0.5 max=0.5 sum=0.5 mean=0.5\n"; b.String() != want {
+		t.Errorf("report = %q, want %q", b.String(), want)
+	}
+}
+
+// TestSummaryExternal reads through the summary split function into an
+// external sort, as Sort does once the input exceeds the memory limit: every
+// record is counted once, although the sort spills many chunks.
+func TestSummaryExternal(t *testing.T) {
+	opts := SortOptions{Numeric: true}
+	stats := newSummary(opts)
+	stats.active = true
+	s := NewLineReader(strings.NewReader("5\n-2\nx\n10\n3\n"), opts)
+	s.Split(stats.observe(opts.splitFunc()))
+	var out bytes.Buffer
+	rw := newRecordWriter(&out, opts)
+	if err := externalSort(s, rw, opts, 4, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "x\n-2\n3\n5\n10\n"; out.String() != want {
+		t.Errorf("output %q, want %q", out.String(), want)
+	}
+	var b bytes.Buffer
+	stats.report(&b)
+	if want := "sort: summary: count=4 min=-2 max=10 sum=16 mean=4\n"; b.String() != want {
+		t.Errorf("summary %q, want %q", b.String(), want)
+	}
+}
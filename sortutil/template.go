@@ -0,0 +1,87 @@
+package sortutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KeyTemplate is a parsed --key-template such as "{2}-{1}": the key of a line
+// is the template with every {N} replaced by field N of the line.
+type KeyTemplate struct {
+	parts []templatePart
+}
+
+// templatePart is either literal text or, when field > 0, a field reference.
+type templatePart struct {
+	text  string
+	field int
+}
+
+// ParseKeyTemplate parses a key template. {N} refers to field N (from 1),
+// {{ and }} stand for literal braces. An invalid template is a SortError of KindInvalidOption.
+func ParseKeyTemplate(s string) (*KeyTemplate, error) {
+	t, err := parseKeyTemplate(s)
+	if err != nil {
+		return nil, newError(KindInvalidOption, fmt.Errorf("invalid key template %q: %v", s, err))
+	}
+	return t, nil
+}
+
+func parseKeyTemplate(s string) (*KeyTemplate, error) {
+	t := &KeyTemplate{}
+	var text strings.Builder
+	hasField := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"), strings.HasPrefix(s[i:], "}}"):
+			text.WriteByte(s[i])
+			i++
+		case s[i] == '}':
+			return nil, fmt.Errorf("unmatched '}' at offset %d", i)
+		case s[i] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated '{' at offset %d", i)
+			}
+			n, err := strconv.Atoi(s[i+1 : i+end])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("field %q is not a positive number", s[i+1:i+end])
+			}
+			if text.Len() > 0 {
+				t.parts = append(t.parts, templatePart{text: text.String()})
+				text.Reset()
+			}
+			t.parts = append(t.parts, templatePart{field: n})
+			hasField = true
+			i += end
+		default:
+			text.WriteByte(s[i])
+		}
+	}
+	if !hasField {
+		return nil, fmt.Errorf("no {N} field reference")
+	}
+	if text.Len() > 0 {
+		t.parts = append(t.parts, templatePart{text: text.String()})
+	}
+	return t, nil
+}
+
+// expand builds the key of line for k. Fields are found as for -k (with -t,
+// --csv or --columns), but without -t the leading blanks of a field are not
+// part of the key. A missing field expands to nothing.
+func (t *KeyTemplate) expand(line string, k KeySpec) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.field == 0 {
+			b.WriteString(p.text)
+			continue
+		}
+		fk := k
+		fk.StartField, fk.StartChar, fk.EndField, fk.EndChar = p.field, 0, p.field, 0
+		fk.SkipStartBlanks = k.sep == "" && k.widths == nil
+		b.WriteString(getKey(line, fk))
+	}
+	return b.String()
+}
@@ -0,0 +1,67 @@
+package sortutil
+
+import "testing"
+
+func TestParseKeyTemplate(t *testing.T) {
+	cases := []struct {
+		template string
+		ok       bool
+	}{
+		{"{2}-{1}", true},
+		{"{1}", true},
+		{"{{{1}}}", true},
+		{"no fields", false},
+		{"{0}", false},
+		{"{x}", false},
+		{"{1", false},
+		{"1}", false},
+		{"{-1}", false},
+	}
+	for _, c := range cases {
+		_, err := ParseKeyTemplate(c.template)
+		if (err == nil) != c.ok {
+			t.Errorf("ParseKeyTemplate(%q): err = %v, want ok %v", c.template, err, c.ok)
+		}
+	}
+}
+
+// TestKeyTemplate builds keys from reordered fields and sorts by them.
+func TestKeyTemplate(t *testing.T) {
+	keys := []struct {
+		template, line string
+		opts           SortOptions
+		want           string
+	}{
+		{"{2}-{1}", "a b", SortOptions{}, "b-a"},
+		{"{2}-{1}", "  a   b", SortOptions{}, "b-a"},
+		{"{3}{1}", "a b", SortOptions{}, "a"},
+		{"{{{1}}}", "a", SortOptions{}, "{a}"},
+		{"{2}/{1}", "a, b", SortOptions{Separator: ","}, " b/a"},
+		{"{2}{1}", `"x,y",z`, SortOptions{CSV: true}, "zx,y"},
+		{"{2}{1}", "abcde", SortOptions{Columns: []int{3, 2}}, "deabc"},
+	}
+	for _, c := range keys {
+		tmpl, err := ParseKeyTemplate(c.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.opts.KeyTemplate = tmpl
+		if got := newComparator(c.opts).keys[0].extract(c.line); got != c.want {
+			t.Errorf("%q of %q = %q, want %q", c.template, c.line, got, c.want)
+		}
+	}
+
+	// Год, затем месяц, затем день: "{3}{2}{1}" упорядочивает даты вида ДД ММ ГГГГ
+	tmpl, err := ParseKeyTemplate("{3}{2}{1}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := "01 02 2024\n31 12 2023\n15 02 2024\n02 01 2024\n"
+	want := "31 12 2023\n02 01 2024\n01 02 2024\n15 02 2024\n"
+	if got := sortText(t, input, SortOptions{KeyTemplate: tmpl}); got != want {
+		t.Errorf("sort by {3}{2}{1}: got %q, want %q", got, want)
+	}
+	if got := sortText(t, input, SortOptions{KeyTemplate: tmpl, Reverse: true}); got != "15 02 2024\n01 02 2024\n02 01 2024\n31 12 2023\n" {
+		t.Errorf("sort -r by {3}{2}{1}: got %q", got)
+	}
+}
@@ -0,0 +1,93 @@
+package sortutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// TempStore keeps the sorted runs of an external sort. By default they are
+// local files in the -T directories; another implementation (in memory,
+// an object store) can be set in SortOptions.TempStore.
+type TempStore interface {
+	// Create starts a new temporary object; pattern is as in os.CreateTemp.
+	// The object is complete once the returned writer is closed.
+	Create(pattern string) (name string, w io.WriteCloser, err error)
+	// Open reads back a complete object.
+	Open(name string) (io.ReadCloser, error)
+	// Remove deletes an object, complete or not.
+	Remove(name string) error
+}
+
+// tempStore returns the store for temporary files of opts.
+func (opts SortOptions) tempStore() TempStore {
+	if opts.TempStore != nil {
+		return opts.TempStore
+	}
+	return newTempDirs(opts.TempDirs)
+}
+
+// tempDirs is the local TempStore. It spreads temporary files across
+// the -T directories, always picking the one with the fewest bytes written so far.
+// It is safe for concurrent use (--parallel-files).
+type tempDirs struct {
+	mu   sync.Mutex
+	dirs []string
+	used []int64
+}
+
+func newTempDirs(dirs []string) *tempDirs {
+	return &tempDirs{dirs: dirs, used: make([]int64, len(dirs))}
+}
+
+// pick returns the least used directory and its slot; "" means the system default.
+func (t *tempDirs) pick() (string, int) {
+	if len(t.dirs) == 0 {
+		return "", -1
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	best := 0
+	for i := range t.used {
+		if t.used[i] < t.used[best] {
+			best = i
+		}
+	}
+	return t.dirs[best], best
+}
+
+// Create opens a new temporary file in the least used directory.
+func (t *tempDirs) Create(pattern string) (string, io.WriteCloser, error) {
+	dir, slot := t.pick()
+	tmp, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		return "", nil, fmt.Errorf("sort: cannot create temporary file in %s: %w", dir, err)
+	}
+	return tmp.Name(), &accountedFile{File: tmp, dirs: t, slot: slot}, nil
+}
+
+func (t *tempDirs) Open(name string) (io.ReadCloser, error) { return os.Open(name) }
+
+func (t *tempDirs) Remove(name string) error { return os.Remove(name) }
+
+// accountedFile adds its size to its directory when closed.
+type accountedFile struct {
+	*os.File
+	dirs *tempDirs
+	slot int
+}
+
+func (f *accountedFile) Close() error {
+	if f.slot >= 0 {
+		if info, err := f.Stat(); err == nil {
+			f.dirs.mu.Lock()
+			f.dirs.used[f.slot] += info.Size()
+			f.dirs.mu.Unlock()
+		}
+	}
+	return f.File.Close()
+}
@@ -0,0 +1,116 @@
+package sortutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memStore is a TempStore that keeps its objects in memory.
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string]*bytes.Buffer
+	created int
+}
+
+func newMemStore() *memStore {
+	return &memStore{objects: make(map[string]*bytes.Buffer)}
+}
+
+func (s *memStore) Create(pattern string) (string, io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.created++
+	name := fmt.Sprintf("%s#%d", pattern, s.created)
+	buf := &bytes.Buffer{}
+	s.objects[name] = buf
+	return name, nopWriteCloser{buf}, nil
+}
+
+func (s *memStore) Open(name string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf, ok := s.objects[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+}
+
+func (s *memStore) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, name)
+	return nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// TestMemTempStore drives forced external sorts through an in-memory
+// TempStore: the output is that of the in-memory sort, every run goes through
+// the store, and no object is left behind.
+func TestMemTempStore(t *testing.T) {
+	lines := numberedLines("line", 20000)
+	cases := []struct {
+		name string
+		opts SortOptions
+	}{
+		{"external", SortOptions{}},
+		{"unique", SortOptions{Unique: true}},
+		{"parallel merge", SortOptions{ParallelMerge: true}},
+	}
+	for _, c := range cases {
+		want := joinLines(SortInMemory(slices.Clone(lines), c.opts))
+		store := newMemStore()
+		c.opts.TempStore = store
+		var out bytes.Buffer
+		// Порции по 1000 байт: их больше maxOpenFiles, слияние идёт в несколько уровней
+		if err := ExternalSortReader(strings.NewReader(joinLines(lines)), &out, c.opts, 1000); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if out.String() != want {
+			t.Errorf("%s: output differs from the in-memory sort", c.name)
+		}
+		if store.created == 0 {
+			t.Errorf("%s: the store was not used", c.name)
+		}
+		if len(store.objects) != 0 {
+			t.Errorf("%s: %d objects left in the store", c.name, len(store.objects))
+		}
+	}
+}
+
+// TestTempDirsSpread checks that the local store puts every new file in the
+// -T directory with the fewest bytes written so far.
+func TestTempDirsSpread(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir(), t.TempDir()}
+	store := newTempDirs(dirs)
+	sizes := []int{100, 10, 50, 1, 1}
+	want := []string{dirs[0], dirs[1], dirs[2], dirs[1], dirs[1]}
+	for i, size := range sizes {
+		dir, _ := store.pick()
+		if dir != want[i] {
+			t.Errorf("file %d went to %s, want %s", i, dir, want[i])
+		}
+		name, w, err := store.Create("sort-*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
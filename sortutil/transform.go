@@ -0,0 +1,64 @@
+package sortutil
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// keyTransform is one step of --transform: it maps a key to its normalized form.
+type keyTransform func(string) string
+
+// keyTransforms are the named steps of --transform. The steps -d, -i, -f, -b
+// and --squeeze-blanks work like the flags of the same name, but run in the
+// order of the list rather than in the fixed order of GNU.
+var keyTransforms = map[string]keyTransform{
+	"lower":       strings.ToLower,
+	"upper":       strings.ToUpper,
+	"trim":        trimBlanks,
+	"squeeze":     func(s string) string { return normalizeKey(s, KeySpec{squeeze: true}) },
+	"dictionary":  func(s string) string { return normalizeKey(s, KeySpec{Dictionary: true}) },
+	"printable":   func(s string) string { return normalizeKey(s, KeySpec{IgnoreNonprinting: true}) },
+	"accents":     foldAccents,
+	"reverse":     func(s string) string { return reverseKey(s, ReverseChars) },
+	"strip-punct": stripPunct,
+}
+
+// ParseTransforms splits the comma-separated --transform list into the names
+// of its steps, in order, and rejects unknown names.
+func ParseTransforms(list string) ([]string, error) {
+	names := strings.Split(list, ",")
+	for _, name := range names {
+		if _, ok := keyTransforms[name]; !ok {
+			known := make([]string, 0, len(keyTransforms))
+			for name := range keyTransforms {
+				known = append(known, name)
+			}
+			slices.Sort(known)
+			return nil, newError(KindInvalidOption, fmt.Errorf("sort: unknown --transform %q: want %s", name, strings.Join(known, ", ")))
+		}
+	}
+	return names, nil
+}
+
+// transformChain resolves the names of ParseTransforms to functions.
+func transformChain(names []string) []keyTransform {
+	var chain []keyTransform
+	for _, name := range names {
+		if t, ok := keyTransforms[name]; ok {
+			chain = append(chain, t)
+		}
+	}
+	return chain
+}
+
+// stripPunct removes punctuation (Unicode category P) from s.
+func stripPunct(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsPunct(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
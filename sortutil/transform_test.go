@@ -0,0 +1,69 @@
+package sortutil
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// TestParseTransforms splits --transform lists and rejects unknown steps.
+func TestParseTransforms(t *testing.T) {
+	cases := []struct {
+		list string
+		want []string
+	}{
+		{"lower", []string{"lower"}},
+		{"lower,trim,strip-punct", []string{"lower", "trim", "strip-punct"}},
+		{"reverse,reverse", []string{"reverse", "reverse"}},
+		{"lower,nope", nil},
+		{"", nil},
+	}
+	for _, c := range cases {
+		got, err := ParseTransforms(c.list)
+		if c.want == nil {
+			var sortErr *SortError
+			if !errors.As(err, &sortErr) || sortErr.Kind != KindInvalidOption {
+				t.Errorf("ParseTransforms(%q): err = %v, want an invalid option", c.list, err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, c.want) {
+			t.Errorf("ParseTransforms(%q) = %q, %v, want %q", c.list, got, err, c.want)
+		}
+	}
+}
+
+// TestTransformChain applies chains of steps to a key: the steps run in the
+// order of the list, so the same steps in another order can give another key.
+func TestTransformChain(t *testing.T) {
+	cases := []struct {
+		list, key, want string
+	}{
+		{"lower", "AbC", "abc"},
+		{"lower,trim,strip-punct", "  Hello, World!  ", "hello world"},
+		{"strip-punct,trim", "- a -", "a"},
+		{"trim,strip-punct", "- a -", " a "},
+		{"squeeze,trim", "  a   b  ", "a b"},
+		{"trim,squeeze", "  a   b  ", "a b"},
+		{"reverse,trim", "ab  ", "ba"},
+		{"accents,upper", "café", "CAFE"},
+		{"dictionary,printable", "a-b\x01c", "abc"},
+		{"upper,lower", "MiXeD", "mixed"},
+		{"lower,upper", "MiXeD", "MIXED"},
+	}
+	for _, c := range cases {
+		names, err := ParseTransforms(c.list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := normalizeKey(c.key, KeySpec{transform: transformChain(names)}); got != c.want {
+			t.Errorf("--transform=%s of %q = %q, want %q", c.list, c.key, got, c.want)
+		}
+	}
+
+	// Ключ преобразуется только для сравнения
+	got := sortText(t, "B-2\na 3\n  c,1\n", SortOptions{Transform: []string{"trim", "lower", "strip-punct"}})
+	if want := "a 3\nB-2\n  c,1\n"; got != want {
+		t.Errorf("sort --transform=trim,lower,strip-punct: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,54 @@
+package sortutil
+
+import "strings"
+
+// tsvFields splits a TSV line into fields at tabs and unescapes them: \t, \n,
+// \r and \\ mean a tab, a newline, a carriage return and a backslash. A tab
+// right after a backslash is escaped and does not split the field; an unknown
+// sequence such as \x is kept as is.
+func tsvFields(line string) []string {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\t':
+			fields = append(fields, field.String())
+			field.Reset()
+		case c == '\\' && i+1 < len(line):
+			i++
+			switch line[i] {
+			case 't', '\t':
+				field.WriteByte('\t')
+			case 'n':
+				field.WriteByte('\n')
+			case 'r':
+				field.WriteByte('\r')
+			case '\\':
+				field.WriteByte('\\')
+			default:
+				field.WriteByte('\\')
+				field.WriteByte(line[i])
+			}
+		default:
+			field.WriteByte(c)
+		}
+	}
+	return append(fields, field.String())
+}
+
+// tsvKey returns the unescaped TSV fields selected by k joined with tabs, like csvKey.
+func tsvKey(line string, k KeySpec) string {
+	fields := tsvFields(line)
+	if k.StartField > len(fields) {
+		return ""
+	}
+	end := len(fields)
+	if k.EndField > 0 && k.EndField < end {
+		end = k.EndField
+	}
+	if end < k.StartField {
+		return ""
+	}
+	return strings.Join(fields[k.StartField-1:end], "\t")
+}
@@ -0,0 +1,70 @@
+package sortutil
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestTSVFields splits TSV lines at unescaped tabs and unescapes the fields.
+func TestTSVFields(t *testing.T) {
+	cases := []struct {
+		line string
+		want []string
+	}{
+		{"a\tb\tc", []string{"a", "b", "c"}},
+		{`a\tb` + "\tc", []string{"a\tb", "c"}},
+		{"a\\\tb\tc", []string{"a\tb", "c"}},
+		{`x\ny\rz\\` + "\tc", []string{"x\ny\rz\\", "c"}},
+		{`a\\` + "\tb", []string{`a\`, "b"}},
+		{`a\x` + "\tb", []string{`a\x`, "b"}},
+		{`a\`, []string{`a\`}},
+		{"\ta\t", []string{"", "a", ""}},
+	}
+	for _, c := range cases {
+		if got := tsvFields(c.line); !slices.Equal(got, c.want) {
+			t.Errorf("tsvFields(%q) = %q, want %q", c.line, got, c.want)
+		}
+	}
+}
+
+// TestTSVSort selects the logical field after an escaped tab with -k and
+// leaves the output lines unchanged.
+func TestTSVSort(t *testing.T) {
+	input := `b\tz` + "\t2\n" + "a\t3\n" + `c\\` + "\t1\n"
+	cases := []struct {
+		name string
+		opts SortOptions
+		want string
+	}{
+		{"second field", SortOptions{Keys: []KeySpec{{StartField: 2, EndField: 2}}},
+			`c\\` + "\t1\n" + `b\tz` + "\t2\n" + "a\t3\n"},
+		{"first field", SortOptions{Keys: []KeySpec{{StartField: 1, EndField: 1}}},
+			"a\t3\n" + `b\tz` + "\t2\n" + `c\\` + "\t1\n"},
+		{"key name", SortOptions{KeyName: "n"}, "f\tn\n" + `c\\` + "\t1\n" + `b\tz` + "\t2\n" + "a\t3\n"},
+	}
+	for _, c := range cases {
+		c.opts.TSV = true
+		in := input
+		if c.opts.KeyName != "" {
+			in = "f\tn\n" + input
+		}
+		if got := sortText(t, in, c.opts); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// TestTSVEscapedTabByte checks the example of the README: a tab right after a
+// backslash does not split the field, so -k 2 of b\<TAB>q<TAB>1 is 1.
+func TestTSVEscapedTabByte(t *testing.T) {
+	input := "b\\\tq\t1\na\t2\n"
+	opts := SortOptions{TSV: true, Keys: []KeySpec{{StartField: 2, EndField: 2}}}
+	if got, want := sortText(t, input, opts), "b\\\tq\t1\na\t2\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	opts.TSV = false
+	opts.Separator = "\t"
+	if got, want := sortText(t, input, opts), "a\t2\nb\\\tq\t1\n"; got != want {
+		t.Errorf("-t TAB: got %q, want %q", got, want)
+	}
+}
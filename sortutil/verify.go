@@ -0,0 +1,48 @@
+package sortutil
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+)
+
+// tally counts records and sums their hashes. The sum does not depend on order,
+// so equal sums of input and output mean the same multiset of lines.
+type tally struct {
+	count int
+	sum   uint64
+}
+
+func (t *tally) add(record []byte) {
+	h := fnv.New64a()
+	_, _ = h.Write(record)
+	t.count++
+	t.sum += h.Sum64()
+}
+
+// verifier compares what Sort read with what it wrote for --verify.
+type verifier struct {
+	in, out tally
+}
+
+// observe wraps split to tally every input record.
+func (v *verifier) observe(split bufio.SplitFunc) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if token != nil {
+			v.in.add(token)
+		}
+		return advance, token, err
+	}
+}
+
+// check returns an error if the output is not a permutation of the input.
+func (v *verifier) check() error {
+	switch {
+	case v.in.count != v.out.count:
+		return fmt.Errorf("sort: --verify: read %d lines but wrote %d", v.in.count, v.out.count)
+	case v.in.sum != v.out.sum:
+		return fmt.Errorf("sort: --verify: output lines differ from input lines (%d lines)", v.in.count)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package sortutil
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// verifyWithLimit sorts input like Sort with --verify, but with a memory limit
+// of limit bytes, and returns the result of the check.
+func verifyWithLimit(t *testing.T, input string, opts SortOptions, limit int) error {
+	t.Helper()
+	var out bytes.Buffer
+	v := &verifier{}
+	rw := newRecordWriter(&out, opts)
+	rw.tally = &v.out
+	s := NewLineReader(strings.NewReader(input), opts)
+	s.Split(v.observe(opts.splitFunc()))
+	lines, err := readLines(s, limit)
+	switch {
+	case errors.Is(err, ErrInputTooLarge):
+		err = externalSort(s, rw, opts, limit, lines)
+	case err == nil:
+		for _, line := range SortInMemory(lines, opts) {
+			if err = rw.write(line); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = rw.flush()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v.check()
+}
+
+// TestSortVerify sorts inputs on the tricky boundaries of the external sort
+// with --verify: the output must be a permutation of the input, including
+// the header and the footer, and output transformations do not matter.
+func TestSortVerify(t *testing.T) {
+	lines := numberedLines("line", 3000)
+	input := joinLines(lines)
+	peak := readPeak(lines)
+	limits := []struct {
+		name  string
+		limit int
+	}{
+		{"at the memory limit", peak},
+		{"one byte over", peak - 1},
+		{"many runs", 4 << 10},
+	}
+	for _, l := range limits {
+		for _, in := range []string{input, strings.TrimSuffix(input, "\n"), strings.ReplaceAll(input, "\n", "\r\n")} {
+			if err := verifyWithLimit(t, in, SortOptions{TempDirs: []string{t.TempDir()}}, l.limit); err != nil {
+				t.Errorf("%s: %v", l.name, err)
+			}
+		}
+	}
+
+	cases := []struct {
+		name  string
+		input string
+		opts  SortOptions
+	}{
+		{"in memory", input, SortOptions{}},
+		{"no final newline", "b\na", SortOptions{}},
+		{"header and footer", "h\n" + input + "f\n", SortOptions{Header: 1, Footer: 1}},
+		{"prepend index", "b\na\n", SortOptions{PrependIndex: true}},
+		{"only keys", "b 2\na 1\n", SortOptions{OnlyKeys: true, Keys: []KeySpec{{StartField: 2, EndField: 2}}}},
+		{"group", "b\na\nb\n", SortOptions{Group: true}},
+	}
+	for _, c := range cases {
+		want := sortText(t, c.input, c.opts)
+		c.opts.Verify = true
+		if got := sortText(t, c.input, c.opts); got != want {
+			t.Errorf("%s: --verify changed the output to %q, want %q", c.name, got, want)
+		}
+	}
+}
+
+func TestVerifierCheck(t *testing.T) {
+	cases := []struct {
+		name    string
+		in, out []string
+		wantErr string
+	}{
+		{"permutation", []string{"b", "a", "a"}, []string{"a", "a", "b"}, ""},
+		{"lost line", []string{"b", "a"}, []string{"a"}, "read 2 lines but wrote 1"},
+		{"doubled line", []string{"a"}, []string{"a", "a"}, "read 1 lines but wrote 2"},
+		{"changed line", []string{"a", "b"}, []string{"a", "c"}, "output lines differ"},
+		{"swapped duplicate", []string{"a", "a", "b"}, []string{"a", "b", "b"}, "output lines differ"},
+	}
+	for _, c := range cases {
+		var v verifier
+		for _, line := range c.in {
+			v.in.add([]byte(line))
+		}
+		for _, line := range c.out {
+			v.out.add([]byte(line))
+		}
+		err := v.check()
+		switch {
+		case c.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)):
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.wantErr)
+		}
+	}
+}